@@ -1,11 +1,15 @@
 package client
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 
+	"github.com/ihexxa/quickshare/src/db"
 	"github.com/ihexxa/quickshare/src/handlers/fileshdr"
 	"github.com/parnurzeal/gorequest"
 )
@@ -39,6 +43,30 @@ func (cl *FilesClient) Create(filepath string, size int64) (*http.Response, stri
 		End()
 }
 
+// CreateWithExpectedHash creates the file which is verified against the hash ("algorithm:hex") after uploading
+func (cl *FilesClient) CreateWithExpectedHash(filepath string, size int64, expectedHash string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/files")).
+		AddCookie(cl.token).
+		Send(fileshdr.CreateReq{
+			Path:         filepath,
+			FileSize:     size,
+			ExpectedHash: expectedHash,
+		}).
+		End()
+}
+
+// Overwrite creates the file and keeps the existing one as a revision
+func (cl *FilesClient) Overwrite(filepath string, size int64) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/files")).
+		AddCookie(cl.token).
+		Send(fileshdr.CreateReq{
+			Path:      filepath,
+			FileSize:  size,
+			Overwrite: true,
+		}).
+		End()
+}
+
 func (cl *FilesClient) Delete(filepath string) (*http.Response, string, []error) {
 	return cl.r.Delete(cl.url("/v2/my/fs/files")).
 		AddCookie(cl.token).
@@ -78,6 +106,43 @@ func (cl *FilesClient) Move(oldpath, newpath string) (*http.Response, string, []
 		End()
 }
 
+func (cl *FilesClient) Copy(srcPath, dstPath string) (*http.Response, *fileshdr.CopyResp, []error) {
+	resp, body, errs := cl.r.Patch(cl.url("/v2/my/fs/files/copy")).
+		AddCookie(cl.token).
+		Send(fileshdr.CopyReq{
+			SrcPath: srcPath,
+			DstPath: dstPath,
+		}).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	cResp := &fileshdr.CopyResp{}
+	err := json.Unmarshal([]byte(body), cResp)
+	if err != nil {
+		return nil, nil, append(errs, err)
+	}
+	return resp, cResp, nil
+}
+
+func (cl *FilesClient) CopyStatus(taskID string) (*http.Response, *fileshdr.CopyStatusResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/files/copy")).
+		AddCookie(cl.token).
+		Param(fileshdr.TaskIDQuery, taskID).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	sResp := &fileshdr.CopyStatusResp{}
+	err := json.Unmarshal([]byte(body), sResp)
+	if err != nil {
+		return nil, nil, append(errs, err)
+	}
+	return resp, sResp, nil
+}
+
 func (cl *FilesClient) UploadChunk(filepath string, content string, offset int64) (*http.Response, string, []error) {
 	return cl.r.Patch(cl.url("/v2/my/fs/files/chunks")).
 		AddCookie(cl.token).
@@ -89,6 +154,205 @@ func (cl *FilesClient) UploadChunk(filepath string, content string, offset int64
 		End()
 }
 
+// UploadChunkWithChecksum uploads the chunk with its checksum in format "<algorithm> <hex>"
+func (cl *FilesClient) UploadChunkWithChecksum(filepath string, content string, offset int64, checksum string) (*http.Response, string, []error) {
+	return cl.r.Patch(cl.url("/v2/my/fs/files/chunks")).
+		AddCookie(cl.token).
+		Set(fileshdr.ChunkChecksumHeader, checksum).
+		Send(fileshdr.UploadChunkReq{
+			Path:    filepath,
+			Content: content,
+			Offset:  offset,
+		}).
+		End()
+}
+
+// do sends the request which can not be built by gorequest, such as requests with binary bodies
+func (cl *FilesClient) do(method, urlpath string, headers map[string]string, body []byte) (*http.Response, string, []error) {
+	req, err := http.NewRequest(method, cl.url(urlpath), bytes.NewReader(body))
+	if err != nil {
+		return nil, "", []error{err}
+	}
+	req.AddCookie(cl.token)
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", []error{err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", []error{err}
+	}
+	return resp, string(respBody), nil
+}
+
+// UploadBinaryChunk uploads the chunk as raw bytes instead of base64 encoded JSON
+func (cl *FilesClient) UploadBinaryChunk(filepath string, content []byte, offset int64) (*http.Response, string, []error) {
+	return cl.do(
+		http.MethodPatch,
+		"/v2/my/fs/files/chunks/binary",
+		map[string]string{
+			"Content-Type":              "application/octet-stream",
+			fileshdr.UploadPathHeader:   url.PathEscape(filepath),
+			fileshdr.UploadOffsetHeader: fmt.Sprint(offset),
+		},
+		content,
+	)
+}
+
+// TusCreate creates a tus upload, the upload URL is in the Location header
+func (cl *FilesClient) TusCreate(filepath string, size int64) (*http.Response, string, []error) {
+	return cl.do(
+		http.MethodPost,
+		"/v2/my/fs/tus",
+		map[string]string{
+			fileshdr.TusResumableHeader:      fileshdr.TusVersion,
+			fileshdr.TusUploadLengthHeader:   fmt.Sprint(size),
+			fileshdr.TusUploadMetadataHeader: fmt.Sprintf("path %s", base64.StdEncoding.EncodeToString([]byte(filepath))),
+		},
+		nil,
+	)
+}
+
+func (cl *FilesClient) TusOffset(uploadURL string) (*http.Response, string, []error) {
+	return cl.do(
+		http.MethodHead,
+		uploadURL,
+		map[string]string{fileshdr.TusResumableHeader: fileshdr.TusVersion},
+		nil,
+	)
+}
+
+// TusPatch uploads the chunk, checksum is "algorithm base64(checksum)" and it is optional
+func (cl *FilesClient) TusPatch(uploadURL string, content []byte, offset int64, checksum string) (*http.Response, string, []error) {
+	headers := map[string]string{
+		"Content-Type":                 fileshdr.TusOffsetContentType,
+		fileshdr.TusResumableHeader:    fileshdr.TusVersion,
+		fileshdr.TusUploadOffsetHeader: fmt.Sprint(offset),
+	}
+	if checksum != "" {
+		headers[fileshdr.TusUploadChecksumHeader] = checksum
+	}
+	return cl.do(http.MethodPatch, uploadURL, headers, content)
+}
+
+func (cl *FilesClient) TusTerminate(uploadURL string) (*http.Response, string, []error) {
+	return cl.do(
+		http.MethodDelete,
+		uploadURL,
+		map[string]string{fileshdr.TusResumableHeader: fileshdr.TusVersion},
+		nil,
+	)
+}
+
+// CreateInParts creates the file which is uploaded in numbered parts
+func (cl *FilesClient) CreateInParts(filepath string, size, partSize int64) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/files")).
+		AddCookie(cl.token).
+		Send(fileshdr.CreateReq{
+			Path:     filepath,
+			FileSize: size,
+			PartSize: partSize,
+		}).
+		End()
+}
+
+// CreateWithSha1 creates the file without uploading if an accessible file has identical content
+func (cl *FilesClient) CreateWithSha1(filepath string, size int64, sha1Sign string) (*http.Response, *fileshdr.CreateResp, []error) {
+	resp, body, errs := cl.r.Post(cl.url("/v2/my/fs/files")).
+		AddCookie(cl.token).
+		Send(fileshdr.CreateReq{
+			Path:     filepath,
+			FileSize: size,
+			Sha1:     sha1Sign,
+		}).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	createResp := &fileshdr.CreateResp{}
+	if resp.StatusCode == 200 {
+		err := json.Unmarshal([]byte(body), createResp)
+		if err != nil {
+			return nil, nil, append(errs, err)
+		}
+	}
+	return resp, createResp, nil
+}
+
+func (cl *FilesClient) HasSha1(sha1Sign string, size int64) (*http.Response, *fileshdr.HasSha1Resp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/hashes/sha1")).
+		AddCookie(cl.token).
+		Param(fileshdr.Sha1Query, sha1Sign).
+		Param(fileshdr.SizeQuery, fmt.Sprint(size)).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	hasResp := &fileshdr.HasSha1Resp{}
+	if resp.StatusCode == 200 {
+		err := json.Unmarshal([]byte(body), hasResp)
+		if err != nil {
+			return nil, nil, append(errs, err)
+		}
+	}
+	return resp, hasResp, nil
+}
+
+func (cl *FilesClient) UploadPart(filepath string, partNumber int64, content []byte) (*http.Response, *fileshdr.UploadPartsResp, []error) {
+	urlpath := fmt.Sprintf(
+		"/v2/my/fs/files/parts?%s",
+		url.Values{
+			fileshdr.FilePathQuery:   []string{filepath},
+			fileshdr.PartNumberQuery: []string{fmt.Sprint(partNumber)},
+		}.Encode(),
+	)
+	resp, body, errs := cl.do(
+		http.MethodPut,
+		urlpath,
+		map[string]string{"Content-Type": "application/octet-stream"},
+		content,
+	)
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	partsResp := &fileshdr.UploadPartsResp{}
+	if resp.StatusCode == 200 {
+		err := json.Unmarshal([]byte(body), partsResp)
+		if err != nil {
+			return nil, nil, append(errs, err)
+		}
+	}
+	return resp, partsResp, nil
+}
+
+func (cl *FilesClient) UploadParts(filepath string) (*http.Response, *fileshdr.UploadPartsResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/files/parts")).
+		AddCookie(cl.token).
+		Param(fileshdr.FilePathQuery, filepath).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	partsResp := &fileshdr.UploadPartsResp{}
+	if resp.StatusCode == 200 {
+		err := json.Unmarshal([]byte(body), partsResp)
+		if err != nil {
+			return nil, nil, append(errs, err)
+		}
+	}
+	return resp, partsResp, nil
+}
+
 func (cl *FilesClient) UploadStatus(filepath string) (*http.Response, *fileshdr.UploadStatusResp, []error) {
 	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/files/chunks")).
 		AddCookie(cl.token).
@@ -114,6 +378,28 @@ func (cl *FilesClient) Download(filepath string, headers map[string]string) (*ht
 	return r.End()
 }
 
+func (cl *FilesClient) PresignDownload(filepath string, ttl int64) (*http.Response, *fileshdr.PresignResp, []error) {
+	resp, body, errs := cl.r.Post(cl.url("/v2/my/fs/files/presign")).
+		AddCookie(cl.token).
+		Send(fileshdr.PresignReq{Path: filepath, TTL: ttl}).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	presignResp := &fileshdr.PresignResp{}
+	err := json.Unmarshal([]byte(body), presignResp)
+	if err != nil {
+		return nil, nil, append(errs, err)
+	}
+	return resp, presignResp, nil
+}
+
+// DownloadPresigned downloads the file by the presigned URL without login
+func (cl *FilesClient) DownloadPresigned(presignedURL string) (*http.Response, string, []error) {
+	return cl.r.Get(cl.url(presignedURL)).End()
+}
+
 func (cl *FilesClient) List(dirPath string) (*http.Response, *fileshdr.ListResp, []error) {
 	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/dirs")).
 		AddCookie(cl.token).
@@ -131,6 +417,14 @@ func (cl *FilesClient) List(dirPath string) (*http.Response, *fileshdr.ListResp,
 	return resp, lResp, nil
 }
 
+func (cl *FilesClient) ArchiveDir(dirPath, format string) (*http.Response, string, []error) {
+	return cl.r.Get(cl.url("/v2/my/fs/dirs/archive")).
+		AddCookie(cl.token).
+		Param(fileshdr.ListDirQuery, dirPath).
+		Param(fileshdr.ArchiveFormatQuery, format).
+		End()
+}
+
 func (cl *FilesClient) ListHome() (*http.Response, *fileshdr.ListResp, []error) {
 	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/dirs/home")).
 		AddCookie(cl.token).
@@ -170,6 +464,67 @@ func (cl *FilesClient) DelUploading(filepath string) (*http.Response, string, []
 		End()
 }
 
+func (cl *FilesClient) ListTrashes() (*http.Response, *fileshdr.ListTrashesResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/trashes")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	lResp := &fileshdr.ListTrashesResp{}
+	err := json.Unmarshal([]byte(body), lResp)
+	if err != nil {
+		return nil, nil, append(errs, err)
+	}
+	return resp, lResp, nil
+}
+
+func (cl *FilesClient) RestoreTrash(trashID uint64) (*http.Response, string, []error) {
+	return cl.r.Patch(cl.url("/v2/my/fs/trashes/restore")).
+		AddCookie(cl.token).
+		Send(fileshdr.RestoreTrashReq{ID: trashID}).
+		End()
+}
+
+func (cl *FilesClient) PurgeTrash(trashID uint64) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/my/fs/trashes")).
+		AddCookie(cl.token).
+		Param(fileshdr.TrashIDQuery, fmt.Sprint(trashID)).
+		End()
+}
+
+func (cl *FilesClient) ListRevisions(filepath string) (*http.Response, *fileshdr.ListRevisionsResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/revisions")).
+		AddCookie(cl.token).
+		Param(fileshdr.FilePathQuery, filepath).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	lResp := &fileshdr.ListRevisionsResp{}
+	err := json.Unmarshal([]byte(body), lResp)
+	if err != nil {
+		return nil, nil, append(errs, err)
+	}
+	return resp, lResp, nil
+}
+
+func (cl *FilesClient) DownloadRevision(revisionID uint64) (*http.Response, string, []error) {
+	return cl.r.Get(cl.url("/v2/my/fs/revisions/file")).
+		AddCookie(cl.token).
+		Param(fileshdr.RevisionIDQuery, fmt.Sprint(revisionID)).
+		End()
+}
+
+func (cl *FilesClient) RestoreRevision(revisionID uint64) (*http.Response, string, []error) {
+	return cl.r.Patch(cl.url("/v2/my/fs/revisions/restore")).
+		AddCookie(cl.token).
+		Send(fileshdr.RestoreRevisionReq{ID: revisionID}).
+		End()
+}
+
 func (cl *FilesClient) AddSharing(dirpath string) (*http.Response, string, []error) {
 	return cl.r.Post(cl.url("/v2/my/fs/sharings")).
 		AddCookie(cl.token).
@@ -177,6 +532,59 @@ func (cl *FilesClient) AddSharing(dirpath string) (*http.Response, string, []err
 		End()
 }
 
+// AddExpiringSharing shares the path until expireAt which is a unix timestamp in seconds
+func (cl *FilesClient) AddExpiringSharing(dirpath string, expireAt int64) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/sharings")).
+		AddCookie(cl.token).
+		Send(fileshdr.SharingReq{SharingPath: dirpath, ExpireAt: expireAt}).
+		End()
+}
+
+// AddSlugSharing shares the path with the human-readable slug as its share ID
+func (cl *FilesClient) AddSlugSharing(dirpath, slug string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/sharings")).
+		AddCookie(cl.token).
+		Send(fileshdr.SharingReq{SharingPath: dirpath, Slug: slug}).
+		End()
+}
+
+// AddProtectedSharing shares the path which must be unlocked by the password
+func (cl *FilesClient) AddProtectedSharing(dirpath, pwd string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/sharings")).
+		AddCookie(cl.token).
+		Send(fileshdr.SharingReq{SharingPath: dirpath, Pwd: pwd}).
+		End()
+}
+
+// UnlockSharing unlocks the protected sharing, the share token is returned in the cookie
+func (cl *FilesClient) UnlockSharing(shareID, pwd string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/public/sharings/unlock")).
+		AddCookie(cl.token).
+		Send(fileshdr.UnlockSharingReq{ShareID: shareID, Pwd: pwd}).
+		End()
+}
+
+// AddLimitedSharing shares the path which is disabled after files are downloaded for maxDownloads times
+func (cl *FilesClient) AddLimitedSharing(dirpath string, maxDownloads int64) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/sharings")).
+		AddCookie(cl.token).
+		Send(fileshdr.SharingReq{SharingPath: dirpath, MaxDownloads: maxDownloads}).
+		End()
+}
+
+// AddDropSharing shares the folder as an upload-only sharing
+func (cl *FilesClient) AddDropSharing(dirpath string, maxSize, maxFiles int64) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/sharings")).
+		AddCookie(cl.token).
+		Send(fileshdr.SharingReq{
+			SharingPath: dirpath,
+			Mode:        fileshdr.SharingModeDrop,
+			MaxSize:     maxSize,
+			MaxFiles:    maxFiles,
+		}).
+		End()
+}
+
 func (cl *FilesClient) DelSharing(dirpath string) (*http.Response, string, []error) {
 	return cl.r.Delete(cl.url("/v2/my/fs/sharings")).
 		AddCookie(cl.token).
@@ -224,6 +632,70 @@ func (cl *FilesClient) ListSharingIDs() (*http.Response, *fileshdr.SharingIDsRes
 	return resp, shResp, nil
 }
 
+func (cl *FilesClient) AddFileACL(itemPath, grantee, perm string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/acls")).
+		AddCookie(cl.token).
+		Send(fileshdr.AddFileACLReq{
+			Path:        itemPath,
+			GranteeType: db.GranteeUser,
+			Grantee:     grantee,
+			Perm:        perm,
+		}).
+		End()
+}
+
+func (cl *FilesClient) AddGroupFileACL(itemPath, group, perm string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/fs/acls")).
+		AddCookie(cl.token).
+		Send(fileshdr.AddFileACLReq{
+			Path:        itemPath,
+			GranteeType: db.GranteeGroup,
+			Grantee:     group,
+			Perm:        perm,
+		}).
+		End()
+}
+
+func (cl *FilesClient) DelFileACL(aclID uint64) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/my/fs/acls")).
+		AddCookie(cl.token).
+		Param(fileshdr.ACLIDQuery, fmt.Sprint(aclID)).
+		End()
+}
+
+func (cl *FilesClient) ListFileACLs(itemPath string) (*http.Response, *fileshdr.FileACLsResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/acls")).
+		AddCookie(cl.token).
+		Param(fileshdr.FilePathQuery, itemPath).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	aclsResp := &fileshdr.FileACLsResp{}
+	err := json.Unmarshal([]byte(body), aclsResp)
+	if err != nil {
+		return nil, nil, append(errs, err)
+	}
+	return resp, aclsResp, nil
+}
+
+func (cl *FilesClient) ListSharedWithMe() (*http.Response, *fileshdr.FileACLsResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/fs/acls/shared-with-me")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	aclsResp := &fileshdr.FileACLsResp{}
+	err := json.Unmarshal([]byte(body), aclsResp)
+	if err != nil {
+		return nil, nil, append(errs, err)
+	}
+	return resp, aclsResp, nil
+}
+
 func (cl *FilesClient) GenerateHash(filepath string) (*http.Response, string, []error) {
 	return cl.r.Post(cl.url("/v2/my/fs/hashes/sha1")).
 		AddCookie(cl.token).
@@ -233,6 +705,24 @@ func (cl *FilesClient) GenerateHash(filepath string) (*http.Response, string, []
 		End()
 }
 
+// GenerateHashWith computes the checksum of the file in the algorithm
+func (cl *FilesClient) GenerateHashWith(algo, filepath string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url(fmt.Sprintf("/v2/my/fs/hashes/%s", algo))).
+		AddCookie(cl.token).
+		Send(fileshdr.GenerateHashReq{
+			FilePath: filepath,
+		}).
+		End()
+}
+
+// DownloadSharing downloads the shared file by its share ID
+func (cl *FilesClient) DownloadSharing(shareID string) (*http.Response, string, []error) {
+	return cl.r.Get(cl.url("/v2/public/sharings/files")).
+		AddCookie(cl.token).
+		Param(fileshdr.ShareIDQuery, shareID).
+		End()
+}
+
 func (cl *FilesClient) GetSharingDir(shareID string) (*http.Response, string, []error) {
 	resp, body, errs := cl.r.Get(cl.url("/v2/public/sharings/dirs")).
 		AddCookie(cl.token).
@@ -45,14 +45,44 @@ func (cl *UsersClient) Login(user, pwd string) (*http.Response, string, []error)
 		}).
 		End()
 
+	cl.setToken(resp, errs)
+	return resp, body, errs
+}
+
+// setToken saves the token cookie of the login response,
+// the token is empty if the login failed or it requires 2FA.
+func (cl *UsersClient) setToken(resp *http.Response, errs []error) {
+	cl.token = &http.Cookie{}
 	if len(errs) == 0 && resp.StatusCode == 200 {
 		// it may overwrite the token
-		httpResp := (*http.Response)(resp)
-		cl.token = GetCookie(httpResp.Cookies(), handlers.TokenCookie)
-	} else {
-		cl.token = &http.Cookie{}
+		if token := GetCookie(resp.Cookies(), handlers.TokenCookie); token != nil {
+			cl.token = token
+		}
 	}
-	return resp, body, errs
+}
+
+func (cl *UsersClient) LoginTOTP(challenge, code, recoveryCode string) (*http.Response, *multiusers.LoginResp, []error) {
+	resp, body, errs := cl.r.Post(cl.url("/v2/public/login/totp")).
+		Send(multiusers.LoginTOTPReq{
+			Challenge: challenge,
+			TOTPCodeReq: multiusers.TOTPCodeReq{
+				Code:         code,
+				RecoveryCode: recoveryCode,
+			},
+		}).
+		End()
+	cl.setToken(resp, errs)
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	loginResp := &multiusers.LoginResp{}
+	err := json.Unmarshal([]byte(body), loginResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, loginResp, errs
 }
 
 func (cl *UsersClient) Logout() (*http.Response, string, []error) {
@@ -139,23 +169,36 @@ func (cl *UsersClient) ListUsers() (*http.Response, *multiusers.ListUsersResp, [
 	return resp, lsResp, errs
 }
 
-// func (cl *UsersClient) AddRole(role string) (*http.Response, string, []error) {
-// 	return cl.r.Post(cl.url("/v1/roles/")).
-// 		AddCookie(cl.token).
-// 		Send(multiusers.AddRoleReq{
-// 			Role: role,
-// 		}).
-// 		End()
-// }
-
-// func (cl *UsersClient) DelRole(role string) (*http.Response, string, []error) {
-// 	return cl.r.Delete(cl.url("/v1/roles/")).
-// 		AddCookie(cl.token).
-// 		Send(multiusers.DelRoleReq{
-// 			Role: role,
-// 		}).
-// 		End()
-// }
+func (cl *UsersClient) AddRole(role string, rules []*db.APIRule, capabilities []string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/admin/roles/")).
+		AddCookie(cl.token).
+		Send(multiusers.AddRoleReq{
+			Role:         role,
+			Rules:        rules,
+			Capabilities: capabilities,
+		}).
+		End()
+}
+
+func (cl *UsersClient) SetRole(role string, rules []*db.APIRule, capabilities []string) (*http.Response, string, []error) {
+	return cl.r.Patch(cl.url("/v2/admin/roles/")).
+		AddCookie(cl.token).
+		Send(multiusers.SetRoleReq{
+			Role:         role,
+			Rules:        rules,
+			Capabilities: capabilities,
+		}).
+		End()
+}
+
+func (cl *UsersClient) DelRole(role string) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/admin/roles/")).
+		AddCookie(cl.token).
+		Send(multiusers.DelRoleReq{
+			Role: role,
+		}).
+		End()
+}
 
 func (cl *UsersClient) ListRoles() (*http.Response, *multiusers.ListRolesResp, []error) {
 	resp, body, errs := cl.r.Get(cl.url("/v2/admin/roles/list")).
@@ -174,6 +217,115 @@ func (cl *UsersClient) ListRoles() (*http.Response, *multiusers.ListRolesResp, [
 	return resp, lsResp, errs
 }
 
+func (cl *UsersClient) AddGroup(name string, quota *db.Quota) (*http.Response, *multiusers.AddGroupResp, []error) {
+	resp, body, errs := cl.r.Post(cl.url("/v2/admin/groups/")).
+		AddCookie(cl.token).
+		Send(multiusers.AddGroupReq{
+			Name:  name,
+			Quota: quota,
+		}).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	addResp := &multiusers.AddGroupResp{}
+	err := json.Unmarshal([]byte(body), addResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, addResp, errs
+}
+
+func (cl *UsersClient) SetGroup(id uint64, quota *db.Quota) (*http.Response, string, []error) {
+	return cl.r.Patch(cl.url("/v2/admin/groups/")).
+		AddCookie(cl.token).
+		Send(multiusers.SetGroupReq{
+			ID:    id,
+			Quota: quota,
+		}).
+		End()
+}
+
+func (cl *UsersClient) DelGroup(id uint64) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/admin/groups/")).
+		AddCookie(cl.token).
+		Param(handlers.GroupIDParam, fmt.Sprint(id)).
+		End()
+}
+
+func (cl *UsersClient) ListGroups() (*http.Response, *multiusers.ListGroupsResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/admin/groups/list")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	lsResp := &multiusers.ListGroupsResp{}
+	err := json.Unmarshal([]byte(body), lsResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, lsResp, errs
+}
+
+func (cl *UsersClient) SetGroupMember(groupId uint64, user, perm string) (*http.Response, string, []error) {
+	return cl.r.Put(cl.url("/v2/admin/groups/members")).
+		AddCookie(cl.token).
+		Send(multiusers.SetGroupMemberReq{
+			GroupID: groupId,
+			User:    user,
+			Perm:    perm,
+		}).
+		End()
+}
+
+func (cl *UsersClient) DelGroupMember(groupId, userId uint64) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/admin/groups/members")).
+		AddCookie(cl.token).
+		Param(handlers.GroupIDParam, fmt.Sprint(groupId)).
+		Param(handlers.UserIDParam, fmt.Sprint(userId)).
+		End()
+}
+
+func (cl *UsersClient) ListGroupMembers(groupId uint64) (*http.Response, *multiusers.ListGroupMembersResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/admin/groups/members")).
+		AddCookie(cl.token).
+		Param(handlers.GroupIDParam, fmt.Sprint(groupId)).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	lsResp := &multiusers.ListGroupMembersResp{}
+	err := json.Unmarshal([]byte(body), lsResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, lsResp, errs
+}
+
+func (cl *UsersClient) ListMyGroups() (*http.Response, *multiusers.ListMyGroupsResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/groups")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	lsResp := &multiusers.ListMyGroupsResp{}
+	err := json.Unmarshal([]byte(body), lsResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, lsResp, errs
+}
+
 func (cl *UsersClient) Self() (*http.Response, *multiusers.SelfResp, []error) {
 	resp, body, errs := cl.r.Get(cl.url("/v2/my/self")).
 		AddCookie(cl.token).
@@ -205,3 +357,163 @@ func (cl *UsersClient) IsAuthed() (*http.Response, string, []error) {
 		AddCookie(cl.token).
 		End()
 }
+
+func (cl *UsersClient) AddAPIToken(name, scope string) (*http.Response, *multiusers.AddAPITokenResp, []error) {
+	resp, body, errs := cl.r.Post(cl.url("/v2/my/tokens")).
+		AddCookie(cl.token).
+		Send(multiusers.AddAPITokenReq{Name: name, Scope: scope}).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	addResp := &multiusers.AddAPITokenResp{}
+	err := json.Unmarshal([]byte(body), addResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, addResp, errs
+}
+
+func (cl *UsersClient) ListAPITokens() (*http.Response, *multiusers.ListAPITokensResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/tokens")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	lsResp := &multiusers.ListAPITokensResp{}
+	err := json.Unmarshal([]byte(body), lsResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, lsResp, errs
+}
+
+func (cl *UsersClient) DelAPIToken(id uint64) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/my/tokens")).
+		AddCookie(cl.token).
+		Param(multiusers.APITokenIDQuery, fmt.Sprint(id)).
+		End()
+}
+
+func (cl *UsersClient) ListSessions() (*http.Response, *multiusers.ListSessionsResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/sessions")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	lsResp := &multiusers.ListSessionsResp{}
+	err := json.Unmarshal([]byte(body), lsResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, lsResp, errs
+}
+
+func (cl *UsersClient) DelSession(id uint64) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/my/sessions")).
+		AddCookie(cl.token).
+		Param(multiusers.SessionIDQuery, fmt.Sprint(id)).
+		End()
+}
+
+func (cl *UsersClient) DelSessions() (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/my/sessions/all")).
+		AddCookie(cl.token).
+		End()
+}
+
+func (cl *UsersClient) GetTOTP() (*http.Response, *multiusers.TOTPStatusResp, []error) {
+	resp, body, errs := cl.r.Get(cl.url("/v2/my/totp")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	statusResp := &multiusers.TOTPStatusResp{}
+	err := json.Unmarshal([]byte(body), statusResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, statusResp, errs
+}
+
+func (cl *UsersClient) SetupTOTP() (*http.Response, *multiusers.SetupTOTPResp, []error) {
+	resp, body, errs := cl.r.Post(cl.url("/v2/my/totp/setup")).
+		AddCookie(cl.token).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	setupResp := &multiusers.SetupTOTPResp{}
+	err := json.Unmarshal([]byte(body), setupResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, setupResp, errs
+}
+
+func (cl *UsersClient) EnableTOTP(code string) (*http.Response, *multiusers.RecoveryCodesResp, []error) {
+	return cl.recoveryCodesReq("/v2/my/totp/enable", code)
+}
+
+func (cl *UsersClient) RegenRecoveryCodes(code string) (*http.Response, *multiusers.RecoveryCodesResp, []error) {
+	return cl.recoveryCodesReq("/v2/my/totp/recovery-codes", code)
+}
+
+func (cl *UsersClient) recoveryCodesReq(urlpath, code string) (*http.Response, *multiusers.RecoveryCodesResp, []error) {
+	resp, body, errs := cl.r.Post(cl.url(urlpath)).
+		AddCookie(cl.token).
+		Send(multiusers.TOTPCodeReq{Code: code}).
+		End()
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	codesResp := &multiusers.RecoveryCodesResp{}
+	err := json.Unmarshal([]byte(body), codesResp)
+	if err != nil {
+		errs = append(errs, err)
+		return nil, nil, errs
+	}
+	return resp, codesResp, errs
+}
+
+func (cl *UsersClient) DisableTOTP(code, recoveryCode string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/my/totp/disable")).
+		AddCookie(cl.token).
+		Send(multiusers.TOTPCodeReq{Code: code, RecoveryCode: recoveryCode}).
+		End()
+}
+
+func (cl *UsersClient) SetUserTOTP(id uint64, forced bool) (*http.Response, string, []error) {
+	return cl.r.Patch(cl.url("/v2/admin/users/totp")).
+		AddCookie(cl.token).
+		Send(multiusers.SetUserTOTPReq{ID: id, Forced: forced}).
+		End()
+}
+
+func (cl *UsersClient) ResetUserTOTP(id uint64) (*http.Response, string, []error) {
+	return cl.r.Delete(cl.url("/v2/admin/users/totp")).
+		AddCookie(cl.token).
+		Param(handlers.UserIDParam, fmt.Sprint(id)).
+		End()
+}
+
+func (cl *UsersClient) LinkOIDCIdentity(id uint64, subject string) (*http.Response, string, []error) {
+	return cl.r.Post(cl.url("/v2/admin/users/identities/oidc")).
+		AddCookie(cl.token).
+		Send(multiusers.LinkOIDCIdentityReq{ID: id, Subject: subject}).
+		End()
+}
@@ -17,3 +17,13 @@ func NewMyCron() *MyCron {
 		Cron: cronv3.New(),
 	}
 }
+
+func (c *MyCron) AddFun(spec string, cmd func()) error {
+	_, err := c.Cron.AddFunc(spec, cmd)
+	return err
+}
+
+func (c *MyCron) Stop() {
+	// wait for running jobs
+	<-c.Cron.Stop().Done()
+}
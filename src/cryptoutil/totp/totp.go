@@ -0,0 +1,106 @@
+// Package totp implements time-based one-time passwords (RFC 6238) with HMAC-SHA1,
+// 6 digits and 30-second steps, which are the defaults of most authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30
+	// SecretSize is the size of generated secrets in bytes, RFC 4226 recommends 160 bits
+	SecretSize = 20
+)
+
+var (
+	ErrInvalidSecret = errors.New("invalid totp secret")
+
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenSecret generates a random secret encoded in base32
+func GenSecret() (string, error) {
+	buf := make([]byte, SecretSize)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// Step returns the time step of the time
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code of the step
+func Code(secret string, step int64) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, step), nil
+}
+
+func code(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	val := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, val%1000000)
+}
+
+// Verify checks the code against steps within the skew around the time,
+// it returns the matched step which should be recorded to reject replaying the code.
+func Verify(secret, inputCode string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(inputCode) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(code(key, step)), []byte(inputCode)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth URI which is encoded in QR codes for authenticator apps
+func ProvisioningURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(Period))
+
+	label := url.PathEscape(fmt.Sprintf("%s:%s", issuer, account))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, query.Encode())
+}
@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// test vectors of RFC 6238 (SHA1), truncated to 6 digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	t.Run("codes match RFC 6238 vectors", func(t *testing.T) {
+		vectors := map[int64]string{
+			59:         "287082",
+			1111111109: "081804",
+			1111111111: "050471",
+			1234567890: "005924",
+			2000000000: "279037",
+		}
+		for unixTime, expected := range vectors {
+			code, err := Code(secret, Step(time.Unix(unixTime, 0)))
+			if err != nil {
+				t.Fatal(err)
+			} else if code != expected {
+				t.Fatalf("code of %d: expected(%s) got(%s)", unixTime, expected, code)
+			}
+		}
+	})
+
+	t.Run("verify with skew", func(t *testing.T) {
+		now := time.Unix(1234567890, 0)
+		prevCode, err := Code(secret, Step(now)-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		step, ok := Verify(secret, prevCode, now, 1)
+		if !ok || step != Step(now)-1 {
+			t.Fatalf("code of the previous step should be accepted: %d %t", step, ok)
+		}
+		if _, ok = Verify(secret, prevCode, now, 0); ok {
+			t.Fatal("code of the previous step should be rejected without skew")
+		}
+		if _, ok = Verify(secret, "12345", now, 1); ok {
+			t.Fatal("short code should be rejected")
+		}
+		if _, ok = Verify("!invalid", prevCode, now, 1); ok {
+			t.Fatal("invalid secret should be rejected")
+		}
+	})
+
+	t.Run("generate secret and URI", func(t *testing.T) {
+		genSecret, err := GenSecret()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := decodeSecret(genSecret)
+		if err != nil || len(key) != SecretSize {
+			t.Fatalf("invalid generated secret: %s", genSecret)
+		}
+
+		uri := ProvisioningURI("Quickshare", "admin", genSecret)
+		if !strings.HasPrefix(uri, "otpauth://totp/Quickshare:admin?") ||
+			!strings.Contains(uri, "secret="+genSecret) ||
+			!strings.Contains(uri, "issuer=Quickshare") {
+			t.Fatalf("incorrect uri: %s", uri)
+		}
+	})
+}
@@ -2,9 +2,13 @@ package db
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"math/big"
 	"reflect"
+	"strings"
+	"time"
 )
 
 const (
@@ -24,6 +28,12 @@ const (
 	UserRole    = "user"
 	VisitorRole = "visitor"
 	BannedRole  = "banned"
+	// DropRole is the internal role of visitors uploading into upload-only sharings,
+	// it can not be assigned because role names can not start with "@"
+	DropRole = "@drop"
+
+	DefaultShareIDLen = 12
+	shareIDChars      = "abcdefghijklmnopqrstuvwxyz0123456789"
 
 	VisitorID   = uint64(1)
 	VisitorName = "visitor"
@@ -47,6 +57,27 @@ var (
 	// uploadings
 	ErrGreaterThanSize = errors.New("uploaded is greater than file size")
 	ErrUploadNotFound  = errors.New("upload info not found")
+	ErrInvalidRange    = errors.New("invalid upload range")
+	// trashes
+	ErrTrashNotFound = errors.New("trash not found")
+	// revisions
+	ErrRevisionNotFound = errors.New("revision not found")
+	// acls
+	ErrFileACLNotFound = errors.New("file acl not found")
+	// api tokens
+	ErrAPITokenNotFound = errors.New("api token not found")
+	// sessions
+	ErrSessionNotFound = errors.New("session not found")
+	// 2fa
+	ErrTOTPCodeUsed         = errors.New("totp code is already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	// external identities
+	ErrIdentityNotFound = errors.New("identity not found")
+	// roles
+	ErrRoleNotFound = errors.New("role not found")
+	// groups
+	ErrGroupNotFound       = errors.New("group not found")
+	ErrGroupMemberNotFound = errors.New("group member not found")
 
 	// site
 	ErrConfigNotFound = errors.New("site config not found")
@@ -95,6 +126,7 @@ var (
 	DefaultTheme      = "light"
 	DefaultAvatar     = ""
 	DefaultEmail      = ""
+	DefaultName       = ""
 
 	DefaultSpaceLimit         = int64(1024 * 1024 * 1024) // 1GB
 	DefaultUploadSpeedLimit   = 50 * 1024 * 1024          // 50MB
@@ -110,6 +142,7 @@ var (
 		Theme:      DefaultTheme,
 		Avatar:     DefaultAvatar,
 		Email:      DefaultEmail,
+		Name:       DefaultName,
 	}
 )
 
@@ -120,6 +153,209 @@ type FileInfo struct {
 	ShareID string `json:"shareID" yaml:"shareID"`
 	Sha1    string `json:"sha1" yaml:"sha1"`
 	Size    int64  `json:"size" yaml:"size"`
+	// Hashes are hex encoded checksums keyed by algorithms
+	Hashes map[string]string `json:"hashes,omitempty" yaml:"hashes,omitempty"`
+	// ExpectedHash is the hash provided by the uploader in format "algorithm:hex"
+	ExpectedHash string `json:"expectedHash,omitempty" yaml:"expectedHash,omitempty"`
+}
+
+type TrashInfo struct {
+	ID         uint64    `json:"id,string"`
+	UserID     uint64    `json:"userID,string"`
+	OriginPath string    `json:"originPath"`
+	TrashPath  string    `json:"trashPath"`
+	IsDir      bool      `json:"isDir"`
+	Size       int64     `json:"size"`
+	DeletedAt  time.Time `json:"deletedAt"`
+}
+
+// SharingStat is the access statistics of a sharing
+type SharingStat struct {
+	ListCount     int64 `json:"listCount"`
+	DownloadCount int64 `json:"downloadCount"`
+	// MaxDownloads disables the sharing once it is reached, it is unlimited if it is 0
+	MaxDownloads int64     `json:"maxDownloads"`
+	LastAccess   time.Time `json:"lastAccess"`
+	LastIP       string    `json:"lastIP"`
+}
+
+// SharingOptions are set in the transaction which creates the sharing, zero values are defaults
+type SharingOptions struct {
+	// ExpireAt is the zero time if the sharing never expires
+	ExpireAt time.Time
+	// PwdHash is the bcrypt hash of the password, the sharing is not protected if it is empty
+	PwdHash string
+	// MaxDownloads is unlimited if it is 0
+	MaxDownloads int64
+	// Drop makes the sharing upload-only if it is not nil
+	Drop *SharingDrop
+	// IDLen is the length of the generated share ID, DefaultShareIDLen is used if it is 0
+	IDLen int
+}
+
+// SharingDrop is the upload-only sharing, visitors can upload files into it but can not list or download them.
+// Uploaded files are charged to the owner.
+type SharingDrop struct {
+	OwnerID uint64 `json:"ownerID,string"`
+	MaxSize int64  `json:"maxSize"`
+	// MaxFiles limits the number of uploaded files, it is unlimited if it is 0
+	MaxFiles  int64 `json:"maxFiles"`
+	UsedSize  int64 `json:"usedSize"`
+	FileCount int64 `json:"fileCount"`
+}
+
+const (
+	// APIScopeReadOnly tokens can only call read APIs (GET, HEAD and OPTIONS)
+	APIScopeReadOnly = "read-only"
+	// APIScopeReadWrite tokens act as a normal user even if the owner is an admin
+	APIScopeReadWrite = "read-write"
+	// APIScopeAdmin tokens act as the owner, they can only be created by admins
+	APIScopeAdmin = "admin"
+)
+
+// APIToken is the long-lived token for scripts, only the hash of the token is stored.
+type APIToken struct {
+	ID         uint64    `json:"id,string"`
+	UserID     uint64    `json:"userID,string"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	TokenHash  string    `json:"-"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+// UserTOTP is the two-factor authentication state of a user, users without records have 2FA disabled.
+type UserTOTP struct {
+	UserID uint64 `json:"userID,string"`
+	// Secret is set but Enabled is false before the enrollment is verified
+	Secret  string `json:"-"`
+	Enabled bool   `json:"enabled"`
+	// Forced is set by admins, users must enroll before logging in and can not disable it
+	Forced bool `json:"forced"`
+	// RecoveryCodes are hashes of unused recovery codes
+	RecoveryCodes []string `json:"-"`
+	// LastStep is the last used time step, codes of it and previous steps are rejected
+	LastStep int64 `json:"-"`
+}
+
+// Session is created by logging in, the token cookie is valid only if its session exists.
+type Session struct {
+	ID        uint64    `json:"id,string"`
+	UserID    uint64    `json:"userID,string"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpireAt  time.Time `json:"expireAt"`
+}
+
+const (
+	// CapRead allows listing, downloading and reading metadata of accessible files
+	CapRead = "read"
+	// CapWrite allows creating, uploading, moving and deleting accessible files
+	CapWrite = "write"
+	// CapShare allows managing sharings, file grants and uploadings
+	CapShare = "share"
+	// CapAllFiles makes all files accessible, otherwise only the home folder and granted files are accessible
+	CapAllFiles = "allFiles"
+)
+
+var Capabilities = map[string]bool{
+	CapRead:     true,
+	CapWrite:    true,
+	CapShare:    true,
+	CapAllFiles: true,
+}
+
+// IsPredefinedRole returns true if the role is built in, predefined roles can not be edited.
+func IsPredefinedRole(role string) bool {
+	return role == AdminRole || role == UserRole || role == VisitorRole || role == BannedRole
+}
+
+// APIRule allows requests of the method to the path, the method "*" matches all methods.
+// If Prefix is true, the rule also matches paths under the path.
+type APIRule struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Prefix bool   `json:"prefix"`
+}
+
+// Role is a custom role, its users can call public APIs and APIs matched by its rules,
+// and its capabilities decide which file operations are allowed.
+type Role struct {
+	Name         string     `json:"name"`
+	Rules        []*APIRule `json:"rules"`
+	Capabilities []string   `json:"capabilities"`
+}
+
+// HasCap returns true if the role has the capability
+func (role *Role) HasCap(capability string) bool {
+	for _, roleCap := range role.Capabilities {
+		if roleCap == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupLocationPrefix is the prefix of group folders, group files are under <GroupLocationPrefix><groupName>/,
+// so user names can not start with it.
+const GroupLocationPrefix = "@"
+
+// GroupLocation returns the root folder of files of the group
+func GroupLocation(groupName string) string {
+	return GroupLocationPrefix + groupName
+}
+
+// LocationGroupName returns the group name of the group folder, it is empty if the location is not a group folder.
+func LocationGroupName(location string) string {
+	if !strings.HasPrefix(location, GroupLocationPrefix) {
+		return ""
+	}
+	return location[len(GroupLocationPrefix):]
+}
+
+// Group owns a group folder, uploads into the folder are charged against the quota of the group.
+type Group struct {
+	ID        uint64 `json:"id,string"`
+	Name      string `json:"name"`
+	UsedSpace int64  `json:"usedSpace,string"`
+	Quota     *Quota `json:"quota"`
+}
+
+// GroupMember accesses the group folder by its permission, PermRead or PermReadWrite.
+type GroupMember struct {
+	GroupID uint64 `json:"groupID,string"`
+	UserID  uint64 `json:"userID,string"`
+	Perm    string `json:"perm"`
+}
+
+const (
+	GranteeUser  = "user"
+	GranteeGroup = "group"
+
+	PermRead      = "r"
+	PermReadWrite = "rw"
+)
+
+// FileACL grants a user or a group access to a file or a folder, and all items under the folder.
+type FileACL struct {
+	ID          uint64    `json:"id,string"`
+	Path        string    `json:"path"`
+	GranteeType string    `json:"granteeType"`
+	GranteeID   uint64    `json:"granteeID,string"`
+	Perm        string    `json:"perm"`
+	GranterID   uint64    `json:"granterID,string"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type Revision struct {
+	ID          uint64    `json:"id,string"`
+	Path        string    `json:"path"`
+	UploaderID  uint64    `json:"uploaderID,string"`
+	Sha1        string    `json:"sha1"`
+	Size        int64     `json:"size"`
+	StoragePath string    `json:"-"`
+	CreatedAt   time.Time `json:"createdAt"`
 }
 
 type UserCfg struct {
@@ -142,6 +378,8 @@ type Preferences struct {
 	Theme      string    `json:"theme" yaml:"theme"`
 	Avatar     string    `json:"avatar" yaml:"avatar"`
 	Email      string    `json:"email" yaml:"email"`
+	// Name is the display name, it is synced from the directory for LDAP users
+	Name string `json:"name" yaml:"name"`
 }
 
 type SiteConfig struct {
@@ -180,6 +418,22 @@ type UploadInfo struct {
 	Uploaded     int64  `json:"uploaded" yaml:"uploaded"`
 }
 
+// UploadRange is a received range [Start, End) of the uploading file
+type UploadRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// UploadParts describes the uploading file which is uploaded in parts,
+// Ranges are sorted and merged, so Uploaded is the sum of their sizes.
+type UploadParts struct {
+	RealFilePath string         `json:"realFilePath"`
+	Size         int64          `json:"size"`
+	Uploaded     int64          `json:"uploaded"`
+	PartSize     int64          `json:"partSize"`
+	Ranges       []*UploadRange `json:"ranges"`
+}
+
 type IUserStore interface {
 	Init(ctx context.Context, rootName, rootPwd string) error
 	IsInited() bool
@@ -233,6 +487,24 @@ func ComparePreferences(p1, p2 *Preferences) bool {
 		reflect.DeepEqual(p1.Bg, p2.Bg)
 }
 
+// GenShareID generates a random share ID which consists of lowercase letters and digits.
+func GenShareID(length int) (string, error) {
+	if length <= 0 {
+		return "", ErrEmpty
+	}
+
+	max := big.NewInt(int64(len(shareIDChars)))
+	shareID := make([]byte, length)
+	for i := range shareID {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		shareID[i] = shareIDChars[n.Int64()]
+	}
+	return string(shareID), nil
+}
+
 func UploadNS(user string) string {
 	return fmt.Sprintf("%s/%s", uploadsPrefix, user)
 }
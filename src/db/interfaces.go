@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -30,12 +31,30 @@ type IDBQuickshare interface {
 	InitUserTable(ctx context.Context, tx *sql.Tx, rootName, rootPwd string) error
 	InitFileTables(ctx context.Context, tx *sql.Tx) error
 	InitConfigTable(ctx context.Context, tx *sql.Tx, cfg *SiteConfig) error
+	InitTrashTable(ctx context.Context, tx *sql.Tx) error
+	InitRevisionTable(ctx context.Context, tx *sql.Tx) error
+	InitSharingStatTable(ctx context.Context, tx *sql.Tx) error
+	InitSharingDropTable(ctx context.Context, tx *sql.Tx) error
+	InitFileACLTable(ctx context.Context, tx *sql.Tx) error
+	InitAPITokenTable(ctx context.Context, tx *sql.Tx) error
+	InitSessionTable(ctx context.Context, tx *sql.Tx) error
+	InitUserTOTPTable(ctx context.Context, tx *sql.Tx) error
+	InitUserIdentityTable(ctx context.Context, tx *sql.Tx) error
+	InitRoleTable(ctx context.Context, tx *sql.Tx) error
+	InitGroupTables(ctx context.Context, tx *sql.Tx) error
+	Upgrade(ctx context.Context) error
 	Close() error
 	IDBLockable
 	IUserDB
+	IGroupDB
+	IAPITokenDB
+	ISessionDB
 	IFileDB
 	IUploadDB
 	ISharingDB
+	ITrashDB
+	IRevisionDB
+	IFileACLDB
 	IConfigDB
 }
 
@@ -58,15 +77,55 @@ type IUserDB interface {
 	ResetUsed(ctx context.Context, id uint64, used int64) error
 	ListUsers(ctx context.Context) ([]*User, error)
 	ListUserIDs(ctx context.Context) (map[string]string, error)
-	AddRole(role string) error
-	DelRole(role string) error
-	ListRoles() (map[string]bool, error)
+	GetUserTOTP(ctx context.Context, userId uint64) (*UserTOTP, error)
+	SetUserTOTP(ctx context.Context, totp *UserTOTP) error
+	UseTOTPStep(ctx context.Context, userId uint64, step int64) error
+	UseRecoveryCode(ctx context.Context, userId uint64, codeHash string) error
+	GetIdentityUser(ctx context.Context, provider, subject string) (uint64, error)
+	AddUserIdentity(ctx context.Context, provider, subject string, userId uint64) error
+	AddRole(ctx context.Context, role *Role) error
+	GetRole(ctx context.Context, name string) (*Role, error)
+	SetRole(ctx context.Context, role *Role) error
+	DelRole(ctx context.Context, name string) error
+	ListRoles(ctx context.Context) ([]*Role, error)
+}
+
+type IGroupDB interface {
+	AddGroup(ctx context.Context, group *Group) error
+	GetGroup(ctx context.Context, id uint64) (*Group, error)
+	GetGroupByName(ctx context.Context, name string) (*Group, error)
+	SetGroupQuota(ctx context.Context, id uint64, quota *Quota) error
+	DelGroup(ctx context.Context, id uint64) ([]string, error)
+	ListGroups(ctx context.Context) ([]*Group, error)
+	SetGroupMember(ctx context.Context, member *GroupMember) error
+	DelGroupMember(ctx context.Context, groupId, userId uint64) error
+	ListGroupMembers(ctx context.Context, groupId uint64) ([]*GroupMember, error)
+	ListUserGroups(ctx context.Context, userId uint64) ([]*GroupMember, error)
+}
+
+type IAPITokenDB interface {
+	AddAPIToken(ctx context.Context, token *APIToken) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error)
+	ListAPITokens(ctx context.Context, userId uint64) ([]*APIToken, error)
+	DelAPIToken(ctx context.Context, userId, id uint64) error
+	SetAPITokenUsed(ctx context.Context, id uint64, usedAt time.Time) error
+}
+
+type ISessionDB interface {
+	AddSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, id uint64) (*Session, error)
+	ListSessions(ctx context.Context, userId uint64) ([]*Session, error)
+	DelSession(ctx context.Context, userId, id uint64) error
+	DelSessions(ctx context.Context, userId, exceptId uint64) error
 }
 
 type IFilesFunctions interface {
 	IFileDB
 	IUploadDB
 	ISharingDB
+	ITrashDB
+	IRevisionDB
+	IFileACLDB
 }
 
 type IFileDB interface {
@@ -74,8 +133,10 @@ type IFileDB interface {
 	DelFileInfo(ctx context.Context, userId uint64, itemPath string) error
 	GetFileInfo(ctx context.Context, itemPath string) (*FileInfo, error)
 	SetSha1(ctx context.Context, itemPath, sign string) error
+	SetHashes(ctx context.Context, itemPath string, hashes map[string]string) error
 	MoveFileInfo(ctx context.Context, userId uint64, oldPath, newPath string, isDir bool) error
 	ListFileInfos(ctx context.Context, itemPaths []string) (map[string]*FileInfo, error)
+	ListFilesBySha1(ctx context.Context, sha1 string, size int64) ([]string, error)
 }
 type IUploadDB interface {
 	AddUploadInfos(ctx context.Context, uploadId, userId uint64, tmpPath, filePath string, info *FileInfo) error
@@ -84,14 +145,59 @@ type IUploadDB interface {
 	SetUploadInfo(ctx context.Context, user uint64, filePath string, newUploaded int64) error
 	GetUploadInfo(ctx context.Context, userId uint64, filePath string) (string, int64, int64, error)
 	ListUploadInfos(ctx context.Context, user uint64) ([]*UploadInfo, error)
+	SetUploadPartSize(ctx context.Context, userId uint64, filePath string, partSize int64) error
+	GetUploadParts(ctx context.Context, userId uint64, filePath string) (*UploadParts, error)
+	AddUploadRange(ctx context.Context, userId uint64, filePath string, uploadRange *UploadRange) (*UploadParts, error)
+	SetUploadExpectedHash(ctx context.Context, userId uint64, filePath, expectedHash string) error
+	GetUploadExpectedHash(ctx context.Context, userId uint64, filePath string) (string, error)
 }
 
 type ISharingDB interface {
 	IsSharing(ctx context.Context, dirPath string) (bool, error)
 	GetSharingDir(ctx context.Context, hashID string) (string, error)
-	AddSharing(ctx context.Context, infoId, userId uint64, dirPath string) error
+	AddSharing(ctx context.Context, infoId, userId uint64, dirPath string, opts *SharingOptions) error
+	AddSharingWithID(ctx context.Context, infoId, userId uint64, dirPath, shareID string, opts *SharingOptions) error
 	DelSharing(ctx context.Context, userId uint64, dirPath string) error
 	ListSharingsByLocation(ctx context.Context, location string) (map[string]string, error)
+	SetSharingExpiry(ctx context.Context, dirPath string, expireAt time.Time) error
+	ListSharingExpiries(ctx context.Context, location string) (map[string]time.Time, error)
+	DelExpiredSharings(ctx context.Context, expiredBefore time.Time) (int64, error)
+	GetSharingPwd(ctx context.Context, dirPath string) (string, error)
+	SetSharingPwd(ctx context.Context, dirPath, pwdHash string) error
+	SetSharingMaxDownloads(ctx context.Context, dirPath string, maxDownloads int64) error
+	AddSharingListing(ctx context.Context, dirPath, ip string) error
+	AddSharingDownload(ctx context.Context, dirPath, ip string) error
+	ListSharingStats(ctx context.Context, location string) (map[string]*SharingStat, error)
+	SetSharingDrop(ctx context.Context, dirPath string, drop *SharingDrop) error
+	GetSharingDrop(ctx context.Context, dirPath string) (*SharingDrop, error)
+	AddSharingDropUsage(ctx context.Context, dirPath string, size, files int64) error
+	SetUploadDrop(ctx context.Context, userId uint64, filePath, dropPath string) error
+}
+
+type ITrashDB interface {
+	AddTrash(ctx context.Context, trash *TrashInfo) error
+	GetTrash(ctx context.Context, id uint64) (*TrashInfo, error)
+	ListTrashes(ctx context.Context, userId uint64) ([]*TrashInfo, error)
+	ListExpiredTrashes(ctx context.Context, deletedBefore time.Time) ([]*TrashInfo, error)
+	RestoreTrash(ctx context.Context, id uint64) error
+	DelTrash(ctx context.Context, id uint64) error
+}
+
+type IRevisionDB interface {
+	AddRevision(ctx context.Context, revision *Revision) error
+	GetRevision(ctx context.Context, id uint64) (*Revision, error)
+	ListRevisions(ctx context.Context, itemPath string) ([]*Revision, error)
+	RestoreRevision(ctx context.Context, id, infoId uint64) error
+	PruneRevisions(ctx context.Context, itemPath string, keep int) ([]*Revision, error)
+}
+
+type IFileACLDB interface {
+	AddFileACL(ctx context.Context, acl *FileACL) error
+	GetFileACL(ctx context.Context, id uint64) (*FileACL, error)
+	DelFileACL(ctx context.Context, id uint64) error
+	ListFileACLs(ctx context.Context, itemPath string) ([]*FileACL, error)
+	ListGranteeFileACLs(ctx context.Context, granteeType string, granteeIds []uint64) ([]*FileACL, error)
+	MatchFileACLs(ctx context.Context, itemPath, granteeType string, granteeIds []uint64) ([]*FileACL, error)
 }
 
 type IConfigDB interface {
@@ -51,6 +51,25 @@ func (st *BaseStore) getFileInfo(ctx context.Context, tx *sql.Tx, itemPath strin
 	return fInfo, nil
 }
 
+// getFileInfoAccount returns the ID of the user or the group charged for the item
+func (st *BaseStore) getFileInfoAccount(ctx context.Context, tx *sql.Tx, itemPath string) (uint64, error) {
+	var accountId uint64
+	err := tx.QueryRowContext(
+		ctx,
+		`select user
+		from t_file_info
+		where path=?`,
+		itemPath,
+	).Scan(&accountId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, db.ErrFileInfoNotFound
+		}
+		return 0, err
+	}
+	return accountId, nil
+}
+
 func (st *BaseStore) GetFileInfo(ctx context.Context, itemPath string) (*db.FileInfo, error) {
 	tx, err := st.db.BeginTx(ctx, txOpts)
 	if err != nil {
@@ -151,14 +170,14 @@ func (st *BaseStore) addFileInfo(ctx context.Context, tx *sql.Tx, infoId, userId
 		ctx,
 		`insert into t_file_info (
 			id, path, user, location, parent, name,
-			is_dir, size, share_id, info
+			is_dir, size, share_id, sha1, info
 		)
 		values (
 			?, ?, ?, ?, ?, ?,
-			?, ?, ?, ?
+			?, ?, ?, ?, ?
 		)`,
 		infoId, itemPath, userId, location, dirPath, itemName,
-		info.IsDir, info.Size, info.ShareID, infoStr,
+		info.IsDir, info.Size, info.ShareID, info.Sha1, infoStr,
 	)
 	return err
 }
@@ -170,13 +189,17 @@ func (st *BaseStore) AddFileInfo(ctx context.Context, infoId, userId uint64, ite
 	}
 	defer tx.Rollback()
 
-	err = st.addFileInfo(ctx, tx, infoId, userId, itemPath, info)
+	accountId, err := st.chargedAccount(ctx, tx, userId, itemPath)
+	if err != nil {
+		return err
+	}
+	err = st.addFileInfo(ctx, tx, infoId, accountId, itemPath, info)
 	if err != nil {
 		return err
 	}
 
 	// increase used space
-	err = st.setUsed(ctx, tx, userId, true, info.Size)
+	err = st.setUsed(ctx, tx, accountId, true, info.Size)
 	if err != nil {
 		return err
 	}
@@ -216,8 +239,51 @@ func (st *BaseStore) SetSha1(ctx context.Context, itemPath, sign string) error {
 	_, err = tx.ExecContext(
 		ctx,
 		`update t_file_info
-		set info=?
+		set sha1=?, info=?
 		where path=?`,
+		sign,
+		infoStr,
+		itemPath,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetHashes merges checksums into the file info, the sha1 column is also updated if sha1 is included.
+func (st *BaseStore) SetHashes(ctx context.Context, itemPath string, hashes map[string]string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	info, err := st.getFileInfo(ctx, tx, itemPath)
+	if err != nil {
+		return err
+	}
+	if info.Hashes == nil {
+		info.Hashes = map[string]string{}
+	}
+	for algo, sign := range hashes {
+		info.Hashes[algo] = sign
+	}
+	if sha1Sign, ok := hashes["sha1"]; ok {
+		info.Sha1 = sha1Sign
+	}
+
+	infoStr, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_file_info
+		set sha1=?, info=?
+		where path=?`,
+		info.Sha1,
 		infoStr,
 		itemPath,
 	)
@@ -239,10 +305,11 @@ func (st *BaseStore) DelFileInfo(ctx context.Context, userID uint64, itemPath st
 		ctx,
 		`select path, size
 		from t_file_info
-		where path = ? or path like ?
+		where path = ? or substr(path, 1, length(?)+1) = ? || '/'
 		`,
 		itemPath,
-		fmt.Sprintf("%s/%%", itemPath),
+		itemPath,
+		itemPath,
 	)
 	if err != nil {
 		return err
@@ -265,7 +332,11 @@ func (st *BaseStore) DelFileInfo(ctx context.Context, userID uint64, itemPath st
 	}
 
 	// decrease used space
-	err = st.setUsed(ctx, tx, userID, false, decrSize)
+	accountId, err := st.chargedAccount(ctx, tx, userID, itemPath)
+	if err != nil {
+		return err
+	}
+	err = st.setUsed(ctx, tx, accountId, false, decrSize)
 	if err != nil {
 		return err
 	}
@@ -284,6 +355,11 @@ func (st *BaseStore) DelFileInfo(ctx context.Context, userID uint64, itemPath st
 		return err
 	}
 
+	err = st.delFileACLs(ctx, tx, itemPath)
+	if err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
@@ -294,21 +370,47 @@ func (st *BaseStore) MoveFileInfo(ctx context.Context, userId uint64, oldPath, n
 	}
 	defer tx.Rollback()
 
+	// grants are moved with the item even if it has no info
+	err = st.moveFileACLs(ctx, tx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
 	info, err := st.getFileInfo(ctx, tx, oldPath)
 	if err != nil {
 		if errors.Is(err, db.ErrFileInfoNotFound) {
 			// info for file does not exist so no need to move it
 			// e.g. folder info is not created before
 			// TODO: but sometimes it could be a bug
-			return nil
+			return tx.Commit()
 		}
 		return err
 	}
+	oldAccountId, err := st.getFileInfoAccount(ctx, tx, oldPath)
+	if err != nil {
+		return err
+	}
 	err = st.delFileInfo(ctx, tx, oldPath)
 	if err != nil {
 		return err
 	}
-	err = st.addFileInfo(ctx, tx, info.Id, userId, newPath, info)
+
+	// the space is moved to another account if the item is moved in or out of a group folder
+	accountId, err := st.movedAccount(ctx, tx, oldAccountId, userId, newPath)
+	if err != nil {
+		return err
+	}
+	if accountId != oldAccountId {
+		err = st.setUsed(ctx, tx, oldAccountId, false, info.Size)
+		if err != nil {
+			return err
+		}
+		err = st.setUsed(ctx, tx, accountId, true, info.Size)
+		if err != nil {
+			return err
+		}
+	}
+	err = st.addFileInfo(ctx, tx, info.Id, accountId, newPath, info)
 	if err != nil {
 		return err
 	}
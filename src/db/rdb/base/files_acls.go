@@ -0,0 +1,240 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// AddFileACL grants the grantee access to the path,
+// the permission and the granter are updated if the grantee is already granted on the path.
+func (st *BaseStore) AddFileACL(ctx context.Context, acl *db.FileACL) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_file_acl (
+			id, path, grantee_type, grantee_id, perm, granter, created
+		)
+		values (?, ?, ?, ?, ?, ?, ?)
+		on conflict(path, grantee_type, grantee_id) do update set
+			perm=excluded.perm,
+			granter=excluded.granter`,
+		acl.ID, acl.Path, acl.GranteeType, acl.GranteeID,
+		acl.Perm, acl.GranterID, acl.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) GetFileACL(ctx context.Context, id uint64) (*db.FileACL, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var created int64
+	acl := &db.FileACL{}
+	err = tx.QueryRowContext(
+		ctx,
+		`select id, path, grantee_type, grantee_id, perm, granter, created
+		from t_file_acl
+		where id=?`,
+		id,
+	).Scan(
+		&acl.ID,
+		&acl.Path,
+		&acl.GranteeType,
+		&acl.GranteeID,
+		&acl.Perm,
+		&acl.GranterID,
+		&created,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrFileACLNotFound
+		}
+		return nil, err
+	}
+	acl.CreatedAt = time.Unix(created, 0)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func (st *BaseStore) DelFileACL(ctx context.Context, id uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_acl
+		where id=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// delFileACLs deletes grants on the item and its children
+func (st *BaseStore) delFileACLs(ctx context.Context, tx *sql.Tx, itemPath string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`delete from t_file_acl
+		where path = ? or substr(path, 1, length(?)+1) = ? || '/'`,
+		itemPath,
+		itemPath,
+		itemPath,
+	)
+	return err
+}
+
+// moveFileACLs moves grants on the item and its children to the new path
+func (st *BaseStore) moveFileACLs(ctx context.Context, tx *sql.Tx, oldPath, newPath string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`update t_file_acl
+		set path = ? || substr(path, length(?)+1)
+		where path = ? or substr(path, 1, length(?)+1) = ? || '/'`,
+		newPath,
+		oldPath,
+		oldPath,
+		oldPath,
+		oldPath,
+	)
+	return err
+}
+
+// ListFileACLs lists grants on the path, grants on its parent folders are not included.
+func (st *BaseStore) ListFileACLs(ctx context.Context, itemPath string) ([]*db.FileACL, error) {
+	return st.listFileACLs(
+		ctx,
+		`select id, path, grantee_type, grantee_id, perm, granter, created
+		from t_file_acl
+		where path=?
+		order by created, id`,
+		itemPath,
+	)
+}
+
+// ListGranteeFileACLs lists grants to the grantees, they are the items shared with the grantees.
+func (st *BaseStore) ListGranteeFileACLs(ctx context.Context, granteeType string, granteeIds []uint64) ([]*db.FileACL, error) {
+	if len(granteeIds) == 0 {
+		return []*db.FileACL{}, nil
+	}
+
+	placeholders := []string{}
+	values := []any{granteeType}
+	for _, granteeId := range granteeIds {
+		placeholders = append(placeholders, "?")
+		values = append(values, granteeId)
+	}
+	return st.listFileACLs(
+		ctx,
+		fmt.Sprintf(
+			`select id, path, grantee_type, grantee_id, perm, granter, created
+			from t_file_acl
+			where grantee_type=? and grantee_id in (%s)
+			order by created, id`,
+			strings.Join(placeholders, ","),
+		),
+		values...,
+	)
+}
+
+// MatchFileACLs lists grants to the grantees which apply to the path,
+// they are grants on the path or on any of its parent folders.
+func (st *BaseStore) MatchFileACLs(ctx context.Context, itemPath, granteeType string, granteeIds []uint64) ([]*db.FileACL, error) {
+	if len(granteeIds) == 0 {
+		return []*db.FileACL{}, nil
+	}
+
+	pathPlaceholders := []string{}
+	values := []any{}
+	for itemPath = path.Clean(itemPath); ; itemPath = path.Dir(itemPath) {
+		pathPlaceholders = append(pathPlaceholders, "?")
+		values = append(values, itemPath)
+		if itemPath == "." || itemPath == "/" {
+			break
+		}
+	}
+	idPlaceholders := []string{}
+	values = append(values, granteeType)
+	for _, granteeId := range granteeIds {
+		idPlaceholders = append(idPlaceholders, "?")
+		values = append(values, granteeId)
+	}
+	return st.listFileACLs(
+		ctx,
+		fmt.Sprintf(
+			`select id, path, grantee_type, grantee_id, perm, granter, created
+			from t_file_acl
+			where path in (%s) and grantee_type=? and grantee_id in (%s)`,
+			strings.Join(pathPlaceholders, ","),
+			strings.Join(idPlaceholders, ","),
+		),
+		values...,
+	)
+}
+
+func (st *BaseStore) listFileACLs(ctx context.Context, query string, args ...any) ([]*db.FileACL, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var created int64
+	acls := []*db.FileACL{}
+	for rows.Next() {
+		acl := &db.FileACL{}
+		err = rows.Scan(
+			&acl.ID,
+			&acl.Path,
+			&acl.GranteeType,
+			&acl.GranteeID,
+			&acl.Perm,
+			&acl.GranterID,
+			&created,
+		)
+		if err != nil {
+			return nil, err
+		}
+		acl.CreatedAt = time.Unix(created, 0)
+		acls = append(acls, acl)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return acls, nil
+}
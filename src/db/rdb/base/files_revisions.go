@@ -0,0 +1,266 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// AddRevision turns the file info of the path into a revision.
+// The space stays charged to the uploader of the file,
+// if the file info does not exist, the space is charged to revision.UploaderID or the group of the group folder.
+func (st *BaseStore) AddRevision(ctx context.Context, revision *db.Revision) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userId uint64
+	var size int64
+	var infoStr string
+	err = tx.QueryRowContext(
+		ctx,
+		`select user, size, info
+		from t_file_info
+		where path=?`,
+		revision.Path,
+	).Scan(&userId, &size, &infoStr)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		revision.UploaderID, err = st.chargedAccount(ctx, tx, revision.UploaderID, revision.Path)
+		if err != nil {
+			return err
+		}
+		err = st.setUsed(ctx, tx, revision.UploaderID, true, revision.Size)
+		if err != nil {
+			return err
+		}
+	} else {
+		info := &db.FileInfo{}
+		err = json.Unmarshal([]byte(infoStr), info)
+		if err != nil {
+			return err
+		}
+		revision.UploaderID, revision.Size, revision.Sha1 = userId, size, info.Sha1
+
+		err = st.delFileInfo(ctx, tx, revision.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_file_revision (
+			id, path, user, sha1, size, storage_path, created
+		)
+		values (?, ?, ?, ?, ?, ?, ?)`,
+		revision.ID, revision.Path, revision.UploaderID, revision.Sha1,
+		revision.Size, revision.StoragePath, revision.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) getRevision(ctx context.Context, tx *sql.Tx, id uint64) (*db.Revision, error) {
+	var created int64
+	revision := &db.Revision{}
+	err := tx.QueryRowContext(
+		ctx,
+		`select id, path, user, sha1, size, storage_path, created
+		from t_file_revision
+		where id=?`,
+		id,
+	).Scan(
+		&revision.ID,
+		&revision.Path,
+		&revision.UploaderID,
+		&revision.Sha1,
+		&revision.Size,
+		&revision.StoragePath,
+		&created,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrRevisionNotFound
+		}
+		return nil, err
+	}
+
+	revision.CreatedAt = time.Unix(created, 0)
+	return revision, nil
+}
+
+func (st *BaseStore) GetRevision(ctx context.Context, id uint64) (*db.Revision, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revision, err := st.getRevision(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+// listRevisions returns revisions of the path, the latest one comes first.
+func (st *BaseStore) listRevisions(ctx context.Context, tx *sql.Tx, itemPath string) ([]*db.Revision, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`select id, path, user, sha1, size, storage_path, created
+		from t_file_revision
+		where path=?
+		order by created desc, id desc`,
+		itemPath,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*db.Revision{}
+	for rows.Next() {
+		var created int64
+		revision := &db.Revision{}
+		err = rows.Scan(
+			&revision.ID,
+			&revision.Path,
+			&revision.UploaderID,
+			&revision.Sha1,
+			&revision.Size,
+			&revision.StoragePath,
+			&created,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		revision.CreatedAt = time.Unix(created, 0)
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (st *BaseStore) ListRevisions(ctx context.Context, itemPath string) ([]*db.Revision, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revisions, err := st.listRevisions(ctx, tx, itemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// RestoreRevision turns the revision back into the file info of its path,
+// the current file info of the path must be turned into a revision before.
+func (st *BaseStore) RestoreRevision(ctx context.Context, id, infoId uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	revision, err := st.getRevision(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = st.getFileInfo(ctx, tx, revision.Path)
+	if err == nil {
+		return db.ErrConflicted
+	} else if !errors.Is(err, db.ErrFileInfoNotFound) {
+		return err
+	}
+
+	err = st.addFileInfo(ctx, tx, infoId, revision.UploaderID, revision.Path, &db.FileInfo{
+		Size: revision.Size,
+		Sha1: revision.Sha1,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_revision
+		where id=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PruneRevisions keeps the latest revisions of the path and releases the space of others,
+// pruned revisions are returned so that their content can be removed.
+func (st *BaseStore) PruneRevisions(ctx context.Context, itemPath string, keep int) ([]*db.Revision, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	revisions, err := st.listRevisions(ctx, tx, itemPath)
+	if err != nil {
+		return nil, err
+	} else if len(revisions) <= keep {
+		return []*db.Revision{}, nil
+	}
+
+	pruned := revisions[keep:]
+	for _, revision := range pruned {
+		_, err = tx.ExecContext(
+			ctx,
+			`delete from t_file_revision
+			where id=?`,
+			revision.ID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = st.setUsed(ctx, tx, revision.UploaderID, false, revision.Size)
+		if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return pruned, nil
+}
@@ -0,0 +1,118 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// maxSha1Matches limits the number of files returned by ListFilesBySha1
+const maxSha1Matches = 32
+
+// initSha1Column copies sha1 out of infos into an indexed column,
+// so that files with identical content can be found.
+func (st *BaseStore) initSha1Column(ctx context.Context, tx *sql.Tx) error {
+	added, err := st.addColumn(ctx, tx, "t_file_info", "sha1", "varchar not null default ''")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_file_sha1 on t_file_info (sha1, size)`,
+	)
+	if err != nil || !added {
+		return err
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select id, info
+		from t_file_info
+		where is_dir=false`,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sha1s := map[uint64]string{}
+	for rows.Next() {
+		var id uint64
+		var infoStr string
+		err = rows.Scan(&id, &infoStr)
+		if err != nil {
+			return err
+		}
+
+		info := &db.FileInfo{}
+		err = json.Unmarshal([]byte(infoStr), info)
+		if err != nil {
+			return err
+		} else if info.Sha1 != "" {
+			sha1s[id] = info.Sha1
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for id, sha1Sign := range sha1s {
+		_, err = tx.ExecContext(
+			ctx,
+			`update t_file_info
+			set sha1=?
+			where id=?`,
+			sha1Sign, id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFilesBySha1 returns paths of files with the sha1 and size, the earliest added ones come first.
+func (st *BaseStore) ListFilesBySha1(ctx context.Context, sha1Sign string, size int64) ([]string, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select path
+		from t_file_info
+		where sha1=? and size=? and is_dir=false
+		order by id
+		limit ?`,
+		sha1Sign, size, maxSha1Matches,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	itemPaths := []string{}
+	for rows.Next() {
+		var itemPath string
+		err = rows.Scan(&itemPath)
+		if err != nil {
+			return nil, err
+		}
+		itemPaths = append(itemPaths, itemPath)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return itemPaths, nil
+}
@@ -0,0 +1,189 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *BaseStore) getSharingDrop(ctx context.Context, tx *sql.Tx, shareId string) (*db.SharingDrop, error) {
+	drop := &db.SharingDrop{}
+	err := tx.QueryRowContext(
+		ctx,
+		`select owner, max_size, max_files, used_size, file_count
+		from t_sharing_drop
+		where share_id=?`,
+		shareId,
+	).Scan(
+		&drop.OwnerID,
+		&drop.MaxSize,
+		&drop.MaxFiles,
+		&drop.UsedSize,
+		&drop.FileCount,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrSharingNotFound
+		}
+		return nil, err
+	}
+	return drop, nil
+}
+
+// SetSharingDrop turns the sharing into an upload-only sharing, its usage is kept if it is a drop already
+func (st *BaseStore) SetSharingDrop(ctx context.Context, dirPath string, drop *db.SharingDrop) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	shareId, err := st.getSharingID(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_sharing_drop (share_id, owner, max_size, max_files)
+		values (?, ?, ?, ?)
+		on conflict(share_id) do update set
+			owner=excluded.owner,
+			max_size=excluded.max_size,
+			max_files=excluded.max_files`,
+		shareId, drop.OwnerID, drop.MaxSize, drop.MaxFiles,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSharingDrop returns ErrSharingNotFound if the path is not an unexpired upload-only sharing
+func (st *BaseStore) GetSharingDrop(ctx context.Context, dirPath string) (*db.SharingDrop, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	shareId, err := st.getSharingID(ctx, tx, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	drop, err := st.getSharingDrop(ctx, tx, shareId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return drop, nil
+}
+
+// initUploadDropColumn adds the column of the drop which is charged for the upload,
+// it is empty if the upload is not created by visitors in an upload-only sharing.
+func (st *BaseStore) initUploadDropColumn(ctx context.Context, tx *sql.Tx) error {
+	_, err := st.addColumn(ctx, tx, "t_file_uploading", "drop_path", "varchar not null default ''")
+	return err
+}
+
+// AddSharingDropUsage adds the size and the number of files uploaded into the drop,
+// it returns ErrReachedLimit if caps of the drop are exceeded. Negative values release the usage.
+func (st *BaseStore) AddSharingDropUsage(ctx context.Context, dirPath string, size, files int64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = st.addSharingDropUsage(ctx, tx, dirPath, size, files)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) addSharingDropUsage(ctx context.Context, tx *sql.Tx, dirPath string, size, files int64) error {
+	shareId, err := st.getSharingID(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
+	drop, err := st.getSharingDrop(ctx, tx, shareId)
+	if err != nil {
+		return err
+	}
+
+	usedSize, fileCount := drop.UsedSize+size, drop.FileCount+files
+	if size > 0 && usedSize > drop.MaxSize {
+		return db.ErrReachedLimit
+	} else if files > 0 && drop.MaxFiles > 0 && fileCount > drop.MaxFiles {
+		return db.ErrReachedLimit
+	}
+	if usedSize < 0 {
+		usedSize = 0
+	}
+	if fileCount < 0 {
+		fileCount = 0
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_sharing_drop
+		set used_size=?, file_count=?
+		where share_id=?`,
+		usedSize, fileCount, shareId,
+	)
+	return err
+}
+
+func (st *BaseStore) getUploadDrop(ctx context.Context, tx *sql.Tx, userId uint64, filePath string) (string, error) {
+	var dropPath string
+	err := tx.QueryRowContext(
+		ctx,
+		`select drop_path
+		from t_file_uploading
+		where real_path=? and user=?`,
+		filePath, userId,
+	).Scan(&dropPath)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", db.ErrUploadNotFound
+		}
+		return "", err
+	}
+	return dropPath, nil
+}
+
+// SetUploadDrop records the drop charged for the upload, its usage is released if the upload is deleted before completing
+func (st *BaseStore) SetUploadDrop(ctx context.Context, userId uint64, filePath, dropPath string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = st.getUploadDrop(ctx, tx, userId, filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_file_uploading
+		set drop_path=?
+		where real_path=? and user=?`,
+		dropPath, filePath, userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,147 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// initSharingExpiryColumn adds the expiry of sharings,
+// it is a unix timestamp in seconds and sharings never expire if it is 0.
+func (st *BaseStore) initSharingExpiryColumn(ctx context.Context, tx *sql.Tx) error {
+	_, err := st.addColumn(ctx, tx, "t_file_info", "share_expire_at", "integer not null default 0")
+	return err
+}
+
+// SetSharingExpiry sets the expiry of the sharing, the zero time means it never expires
+func (st *BaseStore) SetSharingExpiry(ctx context.Context, dirPath string, expireAt time.Time) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var shareId string
+	err = tx.QueryRowContext(
+		ctx,
+		`select share_id
+		from t_file_info
+		where path=?`,
+		dirPath,
+	).Scan(&shareId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.ErrSharingNotFound
+		}
+		return err
+	} else if shareId == "" {
+		return db.ErrSharingNotFound
+	}
+
+	expireAtSec := int64(0)
+	if !expireAt.IsZero() {
+		expireAtSec = expireAt.Unix()
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_file_info
+		set share_expire_at=?
+		where path=?`,
+		expireAtSec, dirPath,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListSharingExpiries returns expiries of unexpired sharings in the location,
+// sharings without expiry are not included.
+func (st *BaseStore) ListSharingExpiries(ctx context.Context, location string) (map[string]time.Time, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select path, share_expire_at
+		from t_file_info
+		where share_id<>'' and location=? and share_expire_at>?`,
+		location, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pathname string
+	var expireAt int64
+	pathToExpiry := map[string]time.Time{}
+	for rows.Next() {
+		err = rows.Scan(&pathname, &expireAt)
+		if err != nil {
+			return nil, err
+		}
+		pathToExpiry[pathname] = time.Unix(expireAt, 0)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return pathToExpiry, nil
+}
+
+// DelExpiredSharings clears sharings expired before the time, it returns the number of cleared sharings
+func (st *BaseStore) DelExpiredSharings(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"t_sharing_stat", "t_sharing_drop"} {
+		_, err = tx.ExecContext(
+			ctx,
+			fmt.Sprintf(
+				`delete from %s
+				where share_id in (
+					select share_id
+					from t_file_info
+					where share_id<>'' and share_expire_at>0 and share_expire_at<=?
+				)`,
+				table,
+			),
+			expiredBefore.Unix(),
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.ExecContext(
+		ctx,
+		`update t_file_info
+		set share_id='', share_expire_at=0, share_pwd=''
+		where share_id<>'' and share_expire_at>0 and share_expire_at<=?`,
+		expiredBefore.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	cleared, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return cleared, tx.Commit()
+}
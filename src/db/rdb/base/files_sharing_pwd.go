@@ -0,0 +1,84 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// initSharingPwdColumn adds the bcrypt hash of the sharing password,
+// the sharing is not protected if it is empty.
+func (st *BaseStore) initSharingPwdColumn(ctx context.Context, tx *sql.Tx) error {
+	_, err := st.addColumn(ctx, tx, "t_file_info", "share_pwd", "varchar not null default ''")
+	return err
+}
+
+func (st *BaseStore) getSharingPwd(ctx context.Context, tx *sql.Tx, dirPath string) (string, error) {
+	var shareId, pwdHash string
+	var expireAt int64
+	err := tx.QueryRowContext(
+		ctx,
+		`select share_id, share_expire_at, share_pwd
+		from t_file_info
+		where path=?`,
+		dirPath,
+	).Scan(&shareId, &expireAt, &pwdHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", db.ErrSharingNotFound
+		}
+		return "", err
+	} else if shareId == "" || sharingExpired(expireAt) {
+		return "", db.ErrSharingNotFound
+	}
+	return pwdHash, nil
+}
+
+// GetSharingPwd returns the password hash of the sharing, it is empty if the sharing is not protected
+func (st *BaseStore) GetSharingPwd(ctx context.Context, dirPath string) (string, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	pwdHash, err := st.getSharingPwd(ctx, tx, dirPath)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return pwdHash, nil
+}
+
+// SetSharingPwd sets the password hash of the sharing, the protection is removed if it is empty
+func (st *BaseStore) SetSharingPwd(ctx context.Context, dirPath, pwdHash string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = st.getSharingPwd(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_file_info
+		set share_pwd=?
+		where path=?`,
+		pwdHash, dirPath,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
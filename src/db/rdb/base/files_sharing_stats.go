@@ -0,0 +1,228 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// getSharingID returns the share ID of the unexpired sharing
+func (st *BaseStore) getSharingID(ctx context.Context, tx *sql.Tx, dirPath string) (string, error) {
+	var shareId string
+	var expireAt int64
+	err := tx.QueryRowContext(
+		ctx,
+		`select share_id, share_expire_at
+		from t_file_info
+		where path=?`,
+		dirPath,
+	).Scan(&shareId, &expireAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", db.ErrSharingNotFound
+		}
+		return "", err
+	} else if shareId == "" || sharingExpired(expireAt) {
+		return "", db.ErrSharingNotFound
+	}
+	return shareId, nil
+}
+
+// delSharingRecords drops statistics and drop settings of the sharing of the path before its share ID is changed
+func (st *BaseStore) delSharingRecords(ctx context.Context, tx *sql.Tx, dirPath string) error {
+	for _, table := range []string{"t_sharing_stat", "t_sharing_drop"} {
+		_, err := tx.ExecContext(
+			ctx,
+			fmt.Sprintf(
+				`delete from %s
+				where share_id in (
+					select share_id
+					from t_file_info
+					where path=? and share_id<>''
+				)`,
+				table,
+			),
+			dirPath,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSharingMaxDownloads sets the number of downloads after which the sharing is disabled, 0 means unlimited
+func (st *BaseStore) SetSharingMaxDownloads(ctx context.Context, dirPath string, maxDownloads int64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	shareId, err := st.getSharingID(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_sharing_stat (share_id, max_downloads)
+		values (?, ?)
+		on conflict(share_id) do update set max_downloads=excluded.max_downloads`,
+		shareId, maxDownloads,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddSharingListing records that the sharing is listed by the ip
+func (st *BaseStore) AddSharingListing(ctx context.Context, dirPath, ip string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	shareId, err := st.getSharingID(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_sharing_stat (share_id, list_count, last_access, last_ip)
+		values (?, 1, ?, ?)
+		on conflict(share_id) do update set
+			list_count=list_count+1,
+			last_access=excluded.last_access,
+			last_ip=excluded.last_ip`,
+		shareId, time.Now().Unix(), ip,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddSharingDownload records that a file in the sharing is downloaded by the ip,
+// the sharing is disabled once it reaches the max downloads.
+// It returns ErrSharingNotFound if the sharing is already disabled.
+func (st *BaseStore) AddSharingDownload(ctx context.Context, dirPath, ip string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	shareId, err := st.getSharingID(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_sharing_stat (share_id, download_count, last_access, last_ip)
+		values (?, 1, ?, ?)
+		on conflict(share_id) do update set
+			download_count=download_count+1,
+			last_access=excluded.last_access,
+			last_ip=excluded.last_ip`,
+		shareId, time.Now().Unix(), ip,
+	)
+	if err != nil {
+		return err
+	}
+
+	var downloadCount, maxDownloads int64
+	err = tx.QueryRowContext(
+		ctx,
+		`select download_count, max_downloads
+		from t_sharing_stat
+		where share_id=?`,
+		shareId,
+	).Scan(&downloadCount, &maxDownloads)
+	if err != nil {
+		return err
+	}
+
+	if maxDownloads > 0 && downloadCount >= maxDownloads {
+		err = st.delSharingRecords(ctx, tx, dirPath)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`update t_file_info
+			set share_id='', share_expire_at=0, share_pwd=''
+			where path=?`,
+			dirPath,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListSharingStats returns statistics of unexpired sharings in the location,
+// sharings which are never accessed and have no max downloads are not included.
+func (st *BaseStore) ListSharingStats(ctx context.Context, location string) (map[string]*db.SharingStat, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select f.path, s.list_count, s.download_count, s.max_downloads, s.last_access, s.last_ip
+		from t_file_info f
+		join t_sharing_stat s on f.share_id=s.share_id
+		where f.share_id<>'' and f.location=? and (f.share_expire_at=0 or f.share_expire_at>?)`,
+		location, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pathToStat := map[string]*db.SharingStat{}
+	for rows.Next() {
+		var pathname string
+		var lastAccess int64
+		stat := &db.SharingStat{}
+		err = rows.Scan(
+			&pathname,
+			&stat.ListCount,
+			&stat.DownloadCount,
+			&stat.MaxDownloads,
+			&lastAccess,
+			&stat.LastIP,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if lastAccess > 0 {
+			stat.LastAccess = time.Unix(lastAccess, 0)
+		}
+		pathToStat[pathname] = stat
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return pathToStat, nil
+}
@@ -2,31 +2,41 @@ package base
 
 import (
 	"context"
-	"crypto/sha1"
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"path"
 	"time"
 
 	"github.com/ihexxa/quickshare/src/db"
 )
 
-func (st *BaseStore) generateShareID(payload string) (string, error) {
-	if len(payload) == 0 {
-		return "", db.ErrEmpty
-	}
+// shareIDRetries is the number of attempts of generating a unique share ID
+const shareIDRetries = 5
 
-	msg := fmt.Sprintf("%s-%d", payload, time.Now().Unix())
-	h := sha1.New()
-	_, err := io.WriteString(h, msg)
+// checkShareID returns ErrConflicted if the share ID is used by another path,
+// share IDs of expired sharings are also reserved until they are purged.
+func (st *BaseStore) checkShareID(ctx context.Context, tx *sql.Tx, dirPath, shareID string) error {
+	var sharedPath string
+	err := tx.QueryRowContext(
+		ctx,
+		`select path
+		from t_file_info
+		where share_id=? and path<>?`,
+		shareID, dirPath,
+	).Scan(&sharedPath)
 	if err != nil {
-		return "", err
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
 	}
+	return db.ErrConflicted
+}
 
-	return fmt.Sprintf("%x", h.Sum(nil))[:7], nil
+// sharingExpired returns true if the sharing has an expiry and it has passed
+func sharingExpired(expireAt int64) bool {
+	return expireAt > 0 && expireAt <= time.Now().Unix()
 }
 
 func (st *BaseStore) IsSharing(ctx context.Context, dirPath string) (bool, error) {
@@ -37,14 +47,16 @@ func (st *BaseStore) IsSharing(ctx context.Context, dirPath string) (bool, error
 	defer tx.Rollback()
 
 	var shareId string
+	var expireAt int64
 	err = tx.QueryRowContext(
 		ctx,
-		`select share_id
+		`select share_id, share_expire_at
 		from t_file_info
 		where path=?`,
 		dirPath,
 	).Scan(
 		&shareId,
+		&expireAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -57,7 +69,7 @@ func (st *BaseStore) IsSharing(ctx context.Context, dirPath string) (bool, error
 	if err != nil {
 		return false, err
 	}
-	return shareId != "", nil
+	return shareId != "" && !sharingExpired(expireAt), nil
 }
 
 func (st *BaseStore) GetSharingDir(ctx context.Context, hashID string) (string, error) {
@@ -72,9 +84,9 @@ func (st *BaseStore) GetSharingDir(ctx context.Context, hashID string) (string,
 		ctx,
 		`select path
 		from t_file_info
-		where share_id=?
+		where share_id=? and (share_expire_at=0 or share_expire_at>?)
 		`,
-		hashID,
+		hashID, time.Now().Unix(),
 	).Scan(
 		&sharedPath,
 	)
@@ -92,14 +104,49 @@ func (st *BaseStore) GetSharingDir(ctx context.Context, hashID string) (string,
 	return sharedPath, nil
 }
 
-func (st *BaseStore) AddSharing(ctx context.Context, infoId, userId uint64, dirPath string) error {
+// AddSharing shares the path with a random share ID, it is regenerated if it collides with existing ones.
+func (st *BaseStore) AddSharing(ctx context.Context, infoId, userId uint64, dirPath string, opts *db.SharingOptions) error {
+	idLen := db.DefaultShareIDLen
+	if opts != nil && opts.IDLen > 0 {
+		idLen = opts.IDLen
+	}
+
+	var err error
+	for i := 0; i < shareIDRetries; i++ {
+		var shareID string
+		shareID, err = db.GenShareID(idLen)
+		if err != nil {
+			return err
+		}
+
+		err = st.AddSharingWithID(ctx, infoId, userId, dirPath, shareID, opts)
+		if !errors.Is(err, db.ErrConflicted) {
+			return err
+		}
+	}
+	return err
+}
+
+// AddSharingWithID shares the path with the share ID and options, it returns ErrConflicted if the ID is used by another path.
+func (st *BaseStore) AddSharingWithID(ctx context.Context, infoId, userId uint64, dirPath, shareID string, opts *db.SharingOptions) error {
+	if shareID == "" {
+		return db.ErrEmpty
+	}
+	if opts == nil {
+		opts = &db.SharingOptions{}
+	}
+	expireAtSec := int64(0)
+	if !opts.ExpireAt.IsZero() {
+		expireAtSec = opts.ExpireAt.Unix()
+	}
+
 	tx, err := st.db.BeginTx(ctx, txOpts)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	shareID, err := st.generateShareID(dirPath)
+	err = st.checkShareID(ctx, tx, dirPath, shareID)
 	if err != nil {
 		return err
 	}
@@ -144,16 +191,42 @@ func (st *BaseStore) AddSharing(ctx context.Context, infoId, userId uint64, dirP
 		}
 	}
 
+	err = st.delSharingRecords(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
 	_, err = tx.ExecContext(
 		ctx,
 		`update t_file_info
-		set share_id=?
+		set share_id=?, share_expire_at=?, share_pwd=?
 		where path=?`,
-		shareID, dirPath,
+		shareID, expireAtSec, opts.PwdHash, dirPath,
 	)
 	if err != nil {
 		return err
 	}
+	if opts.MaxDownloads > 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			`insert into t_sharing_stat (share_id, max_downloads)
+			values (?, ?)`,
+			shareID, opts.MaxDownloads,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Drop != nil {
+		_, err = tx.ExecContext(
+			ctx,
+			`insert into t_sharing_drop (share_id, owner, max_size, max_files)
+			values (?, ?, ?, ?)`,
+			shareID, opts.Drop.OwnerID, opts.Drop.MaxSize, opts.Drop.MaxFiles,
+		)
+		if err != nil {
+			return err
+		}
+	}
 	return tx.Commit()
 }
 
@@ -164,10 +237,14 @@ func (st *BaseStore) DelSharing(ctx context.Context, userId uint64, dirPath stri
 	}
 	defer tx.Rollback()
 
+	err = st.delSharingRecords(ctx, tx, dirPath)
+	if err != nil {
+		return err
+	}
 	_, err = tx.ExecContext(
 		ctx,
 		`update t_file_info
-		set share_id=''
+		set share_id='', share_expire_at=0, share_pwd=''
 		where path=?`,
 		dirPath,
 	)
@@ -189,8 +266,8 @@ func (st *BaseStore) ListSharingsByLocation(ctx context.Context, location string
 		ctx,
 		`select path, share_id
 		from t_file_info
-		where share_id<>'' and location=?`,
-		location,
+		where share_id<>'' and location=? and (share_expire_at=0 or share_expire_at>?)`,
+		location, time.Now().Unix(),
 	)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,461 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// moveFileInfos moves the info of the item and infos of its children,
+// items moved in or out of group folders are charged to other accounts as movedAccount.
+// It returns sizes of moved items grouped by their accounts before and after moving.
+// Sharings are cancelled if dropSharing is true.
+func (st *BaseStore) moveFileInfos(ctx context.Context, tx *sql.Tx, userId uint64, oldPath, newPath string, dropSharing bool) (map[uint64]int64, map[uint64]int64, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`select id, path, user, size, share_id, info
+		from t_file_info
+		where path = ? or substr(path, 1, length(?)+1) = ? || '/'
+		`,
+		oldPath,
+		oldPath,
+		oldPath,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	type movingInfo struct {
+		id        uint64
+		path      string
+		accountId uint64
+		size      int64
+		shareID   string
+		info      *db.FileInfo
+	}
+	movingInfos := []*movingInfo{}
+	for rows.Next() {
+		var infoStr string
+		item := &movingInfo{info: &db.FileInfo{}}
+		err = rows.Scan(&item.id, &item.path, &item.accountId, &item.size, &item.shareID, &infoStr)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = json.Unmarshal([]byte(infoStr), item.info)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		movingInfos = append(movingInfos, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	oldSizes, newSizes := map[uint64]int64{}, map[uint64]int64{}
+	for _, item := range movingInfos {
+		itemPath := fmt.Sprintf("%s%s", newPath, item.path[len(oldPath):])
+		location, err := getLocation(itemPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		accountId, err := st.movedAccount(ctx, tx, item.accountId, userId, itemPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		oldSizes[item.accountId] += item.size
+		newSizes[accountId] += item.size
+
+		if dropSharing {
+			item.shareID = ""
+			item.info.Shared = false
+			item.info.ShareID = ""
+		}
+		infoStr, err := json.Marshal(item.info)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dirPath, itemName := path.Split(itemPath)
+		_, err = tx.ExecContext(
+			ctx,
+			`update t_file_info
+			set path=?, user=?, location=?, parent=?, name=?, share_id=?, info=?
+			where id=?`,
+			itemPath, accountId, location, dirPath, itemName, item.shareID, infoStr,
+			item.id,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return oldSizes, newSizes, nil
+}
+
+// setUsedBySizes updates used space of users, sizes are grouped by user IDs.
+func (st *BaseStore) setUsedBySizes(ctx context.Context, tx *sql.Tx, incr bool, sizes map[uint64]int64) (int64, error) {
+	totalSize := int64(0)
+	for userId, size := range sizes {
+		err := st.setUsed(ctx, tx, userId, incr, size)
+		if err != nil {
+			return 0, err
+		}
+		totalSize += size
+	}
+	return totalSize, nil
+}
+
+// moveUsedSpace releases the space of moved items from their old accounts and charges it to their new accounts,
+// it returns the total size of moved items.
+func (st *BaseStore) moveUsedSpace(ctx context.Context, tx *sql.Tx, oldSizes, newSizes map[uint64]int64) (int64, error) {
+	size, err := st.setUsedBySizes(ctx, tx, false, oldSizes)
+	if err != nil {
+		return 0, err
+	}
+	_, err = st.setUsedBySizes(ctx, tx, true, newSizes)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// initTrashChargedColumn adds the column marking trashes whose space is still charged,
+// trashes added before it released their space when they were trashed.
+func (st *BaseStore) initTrashChargedColumn(ctx context.Context, tx *sql.Tx) error {
+	_, err := st.addColumn(ctx, tx, "t_file_trash", "charged", "boolean not null default 0")
+	return err
+}
+
+func (st *BaseStore) isTrashCharged(ctx context.Context, tx *sql.Tx, id uint64) (bool, error) {
+	var charged bool
+	err := tx.QueryRowContext(
+		ctx,
+		`select charged
+		from t_file_trash
+		where id=?`,
+		id,
+	).Scan(&charged)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, db.ErrTrashNotFound
+		}
+		return false, err
+	}
+	return charged, nil
+}
+
+// releaseTrash releases the space used by infos in the trash,
+// accounts deleted after trashing are skipped.
+func (st *BaseStore) releaseTrash(ctx context.Context, tx *sql.Tx, trashPath string) error {
+	rows, err := tx.QueryContext(
+		ctx,
+		`select user, sum(size)
+		from t_file_info
+		where path = ? or substr(path, 1, length(?)+1) = ? || '/'
+		group by user`,
+		trashPath,
+		trashPath,
+		trashPath,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sizes := map[uint64]int64{}
+	for rows.Next() {
+		var accountId uint64
+		var size int64
+		if err = rows.Scan(&accountId, &size); err != nil {
+			return err
+		}
+		sizes[accountId] = size
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for accountId, size := range sizes {
+		err = st.setUsed(ctx, tx, accountId, false, size)
+		if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTrash moves file infos of the item into the trash,
+// the space stays charged until the trash is deleted, and sharings and grants of them are cancelled.
+func (st *BaseStore) AddTrash(ctx context.Context, trash *db.TrashInfo) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	oldSizes, newSizes, err := st.moveFileInfos(ctx, tx, trash.UserID, trash.OriginPath, trash.TrashPath, true)
+	if err != nil {
+		return err
+	}
+	size, err := st.moveUsedSpace(ctx, tx, oldSizes, newSizes)
+	if err != nil {
+		return err
+	}
+	err = st.delFileACLs(ctx, tx, trash.OriginPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_file_trash (
+			id, user, origin_path, trash_path, is_dir, size, deleted_at, charged
+		)
+		values (?, ?, ?, ?, ?, ?, ?, ?)`,
+		trash.ID, trash.UserID, trash.OriginPath, trash.TrashPath, trash.IsDir, size, trash.DeletedAt.Unix(), true,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	trash.Size = size
+	return nil
+}
+
+func (st *BaseStore) getTrash(ctx context.Context, tx *sql.Tx, id uint64) (*db.TrashInfo, error) {
+	var deletedAt int64
+	trash := &db.TrashInfo{}
+	err := tx.QueryRowContext(
+		ctx,
+		`select id, user, origin_path, trash_path, is_dir, size, deleted_at
+		from t_file_trash
+		where id=?`,
+		id,
+	).Scan(
+		&trash.ID,
+		&trash.UserID,
+		&trash.OriginPath,
+		&trash.TrashPath,
+		&trash.IsDir,
+		&trash.Size,
+		&deletedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrTrashNotFound
+		}
+		return nil, err
+	}
+
+	trash.DeletedAt = time.Unix(deletedAt, 0)
+	return trash, nil
+}
+
+func (st *BaseStore) GetTrash(ctx context.Context, id uint64) (*db.TrashInfo, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	trash, err := st.getTrash(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return trash, nil
+}
+
+func (st *BaseStore) listTrashes(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]*db.TrashInfo, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trashes := []*db.TrashInfo{}
+	for rows.Next() {
+		var deletedAt int64
+		trash := &db.TrashInfo{}
+		err = rows.Scan(
+			&trash.ID,
+			&trash.UserID,
+			&trash.OriginPath,
+			&trash.TrashPath,
+			&trash.IsDir,
+			&trash.Size,
+			&deletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		trash.DeletedAt = time.Unix(deletedAt, 0)
+		trashes = append(trashes, trash)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return trashes, nil
+}
+
+func (st *BaseStore) ListTrashes(ctx context.Context, userId uint64) ([]*db.TrashInfo, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	trashes, err := st.listTrashes(
+		ctx,
+		tx,
+		`select id, user, origin_path, trash_path, is_dir, size, deleted_at
+		from t_file_trash
+		where user=?
+		order by deleted_at desc`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return trashes, nil
+}
+
+func (st *BaseStore) ListExpiredTrashes(ctx context.Context, deletedBefore time.Time) ([]*db.TrashInfo, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	trashes, err := st.listTrashes(
+		ctx,
+		tx,
+		`select id, user, origin_path, trash_path, is_dir, size, deleted_at
+		from t_file_trash
+		where deleted_at<?`,
+		deletedBefore.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return trashes, nil
+}
+
+// RestoreTrash moves file infos back to the original path,
+// it fails if the space of items charged to other accounts after restoring exceeds their space limits.
+func (st *BaseStore) RestoreTrash(ctx context.Context, id uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	trash, err := st.getTrash(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	charged, err := st.isTrashCharged(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	oldSizes, newSizes, err := st.moveFileInfos(ctx, tx, trash.UserID, trash.TrashPath, trash.OriginPath, false)
+	if err != nil {
+		return err
+	}
+	if charged {
+		_, err = st.moveUsedSpace(ctx, tx, oldSizes, newSizes)
+	} else {
+		_, err = st.setUsedBySizes(ctx, tx, true, newSizes)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_trash
+		where id=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DelTrash removes the trash and file infos in it, and releases the space used by them.
+func (st *BaseStore) DelTrash(ctx context.Context, id uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	trash, err := st.getTrash(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	charged, err := st.isTrashCharged(ctx, tx, id)
+	if err != nil {
+		return err
+	} else if charged {
+		err = st.releaseTrash(ctx, tx, trash.TrashPath)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_info
+		where path = ? or substr(path, 1, length(?)+1) = ? || '/'`,
+		trash.TrashPath,
+		trash.TrashPath,
+		trash.TrashPath,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_trash
+		where id=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
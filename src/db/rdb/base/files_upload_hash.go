@@ -0,0 +1,81 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// initUploadHashColumn adds the column of the whole file hash expected by the client,
+// it is in format "algorithm:hex" and empty if the client does not provide it.
+func (st *BaseStore) initUploadHashColumn(ctx context.Context, tx *sql.Tx) error {
+	_, err := st.addColumn(ctx, tx, "t_file_uploading", "expected_hash", "varchar not null default ''")
+	return err
+}
+
+func (st *BaseStore) getUploadExpectedHash(ctx context.Context, tx *sql.Tx, userId uint64, filePath string) (string, error) {
+	var expectedHash string
+	err := tx.QueryRowContext(
+		ctx,
+		`select expected_hash
+		from t_file_uploading
+		where real_path=? and user=?`,
+		filePath, userId,
+	).Scan(&expectedHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", db.ErrUploadNotFound
+		}
+		return "", err
+	}
+	return expectedHash, nil
+}
+
+// GetUploadExpectedHash returns the whole file hash expected by the client, it is empty if it is not provided
+func (st *BaseStore) GetUploadExpectedHash(ctx context.Context, userId uint64, filePath string) (string, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	expectedHash, err := st.getUploadExpectedHash(ctx, tx, userId, filePath)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return expectedHash, nil
+}
+
+// SetUploadExpectedHash records the whole file hash which is verified after the upload is completed
+func (st *BaseStore) SetUploadExpectedHash(ctx context.Context, userId uint64, filePath, expectedHash string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = st.getUploadExpectedHash(ctx, tx, userId, filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_file_uploading
+		set expected_hash=?
+		where real_path=? and user=?`,
+		expectedHash, filePath, userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,162 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// initUploadPartColumns adds columns for tracking uploads in parts,
+// part_size is 0 if the file is uploaded sequentially.
+func (st *BaseStore) initUploadPartColumns(ctx context.Context, tx *sql.Tx) error {
+	_, err := st.addColumn(ctx, tx, "t_file_uploading", "part_size", "bigint not null default 0")
+	if err != nil {
+		return err
+	}
+	_, err = st.addColumn(ctx, tx, "t_file_uploading", "ranges", "varchar not null default '[]'")
+	return err
+}
+
+// mergeUploadRanges adds the range into sorted ranges and merges overlapped or adjacent ones
+func mergeUploadRanges(ranges []*db.UploadRange, newRange *db.UploadRange) []*db.UploadRange {
+	allRanges := append([]*db.UploadRange{newRange}, ranges...)
+	sort.Slice(allRanges, func(i, j int) bool {
+		return allRanges[i].Start < allRanges[j].Start
+	})
+
+	merged := []*db.UploadRange{}
+	for _, uploadRange := range allRanges {
+		last := len(merged) - 1
+		if last >= 0 && uploadRange.Start <= merged[last].End {
+			if uploadRange.End > merged[last].End {
+				merged[last].End = uploadRange.End
+			}
+			continue
+		}
+		merged = append(merged, &db.UploadRange{Start: uploadRange.Start, End: uploadRange.End})
+	}
+	return merged
+}
+
+func (st *BaseStore) getUploadParts(ctx context.Context, tx *sql.Tx, userId uint64, filePath string) (*db.UploadParts, error) {
+	var rangesStr string
+	parts := &db.UploadParts{RealFilePath: filePath}
+	err := tx.QueryRowContext(
+		ctx,
+		`select size, uploaded, part_size, ranges
+		from t_file_uploading
+		where real_path=? and user=?`,
+		filePath, userId,
+	).Scan(&parts.Size, &parts.Uploaded, &parts.PartSize, &rangesStr)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(rangesStr), &parts.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+func (st *BaseStore) GetUploadParts(ctx context.Context, userId uint64, filePath string) (*db.UploadParts, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	parts, err := st.getUploadParts(ctx, tx, userId, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// SetUploadPartSize turns the upload into the multi-part mode
+func (st *BaseStore) SetUploadPartSize(ctx context.Context, userId uint64, filePath string, partSize int64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if partSize <= 0 {
+		return db.ErrInvalidRange
+	}
+	parts, err := st.getUploadParts(ctx, tx, userId, filePath)
+	if err != nil {
+		return err
+	} else if parts.Uploaded > 0 {
+		return db.ErrInvalidRange
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_file_uploading
+		set part_size=?, ranges='[]'
+		where real_path=? and user=?`,
+		partSize, filePath, userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddUploadRange records the received range and updates the uploaded size,
+// the upload is completed when the uploaded size equals to the file size.
+func (st *BaseStore) AddUploadRange(ctx context.Context, userId uint64, filePath string, uploadRange *db.UploadRange) (*db.UploadParts, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	parts, err := st.getUploadParts(ctx, tx, userId, filePath)
+	if err != nil {
+		return nil, err
+	} else if uploadRange.Start < 0 || uploadRange.Start >= uploadRange.End || uploadRange.End > parts.Size {
+		return nil, db.ErrInvalidRange
+	}
+
+	parts.Ranges = mergeUploadRanges(parts.Ranges, uploadRange)
+	parts.Uploaded = 0
+	for _, mergedRange := range parts.Ranges {
+		parts.Uploaded += mergedRange.End - mergedRange.Start
+	}
+	rangesStr, err := json.Marshal(parts.Ranges)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_file_uploading
+		set uploaded=?, ranges=?
+		where real_path=? and user=?`,
+		parts.Uploaded, rangesStr, filePath, userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
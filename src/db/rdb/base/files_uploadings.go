@@ -29,13 +29,6 @@ func (st *BaseStore) AddUploadInfos(ctx context.Context, uploadId, userId uint64
 	}
 	defer tx.Rollback()
 
-	userInfo, err := st.getUser(ctx, tx, userId)
-	if err != nil {
-		return err
-	} else if userInfo.UsedSpace+info.Size > int64(userInfo.Quota.SpaceLimit) {
-		return db.ErrQuota
-	}
-
 	_, _, _, err = st.getUploadInfo(ctx, tx, userId, filePath)
 	if err == nil {
 		return db.ErrKeyExisting
@@ -43,9 +36,16 @@ func (st *BaseStore) AddUploadInfos(ctx context.Context, uploadId, userId uint64
 		return err
 	}
 
-	userInfo.UsedSpace += info.Size
-	err = st.setUser(ctx, tx, userInfo)
+	// uploads into group folders are charged to groups
+	accountId, err := st.chargedAccount(ctx, tx, userId, filePath)
+	if err != nil {
+		return err
+	}
+	err = st.setUsed(ctx, tx, accountId, true, info.Size)
 	if err != nil {
+		if errors.Is(err, db.ErrReachedLimit) {
+			return db.ErrQuota
+		}
 		return err
 	}
 
@@ -78,18 +78,33 @@ func (st *BaseStore) delUploadingInfos(ctx context.Context, tx *sql.Tx, userId u
 		// info may not exist
 		return err
 	}
+	dropPath, err := st.getUploadDrop(ctx, tx, userId, realPath)
+	if err != nil {
+		return err
+	}
 
 	err = st.delUploadInfoOnly(ctx, tx, userId, realPath)
 	if err != nil {
 		return err
 	}
 
-	userInfo, err := st.getUser(ctx, tx, userId)
+	accountId, err := st.chargedAccount(ctx, tx, userId, realPath)
 	if err != nil {
 		return err
 	}
-	userInfo.UsedSpace -= size
-	return st.setUser(ctx, tx, userInfo)
+	err = st.setUsed(ctx, tx, accountId, false, size)
+	if err != nil {
+		return err
+	}
+
+	// the drop may be deleted or expired already
+	if dropPath != "" {
+		err = st.addSharingDropUsage(ctx, tx, dropPath, -size, -1)
+		if err != nil && !errors.Is(err, db.ErrSharingNotFound) {
+			return err
+		}
+	}
+	return nil
 }
 
 func (st *BaseStore) delUploadInfoOnly(ctx context.Context, tx *sql.Tx, userId uint64, filePath string) error {
@@ -113,12 +128,22 @@ func (st *BaseStore) MoveUploadingInfos(ctx context.Context, infoId, userId uint
 	if err != nil {
 		return err
 	}
+	// the expected hash is verified before completing, it is kept as a record
+	expectedHash, err := st.getUploadExpectedHash(ctx, tx, userId, itemPath)
+	if err != nil {
+		return err
+	}
 	err = st.delUploadInfoOnly(ctx, tx, userId, itemPath)
 	if err != nil {
 		return err
 	}
-	err = st.addFileInfo(ctx, tx, infoId, userId, itemPath, &db.FileInfo{
-		Size: size,
+	accountId, err := st.chargedAccount(ctx, tx, userId, itemPath)
+	if err != nil {
+		return err
+	}
+	err = st.addFileInfo(ctx, tx, infoId, accountId, itemPath, &db.FileInfo{
+		Size:         size,
+		ExpectedHash: expectedHash,
 	})
 	if err != nil {
 		return err
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/ihexxa/quickshare/src/db"
@@ -58,9 +59,116 @@ func (st *BaseStore) Init(ctx context.Context, rootName, rootPwd string, cfg *db
 		return err
 	}
 
+	if err = st.initNewTables(ctx, tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Upgrade creates tables and columns which are introduced after the database was created.
+// It is idempotent, so it is safe to apply it to an up to date database.
+func (st *BaseStore) Upgrade(ctx context.Context) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = st.initNewTables(ctx, tx); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
+func (st *BaseStore) initNewTables(ctx context.Context, tx *sql.Tx) error {
+	if err := st.InitTrashTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitRevisionTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.initUploadPartColumns(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.initSha1Column(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.initUploadHashColumn(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.initSharingExpiryColumn(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.initSharingPwdColumn(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitSharingStatTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitSharingDropTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.initUploadDropColumn(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitFileACLTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitAPITokenTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitSessionTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitUserTOTPTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitUserIdentityTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitRoleTable(ctx, tx); err != nil {
+		return err
+	}
+	if err := st.InitGroupTables(ctx, tx); err != nil {
+		return err
+	}
+	return st.initTrashChargedColumn(ctx, tx)
+}
+
+// addColumn adds the column to the table if it does not exist,
+// it returns true if the column is added.
+func (st *BaseStore) addColumn(ctx context.Context, tx *sql.Tx, table, column, definition string) (bool, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("pragma table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return false, err
+		} else if name == column {
+			return false, nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("alter table %s add column %s %s", table, column, definition))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (st *BaseStore) InitUserTable(ctx context.Context, tx *sql.Tx, rootName, rootPwd string) error {
 	_, err := tx.ExecContext(
 		ctx,
@@ -231,3 +339,265 @@ func (st *BaseStore) InitConfigTable(ctx context.Context, tx *sql.Tx, cfg *db.Si
 
 	return nil
 }
+
+func (st *BaseStore) InitTrashTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_file_trash (
+			id bigint not null,
+			user bigint not null,
+			origin_path varchar not null,
+			trash_path varchar not null unique,
+			is_dir boolean not null,
+			size bigint not null,
+			deleted_at bigint not null,
+			primary key(id)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_file_trash_user on t_file_trash (user)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_file_trash_deleted on t_file_trash (deleted_at)`,
+	)
+	return err
+}
+
+func (st *BaseStore) InitRevisionTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_file_revision (
+			id bigint not null,
+			path varchar not null,
+			user bigint not null,
+			sha1 varchar not null,
+			size bigint not null,
+			storage_path varchar not null unique,
+			created bigint not null,
+			primary key(id)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_file_revision_path on t_file_revision (path)`,
+	)
+	return err
+}
+
+// InitSharingStatTable creates the table of sharing statistics,
+// they are keyed by share IDs so that they are dropped along with sharings.
+func (st *BaseStore) InitSharingStatTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_sharing_stat (
+			share_id varchar not null,
+			list_count bigint not null default 0,
+			download_count bigint not null default 0,
+			max_downloads bigint not null default 0,
+			last_access bigint not null default 0,
+			last_ip varchar not null default '',
+			primary key(share_id)
+		)`,
+	)
+	return err
+}
+
+// InitSharingDropTable creates the table of upload-only sharings,
+// they are keyed by share IDs so that they are dropped along with sharings.
+func (st *BaseStore) InitSharingDropTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_sharing_drop (
+			share_id varchar not null,
+			owner bigint not null,
+			max_size bigint not null,
+			max_files bigint not null default 0,
+			used_size bigint not null default 0,
+			file_count bigint not null default 0,
+			primary key(share_id)
+		)`,
+	)
+	return err
+}
+
+// InitFileACLTable creates the table of grants on files and folders for users and groups.
+func (st *BaseStore) InitFileACLTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_file_acl (
+			id bigint not null,
+			path varchar not null,
+			grantee_type varchar not null,
+			grantee_id bigint not null,
+			perm varchar not null,
+			granter bigint not null,
+			created bigint not null,
+			primary key(id),
+			unique(path, grantee_type, grantee_id)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_file_acl_grantee on t_file_acl (grantee_type, grantee_id)`,
+	)
+	return err
+}
+
+// InitAPITokenTable creates the table of personal API tokens, tokens are looked up by their hashes.
+func (st *BaseStore) InitAPITokenTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_api_token (
+			id bigint not null,
+			user bigint not null,
+			name varchar not null,
+			scope varchar not null,
+			token_hash varchar not null unique,
+			created bigint not null,
+			last_used bigint not null default 0,
+			primary key(id)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_api_token_user on t_api_token (user)`,
+	)
+	return err
+}
+
+// InitSessionTable creates the table of login sessions, token cookies are only valid with their sessions.
+func (st *BaseStore) InitSessionTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_session (
+			id bigint not null,
+			user bigint not null,
+			ip varchar not null,
+			user_agent varchar not null,
+			created bigint not null,
+			expire_at bigint not null,
+			primary key(id)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_session_user on t_session (user)`,
+	)
+	return err
+}
+
+// InitUserTOTPTable creates the table of 2FA states, which extends t_user.
+func (st *BaseStore) InitUserTOTPTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_user_totp (
+			user bigint not null,
+			secret varchar not null,
+			enabled boolean not null,
+			forced boolean not null,
+			recovery_codes varchar not null,
+			last_step bigint not null,
+			primary key(user)
+		)`,
+	)
+	return err
+}
+
+// InitUserIdentityTable creates the table which links users of external identity providers to users.
+func (st *BaseStore) InitUserIdentityTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_user_identity (
+			provider varchar not null,
+			subject varchar not null,
+			user bigint not null,
+			primary key(provider, subject)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_user_identity_user on t_user_identity (user)`,
+	)
+	return err
+}
+
+// InitRoleTable creates the table of custom roles, predefined roles are not stored.
+func (st *BaseStore) InitRoleTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_role (
+			name varchar not null,
+			rules varchar not null,
+			capabilities varchar not null,
+			primary key(name)
+		)`,
+	)
+	return err
+}
+
+// InitGroupTables creates tables of groups and their members.
+func (st *BaseStore) InitGroupTables(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`create table if not exists t_group (
+			id bigint not null,
+			name varchar not null,
+			used_space bigint not null,
+			quota varchar not null,
+			primary key(id),
+			unique(name)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create table if not exists t_group_member (
+			group_id bigint not null,
+			user bigint not null,
+			perm varchar not null,
+			primary key(group_id, user)
+		)`,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`create index if not exists t_group_member_user on t_group_member (user)`,
+	)
+	return err
+}
@@ -114,6 +114,8 @@ func (st *BaseStore) AddUser(ctx context.Context, user *db.User) error {
 	return tx.Commit()
 }
 
+// DelUser deletes the user with its credentials, memberships and grants,
+// grants on files in its home folder are also deleted.
 func (st *BaseStore) DelUser(ctx context.Context, id uint64) error {
 	tx, err := st.db.BeginTx(ctx, txOpts)
 	if err != nil {
@@ -121,6 +123,14 @@ func (st *BaseStore) DelUser(ctx context.Context, id uint64) error {
 	}
 	defer tx.Rollback()
 
+	user, err := st.getUser(ctx, tx, id)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
 	_, err = tx.ExecContext(
 		ctx,
 		`delete from t_user where id=?`,
@@ -129,6 +139,65 @@ func (st *BaseStore) DelUser(ctx context.Context, id uint64) error {
 	if err != nil {
 		return err
 	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_api_token where user=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_session where user=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_user_totp where user=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_user_identity where user=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_group_member where user=?`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_acl
+		where (grantee_type=? and grantee_id=?) or granter=?`,
+		db.GranteeUser, id, id,
+	)
+	if err != nil {
+		return err
+	}
+	err = st.delFileACLs(ctx, tx, user.Name)
+	if err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
@@ -289,7 +358,13 @@ func (st *BaseStore) SetUsed(ctx context.Context, id uint64, incr bool, capacity
 	return tx.Commit()
 }
 
+// setUsed updates the used space of the account, which is either a user or a group.
 func (st *BaseStore) setUsed(ctx context.Context, tx *sql.Tx, id uint64, incr bool, capacity int64) error {
+	err := st.setGroupUsed(ctx, tx, id, incr, capacity)
+	if !errors.Is(err, db.ErrGroupNotFound) {
+		return err
+	}
+
 	gotUser, err := st.getUser(ctx, tx, id)
 	if err != nil {
 		return err
@@ -407,18 +482,3 @@ func (st *BaseStore) ListUserIDs(ctx context.Context) (map[string]string, error)
 	}
 	return nameToId, nil
 }
-
-func (st *BaseStore) AddRole(role string) error {
-	// TODO: implement this after adding grant/revoke
-	panic("not implemented")
-}
-
-func (st *BaseStore) DelRole(role string) error {
-	// TODO: implement this after adding grant/revoke
-	panic("not implemented")
-}
-
-func (st *BaseStore) ListRoles() (map[string]bool, error) {
-	// TODO: implement this after adding grant/revoke
-	panic("not implemented")
-}
@@ -0,0 +1,174 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *BaseStore) AddAPIToken(ctx context.Context, token *db.APIToken) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_api_token (
+			id, user, name, scope, token_hash, created
+		)
+		values (?, ?, ?, ?, ?, ?)`,
+		token.ID, token.UserID, token.Name, token.Scope,
+		token.TokenHash, token.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// unixTime converts unix seconds to time, 0 is converted to the zero time
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func (st *BaseStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (*db.APIToken, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var created, lastUsed int64
+	token := &db.APIToken{}
+	err = tx.QueryRowContext(
+		ctx,
+		`select id, user, name, scope, token_hash, created, last_used
+		from t_api_token
+		where token_hash=?`,
+		tokenHash,
+	).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.Scope,
+		&token.TokenHash,
+		&created,
+		&lastUsed,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrAPITokenNotFound
+		}
+		return nil, err
+	}
+	token.CreatedAt, token.LastUsedAt = time.Unix(created, 0), unixTime(lastUsed)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (st *BaseStore) ListAPITokens(ctx context.Context, userId uint64) ([]*db.APIToken, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select id, user, name, scope, token_hash, created, last_used
+		from t_api_token
+		where user=?
+		order by created, id`,
+		userId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var created, lastUsed int64
+	tokens := []*db.APIToken{}
+	for rows.Next() {
+		token := &db.APIToken{}
+		err = rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			&token.Scope,
+			&token.TokenHash,
+			&created,
+			&lastUsed,
+		)
+		if err != nil {
+			return nil, err
+		}
+		token.CreatedAt, token.LastUsedAt = time.Unix(created, 0), unixTime(lastUsed)
+		tokens = append(tokens, token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DelAPIToken revokes the token of the user, it returns ErrAPITokenNotFound if the user has no such token.
+func (st *BaseStore) DelAPIToken(ctx context.Context, userId, id uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`delete from t_api_token
+		where id=? and user=?`,
+		id, userId,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return db.ErrAPITokenNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) SetAPITokenUsed(ctx context.Context, id uint64, usedAt time.Time) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_api_token
+		set last_used=?
+		where id=?`,
+		usedAt.Unix(), id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
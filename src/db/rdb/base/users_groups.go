@@ -0,0 +1,505 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// AddGroup adds the group, it returns ErrConflicted if the name is used by another group.
+func (st *BaseStore) AddGroup(ctx context.Context, group *db.Group) error {
+	quotaStr, err := json.Marshal(group.Quota)
+	if err != nil {
+		return err
+	}
+
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(
+		ctx,
+		`select count(*)
+		from t_group
+		where name=?`,
+		group.Name,
+	).Scan(&count)
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return db.ErrConflicted
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_group (
+			id, name, used_space, quota
+		)
+		values (?, ?, ?, ?)`,
+		group.ID, group.Name, group.UsedSpace, string(quotaStr),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) getGroup(ctx context.Context, tx *sql.Tx, query string, arg any) (*db.Group, error) {
+	var quotaStr string
+	group := &db.Group{}
+	err := tx.QueryRowContext(
+		ctx,
+		`select id, name, used_space, quota
+		from t_group
+		where `+query,
+		arg,
+	).Scan(
+		&group.ID,
+		&group.Name,
+		&group.UsedSpace,
+		&quotaStr,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrGroupNotFound
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(quotaStr), &group.Quota)
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (st *BaseStore) GetGroup(ctx context.Context, id uint64) (*db.Group, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	group, err := st.getGroup(ctx, tx, "id=?", id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (st *BaseStore) GetGroupByName(ctx context.Context, name string) (*db.Group, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	group, err := st.getGroup(ctx, tx, "name=?", name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (st *BaseStore) SetGroupQuota(ctx context.Context, id uint64, quota *db.Quota) error {
+	quotaStr, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`update t_group
+		set quota=?
+		where id=?`,
+		string(quotaStr), id,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return db.ErrGroupNotFound
+	}
+
+	return tx.Commit()
+}
+
+// DelGroup deletes the group with its memberships, grants to it, and infos, trashes, revisions,
+// pending uploads and grants of files in the group folder.
+// It returns temporary paths of the deleted uploads, files in the group folder should be removed after it.
+func (st *BaseStore) DelGroup(ctx context.Context, id uint64) ([]string, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	group, err := st.getGroup(ctx, tx, "id=?", id)
+	if err != nil {
+		return nil, err
+	}
+	location := db.GroupLocation(group.Name)
+
+	tmpPaths, err := st.delGroupUploadings(ctx, tx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		`delete from t_group where id=?`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, db.ErrGroupNotFound
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_group_member where group_id=?`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_acl where grantee_type=? and grantee_id=?`,
+		db.GranteeGroup, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the space of files in the group folder is not released as the group is being deleted
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_info where location=?`,
+		location,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_trash where user=?`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_revision
+		where substr(path, 1, length(?)+1) = ? || '/'`,
+		location,
+		location,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = st.delFileACLs(ctx, tx, location)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return tmpPaths, nil
+}
+
+// delGroupUploadings deletes pending uploads into the group folder and returns their temporary paths,
+// the used space is not released as the group is being deleted.
+func (st *BaseStore) delGroupUploadings(ctx context.Context, tx *sql.Tx, location string) ([]string, error) {
+	rows, err := tx.QueryContext(
+		ctx,
+		`select tmp_path
+		from t_file_uploading
+		where substr(real_path, 1, length(?)+1) = ? || '/'`,
+		location,
+		location,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tmpPaths := []string{}
+	for rows.Next() {
+		var tmpPath string
+		if err = rows.Scan(&tmpPath); err != nil {
+			return nil, err
+		}
+		tmpPaths = append(tmpPaths, tmpPath)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_file_uploading
+		where substr(real_path, 1, length(?)+1) = ? || '/'`,
+		location,
+		location,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tmpPaths, nil
+}
+
+func (st *BaseStore) ListGroups(ctx context.Context) ([]*db.Group, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select id, name, used_space, quota
+		from t_group
+		order by name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotaStr string
+	groups := []*db.Group{}
+	for rows.Next() {
+		group := &db.Group{}
+		err = rows.Scan(&group.ID, &group.Name, &group.UsedSpace, &quotaStr)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(quotaStr), &group.Quota)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// SetGroupMember adds the user to the group, adding the member again updates the permission.
+func (st *BaseStore) SetGroupMember(ctx context.Context, member *db.GroupMember) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = st.getGroup(ctx, tx, "id=?", member.GroupID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_group_member (
+			group_id, user, perm
+		)
+		values (?, ?, ?)
+		on conflict(group_id, user) do update set
+			perm=excluded.perm`,
+		member.GroupID, member.UserID, member.Perm,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) DelGroupMember(ctx context.Context, groupId, userId uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`delete from t_group_member
+		where group_id=? and user=?`,
+		groupId, userId,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return db.ErrGroupMemberNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) listGroupMembers(ctx context.Context, query string, arg any) ([]*db.GroupMember, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select group_id, user, perm
+		from t_group_member
+		where `+query+`
+		order by group_id, user`,
+		arg,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*db.GroupMember{}
+	for rows.Next() {
+		member := &db.GroupMember{}
+		err = rows.Scan(&member.GroupID, &member.UserID, &member.Perm)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (st *BaseStore) ListGroupMembers(ctx context.Context, groupId uint64) ([]*db.GroupMember, error) {
+	return st.listGroupMembers(ctx, "group_id=?", groupId)
+}
+
+// ListUserGroups returns memberships of the user
+func (st *BaseStore) ListUserGroups(ctx context.Context, userId uint64) ([]*db.GroupMember, error) {
+	return st.listGroupMembers(ctx, "user=?", userId)
+}
+
+// chargedAccount returns the ID of the account which is charged for the item,
+// items in group folders are charged to their groups, other items are charged to the user.
+func (st *BaseStore) chargedAccount(ctx context.Context, tx *sql.Tx, userId uint64, itemPath string) (uint64, error) {
+	location, err := getLocation(itemPath)
+	if err != nil {
+		return 0, err
+	}
+	groupName := db.LocationGroupName(location)
+	if groupName == "" {
+		return userId, nil
+	}
+
+	group, err := st.getGroup(ctx, tx, "name=?", groupName)
+	if err != nil {
+		return 0, err
+	}
+	return group.ID, nil
+}
+
+// movedAccount returns the account charged for an item charged to accountId after moving it to newPath,
+// items moved into group folders are charged to the groups, items moved out of them are charged to userId,
+// and other items stay with accountId.
+func (st *BaseStore) movedAccount(ctx context.Context, tx *sql.Tx, accountId, userId uint64, newPath string) (uint64, error) {
+	location, err := getLocation(newPath)
+	if err != nil {
+		return 0, err
+	}
+	if db.LocationGroupName(location) != "" {
+		return st.chargedAccount(ctx, tx, userId, newPath)
+	}
+
+	_, err = st.getGroup(ctx, tx, "id=?", accountId)
+	if err == nil {
+		return userId, nil
+	} else if !errors.Is(err, db.ErrGroupNotFound) {
+		return 0, err
+	}
+	return accountId, nil
+}
+
+// setGroupUsed updates the used space of the group, it returns ErrGroupNotFound if the account is not a group.
+func (st *BaseStore) setGroupUsed(ctx context.Context, tx *sql.Tx, id uint64, incr bool, capacity int64) error {
+	group, err := st.getGroup(ctx, tx, "id=?", id)
+	if err != nil {
+		return err
+	}
+
+	if incr {
+		if group.UsedSpace+capacity > group.Quota.SpaceLimit {
+			return db.ErrReachedLimit
+		}
+		group.UsedSpace += capacity
+	} else {
+		if group.UsedSpace-capacity < 0 {
+			return db.ErrNegtiveUsedSpace
+		}
+		group.UsedSpace -= capacity
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`update t_group
+		set used_space=?
+		where id=?`,
+		group.UsedSpace, group.ID,
+	)
+	return err
+}
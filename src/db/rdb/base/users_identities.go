@@ -0,0 +1,76 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// GetIdentityUser returns the ID of the user linked to the subject of the provider.
+func (st *BaseStore) GetIdentityUser(ctx context.Context, provider, subject string) (uint64, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userId uint64
+	err = tx.QueryRowContext(
+		ctx,
+		`select user
+		from t_user_identity
+		where provider=? and subject=?`,
+		provider, subject,
+	).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, db.ErrIdentityNotFound
+		}
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+// AddUserIdentity links the subject of the provider to the user, it returns ErrConflicted if the subject is linked.
+func (st *BaseStore) AddUserIdentity(ctx context.Context, provider, subject string, userId uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(
+		ctx,
+		`select count(*)
+		from t_user_identity
+		where provider=? and subject=?`,
+		provider, subject,
+	).Scan(&count)
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return db.ErrConflicted
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_user_identity (
+			provider, subject, user
+		)
+		values (?, ?, ?)`,
+		provider, subject, userId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
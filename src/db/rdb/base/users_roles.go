@@ -0,0 +1,212 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// AddRole adds a custom role, it returns ErrConflicted if the role exists.
+func (st *BaseStore) AddRole(ctx context.Context, role *db.Role) error {
+	rulesStr, err := json.Marshal(role.Rules)
+	if err != nil {
+		return err
+	}
+	capsStr, err := json.Marshal(role.Capabilities)
+	if err != nil {
+		return err
+	}
+
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.QueryRowContext(
+		ctx,
+		`select count(*)
+		from t_role
+		where name=?`,
+		role.Name,
+	).Scan(&count)
+	if err != nil {
+		return err
+	} else if count > 0 {
+		return db.ErrConflicted
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_role (
+			name, rules, capabilities
+		)
+		values (?, ?, ?)`,
+		role.Name, string(rulesStr), string(capsStr),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) GetRole(ctx context.Context, name string) (*db.Role, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var rulesStr, capsStr string
+	err = tx.QueryRowContext(
+		ctx,
+		`select rules, capabilities
+		from t_role
+		where name=?`,
+		name,
+	).Scan(&rulesStr, &capsStr)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrRoleNotFound
+		}
+		return nil, err
+	}
+
+	role, err := parseRole(name, rulesStr, capsStr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// SetRole replaces rules and capabilities of the role, it returns ErrRoleNotFound if the role does not exist.
+func (st *BaseStore) SetRole(ctx context.Context, role *db.Role) error {
+	rulesStr, err := json.Marshal(role.Rules)
+	if err != nil {
+		return err
+	}
+	capsStr, err := json.Marshal(role.Capabilities)
+	if err != nil {
+		return err
+	}
+
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`update t_role
+		set rules=?, capabilities=?
+		where name=?`,
+		string(rulesStr), string(capsStr), role.Name,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return db.ErrRoleNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) DelRole(ctx context.Context, name string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`delete from t_role
+		where name=?`,
+		name,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return db.ErrRoleNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (st *BaseStore) ListRoles(ctx context.Context) ([]*db.Role, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select name, rules, capabilities
+		from t_role
+		order by name`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var name, rulesStr, capsStr string
+	roles := []*db.Role{}
+	for rows.Next() {
+		err = rows.Scan(&name, &rulesStr, &capsStr)
+		if err != nil {
+			return nil, err
+		}
+		role, err := parseRole(name, rulesStr, capsStr)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func parseRole(name, rulesStr, capsStr string) (*db.Role, error) {
+	role := &db.Role{
+		Name:         name,
+		Rules:        []*db.APIRule{},
+		Capabilities: []string{},
+	}
+	err := json.Unmarshal([]byte(rulesStr), &role.Rules)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(capsStr), &role.Capabilities)
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
@@ -0,0 +1,177 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// AddSession adds the session and cleans up expired sessions of the same user.
+func (st *BaseStore) AddSession(ctx context.Context, session *db.Session) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_session
+		where user=? and expire_at<=?`,
+		session.UserID, time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_session (
+			id, user, ip, user_agent, created, expire_at
+		)
+		values (?, ?, ?, ?, ?, ?)`,
+		session.ID, session.UserID, session.IP, session.UserAgent,
+		session.CreatedAt.Unix(), session.ExpireAt.Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSession returns ErrSessionNotFound if the session does not exist or it is expired.
+func (st *BaseStore) GetSession(ctx context.Context, id uint64) (*db.Session, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var created, expireAt int64
+	session := &db.Session{}
+	err = tx.QueryRowContext(
+		ctx,
+		`select id, user, ip, user_agent, created, expire_at
+		from t_session
+		where id=? and expire_at>?`,
+		id, time.Now().Unix(),
+	).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.IP,
+		&session.UserAgent,
+		&created,
+		&expireAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, db.ErrSessionNotFound
+		}
+		return nil, err
+	}
+	session.CreatedAt, session.ExpireAt = time.Unix(created, 0), time.Unix(expireAt, 0)
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// ListSessions lists unexpired sessions of the user.
+func (st *BaseStore) ListSessions(ctx context.Context, userId uint64) ([]*db.Session, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`select id, user, ip, user_agent, created, expire_at
+		from t_session
+		where user=? and expire_at>?
+		order by created, id`,
+		userId, time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var created, expireAt int64
+	sessions := []*db.Session{}
+	for rows.Next() {
+		session := &db.Session{}
+		err = rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.IP,
+			&session.UserAgent,
+			&created,
+			&expireAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		session.CreatedAt, session.ExpireAt = time.Unix(created, 0), time.Unix(expireAt, 0)
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DelSession revokes the session of the user, it returns ErrSessionNotFound if the user has no such session.
+func (st *BaseStore) DelSession(ctx context.Context, userId, id uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`delete from t_session
+		where id=? and user=?`,
+		id, userId,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return db.ErrSessionNotFound
+	}
+
+	return tx.Commit()
+}
+
+// DelSessions revokes all sessions of the user except the session exceptId, exceptId is 0 if none is kept.
+func (st *BaseStore) DelSessions(ctx context.Context, userId, exceptId uint64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		`delete from t_session
+		where user=? and id<>?`,
+		userId, exceptId,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,167 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *BaseStore) getUserTOTP(ctx context.Context, tx *sql.Tx, userId uint64) (*db.UserTOTP, error) {
+	var recoveryCodesStr string
+	totp := &db.UserTOTP{}
+	err := tx.QueryRowContext(
+		ctx,
+		`select user, secret, enabled, forced, recovery_codes, last_step
+		from t_user_totp
+		where user=?`,
+		userId,
+	).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.Forced,
+		&recoveryCodesStr,
+		&totp.LastStep,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &db.UserTOTP{UserID: userId, RecoveryCodes: []string{}}, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(recoveryCodesStr), &totp.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+func (st *BaseStore) setUserTOTP(ctx context.Context, tx *sql.Tx, totp *db.UserTOTP) error {
+	recoveryCodes := totp.RecoveryCodes
+	if recoveryCodes == nil {
+		recoveryCodes = []string{}
+	}
+	recoveryCodesStr, err := json.Marshal(recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`insert into t_user_totp (
+			user, secret, enabled, forced, recovery_codes, last_step
+		)
+		values (?, ?, ?, ?, ?, ?)
+		on conflict(user) do update set
+			secret=excluded.secret,
+			enabled=excluded.enabled,
+			forced=excluded.forced,
+			recovery_codes=excluded.recovery_codes,
+			last_step=excluded.last_step`,
+		totp.UserID, totp.Secret, totp.Enabled, totp.Forced,
+		string(recoveryCodesStr), totp.LastStep,
+	)
+	return err
+}
+
+// GetUserTOTP returns the 2FA state of the user, it is disabled if the user never enrolled.
+func (st *BaseStore) GetUserTOTP(ctx context.Context, userId uint64) (*db.UserTOTP, error) {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	totp, err := st.getUserTOTP(ctx, tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+func (st *BaseStore) SetUserTOTP(ctx context.Context, totp *db.UserTOTP) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = st.setUserTOTP(ctx, tx, totp)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the step of a verified code, it returns ErrTOTPCodeUsed if the step is not newer than the last one.
+func (st *BaseStore) UseTOTPStep(ctx context.Context, userId uint64, step int64) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`update t_user_totp
+		set last_step=?
+		where user=? and last_step<?`,
+		step, userId, step,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return db.ErrTOTPCodeUsed
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes the recovery code, it returns ErrRecoveryCodeNotFound if the code is not unused.
+func (st *BaseStore) UseRecoveryCode(ctx context.Context, userId uint64, codeHash string) error {
+	tx, err := st.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	totp, err := st.getUserTOTP(ctx, tx, userId)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	recoveryCodes := []string{}
+	for _, recoveryCode := range totp.RecoveryCodes {
+		if !found && recoveryCode == codeHash {
+			found = true
+			continue
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+	}
+	if !found {
+		return db.ErrRecoveryCodeNotFound
+	}
+
+	totp.RecoveryCodes = recoveryCodes
+	err = st.setUserTOTP(ctx, tx, totp)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -34,6 +34,13 @@ func (st *SQLiteStore) SetSha1(ctx context.Context, itemPath, sign string) error
 	return st.store.SetSha1(ctx, itemPath, sign)
 }
 
+func (st *SQLiteStore) SetHashes(ctx context.Context, itemPath string, hashes map[string]string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetHashes(ctx, itemPath, hashes)
+}
+
 func (st *SQLiteStore) DelFileInfo(ctx context.Context, userID uint64, itemPath string) error {
 	st.Lock()
 	defer st.Unlock()
@@ -47,3 +54,10 @@ func (st *SQLiteStore) MoveFileInfo(ctx context.Context, userId uint64, oldPath,
 
 	return st.store.MoveFileInfo(ctx, userId, oldPath, newPath, isDir)
 }
+
+func (st *SQLiteStore) ListFilesBySha1(ctx context.Context, sha1 string, size int64) ([]string, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListFilesBySha1(ctx, sha1, size)
+}
@@ -0,0 +1,49 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *SQLiteStore) AddFileACL(ctx context.Context, acl *db.FileACL) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddFileACL(ctx, acl)
+}
+
+func (st *SQLiteStore) GetFileACL(ctx context.Context, id uint64) (*db.FileACL, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetFileACL(ctx, id)
+}
+
+func (st *SQLiteStore) DelFileACL(ctx context.Context, id uint64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.DelFileACL(ctx, id)
+}
+
+func (st *SQLiteStore) ListFileACLs(ctx context.Context, itemPath string) ([]*db.FileACL, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListFileACLs(ctx, itemPath)
+}
+
+func (st *SQLiteStore) ListGranteeFileACLs(ctx context.Context, granteeType string, granteeIds []uint64) ([]*db.FileACL, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListGranteeFileACLs(ctx, granteeType, granteeIds)
+}
+
+func (st *SQLiteStore) MatchFileACLs(ctx context.Context, itemPath, granteeType string, granteeIds []uint64) ([]*db.FileACL, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.MatchFileACLs(ctx, itemPath, granteeType, granteeIds)
+}
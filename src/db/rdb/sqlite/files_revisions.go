@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *SQLiteStore) AddRevision(ctx context.Context, revision *db.Revision) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddRevision(ctx, revision)
+}
+
+func (st *SQLiteStore) GetRevision(ctx context.Context, id uint64) (*db.Revision, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetRevision(ctx, id)
+}
+
+func (st *SQLiteStore) ListRevisions(ctx context.Context, itemPath string) ([]*db.Revision, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListRevisions(ctx, itemPath)
+}
+
+func (st *SQLiteStore) RestoreRevision(ctx context.Context, id, infoId uint64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.RestoreRevision(ctx, id, infoId)
+}
+
+func (st *SQLiteStore) PruneRevisions(ctx context.Context, itemPath string, keep int) ([]*db.Revision, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.PruneRevisions(ctx, itemPath, keep)
+}
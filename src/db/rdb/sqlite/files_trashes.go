@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *SQLiteStore) AddTrash(ctx context.Context, trash *db.TrashInfo) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddTrash(ctx, trash)
+}
+
+func (st *SQLiteStore) GetTrash(ctx context.Context, id uint64) (*db.TrashInfo, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetTrash(ctx, id)
+}
+
+func (st *SQLiteStore) ListTrashes(ctx context.Context, userId uint64) ([]*db.TrashInfo, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListTrashes(ctx, userId)
+}
+
+func (st *SQLiteStore) ListExpiredTrashes(ctx context.Context, deletedBefore time.Time) ([]*db.TrashInfo, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListExpiredTrashes(ctx, deletedBefore)
+}
+
+func (st *SQLiteStore) RestoreTrash(ctx context.Context, id uint64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.RestoreTrash(ctx, id)
+}
+
+func (st *SQLiteStore) DelTrash(ctx context.Context, id uint64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.DelTrash(ctx, id)
+}
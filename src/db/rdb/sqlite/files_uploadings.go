@@ -47,3 +47,38 @@ func (st *SQLiteStore) ListUploadInfos(ctx context.Context, userId uint64) ([]*d
 
 	return st.store.ListUploadInfos(ctx, userId)
 }
+
+func (st *SQLiteStore) SetUploadPartSize(ctx context.Context, userId uint64, filePath string, partSize int64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetUploadPartSize(ctx, userId, filePath, partSize)
+}
+
+func (st *SQLiteStore) GetUploadParts(ctx context.Context, userId uint64, filePath string) (*db.UploadParts, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetUploadParts(ctx, userId, filePath)
+}
+
+func (st *SQLiteStore) AddUploadRange(ctx context.Context, userId uint64, filePath string, uploadRange *db.UploadRange) (*db.UploadParts, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddUploadRange(ctx, userId, filePath, uploadRange)
+}
+
+func (st *SQLiteStore) SetUploadExpectedHash(ctx context.Context, userId uint64, filePath, expectedHash string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetUploadExpectedHash(ctx, userId, filePath, expectedHash)
+}
+
+func (st *SQLiteStore) GetUploadExpectedHash(ctx context.Context, userId uint64, filePath string) (string, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetUploadExpectedHash(ctx, userId, filePath)
+}
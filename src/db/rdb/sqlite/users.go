@@ -83,18 +83,3 @@ func (st *SQLiteStore) ListUserIDs(ctx context.Context) (map[string]string, erro
 
 	return st.store.ListUserIDs(ctx)
 }
-
-func (st *SQLiteStore) AddRole(role string) error {
-	// TODO: implement this after adding grant/revoke
-	panic("not implemented")
-}
-
-func (st *SQLiteStore) DelRole(role string) error {
-	// TODO: implement this after adding grant/revoke
-	panic("not implemented")
-}
-
-func (st *SQLiteStore) ListRoles() (map[string]bool, error) {
-	// TODO: implement this after adding grant/revoke
-	panic("not implemented")
-}
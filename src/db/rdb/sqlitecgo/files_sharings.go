@@ -2,6 +2,9 @@ package sqlitecgo
 
 import (
 	"context"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/db"
 )
 
 func (st *SQLiteStore) IsSharing(ctx context.Context, dirPath string) (bool, error) {
@@ -18,11 +21,18 @@ func (st *SQLiteStore) GetSharingDir(ctx context.Context, hashID string) (string
 	return st.store.GetSharingDir(ctx, hashID)
 }
 
-func (st *SQLiteStore) AddSharing(ctx context.Context, infoId, userId uint64, dirPath string) error {
+func (st *SQLiteStore) AddSharing(ctx context.Context, infoId, userId uint64, dirPath string, opts *db.SharingOptions) error {
 	st.Lock()
 	defer st.Unlock()
 
-	return st.store.AddSharing(ctx, infoId, userId, dirPath)
+	return st.store.AddSharing(ctx, infoId, userId, dirPath, opts)
+}
+
+func (st *SQLiteStore) AddSharingWithID(ctx context.Context, infoId, userId uint64, dirPath, shareID string, opts *db.SharingOptions) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddSharingWithID(ctx, infoId, userId, dirPath, shareID, opts)
 }
 
 func (st *SQLiteStore) DelSharing(ctx context.Context, userId uint64, dirPath string) error {
@@ -38,3 +48,94 @@ func (st *SQLiteStore) ListSharingsByLocation(ctx context.Context, location stri
 
 	return st.store.ListSharingsByLocation(ctx, location)
 }
+
+func (st *SQLiteStore) SetSharingExpiry(ctx context.Context, dirPath string, expireAt time.Time) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetSharingExpiry(ctx, dirPath, expireAt)
+}
+
+func (st *SQLiteStore) ListSharingExpiries(ctx context.Context, location string) (map[string]time.Time, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListSharingExpiries(ctx, location)
+}
+
+func (st *SQLiteStore) DelExpiredSharings(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.DelExpiredSharings(ctx, expiredBefore)
+}
+
+func (st *SQLiteStore) GetSharingPwd(ctx context.Context, dirPath string) (string, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetSharingPwd(ctx, dirPath)
+}
+
+func (st *SQLiteStore) SetSharingPwd(ctx context.Context, dirPath, pwdHash string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetSharingPwd(ctx, dirPath, pwdHash)
+}
+
+func (st *SQLiteStore) SetSharingMaxDownloads(ctx context.Context, dirPath string, maxDownloads int64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetSharingMaxDownloads(ctx, dirPath, maxDownloads)
+}
+
+func (st *SQLiteStore) AddSharingListing(ctx context.Context, dirPath, ip string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddSharingListing(ctx, dirPath, ip)
+}
+
+func (st *SQLiteStore) AddSharingDownload(ctx context.Context, dirPath, ip string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddSharingDownload(ctx, dirPath, ip)
+}
+
+func (st *SQLiteStore) ListSharingStats(ctx context.Context, location string) (map[string]*db.SharingStat, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListSharingStats(ctx, location)
+}
+
+func (st *SQLiteStore) SetSharingDrop(ctx context.Context, dirPath string, drop *db.SharingDrop) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetSharingDrop(ctx, dirPath, drop)
+}
+
+func (st *SQLiteStore) GetSharingDrop(ctx context.Context, dirPath string) (*db.SharingDrop, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetSharingDrop(ctx, dirPath)
+}
+
+func (st *SQLiteStore) AddSharingDropUsage(ctx context.Context, dirPath string, size, files int64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddSharingDropUsage(ctx, dirPath, size, files)
+}
+
+func (st *SQLiteStore) SetUploadDrop(ctx context.Context, userId uint64, filePath, dropPath string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetUploadDrop(ctx, userId, filePath, dropPath)
+}
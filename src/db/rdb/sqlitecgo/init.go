@@ -82,3 +82,54 @@ func (st *SQLiteStore) InitFileTables(ctx context.Context, tx *sql.Tx) error {
 func (st *SQLiteStore) InitConfigTable(ctx context.Context, tx *sql.Tx, cfg *db.SiteConfig) error {
 	return st.store.InitConfigTable(ctx, tx, cfg)
 }
+
+func (st *SQLiteStore) InitTrashTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitTrashTable(ctx, tx)
+}
+
+func (st *SQLiteStore) Upgrade(ctx context.Context) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.Upgrade(ctx)
+}
+
+func (st *SQLiteStore) InitRevisionTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitRevisionTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitSharingStatTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitSharingStatTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitSharingDropTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitSharingDropTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitFileACLTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitFileACLTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitAPITokenTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitAPITokenTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitSessionTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitSessionTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitUserTOTPTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitUserTOTPTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitUserIdentityTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitUserIdentityTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitRoleTable(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitRoleTable(ctx, tx)
+}
+
+func (st *SQLiteStore) InitGroupTables(ctx context.Context, tx *sql.Tx) error {
+	return st.store.InitGroupTables(ctx, tx)
+}
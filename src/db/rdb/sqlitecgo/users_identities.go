@@ -0,0 +1,19 @@
+package sqlitecgo
+
+import (
+	"context"
+)
+
+func (st *SQLiteStore) GetIdentityUser(ctx context.Context, provider, subject string) (uint64, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetIdentityUser(ctx, provider, subject)
+}
+
+func (st *SQLiteStore) AddUserIdentity(ctx context.Context, provider, subject string, userId uint64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddUserIdentity(ctx, provider, subject, userId)
+}
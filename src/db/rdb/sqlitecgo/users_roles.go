@@ -0,0 +1,42 @@
+package sqlitecgo
+
+import (
+	"context"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *SQLiteStore) AddRole(ctx context.Context, role *db.Role) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddRole(ctx, role)
+}
+
+func (st *SQLiteStore) GetRole(ctx context.Context, name string) (*db.Role, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetRole(ctx, name)
+}
+
+func (st *SQLiteStore) SetRole(ctx context.Context, role *db.Role) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetRole(ctx, role)
+}
+
+func (st *SQLiteStore) DelRole(ctx context.Context, name string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.DelRole(ctx, name)
+}
+
+func (st *SQLiteStore) ListRoles(ctx context.Context) ([]*db.Role, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListRoles(ctx)
+}
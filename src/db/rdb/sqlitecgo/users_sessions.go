@@ -0,0 +1,42 @@
+package sqlitecgo
+
+import (
+	"context"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *SQLiteStore) AddSession(ctx context.Context, session *db.Session) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.AddSession(ctx, session)
+}
+
+func (st *SQLiteStore) GetSession(ctx context.Context, id uint64) (*db.Session, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetSession(ctx, id)
+}
+
+func (st *SQLiteStore) ListSessions(ctx context.Context, userId uint64) ([]*db.Session, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.ListSessions(ctx, userId)
+}
+
+func (st *SQLiteStore) DelSession(ctx context.Context, userId, id uint64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.DelSession(ctx, userId, id)
+}
+
+func (st *SQLiteStore) DelSessions(ctx context.Context, userId, exceptId uint64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.DelSessions(ctx, userId, exceptId)
+}
@@ -0,0 +1,35 @@
+package sqlitecgo
+
+import (
+	"context"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func (st *SQLiteStore) GetUserTOTP(ctx context.Context, userId uint64) (*db.UserTOTP, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.store.GetUserTOTP(ctx, userId)
+}
+
+func (st *SQLiteStore) SetUserTOTP(ctx context.Context, totp *db.UserTOTP) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.SetUserTOTP(ctx, totp)
+}
+
+func (st *SQLiteStore) UseTOTPStep(ctx context.Context, userId uint64, step int64) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.UseTOTPStep(ctx, userId, step)
+}
+
+func (st *SQLiteStore) UseRecoveryCode(ctx context.Context, userId uint64, codeHash string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	return st.store.UseRecoveryCode(ctx, userId, codeHash)
+}
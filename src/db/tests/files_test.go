@@ -4,11 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ihexxa/quickshare/src/db"
 	"github.com/ihexxa/quickshare/src/db/rdb/sqlite"
@@ -41,6 +43,17 @@ func TestFileStore(t *testing.T) {
 		testSharingMethods(t, store)
 		testFileInfoMethods(t, store)
 		testUploadingMethods(t, store)
+		testUploadPartMethods(t, store)
+		testTrashMethods(t, store)
+		testRevisionMethods(t, store)
+		testSha1Methods(t, store)
+		testUploadHashMethods(t, store)
+		testSharingExpiryMethods(t, store)
+		testSharingPwdMethods(t, store)
+		testSharingStatMethods(t, store)
+		testSharingDropMethods(t, store)
+		testFileACLMethods(t, store)
+		testSharingIDMethods(t, store)
 	})
 }
 
@@ -68,7 +81,7 @@ func testSharingMethods(t *testing.T, store db.IDBQuickshare) {
 	// add sharings
 	for i, dirPath := range dirPaths {
 		infoId := uint64(i)
-		err = store.AddSharing(ctx, infoId, adminId, dirPath)
+		err = store.AddSharing(ctx, infoId, adminId, dirPath, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -96,7 +109,7 @@ func testSharingMethods(t *testing.T, store db.IDBQuickshare) {
 		info, err := store.GetFileInfo(ctx, sharingDir)
 		if err != nil {
 			t.Fatal(err)
-		} else if len(info.ShareID) != 7 {
+		} else if len(info.ShareID) != db.DefaultShareIDLen {
 			t.Fatalf("incorrect ShareID %s", info.ShareID)
 		} else if info.Id != uint64(i) {
 			t.Fatalf("incorrect file info ID %d", info.Id)
@@ -478,3 +491,1084 @@ func testFileInfoMethods(t *testing.T, store db.IDBQuickshare) {
 		}
 	}
 }
+
+func testTrashMethods(t *testing.T, store db.IDBQuickshare) {
+	pathInfos := map[string]*db.FileInfo{
+		"admin/trashing/dir": &db.FileInfo{
+			Id:      200,
+			IsDir:   true,
+			ShareID: "trashingShareID",
+		},
+		"admin/trashing/dir/item1": &db.FileInfo{
+			Id:   201,
+			Size: int64(5),
+			Sha1: "item1_sha",
+		},
+		"admin/trashing/dir/sub/item2": &db.FileInfo{
+			Id:   202,
+			Size: int64(11),
+			Sha1: "item2_sha",
+		},
+	}
+
+	adminId := uint64(0)
+	ctx := context.TODO()
+
+	for itemPath, info := range pathInfos {
+		err := store.AddFileInfo(ctx, info.Id, adminId, itemPath, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin, err := store.GetUser(ctx, adminId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usedSpace := admin.UsedSpace
+
+	assertUsedSpace := func(expected int64) {
+		user, err := store.GetUser(ctx, adminId)
+		if err != nil {
+			t.Fatal(err)
+		} else if user.UsedSpace != expected {
+			t.Fatalf("used space not match (%d) (%d)", user.UsedSpace, expected)
+		}
+	}
+
+	// siblings matched by patterns of the item case-insensitively are not trashed
+	siblingPath := "admin/trashing/DIR/item3"
+	err = store.AddFileInfo(ctx, 203, adminId, siblingPath, &db.FileInfo{Size: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	usedSpace += 7
+
+	// add trash
+	deletedAt := time.Now().Add(-time.Hour)
+	trash := &db.TrashInfo{
+		ID:         300,
+		UserID:     adminId,
+		OriginPath: "admin/trashing/dir",
+		TrashPath:  "admin/.trash/300",
+		IsDir:      true,
+		DeletedAt:  deletedAt,
+	}
+	err = store.AddTrash(ctx, trash)
+	if err != nil {
+		t.Fatal(err)
+	} else if trash.Size != 16 {
+		t.Fatalf("incorrect trash size (%d)", trash.Size)
+	}
+	// trashes are charged until they are deleted
+	assertUsedSpace(usedSpace)
+	_, err = store.GetFileInfo(ctx, siblingPath)
+	if err != nil {
+		t.Fatalf("sibling should not be trashed: %s", err)
+	}
+
+	for itemPath := range pathInfos {
+		_, err := store.GetFileInfo(ctx, itemPath)
+		if !errors.Is(err, db.ErrFileInfoNotFound) {
+			t.Fatalf("info should be moved: %s", err)
+		}
+		trashedPath := strings.Replace(itemPath, trash.OriginPath, trash.TrashPath, 1)
+		info, err := store.GetFileInfo(ctx, trashedPath)
+		if err != nil {
+			t.Fatal(err)
+		} else if info.Sha1 != pathInfos[itemPath].Sha1 || info.ShareID != "" {
+			t.Fatalf("incorrect trashed info (%+v)", info)
+		}
+	}
+	isSharing, err := store.IsSharing(ctx, trash.TrashPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if isSharing {
+		t.Fatal("trashed item should not be shared")
+	}
+
+	// get and list trashes
+	gotTrash, err := store.GetTrash(ctx, trash.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotTrash.OriginPath != trash.OriginPath ||
+		gotTrash.TrashPath != trash.TrashPath ||
+		gotTrash.Size != trash.Size ||
+		!gotTrash.IsDir ||
+		gotTrash.DeletedAt.Unix() != deletedAt.Unix() {
+		t.Fatalf("trash not equaled (%+v) (%+v)", gotTrash, trash)
+	}
+
+	trashes, err := store.ListTrashes(ctx, adminId)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(trashes) != 1 || trashes[0].ID != trash.ID {
+		t.Fatalf("incorrect trashes (%+v)", trashes)
+	}
+
+	trashes, err = store.ListExpiredTrashes(ctx, deletedAt.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(trashes) != 0 {
+		t.Fatalf("trashes should not be expired (%+v)", trashes)
+	}
+	trashes, err = store.ListExpiredTrashes(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	} else if len(trashes) != 1 {
+		t.Fatalf("trashes should be expired (%+v)", trashes)
+	}
+
+	// restore trash
+	err = store.RestoreTrash(ctx, trash.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertUsedSpace(usedSpace)
+	for itemPath := range pathInfos {
+		_, err := store.GetFileInfo(ctx, itemPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = store.GetTrash(ctx, trash.ID)
+	if !errors.Is(err, db.ErrTrashNotFound) {
+		t.Fatalf("trash should be removed: %s", err)
+	}
+
+	// delete trash
+	err = store.AddTrash(ctx, trash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DelTrash(ctx, trash.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertUsedSpace(usedSpace - 16)
+	_, err = store.GetFileInfo(ctx, siblingPath)
+	if err != nil {
+		t.Fatalf("sibling should not be deleted: %s", err)
+	}
+	for itemPath := range pathInfos {
+		trashedPath := strings.Replace(itemPath, trash.OriginPath, trash.TrashPath, 1)
+		_, err := store.GetFileInfo(ctx, trashedPath)
+		if !errors.Is(err, db.ErrFileInfoNotFound) {
+			t.Fatalf("info should be deleted: %s", err)
+		}
+	}
+	trashes, err = store.ListTrashes(ctx, adminId)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(trashes) != 0 {
+		t.Fatalf("trashes should be purged (%+v)", trashes)
+	}
+}
+
+func testRevisionMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	itemPath := "admin/versioned/file"
+	ctx := context.TODO()
+
+	assertUsedSpace := func(expected int64) {
+		user, err := store.GetUser(ctx, adminId)
+		if err != nil {
+			t.Fatal(err)
+		} else if user.UsedSpace != expected {
+			t.Fatalf("used space not match (%d) (%d)", user.UsedSpace, expected)
+		}
+	}
+	assertRevisions := func(expectedIDs []uint64) {
+		revisions, err := store.ListRevisions(ctx, itemPath)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(revisions) != len(expectedIDs) {
+			t.Fatalf("revisions count not match (%+v) (%+v)", revisions, expectedIDs)
+		}
+		for i, revision := range revisions {
+			if revision.ID != expectedIDs[i] {
+				t.Fatalf("revisions not match (%+v) (%+v)", revisions, expectedIDs)
+			}
+		}
+	}
+
+	err := store.AddFileInfo(ctx, 400, adminId, itemPath, &db.FileInfo{Size: 5, Sha1: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := store.GetUser(ctx, adminId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usedSpace := admin.UsedSpace
+
+	// add revisions
+	now := time.Now()
+	versions := []struct {
+		revisionID uint64
+		infoID     uint64
+		size       int64
+		sha1       string
+		createdAt  time.Time
+	}{
+		{revisionID: 500, infoID: 401, size: 7, sha1: "v2", createdAt: now.Add(-2 * time.Hour)},
+		{revisionID: 501, infoID: 402, size: 3, sha1: "v3", createdAt: now.Add(-time.Hour)},
+	}
+	for _, version := range versions {
+		err = store.AddRevision(ctx, &db.Revision{
+			ID:          version.revisionID,
+			Path:        itemPath,
+			UploaderID:  adminId,
+			StoragePath: fmt.Sprintf("admin/.revisions/%d", version.revisionID),
+			CreatedAt:   version.createdAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.GetFileInfo(ctx, itemPath)
+		if !errors.Is(err, db.ErrFileInfoNotFound) {
+			t.Fatalf("info should be turned into revision: %s", err)
+		}
+
+		err = store.AddFileInfo(ctx, version.infoID, adminId, itemPath, &db.FileInfo{Size: version.size, Sha1: version.sha1})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertUsedSpace(usedSpace + 7 + 3)
+	assertRevisions([]uint64{501, 500})
+
+	revision, err := store.GetRevision(ctx, 500)
+	if err != nil {
+		t.Fatal(err)
+	} else if revision.Sha1 != "v1" ||
+		revision.Size != 5 ||
+		revision.UploaderID != adminId ||
+		revision.CreatedAt.Unix() != versions[0].createdAt.Unix() {
+		t.Fatalf("incorrect revision (%+v)", revision)
+	}
+
+	// restore revision
+	err = store.RestoreRevision(ctx, 500, 403)
+	if !errors.Is(err, db.ErrConflicted) {
+		t.Fatalf("restoring should fail when the file exists: %s", err)
+	}
+	err = store.AddRevision(ctx, &db.Revision{
+		ID:          502,
+		Path:        itemPath,
+		UploaderID:  adminId,
+		StoragePath: "admin/.revisions/502",
+		CreatedAt:   now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.RestoreRevision(ctx, 500, 403)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := store.GetFileInfo(ctx, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Sha1 != "v1" || info.Size != 5 {
+		t.Fatalf("incorrect restored info (%+v)", info)
+	}
+	assertUsedSpace(usedSpace + 7 + 3)
+	assertRevisions([]uint64{502, 501})
+
+	// prune revisions
+	pruned, err := store.PruneRevisions(ctx, itemPath, 1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pruned) != 1 || pruned[0].ID != 501 {
+		t.Fatalf("incorrect pruned revisions (%+v)", pruned)
+	}
+	assertUsedSpace(usedSpace + 3)
+	assertRevisions([]uint64{502})
+	_, err = store.GetRevision(ctx, 501)
+	if !errors.Is(err, db.ErrRevisionNotFound) {
+		t.Fatalf("revision should be pruned: %s", err)
+	}
+}
+
+func testUploadPartMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	itemPath := "admin/parts/item"
+	ctx := context.TODO()
+
+	err := store.AddUploadInfos(ctx, 600, adminId, "admin/uploadings/parts", itemPath, &db.FileInfo{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetUploadPartSize(ctx, adminId, itemPath, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.AddUploadRange(ctx, adminId, itemPath, &db.UploadRange{Start: 8, End: 11})
+	if !errors.Is(err, db.ErrInvalidRange) {
+		t.Fatalf("range exceeding the size should be rejected: %s", err)
+	}
+	_, err = store.AddUploadRange(ctx, adminId, "admin/parts/unknown", &db.UploadRange{Start: 0, End: 4})
+	if !errors.Is(err, db.ErrUploadNotFound) {
+		t.Fatalf("upload should not be found: %s", err)
+	}
+
+	steps := []struct {
+		uploadRange    *db.UploadRange
+		expectedRanges []*db.UploadRange
+		uploaded       int64
+	}{
+		{
+			uploadRange:    &db.UploadRange{Start: 8, End: 10},
+			expectedRanges: []*db.UploadRange{{Start: 8, End: 10}},
+			uploaded:       2,
+		},
+		{
+			uploadRange:    &db.UploadRange{Start: 0, End: 4},
+			expectedRanges: []*db.UploadRange{{Start: 0, End: 4}, {Start: 8, End: 10}},
+			uploaded:       6,
+		},
+		{
+			// duplicated parts are merged
+			uploadRange:    &db.UploadRange{Start: 0, End: 4},
+			expectedRanges: []*db.UploadRange{{Start: 0, End: 4}, {Start: 8, End: 10}},
+			uploaded:       6,
+		},
+		{
+			uploadRange:    &db.UploadRange{Start: 4, End: 8},
+			expectedRanges: []*db.UploadRange{{Start: 0, End: 10}},
+			uploaded:       10,
+		},
+	}
+	for _, step := range steps {
+		parts, err := store.AddUploadRange(ctx, adminId, itemPath, step.uploadRange)
+		if err != nil {
+			t.Fatal(err)
+		} else if parts.Uploaded != step.uploaded || parts.PartSize != 4 || parts.Size != 10 {
+			t.Fatalf("incorrect parts (%+v)", parts)
+		}
+
+		gotParts, err := store.GetUploadParts(ctx, adminId, itemPath)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(gotParts.Ranges) != len(step.expectedRanges) {
+			t.Fatalf("incorrect ranges (%+v) (%+v)", gotParts.Ranges, step.expectedRanges)
+		}
+		for i, uploadRange := range gotParts.Ranges {
+			if *uploadRange != *step.expectedRanges[i] {
+				t.Fatalf("incorrect ranges (%+v) (%+v)", gotParts.Ranges, step.expectedRanges)
+			}
+		}
+	}
+
+	_, _, uploaded, err := store.GetUploadInfo(ctx, adminId, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if uploaded != 10 {
+		t.Fatalf("incorrect uploaded (%d)", uploaded)
+	}
+	err = store.DelUploadingInfos(ctx, adminId, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSha1Methods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	ctx := context.TODO()
+	sha1Sign := "2fd4e1c67a2d28fced849ee1bb76e7391b93eb12"
+
+	itemPaths := []string{"admin/sha1/item1", "admin/sha1/item2", "admin/sha1/item3"}
+	for i, itemPath := range itemPaths {
+		err := store.AddFileInfo(ctx, uint64(700+i), adminId, itemPath, &db.FileInfo{Size: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, itemPath := range itemPaths[:2] {
+		err := store.SetSha1(ctx, itemPath, sha1Sign)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	matched, err := store.ListFilesBySha1(ctx, sha1Sign, 5)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(matched) != 2 || matched[0] != itemPaths[0] || matched[1] != itemPaths[1] {
+		t.Fatalf("incorrect matched files: %v", matched)
+	}
+
+	matched, err = store.ListFilesBySha1(ctx, sha1Sign, 6)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(matched) != 0 {
+		t.Fatalf("files with different sizes should not be matched: %v", matched)
+	}
+
+	info, err := store.GetFileInfo(ctx, itemPaths[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Sha1 != sha1Sign {
+		t.Fatalf("incorrect sha1 in info: %s", info.Sha1)
+	}
+
+	// hashes are merged and sha1 in hashes is also indexed
+	err = store.SetHashes(ctx, itemPaths[2], map[string]string{"sha256": "sha256sign"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetHashes(ctx, itemPaths[2], map[string]string{"sha1": sha1Sign, "md5": "md5sign"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = store.GetFileInfo(ctx, itemPaths[2])
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Sha1 != sha1Sign ||
+		info.Hashes["sha1"] != sha1Sign ||
+		info.Hashes["sha256"] != "sha256sign" ||
+		info.Hashes["md5"] != "md5sign" {
+		t.Fatalf("incorrect hashes in info: %+v", info)
+	}
+	matched, err = store.ListFilesBySha1(ctx, sha1Sign, 5)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(matched) != 3 {
+		t.Fatalf("incorrect matched files: %v", matched)
+	}
+
+	for _, itemPath := range itemPaths {
+		err = store.DelFileInfo(ctx, adminId, itemPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	matched, err = store.ListFilesBySha1(ctx, sha1Sign, 5)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(matched) != 0 {
+		t.Fatalf("deleted files should not be matched: %v", matched)
+	}
+}
+
+func testUploadHashMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	itemPath := "admin/hash/item"
+	uploadPath := "admin/uploadings/hash"
+	expectedHash := "sha256:abcd"
+	ctx := context.TODO()
+
+	err := store.SetUploadExpectedHash(ctx, adminId, itemPath, expectedHash)
+	if !errors.Is(err, db.ErrUploadNotFound) {
+		t.Fatalf("setting hash of missing upload should fail: %s", err)
+	}
+
+	err = store.AddUploadInfos(ctx, 800, adminId, uploadPath, itemPath, &db.FileInfo{Size: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetUploadExpectedHash(ctx, adminId, itemPath, expectedHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotHash, err := store.GetUploadExpectedHash(ctx, adminId, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotHash != expectedHash {
+		t.Fatalf("incorrect expected hash of upload: %s", gotHash)
+	}
+	err = store.MoveUploadingInfos(ctx, 801, adminId, uploadPath, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the expected hash is moved with the upload
+	info, err := store.GetFileInfo(ctx, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.ExpectedHash != expectedHash {
+		t.Fatalf("incorrect expected hash: %s", info.ExpectedHash)
+	}
+
+	err = store.DelFileInfo(ctx, adminId, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSharingExpiryMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	expiredPath := "admin/expiry/expired"
+	alivePath := "admin/expiry/alive"
+	ctx := context.TODO()
+
+	err := store.SetSharingExpiry(ctx, expiredPath, time.Now())
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("setting expiry of missing sharing should fail: %s", err)
+	}
+
+	err = store.AddSharing(ctx, 900, adminId, expiredPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetSharingExpiry(ctx, expiredPath, time.Now().Add(-10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the expiry can also be set with the sharing
+	err = store.AddSharing(ctx, 901, adminId, alivePath, &db.SharingOptions{ExpireAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredInfo, err := store.GetFileInfo(ctx, expiredPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expired sharings are treated as gone
+	shared, err := store.IsSharing(ctx, expiredPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if shared {
+		t.Fatal("expired sharing should not be shared")
+	}
+	_, err = store.GetSharingDir(ctx, expiredInfo.ShareID)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("expired sharing should not be found: %s", err)
+	}
+	shared, err = store.IsSharing(ctx, alivePath)
+	if err != nil {
+		t.Fatal(err)
+	} else if !shared {
+		t.Fatal("unexpired sharing should be shared")
+	}
+
+	dirToID, err := store.ListSharingsByLocation(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := dirToID[expiredPath]; ok {
+		t.Fatal("expired sharing should not be listed")
+	} else if _, ok := dirToID[alivePath]; !ok {
+		t.Fatal("unexpired sharing should be listed")
+	}
+	dirToExpiry, err := store.ListSharingExpiries(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dirToExpiry) != 1 {
+		t.Fatalf("incorrect expiries: %v", dirToExpiry)
+	} else if time.Until(dirToExpiry[alivePath]) <= 0 {
+		t.Fatalf("incorrect expiry: %v", dirToExpiry[alivePath])
+	}
+
+	cleared, err := store.DelExpiredSharings(ctx, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	} else if cleared != 1 {
+		t.Fatalf("incorrect cleared sharings: %d", cleared)
+	}
+	expiredInfo, err = store.GetFileInfo(ctx, expiredPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if expiredInfo.ShareID != "" {
+		t.Fatalf("expired sharing should be cleared: %s", expiredInfo.ShareID)
+	}
+
+	// sharing again resets the expiry
+	err = store.AddSharing(ctx, 902, adminId, alivePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirToExpiry, err = store.ListSharingExpiries(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dirToExpiry) != 0 {
+		t.Fatalf("expiry should be reset: %v", dirToExpiry)
+	}
+
+	for _, dirPath := range []string{expiredPath, alivePath} {
+		err = store.DelSharing(ctx, adminId, dirPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testSharingPwdMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	dirPath := "admin/protected"
+	pwdHash := "hash"
+	ctx := context.TODO()
+
+	err := store.SetSharingPwd(ctx, dirPath, pwdHash)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("setting password of missing sharing should fail: %s", err)
+	}
+
+	err = store.AddSharing(ctx, 1000, adminId, dirPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetSharingPwd(ctx, dirPath, pwdHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotHash, err := store.GetSharingPwd(ctx, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotHash != pwdHash {
+		t.Fatalf("incorrect password hash: %s", gotHash)
+	}
+
+	// sharing again removes the protection
+	err = store.AddSharing(ctx, 1001, adminId, dirPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotHash, err = store.GetSharingPwd(ctx, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotHash != "" {
+		t.Fatalf("password should be removed: %s", gotHash)
+	}
+
+	// the password can also be set with the sharing
+	err = store.AddSharing(ctx, 1001, adminId, dirPath, &db.SharingOptions{PwdHash: pwdHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotHash, err = store.GetSharingPwd(ctx, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotHash != pwdHash {
+		t.Fatalf("incorrect password hash: %s", gotHash)
+	}
+
+	err = store.DelSharing(ctx, adminId, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.GetSharingPwd(ctx, dirPath)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("deleted sharing should not be found: %s", err)
+	}
+}
+
+func testSharingStatMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	dirPath := "admin/stats"
+	ip := "127.0.0.1"
+	ctx := context.TODO()
+
+	err := store.AddSharingDownload(ctx, dirPath, ip)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("recording missing sharing should fail: %s", err)
+	}
+
+	err = store.AddSharing(ctx, 1100, adminId, dirPath, &db.SharingOptions{MaxDownloads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetSharingMaxDownloads(ctx, dirPath, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddSharingListing(ctx, dirPath, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddSharingDownload(ctx, dirPath, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pathToStat, err := store.ListSharingStats(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, ok := pathToStat[dirPath]
+	if !ok {
+		t.Fatalf("stat not found: %v", pathToStat)
+	} else if stat.ListCount != 1 || stat.DownloadCount != 1 || stat.MaxDownloads != 2 {
+		t.Fatalf("incorrect stat: %+v", stat)
+	} else if stat.LastIP != ip || stat.LastAccess.IsZero() {
+		t.Fatalf("incorrect last access: %+v", stat)
+	}
+
+	// the sharing is disabled once it reaches the max downloads
+	err = store.AddSharingDownload(ctx, dirPath, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := store.IsSharing(ctx, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if shared {
+		t.Fatal("sharing should be disabled")
+	}
+	err = store.AddSharingDownload(ctx, dirPath, ip)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("recording disabled sharing should fail: %s", err)
+	}
+	pathToStat, err = store.ListSharingStats(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := pathToStat[dirPath]; ok {
+		t.Fatalf("stat of disabled sharing should be removed: %v", pathToStat)
+	}
+}
+
+func testSharingDropMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	dirPath := "admin/drop"
+	ctx := context.TODO()
+
+	_, err := store.GetSharingDrop(ctx, dirPath)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("missing drop should not be found: %s", err)
+	}
+
+	err = store.AddSharing(ctx, 1200, adminId, dirPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.GetSharingDrop(ctx, dirPath)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("normal sharing should not be a drop: %s", err)
+	}
+	err = store.SetSharingDrop(ctx, dirPath, &db.SharingDrop{OwnerID: adminId, MaxSize: 10, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.AddSharingDropUsage(ctx, dirPath, 6, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddSharingDropUsage(ctx, dirPath, 6, 1)
+	if !errors.Is(err, db.ErrReachedLimit) {
+		t.Fatalf("size cap should be reached: %s", err)
+	}
+	err = store.AddSharingDropUsage(ctx, dirPath, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddSharingDropUsage(ctx, dirPath, 0, 1)
+	if !errors.Is(err, db.ErrReachedLimit) {
+		t.Fatalf("file count cap should be reached: %s", err)
+	}
+	err = store.AddSharingDropUsage(ctx, dirPath, -4, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drop, err := store.GetSharingDrop(ctx, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if drop.UsedSize != 6 || drop.FileCount != 1 || drop.MaxSize != 10 || drop.MaxFiles != 2 {
+		t.Fatalf("incorrect drop: %+v", drop)
+	}
+
+	err = store.DelSharing(ctx, adminId, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.GetSharingDrop(ctx, dirPath)
+	if !errors.Is(err, db.ErrSharingNotFound) {
+		t.Fatalf("deleted drop should not be found: %s", err)
+	}
+
+	// the drop can also be set with the sharing
+	err = store.AddSharing(ctx, 1201, adminId, dirPath, &db.SharingOptions{
+		Drop: &db.SharingDrop{OwnerID: adminId, MaxSize: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop, err = store.GetSharingDrop(ctx, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if drop.UsedSize != 0 || drop.MaxSize != 10 || drop.MaxFiles != 0 {
+		t.Fatalf("incorrect drop: %+v", drop)
+	}
+
+	// usage of uploads into the drop is released after deleting them
+	itemPath := "admin/drop/upload"
+	err = store.AddSharingDropUsage(ctx, dirPath, 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddUploadInfos(ctx, 1202, adminId, "admin/uploadings/drop/upload", itemPath, &db.FileInfo{Size: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetUploadDrop(ctx, adminId, itemPath, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DelUploadingInfos(ctx, adminId, itemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop, err = store.GetSharingDrop(ctx, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	} else if drop.UsedSize != 0 || drop.FileCount != 0 {
+		t.Fatalf("usage of the deleted upload is not released: %+v", drop)
+	}
+	err = store.DelSharing(ctx, adminId, dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testFileACLMethods(t *testing.T, store db.IDBQuickshare) {
+	ownerId, granteeId, otherId := uint64(0), uint64(2), uint64(3)
+	ctx := context.TODO()
+
+	_, err := store.GetFileACL(ctx, 1300)
+	if !errors.Is(err, db.ErrFileACLNotFound) {
+		t.Fatalf("missing acl should not be found: %s", err)
+	}
+
+	acls := []*db.FileACL{
+		{ID: 1300, Path: "admin/projects", GranteeType: db.GranteeUser, GranteeID: granteeId, Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now()},
+		{ID: 1301, Path: "admin/projects/foo", GranteeType: db.GranteeUser, GranteeID: granteeId, Perm: db.PermReadWrite, GranterID: ownerId, CreatedAt: time.Now()},
+		{ID: 1302, Path: "admin/projects", GranteeType: db.GranteeUser, GranteeID: otherId, Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now()},
+		{ID: 1303, Path: "admin/projects", GranteeType: db.GranteeGroup, GranteeID: granteeId, Perm: db.PermReadWrite, GranterID: ownerId, CreatedAt: time.Now()},
+	}
+	for _, acl := range acls {
+		err = store.AddFileACL(ctx, acl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// granting again updates the permission
+	err = store.AddFileACL(ctx, &db.FileACL{
+		ID: 1304, Path: "admin/projects", GranteeType: db.GranteeUser, GranteeID: otherId,
+		Perm: db.PermReadWrite, GranterID: ownerId, CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl, err := store.GetFileACL(ctx, 1302)
+	if err != nil {
+		t.Fatal(err)
+	} else if acl.Perm != db.PermReadWrite || acl.Path != "admin/projects" || acl.GranteeID != otherId {
+		t.Fatalf("incorrect acl: %+v", acl)
+	}
+
+	pathACLs, err := store.ListFileACLs(ctx, "admin/projects")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pathACLs) != 3 {
+		t.Fatalf("incorrect acls of path: %d", len(pathACLs))
+	}
+
+	granteeACLs, err := store.ListGranteeFileACLs(ctx, db.GranteeUser, []uint64{granteeId})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(granteeACLs) != 2 {
+		t.Fatalf("incorrect acls of grantee: %d", len(granteeACLs))
+	}
+
+	matched := map[string]int{
+		"admin/projects/foo/bar.md": 2,
+		"admin/projects/foo":        2,
+		"admin/projects/foobar":     1,
+		"admin/projects":            1,
+		"admin/others":              0,
+		"admin":                     0,
+	}
+	for itemPath, expected := range matched {
+		matchedACLs, err := store.MatchFileACLs(ctx, itemPath, db.GranteeUser, []uint64{granteeId})
+		if err != nil {
+			t.Fatal(err)
+		} else if len(matchedACLs) != expected {
+			t.Fatalf("incorrect matched acls of (%s): %d", itemPath, len(matchedACLs))
+		}
+	}
+
+	err = store.DelFileACL(ctx, 1301)
+	if err != nil {
+		t.Fatal(err)
+	}
+	matchedACLs, err := store.MatchFileACLs(ctx, "admin/projects/foo/bar.md", db.GranteeUser, []uint64{granteeId})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(matchedACLs) != 1 || matchedACLs[0].Perm != db.PermRead {
+		t.Fatalf("incorrect matched acls after deleting: %+v", matchedACLs)
+	}
+
+	// grants are moved and deleted with items
+	countACLs := func(itemPath string) int {
+		pathACLs, err := store.ListFileACLs(ctx, itemPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(pathACLs)
+	}
+	err = store.AddFileACL(ctx, &db.FileACL{
+		ID: 1305, Path: "admin/projects/bar", GranteeType: db.GranteeUser, GranteeID: granteeId,
+		Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.MoveFileInfo(ctx, ownerId, "admin/projects", "admin/moved", true)
+	if err != nil {
+		t.Fatal(err)
+	} else if countACLs("admin/projects") != 0 || countACLs("admin/moved") != 3 || countACLs("admin/moved/bar") != 1 {
+		t.Fatal("grants are not moved")
+	}
+	err = store.AddTrash(ctx, &db.TrashInfo{
+		ID:         1306,
+		UserID:     ownerId,
+		OriginPath: "admin/moved/bar",
+		TrashPath:  "admin/.trash/1306",
+		DeletedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if countACLs("admin/moved/bar") != 0 || countACLs("admin/.trash/1306") != 0 {
+		t.Fatal("grants of trashed items are not deleted")
+	}
+	err = store.DelFileInfo(ctx, ownerId, "admin/moved")
+	if err != nil {
+		t.Fatal(err)
+	} else if countACLs("admin/moved") != 0 {
+		t.Fatal("grants of deleted items are not deleted")
+	}
+
+	// grants of siblings are not matched by wildcards in paths
+	siblingACLs := []*db.FileACL{
+		{ID: 1311, Path: "admin/a_b/c", GranteeType: db.GranteeUser, GranteeID: granteeId, Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now()},
+		{ID: 1312, Path: "admin/aXb/c", GranteeType: db.GranteeUser, GranteeID: granteeId, Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now()},
+		{ID: 1313, Path: "admin/A_B/c", GranteeType: db.GranteeUser, GranteeID: granteeId, Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now()},
+	}
+	for _, acl := range siblingACLs {
+		err = store.AddFileACL(ctx, acl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.MoveFileInfo(ctx, ownerId, "admin/a_b", "admin/a_b2", true)
+	if err != nil {
+		t.Fatal(err)
+	} else if countACLs("admin/a_b2/c") != 1 || countACLs("admin/aXb/c") != 1 || countACLs("admin/A_B/c") != 1 {
+		t.Fatal("grants of siblings are moved")
+	}
+	err = store.DelFileInfo(ctx, ownerId, "admin/a_b2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.MoveFileInfo(ctx, ownerId, "admin/aXb", "admin/a_b2", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DelFileInfo(ctx, ownerId, "admin/a%b2")
+	if err != nil {
+		t.Fatal(err)
+	} else if countACLs("admin/a_b2/c") != 1 || countACLs("admin/A_B/c") != 1 {
+		t.Fatal("grants of siblings are deleted")
+	}
+
+	// grants to, by and in the home of deleted users are deleted
+	userId := uint64(1307)
+	err = store.AddUser(ctx, &db.User{
+		ID:          userId,
+		Name:        "acl_user",
+		Pwd:         "1234",
+		Role:        db.UserRole,
+		Quota:       &db.Quota{},
+		Preferences: &db.DefaultPreferences,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userACLs := []*db.FileACL{
+		{ID: 1308, Path: "admin/shared", GranteeType: db.GranteeUser, GranteeID: userId, Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now()},
+		{ID: 1309, Path: "@devs/shared", GranteeType: db.GranteeUser, GranteeID: granteeId, Perm: db.PermRead, GranterID: userId, CreatedAt: time.Now()},
+		{ID: 1310, Path: "acl_user/files", GranteeType: db.GranteeUser, GranteeID: granteeId, Perm: db.PermRead, GranterID: ownerId, CreatedAt: time.Now()},
+	}
+	for _, acl := range userACLs {
+		err = store.AddFileACL(ctx, acl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.DelUser(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acl := range userACLs {
+		_, err = store.GetFileACL(ctx, acl.ID)
+		if !errors.Is(err, db.ErrFileACLNotFound) {
+			t.Fatalf("grant(%s) of the deleted user is not deleted: %s", acl.Path, err)
+		}
+	}
+}
+
+func testSharingIDMethods(t *testing.T, store db.IDBQuickshare) {
+	adminId := uint64(0)
+	slugPath, otherPath := "admin/slug", "admin/slug_other"
+	ctx := context.TODO()
+
+	shareID, err := db.GenShareID(db.DefaultShareIDLen)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(shareID) != db.DefaultShareIDLen {
+		t.Fatalf("incorrect share ID length: %s", shareID)
+	}
+
+	err = store.AddSharingWithID(ctx, 1400, adminId, slugPath, "q3-report", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharedPath, err := store.GetSharingDir(ctx, "q3-report")
+	if err != nil {
+		t.Fatal(err)
+	} else if sharedPath != slugPath {
+		t.Fatalf("incorrect sharing dir: %s", sharedPath)
+	}
+
+	// IDs can not be shared by paths
+	err = store.AddSharingWithID(ctx, 1401, adminId, otherPath, "q3-report", nil)
+	if !errors.Is(err, db.ErrConflicted) {
+		t.Fatalf("share ID should conflict: %s", err)
+	}
+	// sharing the same path again replaces its ID
+	err = store.AddSharingWithID(ctx, 1400, adminId, slugPath, "q3-report", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddSharingWithID(ctx, 1400, adminId, slugPath, "q4-report", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddSharingWithID(ctx, 1401, adminId, otherPath, "q3-report", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// generated IDs are in the length of options
+	generatedPath := "admin/slug_generated"
+	err = store.AddSharing(ctx, 1402, adminId, generatedPath, &db.SharingOptions{IDLen: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharings, err := store.ListSharingsByLocation(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(sharings[generatedPath]) != 20 {
+		t.Fatalf("incorrect generated share ID: %s", sharings[generatedPath])
+	}
+
+	for _, dirPath := range []string{slugPath, otherPath, generatedPath} {
+		err = store.DelSharing(ctx, adminId, dirPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -2,11 +2,15 @@ package tests
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ihexxa/quickshare/src/db"
 	"github.com/ihexxa/quickshare/src/db/rdb/sqlite"
@@ -338,5 +342,566 @@ func TestUserStore(t *testing.T) {
 		}
 
 		testUserMethods(t, store)
+		testAPITokenMethods(t, store)
+		testSessionMethods(t, store)
+		testUserTOTPMethods(t, store)
+		testUserIdentityMethods(t, store)
+		testRoleMethods(t, store)
+		testGroupMethods(t, store)
 	})
 }
+
+func testAPITokenMethods(t *testing.T, store db.IDBQuickshare) {
+	ctx := context.TODO()
+	userId := uint64(0)
+
+	_, err := store.GetAPITokenByHash(ctx, "missing")
+	if !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Fatalf("missing token should not be found: %s", err)
+	}
+
+	tokens := []*db.APIToken{
+		{ID: 100, UserID: userId, Name: "ci", Scope: db.APIScopeReadOnly, TokenHash: "hash1", CreatedAt: time.Now()},
+		{ID: 101, UserID: userId, Name: "backup", Scope: db.APIScopeAdmin, TokenHash: "hash2", CreatedAt: time.Now()},
+	}
+	for _, token := range tokens {
+		err = store.AddAPIToken(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.AddAPIToken(ctx, &db.APIToken{ID: 102, UserID: userId, Name: "dup", Scope: db.APIScopeReadOnly, TokenHash: "hash1", CreatedAt: time.Now()})
+	if err == nil {
+		t.Fatal("token hashes should be unique")
+	}
+
+	token, err := store.GetAPITokenByHash(ctx, "hash2")
+	if err != nil {
+		t.Fatal(err)
+	} else if token.ID != 101 || token.Name != "backup" || token.Scope != db.APIScopeAdmin || !token.LastUsedAt.IsZero() {
+		t.Fatalf("incorrect token: %+v", token)
+	}
+
+	usedAt := time.Now()
+	err = store.SetAPITokenUsed(ctx, 101, usedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = store.GetAPITokenByHash(ctx, "hash2")
+	if err != nil {
+		t.Fatal(err)
+	} else if token.LastUsedAt.Unix() != usedAt.Unix() {
+		t.Fatalf("incorrect last used time: %s", token.LastUsedAt)
+	}
+
+	listed, err := store.ListAPITokens(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(listed) != 2 {
+		t.Fatalf("incorrect tokens size: %d", len(listed))
+	}
+
+	err = store.DelAPIToken(ctx, userId+1, 100)
+	if !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Fatalf("tokens of others should not be deleted: %s", err)
+	}
+	for _, token := range tokens {
+		err = store.DelAPIToken(ctx, userId, token.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed, err = store.ListAPITokens(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(listed) != 0 {
+		t.Fatalf("tokens should be deleted: %d", len(listed))
+	}
+}
+
+func testSessionMethods(t *testing.T, store db.IDBQuickshare) {
+	ctx := context.TODO()
+	userId, otherId := uint64(0), uint64(1)
+	now := time.Now()
+
+	_, err := store.GetSession(ctx, 1)
+	if !errors.Is(err, db.ErrSessionNotFound) {
+		t.Fatalf("missing session should not be found: %s", err)
+	}
+
+	sessions := []*db.Session{
+		{ID: 200, UserID: userId, IP: "127.0.0.1", UserAgent: "ua1", CreatedAt: now, ExpireAt: now.Add(time.Hour)},
+		{ID: 201, UserID: userId, IP: "127.0.0.2", UserAgent: "ua2", CreatedAt: now, ExpireAt: now.Add(time.Hour)},
+		{ID: 202, UserID: userId, IP: "127.0.0.3", UserAgent: "ua3", CreatedAt: now, ExpireAt: now.Add(time.Hour)},
+		{ID: 203, UserID: otherId, IP: "127.0.0.4", UserAgent: "ua4", CreatedAt: now, ExpireAt: now.Add(time.Hour)},
+		{ID: 204, UserID: userId, IP: "127.0.0.5", UserAgent: "ua5", CreatedAt: now, ExpireAt: now.Add(-time.Hour)},
+	}
+	for _, session := range sessions {
+		err = store.AddSession(ctx, session)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session, err := store.GetSession(ctx, 201)
+	if err != nil {
+		t.Fatal(err)
+	} else if session.UserID != userId || session.IP != "127.0.0.2" || session.UserAgent != "ua2" ||
+		session.ExpireAt.Unix() != now.Add(time.Hour).Unix() {
+		t.Fatalf("incorrect session: %+v", session)
+	}
+	_, err = store.GetSession(ctx, 204)
+	if !errors.Is(err, db.ErrSessionNotFound) {
+		t.Fatalf("expired session should not be found: %s", err)
+	}
+
+	listed, err := store.ListSessions(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(listed) != 3 {
+		t.Fatalf("incorrect sessions size: %d", len(listed))
+	}
+
+	err = store.DelSession(ctx, otherId, 200)
+	if !errors.Is(err, db.ErrSessionNotFound) {
+		t.Fatalf("sessions of others should not be deleted: %s", err)
+	}
+	err = store.DelSession(ctx, userId, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DelSessions(ctx, userId, 202)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err = store.ListSessions(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(listed) != 1 || listed[0].ID != 202 {
+		t.Fatalf("only the excepted session should be kept: %+v", listed)
+	}
+	_, err = store.GetSession(ctx, 203)
+	if err != nil {
+		t.Fatalf("sessions of others should be kept: %s", err)
+	}
+
+	for _, id := range []uint64{userId, otherId} {
+		err = store.DelSessions(ctx, id, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testUserTOTPMethods(t *testing.T, store db.IDBQuickshare) {
+	ctx := context.TODO()
+	userId := uint64(0)
+
+	userTOTP, err := store.GetUserTOTP(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	} else if userTOTP.UserID != userId || userTOTP.Enabled || userTOTP.Forced || userTOTP.Secret != "" {
+		t.Fatalf("2fa should be disabled by default: %+v", userTOTP)
+	}
+
+	err = store.SetUserTOTP(ctx, &db.UserTOTP{
+		UserID:        userId,
+		Secret:        "secret",
+		Enabled:       true,
+		Forced:        true,
+		RecoveryCodes: []string{"hash1", "hash2"},
+		LastStep:      10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userTOTP, err = store.GetUserTOTP(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	} else if userTOTP.Secret != "secret" || !userTOTP.Enabled || !userTOTP.Forced ||
+		len(userTOTP.RecoveryCodes) != 2 || userTOTP.LastStep != 10 {
+		t.Fatalf("incorrect 2fa: %+v", userTOTP)
+	}
+
+	err = store.UseTOTPStep(ctx, userId, 10)
+	if !errors.Is(err, db.ErrTOTPCodeUsed) {
+		t.Fatalf("used step should be rejected: %s", err)
+	}
+	err = store.UseTOTPStep(ctx, userId, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.UseRecoveryCode(ctx, userId, "hash1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.UseRecoveryCode(ctx, userId, "hash1")
+	if !errors.Is(err, db.ErrRecoveryCodeNotFound) {
+		t.Fatalf("used recovery code should be rejected: %s", err)
+	}
+
+	userTOTP, err = store.GetUserTOTP(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	} else if userTOTP.LastStep != 11 || len(userTOTP.RecoveryCodes) != 1 || userTOTP.RecoveryCodes[0] != "hash2" {
+		t.Fatalf("incorrect 2fa: %+v", userTOTP)
+	}
+
+	err = store.SetUserTOTP(ctx, &db.UserTOTP{UserID: userId})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUserIdentityMethods(t *testing.T, store db.IDBQuickshare) {
+	ctx := context.TODO()
+	provider, subject, userId := "https://idp.example.com", "subject", uint64(0)
+
+	_, err := store.GetIdentityUser(ctx, provider, subject)
+	if !errors.Is(err, db.ErrIdentityNotFound) {
+		t.Fatalf("identity should not be found: %s", err)
+	}
+
+	err = store.AddUserIdentity(ctx, provider, subject, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddUserIdentity(ctx, provider, subject, userId+1)
+	if !errors.Is(err, db.ErrConflicted) {
+		t.Fatalf("linked identity should not be linked again: %s", err)
+	}
+
+	gotId, err := store.GetIdentityUser(ctx, provider, subject)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotId != userId {
+		t.Fatalf("incorrect user ID: %d", gotId)
+	}
+	_, err = store.GetIdentityUser(ctx, "https://other.example.com", subject)
+	if !errors.Is(err, db.ErrIdentityNotFound) {
+		t.Fatalf("identity of other providers should not be found: %s", err)
+	}
+}
+
+func testRoleMethods(t *testing.T, store db.IDBQuickshare) {
+	ctx := context.TODO()
+	auditor := &db.Role{
+		Name: "auditor",
+		Rules: []*db.APIRule{
+			{Method: "GET", Path: "/v2/admin/users/list"},
+			{Method: "GET", Path: "/v2/my/fs/", Prefix: true},
+		},
+		Capabilities: []string{db.CapRead, db.CapAllFiles},
+	}
+	uploader := &db.Role{
+		Name:         "uploader",
+		Rules:        []*db.APIRule{{Method: "*", Path: "/v2/my/fs/", Prefix: true}},
+		Capabilities: []string{db.CapWrite},
+	}
+
+	_, err := store.GetRole(ctx, auditor.Name)
+	if !errors.Is(err, db.ErrRoleNotFound) {
+		t.Fatalf("role should not be found: %s", err)
+	}
+	for _, role := range []*db.Role{auditor, uploader} {
+		if err = store.AddRole(ctx, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.AddRole(ctx, auditor)
+	if !errors.Is(err, db.ErrConflicted) {
+		t.Fatalf("existing role should not be added: %s", err)
+	}
+
+	gotRole, err := store.GetRole(ctx, auditor.Name)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(gotRole, auditor) {
+		t.Fatalf("roles not equal: %+v %+v", gotRole, auditor)
+	}
+
+	uploader.Capabilities = []string{db.CapRead, db.CapWrite}
+	if err = store.SetRole(ctx, uploader); err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetRole(ctx, &db.Role{Name: "nobody"})
+	if !errors.Is(err, db.ErrRoleNotFound) {
+		t.Fatalf("missing role should not be set: %s", err)
+	}
+
+	roles, err := store.ListRoles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(roles, []*db.Role{auditor, uploader}) {
+		t.Fatalf("incorrect roles: %+v", roles)
+	}
+
+	if err = store.DelRole(ctx, auditor.Name); err != nil {
+		t.Fatal(err)
+	}
+	err = store.DelRole(ctx, auditor.Name)
+	if !errors.Is(err, db.ErrRoleNotFound) {
+		t.Fatalf("deleted role should not be found: %s", err)
+	}
+	roles, err = store.ListRoles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(roles) != 1 || roles[0].Name != uploader.Name {
+		t.Fatalf("incorrect roles: %+v", roles)
+	}
+}
+
+func testGroupMethods(t *testing.T, store db.IDBQuickshare) {
+	ctx := context.TODO()
+	rootId := uint64(0)
+	group := &db.Group{
+		ID:   1001,
+		Name: "devs",
+		Quota: &db.Quota{
+			SpaceLimit:         100,
+			UploadSpeedLimit:   1024,
+			DownloadSpeedLimit: 1024,
+		},
+	}
+
+	_, err := store.GetGroupByName(ctx, group.Name)
+	if !errors.Is(err, db.ErrGroupNotFound) {
+		t.Fatalf("group should not be found: %s", err)
+	}
+	if err = store.AddGroup(ctx, group); err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddGroup(ctx, &db.Group{ID: 1002, Name: group.Name, Quota: group.Quota})
+	if !errors.Is(err, db.ErrConflicted) {
+		t.Fatalf("group name should not be reused: %s", err)
+	}
+
+	group.Quota.SpaceLimit = 200
+	if err = store.SetGroupQuota(ctx, group.ID, group.Quota); err != nil {
+		t.Fatal(err)
+	}
+	gotGroup, err := store.GetGroupByName(ctx, group.Name)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(gotGroup, group) {
+		t.Fatalf("groups not equal: %+v %+v", gotGroup, group)
+	}
+
+	// files in the group folder are charged to the group
+	rootUser, err := store.GetUser(ctx, rootId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupFilePath := db.GroupLocation(group.Name) + "/files/report"
+	err = store.AddFileInfo(ctx, 2001, rootId, groupFilePath, &db.FileInfo{Size: 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddFileInfo(ctx, 2002, rootId, groupFilePath+"2", &db.FileInfo{Size: 100})
+	if !errors.Is(err, db.ErrReachedLimit) {
+		t.Fatalf("group quota should be reached: %s", err)
+	}
+	gotGroup, err = store.GetGroup(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotGroup.UsedSpace != 150 {
+		t.Fatalf("incorrect group used space: %d", gotGroup.UsedSpace)
+	}
+	gotRoot, err := store.GetUser(ctx, rootId)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotRoot.UsedSpace != rootUser.UsedSpace {
+		t.Fatalf("user should not be charged: %d %d", gotRoot.UsedSpace, rootUser.UsedSpace)
+	}
+	if err = store.DelFileInfo(ctx, rootId, groupFilePath); err != nil {
+		t.Fatal(err)
+	}
+	gotGroup, err = store.GetGroup(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if gotGroup.UsedSpace != 0 {
+		t.Fatalf("incorrect group used space: %d", gotGroup.UsedSpace)
+	}
+
+	// the space is moved with items moved in or out of the group folder
+	assertUsedSpaces := func(groupUsed, rootUsed int64) {
+		gotGroup, err := store.GetGroup(ctx, group.ID)
+		if err != nil {
+			t.Fatal(err)
+		} else if gotGroup.UsedSpace != groupUsed {
+			t.Fatalf("incorrect group used space: %d %d", gotGroup.UsedSpace, groupUsed)
+		}
+		gotRoot, err := store.GetUser(ctx, rootId)
+		if err != nil {
+			t.Fatal(err)
+		} else if gotRoot.UsedSpace != rootUsed {
+			t.Fatalf("incorrect user used space: %d %d", gotRoot.UsedSpace, rootUsed)
+		}
+	}
+	userFilePath := "qs/files/moving"
+	err = store.AddFileInfo(ctx, 2006, rootId, userFilePath, &db.FileInfo{Size: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertUsedSpaces(0, rootUser.UsedSpace+30)
+	if err = store.MoveFileInfo(ctx, rootId, userFilePath, groupFilePath, false); err != nil {
+		t.Fatal(err)
+	}
+	assertUsedSpaces(30, rootUser.UsedSpace)
+
+	// items trashed out of the group folder are charged to the owner of the trash until they are restored
+	err = store.AddTrash(ctx, &db.TrashInfo{
+		ID: 2007, UserID: rootId, OriginPath: groupFilePath, TrashPath: "qs/.trash/2007", DeletedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertUsedSpaces(0, rootUser.UsedSpace+30)
+	if err = store.RestoreTrash(ctx, 2007); err != nil {
+		t.Fatal(err)
+	}
+	assertUsedSpaces(30, rootUser.UsedSpace)
+
+	if err = store.MoveFileInfo(ctx, rootId, groupFilePath, userFilePath, false); err != nil {
+		t.Fatal(err)
+	}
+	assertUsedSpaces(0, rootUser.UsedSpace+30)
+	if err = store.DelFileInfo(ctx, rootId, userFilePath); err != nil {
+		t.Fatal(err)
+	}
+
+	members := []*db.GroupMember{
+		{GroupID: group.ID, UserID: rootId, Perm: db.PermRead},
+		{GroupID: group.ID, UserID: 3001, Perm: db.PermReadWrite},
+	}
+	for _, member := range members {
+		if err = store.SetGroupMember(ctx, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	members[0].Perm = db.PermReadWrite
+	if err = store.SetGroupMember(ctx, members[0]); err != nil {
+		t.Fatal(err)
+	}
+	err = store.SetGroupMember(ctx, &db.GroupMember{GroupID: 1002, UserID: rootId, Perm: db.PermRead})
+	if !errors.Is(err, db.ErrGroupNotFound) {
+		t.Fatalf("members should not be added to missing groups: %s", err)
+	}
+
+	gotMembers, err := store.ListGroupMembers(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(gotMembers, members) {
+		t.Fatalf("incorrect members: %+v", gotMembers)
+	}
+	gotMembers, err = store.ListUserGroups(ctx, 3001)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(gotMembers, members[1:]) {
+		t.Fatalf("incorrect groups: %+v", gotMembers)
+	}
+
+	if err = store.DelGroupMember(ctx, group.ID, 3001); err != nil {
+		t.Fatal(err)
+	}
+	err = store.DelGroupMember(ctx, group.ID, 3001)
+	if !errors.Is(err, db.ErrGroupMemberNotFound) {
+		t.Fatalf("deleted member should not be found: %s", err)
+	}
+
+	// pending uploads, infos and grants in the group folder are deleted with the group
+	groupTmpPath := "qs/uploadings/report"
+	err = store.AddUploadInfos(ctx, 2003, rootId, groupTmpPath, groupFilePath+"3", &db.FileInfo{Size: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddFileInfo(ctx, 2004, rootId, groupFilePath+"4", &db.FileInfo{Size: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddFileACL(ctx, &db.FileACL{
+		ID: 2005, Path: groupFilePath + "4", GranteeType: db.GranteeUser, GranteeID: 3001,
+		Perm: db.PermRead, GranterID: rootId, CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpPaths, err := store.DelGroup(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(tmpPaths, []string{groupTmpPath}) {
+		t.Fatalf("incorrect temporary paths: %+v", tmpPaths)
+	}
+	_, err = store.DelGroup(ctx, group.ID)
+	if !errors.Is(err, db.ErrGroupNotFound) {
+		t.Fatalf("deleted group should not be found: %s", err)
+	}
+	_, _, _, err = store.GetUploadInfo(ctx, rootId, groupFilePath+"3")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("pending upload should be deleted with the group: %s", err)
+	}
+	_, err = store.GetFileInfo(ctx, groupFilePath+"4")
+	if !errors.Is(err, db.ErrFileInfoNotFound) {
+		t.Fatalf("file info should be deleted with the group: %s", err)
+	}
+	_, err = store.GetFileACL(ctx, 2005)
+	if !errors.Is(err, db.ErrFileACLNotFound) {
+		t.Fatalf("grant should be deleted with the group: %s", err)
+	}
+	groups, err := store.ListGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(groups) != 0 {
+		t.Fatalf("incorrect groups: %+v", groups)
+	}
+	gotMembers, err = store.ListUserGroups(ctx, rootId)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(gotMembers) != 0 {
+		t.Fatalf("memberships should be deleted with the group: %+v", gotMembers)
+	}
+
+	// data of groups whose names only differ at wildcards are kept
+	for _, g := range []*db.Group{
+		{ID: 1003, Name: "team_a", Quota: &db.Quota{SpaceLimit: 100}},
+		{ID: 1004, Name: "teamXa", Quota: &db.Quota{SpaceLimit: 100}},
+	} {
+		if err = store.AddGroup(ctx, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	siblingFilePath := "@teamXa/report"
+	err = store.AddUploadInfos(ctx, 2008, rootId, "qs/uploadings/team_report", siblingFilePath, &db.FileInfo{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.AddRevision(ctx, &db.Revision{
+		ID: 2009, Path: siblingFilePath, UploaderID: rootId, Size: 10,
+		StoragePath: "@teamXa/.revisions/2009", CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpPaths, err = store.DelGroup(ctx, 1003)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(tmpPaths) != 0 {
+		t.Fatalf("pending uploads of other groups should not be deleted: %+v", tmpPaths)
+	}
+	_, _, _, err = store.GetUploadInfo(ctx, rootId, siblingFilePath)
+	if err != nil {
+		t.Fatalf("pending upload of other groups should be kept: %s", err)
+	}
+	revisions, err := store.ListRevisions(ctx, siblingFilePath)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(revisions) != 1 {
+		t.Fatalf("revisions of other groups should be kept: %+v", revisions)
+	}
+	if _, err = store.DelGroup(ctx, 1004); err != nil {
+		t.Fatal(err)
+	}
+}
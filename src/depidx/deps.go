@@ -84,6 +84,18 @@ func (deps *Deps) Users() db.IUserDB {
 	return deps.db
 }
 
+func (deps *Deps) Groups() db.IGroupDB {
+	return deps.db
+}
+
+func (deps *Deps) APITokens() db.IAPITokenDB {
+	return deps.db
+}
+
+func (deps *Deps) Sessions() db.ISessionDB {
+	return deps.db
+}
+
 func (deps *Deps) FileInfos() db.IFilesFunctions {
 	return deps.db
 }
@@ -17,6 +17,7 @@ type ISimpleFS interface {
 	MkdirAll(path string) error
 	Remove(path string) error
 	Rename(oldpath, newpath string) error
+	Link(oldpath, newpath string) error
 	ReadAt(path string, b []byte, off int64) (n int, err error)
 	WriteAt(path string, b []byte, off int64) (n int, err error)
 	Stat(path string) (os.FileInfo, error)
@@ -246,6 +246,20 @@ func (fs *LocalFS) Remove(entryPath string) error {
 	return fs.CloseFDs(map[string]bool{fullpath: true})
 }
 
+// Link creates newpath as a hard link to oldpath, so their content is stored once.
+// It fails if newpath exists.
+func (fs *LocalFS) Link(oldpath, newpath string) error {
+	fullOldPath, err := fs.translate(oldpath)
+	if err != nil {
+		return err
+	}
+	fullNewPath, err := fs.translate(newpath)
+	if err != nil {
+		return err
+	}
+	return os.Link(fullOldPath, fullNewPath)
+}
+
 func (fs *LocalFS) Rename(oldpath, newpath string) error {
 	// TODO: if the rename will be implemented without Rename
 	// we must check if the files are in reading/writing
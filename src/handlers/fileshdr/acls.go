@@ -0,0 +1,283 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	ACLIDQuery = "aclid"
+)
+
+var (
+	// aclReadOps are granted by both read and read-write grants
+	aclReadOps = map[string]bool{
+		"list":              true,
+		"download":          true,
+		"metadata":          true,
+		"revision.list":     true,
+		"revision.download": true,
+	}
+	// aclWriteOps are granted by read-write grants only,
+	// copying is a write op because both the source and the destination are checked with it.
+	aclWriteOps = map[string]bool{
+		"create":           true,
+		"delete":           true,
+		"mkdir":            true,
+		"move":             true,
+		"copy":             true,
+		"upload.chunk":     true,
+		"upload.status":    true,
+		"hash.gen":         true,
+		"revision.restore": true,
+	}
+)
+
+// opCapability returns the capability of custom roles which is required by the op
+func opCapability(op string) string {
+	if aclReadOps[op] {
+		return db.CapRead
+	} else if aclWriteOps[op] {
+		return db.CapWrite
+	}
+	return db.CapShare
+}
+
+// grantedByACL checks if the path is shared with the user or its groups by grants on the path or its parent folders.
+// Managing sharings, grants and uploadings (the empty op) is never granted.
+func (h *FileHandlers) grantedByACL(ctx context.Context, userId uint64, role, op, accessingPath string) bool {
+	if role == db.VisitorRole || userId == db.VisitorID {
+		return false
+	} else if !aclReadOps[op] && !aclWriteOps[op] {
+		return false
+	}
+
+	acls, err := h.deps.FileInfos().MatchFileACLs(ctx, accessingPath, db.GranteeUser, []uint64{userId})
+	if err != nil {
+		h.deps.Log().Errorf("failed to match acls of (%s): %s", accessingPath, err)
+		return false
+	}
+	groupIds, err := h.userGroupIds(ctx, userId)
+	if err != nil {
+		h.deps.Log().Errorf("failed to list groups of (%d): %s", userId, err)
+		return false
+	} else if len(groupIds) > 0 {
+		groupACLs, err := h.deps.FileInfos().MatchFileACLs(ctx, accessingPath, db.GranteeGroup, groupIds)
+		if err != nil {
+			h.deps.Log().Errorf("failed to match group acls of (%s): %s", accessingPath, err)
+			return false
+		}
+		acls = append(acls, groupACLs...)
+	}
+	for _, acl := range acls {
+		if aclReadOps[op] || acl.Perm == db.PermReadWrite {
+			return true
+		}
+	}
+	return false
+}
+
+type AddFileACLReq struct {
+	Path string `json:"path"`
+	// GranteeType is "user" or "group", it is "user" if it is empty
+	GranteeType string `json:"granteeType"`
+	// Grantee is the name of the user or the group
+	Grantee string `json:"grantee"`
+	// Perm is "r" or "rw"
+	Perm string `json:"perm"`
+}
+
+// AddFileACL shares the file or the folder with a user or a group, granting it again updates the permission.
+func (h *FileHandlers) AddFileACL(c *gin.Context) {
+	req := &AddFileACLReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	if req.Perm != db.PermRead && req.Perm != db.PermReadWrite {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid permission: %s", req.Perm)))
+		return
+	}
+	if req.GranteeType == "" {
+		req.GranteeType = db.GranteeUser
+	}
+	if req.GranteeType != db.GranteeUser && req.GranteeType != db.GranteeGroup {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("unsupported grantee type: %s", req.GranteeType)))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	userName := c.MustGet(q.UserParam).(string)
+	role := c.MustGet(q.RoleParam).(string)
+	itemPath := filepath.Clean(req.Path)
+	// op is empty, because users must be admin, or the path belongs to this user
+	if !h.canAccess(c, userId, userName, role, "", itemPath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	_, err = h.deps.FS().Stat(itemPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	granteeId, code, err := h.getGranteeId(c, req.GranteeType, req.Grantee)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	} else if req.GranteeType == db.GranteeUser && (granteeId == userId || granteeId == db.VisitorID) {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid grantee")))
+		return
+	}
+
+	err = h.deps.FileInfos().AddFileACL(c, &db.FileACL{
+		ID:          h.deps.ID().Gen(),
+		Path:        itemPath,
+		GranteeType: req.GranteeType,
+		GranteeID:   granteeId,
+		Perm:        req.Perm,
+		GranterID:   userId,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
+
+// getGranteeId returns the ID of the user or the group by its name
+func (h *FileHandlers) getGranteeId(ctx context.Context, granteeType, name string) (uint64, int, error) {
+	if granteeType == db.GranteeGroup {
+		group, err := h.deps.Groups().GetGroupByName(ctx, name)
+		if err != nil {
+			if errors.Is(err, db.ErrGroupNotFound) {
+				return 0, 404, err
+			}
+			return 0, 500, err
+		}
+		return group.ID, 200, nil
+	}
+
+	user, err := h.deps.Users().GetUserByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return 0, 404, err
+		}
+		return 0, 500, err
+	}
+	return user.ID, 200, nil
+}
+
+// DelFileACL revokes the grant, only the owner of the path or admins can revoke it.
+func (h *FileHandlers) DelFileACL(c *gin.Context) {
+	aclID, err := strconv.ParseUint(c.Query(ACLIDQuery), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid acl ID: %w", err)))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	userName := c.MustGet(q.UserParam).(string)
+	role := c.MustGet(q.RoleParam).(string)
+
+	acl, err := h.deps.FileInfos().GetFileACL(c, aclID)
+	if err != nil {
+		if errors.Is(err, db.ErrFileACLNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	} else if !h.canAccess(c, userId, userName, role, "", acl.Path) {
+		c.JSON(q.ErrResp(c, 404, db.ErrFileACLNotFound))
+		return
+	}
+
+	err = h.deps.FileInfos().DelFileACL(c, aclID)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
+
+type FileACLsResp struct {
+	ACLs []*db.FileACL `json:"acls"`
+}
+
+// ListFileACLs lists grants on the path, only the owner of the path or admins can list them.
+func (h *FileHandlers) ListFileACLs(c *gin.Context) {
+	itemPath := filepath.Clean(c.Query(FilePathQuery))
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	userName := c.MustGet(q.UserParam).(string)
+	role := c.MustGet(q.RoleParam).(string)
+	if !h.canAccess(c, userId, userName, role, "", itemPath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	acls, err := h.deps.FileInfos().ListFileACLs(c, itemPath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &FileACLsResp{ACLs: acls})
+}
+
+// ListSharedWithMe lists grants to the current user and its groups, the granted paths can be accessed by the file APIs.
+func (h *FileHandlers) ListSharedWithMe(c *gin.Context) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	acls, err := h.deps.FileInfos().ListGranteeFileACLs(c, db.GranteeUser, []uint64{userId})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	groupIds, err := h.userGroupIds(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if len(groupIds) > 0 {
+		groupACLs, err := h.deps.FileInfos().ListGranteeFileACLs(c, db.GranteeGroup, groupIds)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+		acls = append(acls, groupACLs...)
+	}
+	c.JSON(200, &FileACLsResp{ACLs: acls})
+}
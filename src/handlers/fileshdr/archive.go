@@ -0,0 +1,272 @@
+package fileshdr
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	ArchiveFormatQuery = "format"
+
+	ZipFormat   = "zip"
+	TarGzFormat = "tar.gz"
+)
+
+var archiveContentTypes = map[string]string{
+	ZipFormat:   "application/zip",
+	TarGzFormat: "application/gzip",
+}
+
+// archiveWriter appends items to an archive,
+// item names are slash separated paths relative to the archive root.
+type archiveWriter interface {
+	AddDir(name string, info os.FileInfo) error
+	AddFile(name string, info os.FileInfo, content io.Reader) error
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func newZipArchiveWriter(w io.Writer) *zipArchiveWriter {
+	return &zipArchiveWriter{zw: zip.NewWriter(w)}
+}
+
+func (w *zipArchiveWriter) AddDir(name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = fmt.Sprintf("%s/", name)
+	_, err = w.zw.CreateHeader(header)
+	return err
+}
+
+func (w *zipArchiveWriter) AddFile(name string, info os.FileInfo, content io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	fw, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, content)
+	return err
+}
+
+func (w *zipArchiveWriter) Close() error {
+	return w.zw.Close()
+}
+
+type tarGzArchiveWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGzArchiveWriter(w io.Writer) *tarGzArchiveWriter {
+	gw := gzip.NewWriter(w)
+	return &tarGzArchiveWriter{
+		gw: gw,
+		tw: tar.NewWriter(gw),
+	}
+}
+
+func (w *tarGzArchiveWriter) AddDir(name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = fmt.Sprintf("%s/", name)
+	return w.tw.WriteHeader(header)
+}
+
+func (w *tarGzArchiveWriter) AddFile(name string, info os.FileInfo, content io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	err = w.tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	// the size in the header must match the content
+	_, err = io.CopyN(w.tw, content, info.Size())
+	return err
+}
+
+func (w *tarGzArchiveWriter) Close() error {
+	err := w.tw.Close()
+	if err != nil {
+		return err
+	}
+	return w.gw.Close()
+}
+
+// ArchiveDir streams a folder as an archive, no temporary file is created,
+// sub-folders which can not be downloaded by the user are skipped.
+func (h *FileHandlers) ArchiveDir(c *gin.Context) {
+	dirPath := c.Query(ListDirQuery)
+	if dirPath == "" {
+		c.JSON(q.ErrResp(c, 400, errors.New("incorrect path name")))
+		return
+	}
+	dirPath = filepath.Clean(dirPath)
+
+	format := c.DefaultQuery(ArchiveFormatQuery, ZipFormat)
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("unsupported archive format: %s", format)))
+		return
+	}
+
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+
+	var err error
+	userId := db.VisitorID
+	if role != db.VisitorRole {
+		userId, err = q.GetUserId(c)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+	}
+
+	if !h.canAccess(c, userId, userName, role, "download", dirPath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	info, err := h.deps.FS().Stat(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(q.ErrResp(c, 404, os.ErrNotExist))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	} else if !info.IsDir() {
+		c.JSON(q.ErrResp(c, 400, errors.New("only folders can be archived")))
+		return
+	}
+	code, err := h.recordSharingDownload(c, userName, role, "", dirPath, dirPath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	pr, pw := io.Pipe()
+	var aw archiveWriter
+	if format == ZipFormat {
+		aw = newZipArchiveWriter(pw)
+	} else {
+		aw = newTarGzArchiveWriter(pw)
+	}
+
+	// the context is copied as the archiving goroutine may outlive the request
+	cc := c.Copy()
+	canArchive := func(subDirPath string) bool {
+		return h.canAccess(cc, userId, userName, role, "download", subDirPath)
+	}
+	go func() {
+		err := h.writeArchive(aw, dirPath, canArchive)
+		if err != nil {
+			h.deps.Log().Errorf("failed to archive(%s): %s", dirPath, err)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	limitedReader, err := h.GetStreamReader(userId, pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	defer func() {
+		// stops the archiving goroutine if the client is gone
+		pr.Close()
+		err := limitedReader.Close()
+		if err != nil {
+			h.deps.Log().Errorf("failed to close limitedReader: %s", err)
+		}
+	}()
+
+	extraHeaders := map[string]string{
+		"Content-Disposition": contentDisposition(fmt.Sprintf("%s.%s", info.Name(), format)),
+	}
+	// the size is unknown before archiving, so it is sent in chunked encoding
+	c.DataFromReader(200, -1, contentType, limitedReader, extraHeaders)
+}
+
+// writeArchive walks the folder, sub-folders are archived only if canArchive returns true for them,
+// as sharings and grants are not inherited by them.
+func (h *FileHandlers) writeArchive(aw archiveWriter, dirPath string, canArchive func(subDirPath string) bool) error {
+	rootName := path.Base(dirPath)
+	dirQueue := []string{""}
+	for len(dirQueue) > 0 {
+		relDir := dirQueue[0]
+		dirQueue = dirQueue[1:]
+
+		infos, err := h.deps.FS().ListDir(filepath.Join(dirPath, relDir))
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			relPath := path.Join(relDir, info.Name())
+			name := path.Join(rootName, relPath)
+			if info.IsDir() {
+				if !canArchive(filepath.Join(dirPath, relPath)) {
+					continue
+				}
+				err = aw.AddDir(name, info)
+				if err != nil {
+					return err
+				}
+				dirQueue = append(dirQueue, relPath)
+				continue
+			}
+
+			err = h.archiveFile(aw, name, filepath.Join(dirPath, relPath), info)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return aw.Close()
+}
+
+func (h *FileHandlers) archiveFile(aw archiveWriter, name, filePath string, info os.FileInfo) error {
+	fd, id, err := h.deps.FS().GetFileReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := h.deps.FS().CloseReader(fmt.Sprint(id))
+		if err != nil {
+			h.deps.Log().Errorf("failed to close: %s", err)
+		}
+	}()
+
+	return aw.AddFile(name, info, fd)
+}
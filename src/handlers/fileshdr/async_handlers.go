@@ -2,17 +2,30 @@ package fileshdr
 
 import (
 	"context"
-	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/ihexxa/fsearch"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
 	"github.com/ihexxa/quickshare/src/worker"
+	"github.com/ihexxa/quickshare/src/worker/localworker"
 )
 
+const copyBufSize = 512 * 1024
+
+// copyTaskTTL is how long finished copy tasks are kept for polling their status
+const copyTaskTTL = 10 * time.Minute
+
 const (
 	MsgTypeSha1     = "sha1"
 	MsgTypeIndexing = "indexing"
@@ -21,18 +34,61 @@ const (
 type Sha1Params struct {
 	FilePath string
 	UserId   uint64
+	// Algorithms are computed, configured algorithms are computed if it is empty
+	Algorithms []string
 }
 
-func (h *FileHandlers) genSha1(msg worker.IMsg) error {
+// genHashes computes checksums of the file in one pass
+func (h *FileHandlers) genHashes(msg worker.IMsg) error {
 	taskInputs := &Sha1Params{}
 	err := json.Unmarshal([]byte(msg.Body()), taskInputs)
 	if err != nil {
 		return fmt.Errorf("fail to unmarshal sha1 msg: %w", err)
 	}
+	algos := taskInputs.Algorithms
+	if len(algos) == 0 {
+		algos = h.hashAlgorithms
+	}
 
-	f, id, err := h.deps.FS().GetFileReader(taskInputs.FilePath)
+	hashes, err := h.computeHashes(taskInputs.FilePath, algos)
 	if err != nil {
-		return fmt.Errorf("fail to get reader: %s", err)
+		return err
+	}
+	err = h.deps.FileInfos().
+		SetHashes(context.TODO(), taskInputs.FilePath, hashes) // TODO: use source context
+	if err != nil {
+		return fmt.Errorf("fail to set hashes: %s", err)
+	}
+
+	if sha1Sign, ok := hashes[HashSha1]; ok {
+		info, err := h.deps.FS().Stat(taskInputs.FilePath)
+		if err != nil {
+			return fmt.Errorf("fail to stat: %w", err)
+		}
+		h.dedupFile(context.TODO(), taskInputs.UserId, taskInputs.FilePath, sha1Sign, info.Size())
+	}
+	return nil
+}
+
+// computeHashes reads the file once for all algorithms, the file is closed before returning
+func (h *FileHandlers) computeHashes(filePath string, algos []string) (map[string]string, error) {
+	hashers := map[string]hash.Hash{}
+	writers := []io.Writer{}
+	for _, algo := range algos {
+		if _, ok := hashers[algo]; ok {
+			continue
+		}
+		hasher, err := newHasher(algo)
+		if err != nil {
+			return nil, err
+		}
+		hashers[algo] = hasher
+		writers = append(writers, hasher)
+	}
+
+	f, id, err := h.deps.FS().GetFileReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get reader: %s", err)
 	}
 	defer func() {
 		err := h.deps.FS().CloseReader(fmt.Sprint(id))
@@ -41,21 +97,17 @@ func (h *FileHandlers) genSha1(msg worker.IMsg) error {
 		}
 	}()
 
-	hasher := sha1.New()
 	buf := make([]byte, 4096)
-	_, err = io.CopyBuffer(hasher, f, buf)
+	_, err = io.CopyBuffer(io.MultiWriter(writers...), f, buf)
 	if err != nil {
-		return fmt.Errorf("faile to copy buffer: %w", err)
+		return nil, fmt.Errorf("faile to copy buffer: %w", err)
 	}
 
-	sha1Sign := fmt.Sprintf("%x", hasher.Sum(nil))
-	err = h.deps.FileInfos().
-		SetSha1(context.TODO(), taskInputs.FilePath, sha1Sign) // TODO: use source context
-	if err != nil {
-		return fmt.Errorf("fail to set sha1: %s", err)
+	hashes := map[string]string{}
+	for algo, hasher := range hashers {
+		hashes[algo] = fmt.Sprintf("%x", hasher.Sum(nil))
 	}
-
-	return nil
+	return hashes, nil
 }
 
 type IndexingParams struct{}
@@ -122,8 +174,13 @@ func (h *FileHandlers) resetUsedSpace(msg worker.IMsg) error {
 		}
 
 		for _, info := range infos {
-			if info.IsDir() {
-				dirQueue = append(dirQueue, filepath.Join(dirPath, info.Name()))
+			childPath := filepath.Join(dirPath, info.Name())
+			if childPath == q.TrashFolder(params.UserHomePath) ||
+				childPath == q.QuarantineFolder(params.UserHomePath) {
+				// space used by trashes and quarantined files is released already
+				continue
+			} else if info.IsDir() {
+				dirQueue = append(dirQueue, childPath)
 			} else {
 				usedSpace += info.Size()
 			}
@@ -132,3 +189,314 @@ func (h *FileHandlers) resetUsedSpace(msg worker.IMsg) error {
 
 	return h.deps.Users().ResetUsed(context.TODO(), params.UserID, usedSpace) // TODO: use source context
 }
+
+const (
+	MsgTypeCopy = "copy"
+)
+
+type CopyParams struct {
+	TaskID  uint64
+	OwnerID uint64
+}
+
+type copyTask struct {
+	mtx     sync.Mutex
+	id      uint64
+	userID  uint64
+	srcPath string
+	dstPath string
+	total   int64
+	copied  int64
+	done    bool
+	err     error
+	// finishedAt is used for evicting finished tasks which are never polled
+	finishedAt time.Time
+}
+
+func (t *copyTask) addCopied(size int64) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.copied += size
+}
+
+func (t *copyTask) finish(err error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.done, t.err, t.finishedAt = true, err, time.Now()
+}
+
+func (t *copyTask) expired(now time.Time) bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.done && now.Sub(t.finishedAt) > copyTaskTTL
+}
+
+// evictCopyTasks removes finished tasks which are not polled within the TTL
+func (h *FileHandlers) evictCopyTasks() {
+	now := time.Now()
+	h.copyTasks.Range(func(key, val any) bool {
+		if val.(*copyTask).expired(now) {
+			h.copyTasks.Delete(key)
+		}
+		return true
+	})
+}
+
+func (t *copyTask) status() *CopyStatusResp {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	errMsg := ""
+	if t.err != nil {
+		errMsg = t.err.Error()
+	}
+	return &CopyStatusResp{
+		TaskID:  fmt.Sprint(t.id),
+		SrcPath: t.srcPath,
+		DstPath: t.dstPath,
+		Total:   t.total,
+		Copied:  t.copied,
+		Done:    t.done,
+		Err:     errMsg,
+	}
+}
+
+func (h *FileHandlers) copyInBackground(msg worker.IMsg) error {
+	params := &CopyParams{}
+	err := json.Unmarshal([]byte(msg.Body()), params)
+	if err != nil {
+		return fmt.Errorf("fail to unmarshal copy msg: %w", err)
+	}
+
+	val, ok := h.copyTasks.Load(params.TaskID)
+	if !ok {
+		return fmt.Errorf("copy task(%d) not found", params.TaskID)
+	}
+	task := val.(*copyTask)
+
+	var code int
+	h.lock(lockName(task.dstPath), &code, &err, func() (int, error) {
+		// the space may be used by other uploads or copies after the task was queued
+		ctx := context.TODO() // TODO: use source context
+		_, code, err := h.copyOwner(ctx, task.userID, task.dstPath, task.total)
+		if err != nil {
+			return code, err
+		}
+		err = h.copyItems(ctx, params.OwnerID, task)
+		if err != nil {
+			return 500, err
+		}
+		return 200, nil
+	})
+	task.finish(err)
+	return err
+}
+
+// copyOwner returns the ID of the owner of the destination who is charged for the copy,
+// it is the group if the destination is in a group folder. It fails if the copy exceeds the quota.
+func (h *FileHandlers) copyOwner(ctx context.Context, userId uint64, dstPath string, totalSize int64) (uint64, int, error) {
+	group, err := h.pathGroup(ctx, dstPath)
+	if err != nil {
+		return 0, 500, err
+	} else if group != nil {
+		if group.UsedSpace+totalSize > group.Quota.SpaceLimit {
+			return 0, 403, db.ErrQuota
+		}
+		return userId, 200, nil
+	}
+
+	owner, err := h.getOwner(ctx, dstPath, userId)
+	if err != nil {
+		return 0, 500, err
+	} else if owner.UsedSpace+totalSize > owner.Quota.SpaceLimit {
+		return 0, 403, db.ErrQuota
+	}
+	return owner.ID, 200, nil
+}
+
+// copyItems copies task.srcPath to task.dstPath recursively,
+// the copied files are charged to the owner, and the destination is removed if it fails.
+func (h *FileHandlers) copyItems(ctx context.Context, ownerID uint64, task *copyTask) error {
+	err := h.copyTree(ctx, ownerID, task)
+	if err != nil {
+		h.cleanCopy(ctx, ownerID, task.dstPath)
+	}
+	return err
+}
+
+// cleanCopy removes the partially copied destination and releases its space
+func (h *FileHandlers) cleanCopy(ctx context.Context, ownerID uint64, dstPath string) {
+	err := h.deps.FileInfos().DelFileInfo(ctx, ownerID, dstPath)
+	if err != nil {
+		h.deps.Log().Errorf("failed to clean file infos(%s): %s", dstPath, err)
+	}
+	err = h.deps.FileIndex().DelPath(dstPath)
+	if err != nil && !errors.Is(err, fsearch.ErrNotFound) {
+		h.deps.Log().Errorf("failed to clean file index(%s): %s", dstPath, err)
+	}
+	err = h.deps.FS().Remove(dstPath)
+	if err != nil {
+		h.deps.Log().Errorf("failed to clean files(%s): %s", dstPath, err)
+	}
+}
+
+func (h *FileHandlers) copyTree(ctx context.Context, ownerID uint64, task *copyTask) error {
+	info, err := h.deps.FS().Stat(task.srcPath)
+	if err != nil {
+		return err
+	} else if !info.IsDir() {
+		err = h.deps.FS().MkdirAll(filepath.Dir(task.dstPath))
+		if err != nil {
+			return err
+		}
+		return h.copyFile(ctx, ownerID, task, task.srcPath, task.dstPath, info.Size())
+	}
+
+	dirQueue := []string{""}
+	for len(dirQueue) > 0 {
+		relDir := dirQueue[0]
+		dirQueue = dirQueue[1:]
+
+		dstDir := filepath.Join(task.dstPath, relDir)
+		err = h.deps.FS().MkdirAll(dstDir)
+		if err != nil {
+			return err
+		}
+		err = h.deps.FileIndex().AddPath(dstDir)
+		if err != nil {
+			return err
+		}
+
+		infos, err := h.deps.FS().ListDir(filepath.Join(task.srcPath, relDir))
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			relPath := filepath.Join(relDir, info.Name())
+			if info.IsDir() {
+				dirQueue = append(dirQueue, relPath)
+				continue
+			}
+
+			err = h.copyFile(
+				ctx,
+				ownerID,
+				task,
+				filepath.Join(task.srcPath, relPath),
+				filepath.Join(task.dstPath, relPath),
+				info.Size(),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (h *FileHandlers) copyFile(ctx context.Context, ownerID uint64, task *copyTask, srcPath, dstPath string, size int64) error {
+	// the content is identical so checksums of the source can be reused
+	sha1Sign := ""
+	var hashes map[string]string
+	srcInfo, err := h.deps.FileInfos().GetFileInfo(ctx, srcPath)
+	if err != nil {
+		if !errors.Is(err, db.ErrFileInfoNotFound) {
+			return err
+		}
+	} else {
+		sha1Sign, hashes = srcInfo.Sha1, srcInfo.Hashes
+	}
+
+	err = h.deps.FileInfos().AddFileInfo(ctx, h.deps.ID().Gen(), ownerID, dstPath, &db.FileInfo{
+		Size:   size,
+		Sha1:   sha1Sign,
+		Hashes: hashes,
+	})
+	if err != nil {
+		return err
+	}
+
+	if h.cfg.BoolOr("Fs.Dedup", false) {
+		// identical content is stored once
+		err = h.deps.FS().Link(srcPath, dstPath)
+		if err == nil {
+			task.addCopied(size)
+		}
+	} else {
+		err = h.copyContent(task, srcPath, dstPath)
+	}
+	if err != nil {
+		if delErr := h.deps.FileInfos().DelFileInfo(ctx, ownerID, dstPath); delErr != nil {
+			h.deps.Log().Errorf("failed to clean file info(%s): %s", dstPath, delErr)
+		}
+		if rmErr := h.deps.FS().Remove(dstPath); rmErr != nil && !os.IsNotExist(rmErr) {
+			h.deps.Log().Errorf("failed to clean file(%s): %s", dstPath, rmErr)
+		}
+		return err
+	}
+
+	if sha1Sign == "" {
+		msg, err := json.Marshal(Sha1Params{
+			UserId:   ownerID,
+			FilePath: dstPath,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = h.deps.Workers().TryPut(
+			localworker.NewMsg(
+				h.deps.ID().Gen(),
+				map[string]string{localworker.MsgTypeKey: MsgTypeSha1},
+				string(msg),
+			),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.deps.FileIndex().AddPath(dstPath)
+}
+
+func (h *FileHandlers) copyContent(task *copyTask, srcPath, dstPath string) error {
+	err := h.deps.FS().Create(dstPath)
+	if err != nil {
+		return err
+	}
+
+	reader, id, err := h.deps.FS().GetFileReader(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := h.deps.FS().CloseReader(fmt.Sprint(id))
+		if err != nil {
+			h.deps.Log().Errorf("failed to close file: %s", err)
+		}
+	}()
+
+	offset := int64(0)
+	buf := make([]byte, copyBufSize)
+	for {
+		n, readErr := reader.Read(buf)
+		if n > 0 {
+			wrote, err := h.deps.FS().WriteAt(dstPath, buf[:n], offset)
+			if err != nil {
+				return err
+			}
+			offset += int64(wrote)
+			task.addCopied(int64(wrote))
+		}
+
+		if readErr != nil {
+			if readErr == io.EOF {
+				break
+			}
+			return readErr
+		}
+	}
+
+	return h.deps.FS().Sync()
+}
@@ -0,0 +1,281 @@
+package fileshdr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	Sha1Query = "sha1"
+	SizeQuery = "size"
+)
+
+// findCopy returns the path of an accessible file with the sha1 and size,
+// it returns an empty string if no such file is found.
+// Only files accessible by the user are considered so that contents of others are not leaked.
+func (h *FileHandlers) findCopy(ctx context.Context, userId uint64, userName, role, sha1Sign string, size int64, excludedPath string) (string, error) {
+	itemPaths, err := h.deps.FileInfos().ListFilesBySha1(ctx, sha1Sign, size)
+	if err != nil {
+		return "", err
+	}
+
+	for _, itemPath := range itemPaths {
+		if itemPath == excludedPath {
+			continue
+		} else if !h.canAccess(ctx, userId, userName, role, "download", itemPath) {
+			continue
+		}
+
+		info, err := h.deps.FS().Stat(itemPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		} else if info.IsDir() || info.Size() != size {
+			continue
+		}
+		return itemPath, nil
+	}
+	return "", nil
+}
+
+// sameContent compares the contents of two files byte by byte,
+// so that files are never linked only because their checksums collide.
+func (h *FileHandlers) sameContent(path1, path2 string) (bool, error) {
+	f1, id1, err := h.deps.FS().GetFileReader(path1)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := h.deps.FS().CloseReader(fmt.Sprint(id1)); err != nil {
+			h.deps.Log().Errorf("failed to close file: %s", err)
+		}
+	}()
+	f2, id2, err := h.deps.FS().GetFileReader(path2)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := h.deps.FS().CloseReader(fmt.Sprint(id2)); err != nil {
+			h.deps.Log().Errorf("failed to close file: %s", err)
+		}
+	}()
+
+	buf1, buf2 := make([]byte, 4096), make([]byte, 4096)
+	for {
+		n1, err1 := io.ReadFull(f1, buf1)
+		n2, err2 := io.ReadFull(f2, buf2)
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+
+		end1 := errors.Is(err1, io.EOF) || errors.Is(err1, io.ErrUnexpectedEOF)
+		end2 := errors.Is(err2, io.EOF) || errors.Is(err2, io.ErrUnexpectedEOF)
+		if err1 != nil && !end1 {
+			return false, err1
+		} else if err2 != nil && !end2 {
+			return false, err2
+		} else if end1 || end2 {
+			return end1 && end2, nil
+		}
+	}
+}
+
+// dedupFile replaces the file with a hard link to another file with identical content,
+// failures are only logged because the file is still valid without deduplication.
+// Only files readable by the uploader are linked, and their bytes are compared before linking.
+func (h *FileHandlers) dedupFile(ctx context.Context, userId uint64, filePath, sha1Sign string, size int64) {
+	if !h.cfg.BoolOr("Fs.Dedup", false) || size == 0 {
+		return
+	}
+
+	user, err := h.deps.Users().GetUser(ctx, userId)
+	if err != nil {
+		h.deps.Log().Errorf("failed to get uploader of (%s): %s", filePath, err)
+		return
+	}
+	srcPath, err := h.findCopy(ctx, user.ID, user.Name, user.Role, sha1Sign, size, filePath)
+	if err != nil {
+		h.deps.Log().Errorf("failed to find copies(%s): %s", filePath, err)
+		return
+	} else if srcPath == "" {
+		return
+	}
+
+	var code int
+	h.lock(lockName(filePath), &code, &err, func() (int, error) {
+		same, err := h.sameContent(srcPath, filePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// the source or the file is removed in the meantime
+				return 200, nil
+			}
+			return 500, err
+		} else if !same {
+			return 200, nil
+		}
+
+		location := strings.Split(filePath, "/")[0]
+		tmpPath := filepath.Join(q.UploadFolder(location), fmt.Sprintf("dedup_%d", h.deps.ID().Gen()))
+		err = h.deps.FS().MkdirAll(q.UploadFolder(location))
+		if err != nil {
+			return 500, err
+		}
+		err = h.deps.FS().Link(srcPath, tmpPath)
+		if err != nil {
+			return 500, err
+		}
+
+		// the link is created aside first so that the file is only replaced when linking succeeds
+		err = h.deps.FS().Remove(filePath)
+		if err != nil {
+			if rmErr := h.deps.FS().Remove(tmpPath); rmErr != nil {
+				h.deps.Log().Errorf("failed to remove link(%s): %s", tmpPath, rmErr)
+			}
+			return 500, err
+		}
+		err = h.deps.FS().Rename(tmpPath, filePath)
+		if err != nil {
+			return 500, err
+		}
+		return 200, nil
+	})
+	if err != nil {
+		h.deps.Log().Errorf("failed to dedup(%s): %s", filePath, err)
+	}
+}
+
+// linkUpload creates the file by linking it to an accessible file with identical content,
+// it returns false if no such file is found then the content must be uploaded.
+func (h *FileHandlers) linkUpload(c *gin.Context, userID uint64, userName, role, fsFilePath string, req *CreateReq) (bool, int, error) {
+	srcPath, err := h.findCopy(c, userID, userName, role, req.Sha1, req.FileSize, fsFilePath)
+	if err != nil {
+		return false, 500, err
+	} else if srcPath == "" {
+		return false, 200, nil
+	}
+
+	var code int
+	linked := false
+	h.lock(lockName(fsFilePath), &code, &err, func() (int, error) {
+		// checksums of the source are reused because the content is identical
+		var hashes map[string]string
+		srcInfo, err := h.deps.FileInfos().GetFileInfo(c, srcPath)
+		if err == nil {
+			hashes = srcInfo.Hashes
+		} else if !errors.Is(err, db.ErrFileInfoNotFound) {
+			return 500, err
+		}
+		if req.ExpectedHash != "" {
+			// the content is uploaded and verified instead if the source does not match
+			algo, sign, err := parseExpectedHash(req.ExpectedHash)
+			if err != nil {
+				return 400, err
+			}
+			srcSign, ok := hashes[algo]
+			if !ok {
+				srcHashes, err := h.computeHashes(srcPath, []string{algo})
+				if err != nil {
+					return 500, err
+				}
+				srcSign = srcHashes[algo]
+			}
+			if srcSign != sign {
+				return 200, nil
+			}
+		}
+
+		err = h.deps.FS().MkdirAll(filepath.Dir(fsFilePath))
+		if err != nil {
+			return 500, err
+		}
+		kept, err := h.keepRevision(c, userID, fsFilePath)
+		if err != nil {
+			return 500, err
+		}
+		err = h.deps.FS().Link(srcPath, fsFilePath)
+		if err != nil {
+			h.undoKeepRevision(c, kept)
+			if os.IsNotExist(err) {
+				// the source is removed in the meantime
+				return 200, nil
+			}
+			return 500, err
+		}
+
+		// the uploader is still charged for the file even its content is shared
+		err = h.deps.FileInfos().AddFileInfo(c, h.deps.ID().Gen(), userID, fsFilePath, &db.FileInfo{
+			Size:   req.FileSize,
+			Sha1:   req.Sha1,
+			Hashes: hashes,
+		})
+		if err != nil {
+			if rmErr := h.deps.FS().Remove(fsFilePath); rmErr != nil {
+				h.deps.Log().Errorf("failed to remove link(%s): %s", fsFilePath, rmErr)
+			} else {
+				h.undoKeepRevision(c, kept)
+			}
+			if errors.Is(err, db.ErrReachedLimit) {
+				return 403, db.ErrQuota
+			}
+			return 500, err
+		}
+		linked = true
+
+		h.pruneRevisions(c, fsFilePath)
+		err = h.deps.FileIndex().AddPath(fsFilePath)
+		if err != nil {
+			return 500, err
+		}
+		return 200, nil
+	})
+	return linked, code, err
+}
+
+type HasSha1Resp struct {
+	Exist bool `json:"exist"`
+}
+
+// HasSha1 checks if an accessible file has the sha1 and size,
+// so that clients can create the file by CreateReq.Sha1 without uploading it.
+func (h *FileHandlers) HasSha1(c *gin.Context) {
+	sha1Sign := strings.ToLower(c.Query(Sha1Query))
+	if sha1Sign == "" {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid sha1")))
+		return
+	}
+	size, err := strconv.ParseInt(c.Query(SizeQuery), 10, 64)
+	if err != nil || size < 0 {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid size")))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+
+	srcPath, err := h.findCopy(c, userId, userName, role, sha1Sign, size, "")
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &HasSha1Resp{Exist: srcPath != ""})
+}
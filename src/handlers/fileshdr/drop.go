@@ -0,0 +1,133 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// SharingModeDrop is the upload-only sharing,
+	// visitors can upload files into the folder but can not list or download them.
+	SharingModeDrop = "drop"
+)
+
+// isDrop returns true if the path is an upload-only sharing
+func (h *FileHandlers) isDrop(ctx context.Context, dirPath string) bool {
+	_, err := h.deps.FileInfos().GetSharingDrop(ctx, dirPath)
+	return err == nil
+}
+
+// getUploader returns the user who is charged for uploading the file.
+// Visitors can only upload files directly in upload-only sharings, they are charged to the owner of the sharing
+// but upload with the drop role which can not access anything else, the drop is returned in this case.
+func (h *FileHandlers) getUploader(c *gin.Context, filePath string) (uint64, string, string, *db.SharingDrop, int, error) {
+	role := c.MustGet(q.RoleParam).(string)
+	if role != db.VisitorRole {
+		userID, err := q.GetUserId(c)
+		if err != nil {
+			return 0, "", "", nil, 500, err
+		}
+		return userID, c.MustGet(q.UserParam).(string), role, nil, 200, nil
+	}
+
+	drop, err := h.deps.FileInfos().GetSharingDrop(c, filepath.Dir(filepath.Clean(filePath)))
+	if err != nil {
+		if errors.Is(err, db.ErrSharingNotFound) {
+			return 0, "", "", nil, 403, q.ErrAccessDenied
+		}
+		return 0, "", "", nil, 500, err
+	}
+	owner, err := h.deps.Users().GetUser(c, drop.OwnerID)
+	if err != nil {
+		return 0, "", "", nil, 500, err
+	}
+	return owner.ID, owner.Name, db.DropRole, drop, 200, nil
+}
+
+// createDropUpload creates the upload in the drop, it is charged to the drop before creating,
+// the drop can not be used to overwrite or to probe existing files.
+func (h *FileHandlers) createDropUpload(c *gin.Context, userID uint64, userName, role string, req *CreateReq) (bool, int, error) {
+	dropPath := filepath.Dir(filepath.Clean(req.Path))
+	req.Overwrite = false
+	req.Sha1 = ""
+
+	err := h.deps.FileInfos().AddSharingDropUsage(c, dropPath, req.FileSize, 1)
+	if err != nil {
+		if errors.Is(err, db.ErrReachedLimit) {
+			return false, 403, err
+		}
+		return false, 500, err
+	}
+
+	completed, code, err := h.createUpload(c, userID, userName, role, req)
+	if err != nil {
+		h.releaseDropUsage(c, dropPath, req.FileSize)
+		return completed, code, err
+	} else if completed || req.FileSize == 0 {
+		// the file is created without uploading
+		return completed, code, err
+	}
+
+	// the usage is released with the upload info if the upload is abandoned and cleaned up
+	fsFilePath := filepath.Clean(req.Path)
+	err = h.deps.FileInfos().SetUploadDrop(c, userID, fsFilePath, dropPath)
+	if err != nil {
+		if delErr := h.deps.FileInfos().DelUploadingInfos(c, userID, fsFilePath); delErr != nil {
+			h.deps.Log().Errorf("failed to delete upload info(%s): %s", fsFilePath, delErr)
+		}
+		if delErr := h.deps.FS().Remove(q.UploadPath(userName, fsFilePath)); delErr != nil {
+			h.deps.Log().Errorf("failed to delete uploading file(%s): %s", fsFilePath, delErr)
+		}
+		h.releaseDropUsage(c, dropPath, req.FileSize)
+		return false, 500, err
+	}
+	return completed, code, err
+}
+
+func (h *FileHandlers) releaseDropUsage(ctx context.Context, dropPath string, size int64) {
+	if err := h.deps.FileInfos().AddSharingDropUsage(ctx, dropPath, -size, -1); err != nil {
+		h.deps.Log().Errorf("failed to release usage of drop(%s): %s", dropPath, err)
+	}
+}
+
+// checkSharingMode validates options of the sharing mode
+func checkSharingMode(req *SharingReq, isDir bool) error {
+	switch req.Mode {
+	case "":
+		return nil
+	case SharingModeDrop:
+		if !isDir {
+			return errors.New("upload-only sharing must be a folder")
+		} else if req.MaxSize <= 0 {
+			return errors.New("invalid max size")
+		} else if req.MaxFiles < 0 {
+			return errors.New("invalid max files")
+		} else if req.Pwd != "" || req.MaxDownloads > 0 {
+			return errors.New("upload-only sharing can not be protected or downloaded")
+		}
+		return nil
+	}
+	return fmt.Errorf("sharing mode(%s) is not supported", req.Mode)
+}
+
+// newDrop returns the upload-only sharing owned by the user of its location,
+// the sharer owns drops in other locations such as group folders, and uploads are charged to the location.
+func (h *FileHandlers) newDrop(ctx context.Context, userId uint64, sharingPath string, req *SharingReq) (*db.SharingDrop, error) {
+	owner, err := h.getOwner(ctx, sharingPath, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.SharingDrop{
+		OwnerID:  owner.ID,
+		MaxSize:  req.MaxSize,
+		MaxFiles: req.MaxFiles,
+	}, nil
+}
@@ -0,0 +1,74 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+// pathGroup returns the group owning the folder of the path, it is nil if the path is not in a group folder.
+func (h *FileHandlers) pathGroup(ctx context.Context, itemPath string) (*db.Group, error) {
+	location := strings.Split(itemPath, "/")[0]
+	groupName := db.LocationGroupName(location)
+	if groupName == "" {
+		return nil, nil
+	}
+	return h.deps.Groups().GetGroupByName(ctx, groupName)
+}
+
+// quotaOwner returns the ID of the user or the group whose quota limits uploading to the path,
+// uploads into group folders are limited by quotas of groups.
+func (h *FileHandlers) quotaOwner(ctx context.Context, userId uint64, itemPath string) (uint64, error) {
+	group, err := h.pathGroup(ctx, itemPath)
+	if err != nil {
+		return 0, err
+	} else if group == nil {
+		return userId, nil
+	}
+	return group.ID, nil
+}
+
+// grantedByGroup checks if the path is in the folder of a group of the user,
+// members can read the folder, and only members with PermReadWrite can write or manage it.
+func (h *FileHandlers) grantedByGroup(ctx context.Context, userId uint64, role, op, accessingPath string) bool {
+	if role == db.VisitorRole || userId == db.VisitorID {
+		return false
+	}
+
+	group, err := h.pathGroup(ctx, accessingPath)
+	if err != nil {
+		if !errors.Is(err, db.ErrGroupNotFound) {
+			h.deps.Log().Errorf("failed to get group of (%s): %s", accessingPath, err)
+		}
+		return false
+	} else if group == nil {
+		return false
+	}
+
+	memberships, err := h.deps.Groups().ListUserGroups(ctx, userId)
+	if err != nil {
+		h.deps.Log().Errorf("failed to list groups of (%d): %s", userId, err)
+		return false
+	}
+	for _, membership := range memberships {
+		if membership.GroupID == group.ID {
+			return aclReadOps[op] || membership.Perm == db.PermReadWrite
+		}
+	}
+	return false
+}
+
+// userGroupIds returns IDs of groups of the user
+func (h *FileHandlers) userGroupIds(ctx context.Context, userId uint64) ([]uint64, error) {
+	memberships, err := h.deps.Groups().ListUserGroups(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	groupIds := []uint64{}
+	for _, membership := range memberships {
+		groupIds = append(groupIds, membership.GroupID)
+	}
+	return groupIds, nil
+}
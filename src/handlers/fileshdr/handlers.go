@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,10 +19,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/ihexxa/gocfg"
 	"github.com/ihexxa/multipart"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/ihexxa/fsearch"
 	"github.com/ihexxa/quickshare/src/db"
 	"github.com/ihexxa/quickshare/src/depidx"
+	"github.com/ihexxa/quickshare/src/golimiter"
 	q "github.com/ihexxa/quickshare/src/handlers"
 	"github.com/ihexxa/quickshare/src/worker/localworker"
 )
@@ -31,6 +35,7 @@ const (
 	ListDirQuery  = "dp"
 	ShareIDQuery  = "shid"
 	Keyword       = "k"
+	TaskIDQuery   = "tid"
 
 	// headers
 	rangeHeader       = "Range"
@@ -41,20 +46,54 @@ const (
 )
 
 type FileHandlers struct {
-	cfg         gocfg.ICfg
-	deps        *depidx.Deps
-	lockedPaths *sync.Map
+	cfg            gocfg.ICfg
+	deps           *depidx.Deps
+	lockedPaths    *sync.Map
+	copyTasks      *sync.Map
+	rangeDownloads *sync.Map
+	hashAlgorithms []string
+	shareIDLen     int
+	// unlockLimiter limits attempts of unlocking protected sharings by share IDs and client IPs
+	unlockLimiter *golimiter.Limiter
 }
 
 func NewFileHandlers(cfg gocfg.ICfg, deps *depidx.Deps) (*FileHandlers, error) {
-	handlers := &FileHandlers{
-		cfg:         cfg,
-		deps:        deps,
-		lockedPaths: &sync.Map{},
+	algosConfig, _ := cfg.Slice("Fs.HashAlgorithms")
+	hashAlgorithms, err := getHashAlgorithms(algosConfig)
+	if err != nil {
+		return nil, err
 	}
-	deps.Workers().AddHandler(MsgTypeSha1, handlers.genSha1)
+
+	shareIDLen := cfg.IntOr("Fs.ShareIDLen", db.DefaultShareIDLen)
+	if err = checkShareIDLen(shareIDLen); err != nil {
+		return nil, err
+	}
+
+	handlers := &FileHandlers{
+		cfg:            cfg,
+		deps:           deps,
+		lockedPaths:    &sync.Map{},
+		copyTasks:      &sync.Map{},
+		rangeDownloads: &sync.Map{},
+		hashAlgorithms: hashAlgorithms,
+		shareIDLen:     shareIDLen,
+		unlockLimiter:  golimiter.New(1024, unlockAttemptCyc),
+	}
+	deps.Workers().AddHandler(MsgTypeSha1, handlers.genHashes)
 	deps.Workers().AddHandler(MsgTypeIndexing, handlers.indexingItems)
 	deps.Workers().AddHandler(MsgTypeResetUsedSpace, handlers.resetUsedSpace)
+	deps.Workers().AddHandler(MsgTypeCopy, handlers.copyInBackground)
+
+	if cfg.GrabInt("Fs.TrashTTL") > 0 {
+		err := deps.Cron().AddFun(cfg.GrabString("Fs.TrashPurgeSpec"), handlers.purgeExpiredTrashes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to schedule trash purging: %w", err)
+		}
+	}
+	err = deps.Cron().AddFun(cfg.GrabString("Fs.SharingPurgeSpec"), handlers.purgeExpiredSharings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule sharing purging: %w", err)
+	}
 
 	return handlers, nil
 }
@@ -83,15 +122,39 @@ func (h *FileHandlers) lock(key string, code *int, err *error, execution func()
 
 // related elements: role, user, action(listing, downloading)/sharing
 func (h *FileHandlers) canAccess(ctx context.Context, userId uint64, userName, role, op, accessingPath string) bool {
-	if role == db.AdminRole {
+	if q.IsReservedPath(accessingPath) {
+		return false
+	} else if role == db.DropRole {
+		// visitors can only upload files directly into upload-only sharings
+		return (op == "create" || op == "upload.chunk") && h.isDrop(ctx, filepath.Dir(accessingPath))
+	} else if role == db.AdminRole {
 		return true
 	}
 
+	// custom roles access owned and granted files by capabilities, sharings are checked as usual
+	capable := true
+	if customRole, ok := ctx.Value(q.CustomRoleParam).(*db.Role); ok {
+		capable = customRole.HasCap(opCapability(op))
+		if capable && customRole.HasCap(db.CapAllFiles) {
+			return true
+		}
+	}
+
 	// the file path must start with userName: <userName>/...
 	parts := strings.Split(accessingPath, "/")
 	if len(parts) < 2 { // the path must be longer than <userName>/files
 		return false
-	} else if parts[0] == userName && userName != "" && parts[1] != "" {
+	} else if capable && parts[0] == userName && userName != "" && parts[1] != "" {
+		return true
+	}
+
+	// check if it is in the folder of a group of the user
+	if capable && h.grantedByGroup(ctx, userId, role, op, accessingPath) {
+		return true
+	}
+
+	// check if it is shared with the user or its groups directly
+	if capable && h.grantedByACL(ctx, userId, role, op, accessingPath) {
 		return true
 	}
 
@@ -101,16 +164,41 @@ func (h *FileHandlers) canAccess(ctx context.Context, userId uint64, userName, r
 		return false
 	}
 
+	// an unlocked protected sharing grants its subtree
+	sharingPath := h.unlockedSharingPath(ctx)
+	if sharingPath != "" &&
+		(accessingPath == sharingPath || strings.HasPrefix(accessingPath, sharingPath+"/")) {
+		return true
+	}
+
 	isSharing, err := h.deps.FileInfos().IsSharing(ctx, accessingPath)
-	if err != nil {
+	if err != nil || !isSharing {
 		return false // TODO: return error
 	}
-	return isSharing
+	pwdHash, err := h.deps.FileInfos().GetSharingPwd(ctx, accessingPath)
+	if err != nil {
+		return false
+	}
+	// items in upload-only sharings are not visible to others
+	return pwdHash == "" && !h.isDrop(ctx, accessingPath)
 }
 
 type CreateReq struct {
 	Path     string `json:"path"`
 	FileSize int64  `json:"fileSize"`
+	// Overwrite keeps the existing file as a revision, it requires versioning enabled
+	Overwrite bool `json:"overwrite"`
+	// PartSize enables uploading numbered parts concurrently, 0 means uploading sequentially
+	PartSize int64 `json:"partSize"`
+	// Sha1 creates the file without uploading if an accessible file has identical content, it requires dedup enabled
+	Sha1 string `json:"sha1"`
+	// ExpectedHash is "algorithm:hex" of the whole file, the file is quarantined if it does not match after uploading
+	ExpectedHash string `json:"expectedHash"`
+}
+
+type CreateResp struct {
+	// Completed is true if the file is created without uploading its content
+	Completed bool `json:"completed"`
 }
 
 func (h *FileHandlers) Create(c *gin.Context) {
@@ -119,28 +207,66 @@ func (h *FileHandlers) Create(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
+	// sha1 signs are stored and compared in lower case
+	req.Sha1 = strings.ToLower(req.Sha1)
 
-	userID, err := q.GetUserId(c)
+	userID, userName, role, drop, code, err := h.getUploader(c, req.Path)
 	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
+		c.JSON(q.ErrResp(c, code, err))
 		return
 	}
 
+	var completed bool
+	if drop != nil {
+		completed, code, err = h.createDropUpload(c, userID, userName, role, req)
+	} else {
+		completed, code, err = h.createUpload(c, userID, userName, role, req)
+	}
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	c.JSON(200, &CreateResp{Completed: completed})
+}
+
+// createUpload creates the upload info and the uploading file,
+// the file is created directly if it is empty or its content exists already.
+func (h *FileHandlers) createUpload(c *gin.Context, userID uint64, userName, role string, req *CreateReq) (bool, int, error) {
 	fsFilePath, err := h.getFSFilePath(fmt.Sprint(userID), req.Path)
 	if err != nil {
-		if errors.Is(err, os.ErrExist) {
-			c.JSON(q.ErrResp(c, 400, err))
-		} else {
-			c.JSON(q.ErrResp(c, 500, err))
+		if !errors.Is(err, os.ErrExist) {
+			return false, 500, err
+		}
+
+		fsFilePath, err = h.getOverwritingPath(req)
+		if err != nil {
+			return false, 400, err
 		}
-		return
 	}
 
-	role := c.MustGet(q.RoleParam).(string)
-	userName := c.MustGet(q.UserParam).(string)
 	if !h.canAccess(c, userID, userName, role, "create", fsFilePath) {
-		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
-		return
+		return false, 403, q.ErrAccessDenied
+	}
+	if req.ExpectedHash != "" {
+		algo, sign, err := parseExpectedHash(req.ExpectedHash)
+		if err != nil {
+			return false, 400, err
+		} else if req.FileSize == 0 {
+			// empty files are verified at once because there is nothing to upload
+			hasher, err := newHasher(algo)
+			if err != nil {
+				return false, 400, err
+			} else if fmt.Sprintf("%x", hasher.Sum(nil)) != sign {
+				return false, fileCorruptedCode, ErrFileCorrupted
+			}
+		}
+	}
+
+	if req.Sha1 != "" && req.FileSize > 0 && h.cfg.BoolOr("Fs.Dedup", false) {
+		linked, code, err := h.linkUpload(c, userID, userName, role, fsFilePath, req)
+		if err != nil || linked {
+			return linked, code, err
+		}
 	}
 
 	infoId := h.deps.ID().Gen()
@@ -152,35 +278,39 @@ func (h *FileHandlers) Create(c *gin.Context) {
 		})
 		if err != nil {
 			if errors.Is(err, db.ErrQuota) {
-				c.JSON(q.ErrResp(c, 403, err))
-			} else {
-				c.JSON(q.ErrResp(c, 500, err))
+				return false, 403, err
 			}
-			return
+			return false, 500, err
+		}
+		err = h.setExpectedHash(c, userID, fsFilePath, req.ExpectedHash)
+		if err != nil {
+			return false, 500, err
+		}
+		err = h.deps.FS().MkdirAll(filepath.Dir(fsFilePath))
+		if err != nil {
+			return false, 500, err
 		}
 
-		// it is ok to use same info ID here
-		// because the upload info is just moved to the right place after creating.
-		err = h.deps.FileInfos().MoveUploadingInfos(c, infoId, userID, tmpFilePath, fsFilePath)
+		kept, err := h.keepRevision(c, userID, fsFilePath)
 		if err != nil {
-			c.JSON(q.ErrResp(c, 500, err))
-			return
+			return false, 500, err
 		}
 
-		err = h.deps.FS().MkdirAll(filepath.Dir(fsFilePath))
+		// it is ok to use same info ID here
+		// because the upload info is just moved to the right place after creating.
+		err = h.deps.FileInfos().MoveUploadingInfos(c, infoId, userID, tmpFilePath, fsFilePath)
 		if err != nil {
-			c.JSON(q.ErrResp(c, 500, err))
-			return
+			h.undoKeepRevision(c, kept)
+			return false, 500, err
 		}
 
 		err = h.deps.FS().Create(fsFilePath)
 		if err != nil {
 			if os.IsExist(err) {
-				c.JSON(q.ErrResp(c, 304, fmt.Errorf("file(%s) exists", fsFilePath)))
-			} else {
-				c.JSON(q.ErrResp(c, 500, err))
+				return false, 304, fmt.Errorf("file(%s) exists", fsFilePath)
 			}
-			return
+			h.undoReplace(c, userID, fsFilePath, kept)
+			return false, 500, err
 		}
 
 		msg, err := json.Marshal(Sha1Params{
@@ -188,8 +318,8 @@ func (h *FileHandlers) Create(c *gin.Context) {
 			FilePath: fsFilePath,
 		})
 		if err != nil {
-			c.JSON(q.ErrResp(c, 500, err))
-			return
+			h.undoReplace(c, userID, fsFilePath, kept)
+			return false, 500, err
 		}
 
 		err = h.deps.Workers().TryPut(
@@ -200,30 +330,47 @@ func (h *FileHandlers) Create(c *gin.Context) {
 			),
 		)
 		if err != nil {
-			c.JSON(q.ErrResp(c, 500, err))
-			return
+			h.undoReplace(c, userID, fsFilePath, kept)
+			return false, 500, err
 		}
 
 		err = h.deps.FileIndex().AddPath(fsFilePath)
 		if err != nil {
-			c.JSON(q.ErrResp(c, 500, err))
-			return
+			h.undoReplace(c, userID, fsFilePath, kept)
+			return false, 500, err
 		}
+		h.pruneRevisions(c, fsFilePath)
 
-		c.JSON(q.Resp(200))
-		return
+		return false, 200, nil
 	}
 
+	if req.PartSize < 0 {
+		return false, 400, errors.New("invalid part size")
+	}
 	err = h.deps.FileInfos().AddUploadInfos(c, infoId, userID, tmpFilePath, fsFilePath, &db.FileInfo{
 		Size: req.FileSize,
 	})
 	if err != nil {
 		if errors.Is(err, db.ErrQuota) {
-			c.JSON(q.ErrResp(c, 403, err))
-		} else {
-			c.JSON(q.ErrResp(c, 500, err))
+			return false, 403, err
 		}
-		return
+		return false, 500, err
+	}
+	if req.PartSize > 0 {
+		err = h.deps.FileInfos().SetUploadPartSize(c, userID, fsFilePath, req.PartSize)
+		if err != nil {
+			if delErr := h.deps.FileInfos().DelUploadingInfos(c, userID, fsFilePath); delErr != nil {
+				h.deps.Log().Errorf("failed to delete upload info(%s): %s", fsFilePath, delErr)
+			}
+			return false, 500, err
+		}
+	}
+	err = h.setExpectedHash(c, userID, fsFilePath, req.ExpectedHash)
+	if err != nil {
+		if delErr := h.deps.FileInfos().DelUploadingInfos(c, userID, fsFilePath); delErr != nil {
+			h.deps.Log().Errorf("failed to delete upload info(%s): %s", fsFilePath, delErr)
+		}
+		return false, 500, err
 	}
 
 	var code int
@@ -243,11 +390,7 @@ func (h *FileHandlers) Create(c *gin.Context) {
 		}
 		return 200, nil
 	})
-	if err != nil {
-		c.JSON(q.ErrResp(c, code, err))
-		return
-	}
-	c.JSON(q.Resp(200))
+	return false, code, err
 }
 
 func (h *FileHandlers) Delete(c *gin.Context) {
@@ -275,6 +418,10 @@ func (h *FileHandlers) Delete(c *gin.Context) {
 	// locker := h.NewAutoLocker(c, lockName(filePath))
 	var code int
 	h.lock(lockName(filePath), &code, &err, func() (int, error) {
+		if h.cfg.GrabInt("Fs.TrashTTL") > 0 {
+			return h.trashItem(c, userId, filePath)
+		}
+
 		err := h.deps.FS().Remove(filePath)
 		if err != nil {
 			return 500, err
@@ -304,6 +451,8 @@ type MetadataResp struct {
 	ModTime time.Time `json:"modTime"`
 	IsDir   bool      `json:"isDir"`
 	Sha1    string    `json:"sha1"`
+	// Hashes are hex encoded checksums keyed by algorithms
+	Hashes map[string]string `json:"hashes"`
 }
 
 func (h *FileHandlers) Metadata(c *gin.Context) {
@@ -337,12 +486,24 @@ func (h *FileHandlers) Metadata(c *gin.Context) {
 		return
 	}
 
-	c.JSON(200, MetadataResp{
+	metadata := &MetadataResp{
 		Name:    info.Name(),
 		Size:    info.Size(),
 		ModTime: info.ModTime(),
 		IsDir:   info.IsDir(),
-	})
+	}
+	if !info.IsDir() {
+		dbInfo, err := h.deps.FileInfos().GetFileInfo(c, filePath)
+		if err != nil {
+			if !errors.Is(err, db.ErrFileInfoNotFound) {
+				c.JSON(q.ErrResp(c, 500, err))
+				return
+			}
+		} else {
+			metadata.Sha1, metadata.Hashes = dbInfo.Sha1, dbInfo.Hashes
+		}
+	}
+	c.JSON(200, metadata)
 }
 
 type MkdirReq struct {
@@ -410,6 +571,13 @@ func (h *FileHandlers) Move(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
 		return
 	}
+	// used spaces are charged to groups in group folders, so items can only be copied in or out of them
+	oldLocation, newLocation := strings.Split(oldPath, "/")[0], strings.Split(newPath, "/")[0]
+	if oldLocation != newLocation &&
+		(db.LocationGroupName(oldLocation) != "" || db.LocationGroupName(newLocation) != "") {
+		c.JSON(q.ErrResp(c, 400, errors.New("can not move items in or out of group folders")))
+		return
+	}
 
 	itemInfo, err := h.deps.FS().Stat(oldPath)
 	if err != nil {
@@ -466,20 +634,28 @@ func (h *FileHandlers) UploadChunk(c *gin.Context) {
 		return
 	}
 
-	userId, err := q.GetUserId(c)
+	filePath := filepath.Clean(req.Path)
+	userId, userName, role, _, code, err := h.getUploader(c, filePath)
 	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
+		c.JSON(q.ErrResp(c, code, err))
 		return
 	}
-	role := c.MustGet(q.RoleParam).(string)
-	userName := c.MustGet(q.UserParam).(string)
-	filePath := filepath.Clean(req.Path)
 	if !h.canAccess(c, userId, userName, role, "upload.chunk", filePath) {
 		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
 		return
 	}
+	hasher, checksum, err := parseChunkChecksum(c.GetHeader(ChunkChecksumHeader))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
 
-	ok, err := h.deps.Limiter().CanWrite(userId, len([]byte(req.Content)))
+	quotaOwnerId, err := h.quotaOwner(c, userId, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	ok, err := h.deps.Limiter().CanWrite(quotaOwnerId, len([]byte(req.Content)))
 	if err != nil {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
@@ -492,16 +668,15 @@ func (h *FileHandlers) UploadChunk(c *gin.Context) {
 	// var statusCode int
 	// locker := h.NewAutoLocker(c, lockName(tmpFilePath))
 	tmpFilePath := q.UploadPath(userName, filePath)
-	var code int
 	fsFilePath, fileSize, uploaded, wrote := "", int64(0), int64(0), 0
 	h.lock(lockName(tmpFilePath), &code, &err, func() (int, error) {
 		// lockErr := locker.Exec(func() {
-		var err error
-
-		fsFilePath, fileSize, uploaded, err = h.deps.FileInfos().GetUploadInfo(c, userId, filePath)
+		upload, code, err := h.getSequentialUpload(c, userId, filePath)
 		if err != nil {
-			return 500, err
-		} else if uploaded != req.Offset {
+			return code, err
+		}
+		fsFilePath, fileSize, uploaded = upload.RealFilePath, upload.Size, upload.Uploaded
+		if uploaded != req.Offset {
 			return 500, errors.New("offset != uploaded")
 		}
 
@@ -509,6 +684,12 @@ func (h *FileHandlers) UploadChunk(c *gin.Context) {
 		if err != nil {
 			return 500, err
 		}
+		if hasher != nil {
+			hasher.Write(content)
+			if err = checkChunk(hasher, checksum); err != nil {
+				return chunkCorruptedCode, err
+			}
+		}
 
 		wrote, err = h.deps.FS().WriteAt(tmpFilePath, []byte(content), req.Offset)
 		if err != nil {
@@ -520,41 +701,10 @@ func (h *FileHandlers) UploadChunk(c *gin.Context) {
 			return 500, err
 		}
 
-		// move the file from uploading dir to uploaded dir
-		infoId := h.deps.ID().Gen()
 		if uploaded+int64(wrote) == fileSize {
-			err = h.deps.FileInfos().MoveUploadingInfos(c, infoId, userId, tmpFilePath, fsFilePath)
-			if err != nil {
-				return 500, err
-			}
-
-			err = h.deps.FS().Rename(tmpFilePath, fsFilePath)
+			code, err := h.completeUpload(c, userId, tmpFilePath, fsFilePath)
 			if err != nil {
-				return 500, fmt.Errorf("%s error: %w", fsFilePath, err)
-			}
-
-			msg, err := json.Marshal(Sha1Params{
-				UserId:   userId,
-				FilePath: fsFilePath,
-			})
-			if err != nil {
-				return 500, err
-			}
-
-			err = h.deps.Workers().TryPut(
-				localworker.NewMsg(
-					h.deps.ID().Gen(),
-					map[string]string{localworker.MsgTypeKey: MsgTypeSha1},
-					string(msg),
-				),
-			)
-			if err != nil {
-				return 500, err
-			}
-
-			err = h.deps.FileIndex().AddPath(fsFilePath)
-			if err != nil {
-				return 500, err
+				return code, err
 			}
 		}
 		return 200, nil
@@ -572,6 +722,75 @@ func (h *FileHandlers) UploadChunk(c *gin.Context) {
 	})
 }
 
+// setExpectedHash records the expected hash which is verified before the upload is completed
+func (h *FileHandlers) setExpectedHash(ctx context.Context, userID uint64, filePath, expectedHash string) error {
+	if expectedHash == "" {
+		return nil
+	}
+	algo, sign, err := parseExpectedHash(expectedHash)
+	if err != nil {
+		return err
+	}
+	return h.deps.FileInfos().SetUploadExpectedHash(ctx, userID, filePath, fmt.Sprintf("%s:%s", algo, sign))
+}
+
+// completeUpload verifies the uploaded file and moves it from the uploading folder to its target path
+func (h *FileHandlers) completeUpload(ctx context.Context, userId uint64, tmpFilePath, fsFilePath string) (int, error) {
+	code, err := h.verifyUpload(ctx, userId, tmpFilePath, fsFilePath)
+	if err != nil {
+		return code, err
+	}
+
+	// the replaced file is kept only after the upload is accepted
+	kept, err := h.keepRevision(ctx, userId, fsFilePath)
+	if err != nil {
+		return 500, err
+	}
+
+	// move the file from uploading dir to uploaded dir
+	infoId := h.deps.ID().Gen()
+	err = h.deps.FileInfos().MoveUploadingInfos(ctx, infoId, userId, tmpFilePath, fsFilePath)
+	if err != nil {
+		h.undoKeepRevision(ctx, kept)
+		return 500, err
+	}
+
+	err = h.deps.FS().Rename(tmpFilePath, fsFilePath)
+	if err != nil {
+		h.undoReplace(ctx, userId, fsFilePath, kept)
+		return 500, fmt.Errorf("%s error: %w", fsFilePath, err)
+	}
+
+	msg, err := json.Marshal(Sha1Params{
+		UserId:   userId,
+		FilePath: fsFilePath,
+	})
+	if err != nil {
+		h.undoReplace(ctx, userId, fsFilePath, kept)
+		return 500, err
+	}
+
+	err = h.deps.Workers().TryPut(
+		localworker.NewMsg(
+			h.deps.ID().Gen(),
+			map[string]string{localworker.MsgTypeKey: MsgTypeSha1},
+			string(msg),
+		),
+	)
+	if err != nil {
+		h.undoReplace(ctx, userId, fsFilePath, kept)
+		return 500, err
+	}
+
+	err = h.deps.FileIndex().AddPath(fsFilePath)
+	if err != nil {
+		h.undoReplace(ctx, userId, fsFilePath, kept)
+		return 500, err
+	}
+	h.pruneRevisions(ctx, fsFilePath)
+	return 200, nil
+}
+
 func (h *FileHandlers) getFSFilePath(userID, fsFilePath string) (string, error) {
 	fsFilePath = filepath.Clean(fsFilePath)
 
@@ -677,6 +896,11 @@ func (h *FileHandlers) Download(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 400, errors.New("invalid file name")))
 		return
 	}
+	// presigned URLs are accepted without login
+	if c.Query(PresignSigQuery) != "" {
+		h.downloadPresigned(c, filePath, rangeVal, ifRangeVal)
+		return
+	}
 
 	role := c.MustGet(q.RoleParam).(string)
 	userName := c.MustGet(q.UserParam).(string)
@@ -692,12 +916,46 @@ func (h *FileHandlers) Download(c *gin.Context) {
 		}
 	}
 
-	if !h.canAccess(c, userId, userName, role, "download", dirPath) {
-		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
-		return
+	// range requests in a counted window are served even if the sharing is disabled in the meantime
+	counted := false
+	if rangeVal != "" {
+		_, counted = h.countedRangeDownload(filePath, c.ClientIP())
+	}
+	if !counted {
+		// the file is accessible if its folder or itself is shared
+		if !h.canAccess(c, userId, userName, role, "download", dirPath) &&
+			!h.canAccess(c, userId, userName, role, "download", filePath) {
+			c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+			return
+		}
+		code, err := h.recordSharingDownload(c, userName, role, rangeVal, filePath, filePath, dirPath)
+		if err != nil {
+			c.JSON(q.ErrResp(c, code, err))
+			return
+		}
 	}
 
-	// TODO: when sharing is introduced, move following logics to a separeted method
+	h.serveFile(c, userId, filePath, rangeVal, ifRangeVal)
+}
+
+// contentDisposition returns the attachment header value (RFC 6266),
+// non-ASCII names are also encoded in filename* so that browsers save files with correct names.
+func contentDisposition(fileName string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, fileName)
+	if fallback == fileName {
+		return fmt.Sprintf(`attachment; filename="%s"`, fileName)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, fallback, url.PathEscape(fileName))
+}
+
+// serveFile streams the file with the download speed limit of the user,
+// range requests are supported.
+func (h *FileHandlers) serveFile(c *gin.Context, userId uint64, filePath, rangeVal, ifRangeVal string) {
 	// concurrently file accessing is managed by os
 	info, err := h.deps.FS().Stat(filePath)
 	if err != nil {
@@ -735,7 +993,7 @@ func (h *FileHandlers) Download(c *gin.Context) {
 	}()
 
 	extraHeaders := map[string]string{
-		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, info.Name()),
+		"Content-Disposition": contentDisposition(info.Name()),
 	}
 
 	// respond to normal requests
@@ -824,7 +1082,7 @@ func (h *FileHandlers) MergeFileInfos(ctx *gin.Context, dirPath string, infos []
 		if !metadata.IsDir {
 			dbInfo, ok := dbInfos[filepath.Join(dirPath, metadata.Name)]
 			if ok {
-				metadata.Sha1 = dbInfo.Sha1
+				metadata.Sha1, metadata.Hashes = dbInfo.Sha1, dbInfo.Hashes
 			}
 		}
 	}
@@ -857,6 +1115,7 @@ func (h *FileHandlers) List(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
+	h.recordSharingListing(c, userName, role, dirPath)
 
 	metadatas, err := h.MergeFileInfos(c, dirPath, infos)
 	if err != nil {
@@ -892,12 +1151,206 @@ func (h *FileHandlers) ListHome(c *gin.Context) {
 	})
 }
 
+type CopyReq struct {
+	SrcPath string `json:"srcPath"`
+	DstPath string `json:"dstPath"`
+}
+
+type CopyResp struct {
+	TaskID string `json:"taskID"`
+}
+
+// Copy duplicates a file or a folder recursively.
+// Small copies are done in the request,
+// others are done by workers and the progress can be checked with the returned task ID.
 func (h *FileHandlers) Copy(c *gin.Context) {
-	c.JSON(q.NewMsgResp(501, "Not Implemented"))
+	req := &CopyReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+
+	srcPath := filepath.Clean(req.SrcPath)
+	dstPath := filepath.Clean(req.DstPath)
+	if !h.canAccess(c, userId, userName, role, "copy", srcPath) ||
+		!h.canAccess(c, userId, userName, role, "copy", dstPath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+	if srcPath == dstPath || strings.HasPrefix(dstPath, fmt.Sprintf("%s/", srcPath)) {
+		c.JSON(q.ErrResp(c, 400, errors.New("can not copy an item into itself")))
+		return
+	}
+
+	_, err = h.deps.FS().Stat(dstPath)
+	if err != nil && !os.IsNotExist(err) {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if err == nil {
+		c.JSON(q.ErrResp(c, 400, os.ErrExist))
+		return
+	}
+
+	totalSize, err := h.itemSize(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(q.ErrResp(c, 404, os.ErrNotExist))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	ownerId, code, err := h.copyOwner(c, userId, dstPath, totalSize)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	task := &copyTask{
+		id:      h.deps.ID().Gen(),
+		userID:  userId,
+		srcPath: srcPath,
+		dstPath: dstPath,
+		total:   totalSize,
+	}
+	threshold := int64(h.cfg.GrabInt("Fs.CopyAsyncThreshold"))
+	if totalSize <= threshold {
+		h.lock(lockName(dstPath), &code, &err, func() (int, error) {
+			err := h.copyItems(c, ownerId, task)
+			if err != nil {
+				if errors.Is(err, db.ErrReachedLimit) {
+					return 403, err
+				}
+				return 500, err
+			}
+			return 200, nil
+		})
+		if err != nil {
+			c.JSON(q.ErrResp(c, code, err))
+			return
+		}
+		c.JSON(q.Resp(200))
+		return
+	}
+
+	msg, err := json.Marshal(CopyParams{
+		TaskID:  task.id,
+		OwnerID: ownerId,
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	h.evictCopyTasks()
+	h.copyTasks.Store(task.id, task)
+	err = h.deps.Workers().TryPut(
+		localworker.NewMsg(
+			h.deps.ID().Gen(),
+			map[string]string{localworker.MsgTypeKey: MsgTypeCopy},
+			string(msg),
+		),
+	)
+	if err != nil {
+		h.copyTasks.Delete(task.id)
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(202, &CopyResp{TaskID: fmt.Sprint(task.id)})
+}
+
+type CopyStatusResp struct {
+	TaskID  string `json:"taskID"`
+	SrcPath string `json:"srcPath"`
+	DstPath string `json:"dstPath"`
+	Total   int64  `json:"total"`
+	Copied  int64  `json:"copied"`
+	Done    bool   `json:"done"`
+	Err     string `json:"err"`
+}
+
+// CopyStatus reports the progress of an async copy,
+// finished tasks are forgotten once their status is returned or after copyTaskTTL.
+func (h *FileHandlers) CopyStatus(c *gin.Context) {
+	taskID, err := strconv.ParseUint(c.Query(TaskIDQuery), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid task ID: %w", err)))
+		return
+	}
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	val, ok := h.copyTasks.Load(taskID)
+	if !ok {
+		c.JSON(q.ErrResp(c, 404, errors.New("copy task not found")))
+		return
+	}
+	task := val.(*copyTask)
+	if task.userID != userId {
+		c.JSON(q.ErrResp(c, 404, errors.New("copy task not found")))
+		return
+	}
+
+	resp := task.status()
+	if resp.Done {
+		h.copyTasks.Delete(taskID)
+	}
+	c.JSON(200, resp)
 }
 
-func (h *FileHandlers) CopyDir(c *gin.Context) {
-	c.JSON(q.NewMsgResp(501, "Not Implemented"))
+// itemSize returns the total size of the files under the item.
+func (h *FileHandlers) itemSize(itemPath string) (int64, error) {
+	info, err := h.deps.FS().Stat(itemPath)
+	if err != nil {
+		return 0, err
+	} else if !info.IsDir() {
+		return info.Size(), nil
+	}
+
+	size := int64(0)
+	dirQueue := []string{itemPath}
+	for len(dirQueue) > 0 {
+		dirPath := dirQueue[0]
+		dirQueue = dirQueue[1:]
+
+		infos, err := h.deps.FS().ListDir(dirPath)
+		if err != nil {
+			return 0, err
+		}
+		for _, info := range infos {
+			if info.IsDir() {
+				dirQueue = append(dirQueue, filepath.Join(dirPath, info.Name()))
+			} else {
+				size += info.Size()
+			}
+		}
+	}
+	return size, nil
+}
+
+// getOwner returns the user whose home contains the item,
+// it falls back to the default user if the location is not a user's home.
+func (h *FileHandlers) getOwner(ctx context.Context, itemPath string, defaultUserID uint64) (*db.User, error) {
+	location := strings.Split(itemPath, "/")[0]
+	user, err := h.deps.Users().GetUserByName(ctx, location)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return h.deps.Users().GetUser(ctx, defaultUserID)
+		}
+		return nil, err
+	}
+	return user, nil
 }
 
 func lockName(filePath string) string {
@@ -947,12 +1400,23 @@ func (h *FileHandlers) DelUploading(c *gin.Context) {
 		return
 	}
 
+	code, err := h.delUploading(c, userId, userName, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
+
+// delUploading removes the uploading file and its upload info
+func (h *FileHandlers) delUploading(ctx context.Context, userId uint64, userName, filePath string) (int, error) {
 	// var txErr error
 	// var statusCode int
 	tmpFilePath := q.UploadPath(userName, filePath)
 	// locker := h.NewAutoLocker(c, lockName(tmpFilePath))
 	// lockErr := locker.Exec(func() {
 	var code int
+	var err error
 	h.lock(lockName(tmpFilePath), &code, &err, func() (int, error) {
 		_, err = h.deps.FS().Stat(tmpFilePath)
 		if err != nil {
@@ -969,20 +1433,31 @@ func (h *FileHandlers) DelUploading(c *gin.Context) {
 		return 200, nil
 	})
 	if err != nil {
-		c.JSON(q.ErrResp(c, code, err))
-		return
+		return code, err
 	}
 
-	err = h.deps.FileInfos().DelUploadingInfos(c, userId, filePath)
+	err = h.deps.FileInfos().DelUploadingInfos(ctx, userId, filePath)
 	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
-		return
+		return 500, err
 	}
-	c.JSON(q.Resp(200))
+	return 200, nil
 }
 
 type SharingReq struct {
 	SharingPath string `json:"sharingPath"`
+	// ExpireAt is the unix timestamp in seconds when the sharing expires, it never expires if it is 0
+	ExpireAt int64 `json:"expireAt"`
+	// Pwd protects the sharing, visitors must unlock it by UnlockSharing if it is not empty
+	Pwd string `json:"pwd"`
+	// MaxDownloads disables the sharing after files are downloaded for the times, it is unlimited if it is 0
+	MaxDownloads int64 `json:"maxDownloads"`
+	// Mode is empty for normal sharings, or SharingModeDrop for upload-only sharings
+	Mode string `json:"mode"`
+	// MaxSize and MaxFiles limit uploads into the upload-only sharing, MaxFiles is unlimited if it is 0
+	MaxSize  int64 `json:"maxSize"`
+	MaxFiles int64 `json:"maxFiles"`
+	// Slug is the human-readable share ID picked by the owner, a random share ID is generated if it is empty
+	Slug string `json:"slug"`
 }
 
 func (h *FileHandlers) AddSharing(c *gin.Context) {
@@ -1012,20 +1487,59 @@ func (h *FileHandlers) AddSharing(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 403, errors.New("forbidden")))
 		return
 	}
+	if req.MaxDownloads < 0 {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid max downloads")))
+		return
+	}
+	opts := &db.SharingOptions{MaxDownloads: req.MaxDownloads}
+	if req.ExpireAt != 0 {
+		opts.ExpireAt = time.Unix(req.ExpireAt, 0)
+		if !opts.ExpireAt.After(time.Now()) {
+			c.JSON(q.ErrResp(c, 400, errors.New("expiry must be in the future")))
+			return
+		}
+	}
+	if req.Pwd != "" {
+		pwdHash, err := bcrypt.GenerateFromPassword([]byte(req.Pwd), 10)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, errors.New("fail to set password")))
+			return
+		}
+		opts.PwdHash = string(pwdHash)
+	}
 
 	info, err := h.deps.FS().Stat(sharingPath)
 	if err != nil {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	} else if !info.IsDir() {
-		c.JSON(q.ErrResp(c, 400, errors.New("can not sharing a file")))
+		// files are always tracked, otherwise the sharing would be recorded as a folder
+		_, err = h.deps.FileInfos().GetFileInfo(c, sharingPath)
+		if err != nil {
+			if errors.Is(err, db.ErrFileInfoNotFound) {
+				c.JSON(q.ErrResp(c, 404, err))
+			} else {
+				c.JSON(q.ErrResp(c, 500, err))
+			}
+			return
+		}
+	}
+	if err = checkSharingMode(req, info.IsDir()); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
 		return
 	}
+	if req.Mode == SharingModeDrop {
+		opts.Drop, err = h.newDrop(c, userId, sharingPath, req)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+	}
 
 	infoId := h.deps.ID().Gen()
-	err = h.deps.FileInfos().AddSharing(c, infoId, userId, sharingPath)
+	code, err := h.addSharing(c, infoId, userId, sharingPath, req.Slug, opts)
 	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
+		c.JSON(q.ErrResp(c, code, err))
 		return
 	}
 	c.JSON(q.Resp(200))
@@ -1106,6 +1620,10 @@ func (h *FileHandlers) ListSharings(c *gin.Context) {
 
 type SharingIDsResp struct {
 	IDs map[string]string `json:"IDs"`
+	// TTLs are remaining lifetimes of expiring sharings in seconds, sharings without expiry are not included
+	TTLs map[string]int64 `json:"TTLs"`
+	// Stats are access statistics of sharings, sharings never accessed and without max downloads are not included
+	Stats map[string]*db.SharingStat `json:"stats"`
 }
 
 func (h *FileHandlers) ListSharingIDs(c *gin.Context) {
@@ -1115,19 +1633,47 @@ func (h *FileHandlers) ListSharingIDs(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
-	c.JSON(200, &SharingIDsResp{IDs: dirToID})
+	dirToExpiry, err := h.deps.FileInfos().ListSharingExpiries(c, userName)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	now := time.Now()
+	dirToTTL := map[string]int64{}
+	for dirPath, expireAt := range dirToExpiry {
+		if _, ok := dirToID[dirPath]; ok {
+			dirToTTL[dirPath] = int64(expireAt.Sub(now).Seconds())
+		}
+	}
+	dirToStat, err := h.deps.FileInfos().ListSharingStats(c, userName)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &SharingIDsResp{IDs: dirToID, TTLs: dirToTTL, Stats: dirToStat})
 }
 
 type GenerateHashReq struct {
 	FilePath string `json:"filePath"`
 }
 
+// GenerateHash computes the checksum of the file in the algorithm of the path,
+// it is sha1 for legacy routes without the algorithm.
 func (h *FileHandlers) GenerateHash(c *gin.Context) {
 	req := &GenerateHashReq{}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(q.ErrResp(c, 400, err))
 		return
 	}
+	algo := c.Param(HashAlgoParam)
+	if algo == "" {
+		algo = HashSha1
+	}
+	if _, err := newHasher(algo); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
 
 	filePath := filepath.Clean(req.FilePath)
 	if filePath == "" {
@@ -1149,8 +1695,9 @@ func (h *FileHandlers) GenerateHash(c *gin.Context) {
 	}
 
 	msg, err := json.Marshal(Sha1Params{
-		UserId:   userId,
-		FilePath: filePath,
+		UserId:     userId,
+		FilePath:   filePath,
+		Algorithms: []string{algo},
 	})
 	if err != nil {
 		c.JSON(q.ErrResp(c, 500, err))
@@ -1173,6 +1720,12 @@ func (h *FileHandlers) GenerateHash(c *gin.Context) {
 
 type GetSharingDirResp struct {
 	SharingDir string `json:"sharingDir"`
+	// IsDir is false if a file is shared, it can be downloaded by DownloadSharing
+	IsDir bool `json:"isDir"`
+	// Protected is true if the sharing must be unlocked by UnlockSharing
+	Protected bool `json:"protected"`
+	// IsDrop is true if the sharing is upload-only
+	IsDrop bool `json:"isDrop"`
 }
 
 func (h *FileHandlers) GetSharingDir(c *gin.Context) {
@@ -1183,11 +1736,95 @@ func (h *FileHandlers) GetSharingDir(c *gin.Context) {
 	}
 
 	dirPath, err := h.deps.FileInfos().GetSharingDir(c, shareID)
+	if err != nil {
+		if errors.Is(err, db.ErrSharingNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	info, err := h.deps.FS().Stat(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(q.ErrResp(c, 404, os.ErrNotExist))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	pwdHash, err := h.deps.FileInfos().GetSharingPwd(c, dirPath)
 	if err != nil {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
-	c.JSON(200, &GetSharingDirResp{SharingDir: dirPath})
+	c.JSON(200, &GetSharingDirResp{
+		SharingDir: dirPath,
+		IsDir:      info.IsDir(),
+		Protected:  pwdHash != "",
+		IsDrop:     h.isDrop(c, dirPath),
+	})
+}
+
+// purgeExpiredSharings is run by cron, it clears share IDs of expired sharings.
+// Expired sharings are not accessible even before they are cleared.
+func (h *FileHandlers) purgeExpiredSharings() {
+	cleared, err := h.deps.FileInfos().DelExpiredSharings(context.TODO(), time.Now())
+	if err != nil {
+		h.deps.Log().Errorf("failed to purge expired sharings: %s", err)
+	} else if cleared > 0 {
+		h.deps.Log().Infof("%d expired sharings are purged", cleared)
+	}
+}
+
+// DownloadSharing downloads the shared file by its share ID
+func (h *FileHandlers) DownloadSharing(c *gin.Context) {
+	shareID := c.Query(ShareIDQuery)
+	if shareID == "" {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid share ID")))
+		return
+	}
+
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+	var err error
+	userId := db.VisitorID
+	if role != db.VisitorRole {
+		userId, err = q.GetUserId(c)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+	}
+
+	// range requests in a counted window are served even if the sharing is disabled in the meantime
+	rangeVal := c.GetHeader(rangeHeader)
+	if rangeVal != "" {
+		if filePath, counted := h.countedRangeDownload(shareID, c.ClientIP()); counted {
+			h.serveFile(c, userId, filePath, rangeVal, c.GetHeader(ifRangeHeader))
+			return
+		}
+	}
+
+	filePath, err := h.deps.FileInfos().GetSharingDir(c, shareID)
+	if err != nil {
+		if errors.Is(err, db.ErrSharingNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	} else if !h.canAccess(c, userId, userName, role, "download", filePath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+	code, err := h.recordSharingDownload(c, userName, role, rangeVal, shareID, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	h.serveFile(c, userId, filePath, rangeVal, c.GetHeader(ifRangeHeader))
 }
 
 type SearchItemsResp struct {
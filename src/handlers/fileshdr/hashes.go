@@ -0,0 +1,63 @@
+package fileshdr
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	HashSha1    = "sha1"
+	HashSha256  = "sha256"
+	HashMD5     = "md5"
+	HashBlake2b = "blake2b"
+
+	// params
+	HashAlgoParam = "algo"
+)
+
+// newHasher returns the hash of the algorithm, blake2b is blake2b-256
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case HashSha1:
+		return sha1.New(), nil
+	case HashSha256:
+		return sha256.New(), nil
+	case HashMD5:
+		return md5.New(), nil
+	case HashBlake2b:
+		return blake2b.New256(nil)
+	}
+	return nil, fmt.Errorf("hash algorithm(%s) is not supported", algo)
+}
+
+// getHashAlgorithms returns configured algorithms computed after uploading,
+// sha1 is always included because it is used for deduplication and sharing.
+func getHashAlgorithms(algosConfig interface{}) ([]string, error) {
+	algos := []string{HashSha1}
+	configured, ok := algosConfig.([]string)
+	if algosConfig != nil && !ok {
+		return nil, fmt.Errorf("hash algorithms are invalid: %v", algosConfig)
+	}
+
+	for _, algo := range configured {
+		if _, err := newHasher(algo); err != nil {
+			return nil, err
+		}
+		duplicated := false
+		for _, added := range algos {
+			if added == algo {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			algos = append(algos, algo)
+		}
+	}
+	return algos, nil
+}
@@ -0,0 +1,139 @@
+package fileshdr
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// headers
+	// ChunkChecksumHeader is "<algorithm> <hex checksum>" of the chunk, algorithm is crc32c or sha256
+	ChunkChecksumHeader = "X-Chunk-Checksum"
+
+	ChecksumCRC32C = "crc32c"
+
+	// chunkCorruptedCode is returned when the chunk does not match its checksum
+	chunkCorruptedCode = 422
+	// fileCorruptedCode is returned when the uploaded file does not match the expected hash
+	fileCorruptedCode = 422
+)
+
+var (
+	ErrChunkCorrupted = errors.New("chunk is corrupted: checksum mismatch")
+	ErrFileCorrupted  = errors.New("file is corrupted: expected hash mismatch")
+)
+
+// parseChunkChecksum parses the checksum header, hash is nil if the header is empty
+func parseChunkChecksum(header string) (hash.Hash, []byte, error) {
+	if header == "" {
+		return nil, nil, nil
+	}
+
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid checksum header(%s)", header)
+	}
+	checksum, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid checksum(%s): %w", parts[1], err)
+	}
+
+	algo := strings.ToLower(parts[0])
+	switch algo {
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), checksum, nil
+	case HashSha256:
+		hasher, err := newHasher(algo)
+		return hasher, checksum, err
+	}
+	return nil, nil, fmt.Errorf("checksum algorithm(%s) is not supported", parts[0])
+}
+
+// checkChunk returns ErrChunkCorrupted if the digest of the hasher does not match the checksum
+func checkChunk(hasher hash.Hash, checksum []byte) error {
+	if hasher == nil {
+		return nil
+	} else if !bytes.Equal(hasher.Sum(nil), checksum) {
+		return ErrChunkCorrupted
+	}
+	return nil
+}
+
+// parseExpectedHash parses the whole file hash in format "algorithm:hex"
+func parseExpectedHash(expectedHash string) (string, string, error) {
+	parts := strings.SplitN(expectedHash, ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid expected hash(%s)", expectedHash)
+	}
+
+	algo, sign := strings.ToLower(parts[0]), strings.ToLower(parts[1])
+	if _, err := newHasher(algo); err != nil {
+		return "", "", err
+	} else if _, err := hex.DecodeString(sign); err != nil || sign == "" {
+		return "", "", fmt.Errorf("invalid hash(%s)", sign)
+	}
+	return algo, sign, nil
+}
+
+// verifyUpload checks the uploaded file against the expected hash before it is completed,
+// the file is quarantined if it does not match.
+func (h *FileHandlers) verifyUpload(ctx context.Context, userId uint64, tmpFilePath, fsFilePath string) (int, error) {
+	expectedHash, err := h.deps.FileInfos().GetUploadExpectedHash(ctx, userId, fsFilePath)
+	if err != nil {
+		if errors.Is(err, db.ErrUploadNotFound) {
+			return 404, err
+		}
+		return 500, err
+	} else if expectedHash == "" {
+		return 200, nil
+	}
+
+	algo, sign, err := parseExpectedHash(expectedHash)
+	if err != nil {
+		return 500, err
+	}
+	hashes, err := h.computeHashes(tmpFilePath, []string{algo})
+	if err != nil {
+		return 500, err
+	} else if hashes[algo] == sign {
+		return 200, nil
+	}
+
+	err = h.quarantine(ctx, userId, tmpFilePath, fsFilePath)
+	if err != nil {
+		return 500, fmt.Errorf("fail to quarantine: %w", err)
+	}
+	return fileCorruptedCode, ErrFileCorrupted
+}
+
+// quarantine moves the uploaded file which fails the verification to the quarantine folder for investigation,
+// the upload is removed and its space is released from the account charged for it.
+func (h *FileHandlers) quarantine(ctx context.Context, userId uint64, tmpFilePath, fsFilePath string) error {
+	location := strings.Split(fsFilePath, "/")[0]
+	quarantinePath := q.QuarantinePath(location, h.deps.ID().Gen())
+	err := h.deps.FS().MkdirAll(q.QuarantineFolder(location))
+	if err != nil {
+		return err
+	}
+	err = h.deps.FS().Rename(tmpFilePath, quarantinePath)
+	if err != nil {
+		return err
+	}
+
+	err = h.deps.FileInfos().DelUploadingInfos(ctx, userId, fsFilePath)
+	if err != nil {
+		return err
+	}
+
+	h.deps.Log().Errorf("file(%s) is quarantined to (%s) because of hash mismatch", fsFilePath, quarantinePath)
+	return nil
+}
@@ -0,0 +1,195 @@
+package fileshdr
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	PresignExpQuery  = "pexp"
+	PresignSigQuery  = "psig"
+	PresignUserQuery = "puid"
+
+	// PresignedDownloadPath is the public path of Download which accepts presigned URLs
+	PresignedDownloadPath = "/v2/public/fs/files"
+	maxPresignTTL         = 7 * 24 * 3600
+)
+
+var ErrInvalidSignature = errors.New("invalid or expired signature")
+
+// signDownload signs the path, the expiry and the user minting the URL with the token secret
+func (h *FileHandlers) signDownload(userId uint64, filePath string, expireAt int64) ([]byte, error) {
+	secret := h.cfg.StringOr("Secrets.TokenSecret", "")
+	if secret == "" {
+		return nil, errors.New("token secret is not set")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, err := mac.Write([]byte(fmt.Sprintf("download\n%d\n%s\n%d", userId, filePath, expireAt)))
+	if err != nil {
+		return nil, err
+	}
+	return mac.Sum(nil), nil
+}
+
+// verifyPresignedDownload checks if the request carries an unexpired signature for the path,
+// it returns the ID of the user who minted the URL.
+func (h *FileHandlers) verifyPresignedDownload(c *gin.Context, filePath string) (uint64, error) {
+	expireAt, err := strconv.ParseInt(c.Query(PresignExpQuery), 10, 64)
+	if err != nil || expireAt <= time.Now().Unix() {
+		return 0, ErrInvalidSignature
+	}
+	userId, err := strconv.ParseUint(c.Query(PresignUserQuery), 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(c.Query(PresignSigQuery))
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+
+	expectedSig, err := h.signDownload(userId, filePath, expireAt)
+	if err != nil {
+		return 0, err
+	}
+	if !hmac.Equal(sig, expectedSig) {
+		return 0, ErrInvalidSignature
+	}
+	return userId, nil
+}
+
+// presignerCanAccess checks if the user who minted the URL still exists and can download the file
+func (h *FileHandlers) presignerCanAccess(c *gin.Context, userId uint64, filePath string) (bool, error) {
+	user, err := h.deps.Users().GetUser(c, userId)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	} else if user.Role == db.BannedRole {
+		return false, nil
+	}
+
+	if !db.IsPredefinedRole(user.Role) {
+		customRole, err := h.deps.Users().GetRole(c, user.Role)
+		if err != nil {
+			if errors.Is(err, db.ErrRoleNotFound) {
+				return false, nil
+			}
+			return false, err
+		}
+		c.Set(q.CustomRoleParam, customRole)
+	}
+	return h.canAccess(c, user.ID, user.Name, user.Role, "download", filePath), nil
+}
+
+// downloadPresigned serves the file for a presigned URL, no login is required.
+// The URL stops working once its minter is deleted, banned or loses access to the file.
+// The download speed is limited by the owner of the file.
+func (h *FileHandlers) downloadPresigned(c *gin.Context, filePath, rangeVal, ifRangeVal string) {
+	userId, err := h.verifyPresignedDownload(c, filePath)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSignature) {
+			c.JSON(q.ErrResp(c, 403, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	ok, err := h.presignerCanAccess(c, userId, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if !ok {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	owner, err := h.getOwner(c, filePath, 0)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	h.serveFile(c, owner.ID, filePath, rangeVal, ifRangeVal)
+}
+
+type PresignReq struct {
+	Path string `json:"path"`
+	// TTL is the lifetime of the URL in seconds, it is at most 7 days
+	TTL int64 `json:"ttl"`
+}
+
+type PresignResp struct {
+	// URL is the path and the query of the presigned download URL
+	URL      string `json:"url"`
+	ExpireAt int64  `json:"expireAt"`
+}
+
+// PresignDownload mints a signed URL which downloads the file without login until it expires.
+func (h *FileHandlers) PresignDownload(c *gin.Context) {
+	req := &PresignReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	if req.TTL <= 0 || req.TTL > maxPresignTTL {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("ttl must be in (0, %d]", maxPresignTTL)))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	userName := c.MustGet(q.UserParam).(string)
+	role := c.MustGet(q.RoleParam).(string)
+	filePath := filepath.Clean(req.Path)
+	if !h.canAccess(c, userId, userName, role, "download", filePath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	info, err := h.deps.FS().Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	} else if info.IsDir() {
+		c.JSON(q.ErrResp(c, 400, errors.New("downloading a folder is not supported")))
+		return
+	}
+
+	expireAt := time.Now().Unix() + req.TTL
+	sig, err := h.signDownload(userId, filePath, expireAt)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	query := url.Values{}
+	query.Set(FilePathQuery, filePath)
+	query.Set(PresignExpQuery, fmt.Sprint(expireAt))
+	query.Set(PresignUserQuery, fmt.Sprint(userId))
+	query.Set(PresignSigQuery, base64.RawURLEncoding.EncodeToString(sig))
+	c.JSON(200, &PresignResp{
+		URL:      fmt.Sprintf("%s?%s", PresignedDownloadPath, query.Encode()),
+		ExpireAt: expireAt,
+	})
+}
@@ -0,0 +1,322 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	RevisionIDQuery = "rid"
+)
+
+// getOverwritingPath checks if the existing file can be overwritten by the upload
+func (h *FileHandlers) getOverwritingPath(req *CreateReq) (string, error) {
+	fsFilePath := filepath.Clean(req.Path)
+	if !req.Overwrite || h.cfg.GrabInt("Fs.MaxRevisions") <= 0 {
+		return "", os.ErrExist
+	}
+
+	info, err := h.deps.FS().Stat(fsFilePath)
+	if err != nil {
+		return "", err
+	} else if info.IsDir() {
+		return "", errors.New("can not overwrite a folder")
+	}
+	return fsFilePath, nil
+}
+
+// keepRevision moves the file being replaced to the revision folder of its location,
+// it should be called only after the replacing is validated.
+// It returns nil if nothing is kept, i.e. versioning is disabled or the file does not exist.
+func (h *FileHandlers) keepRevision(ctx context.Context, userId uint64, filePath string) (*db.Revision, error) {
+	if h.cfg.GrabInt("Fs.MaxRevisions") <= 0 {
+		return nil, nil
+	}
+
+	info, err := h.deps.FS().Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	} else if info.IsDir() {
+		return nil, os.ErrExist
+	}
+
+	location := strings.Split(filePath, "/")[0]
+	revisionID := h.deps.ID().Gen()
+	revisionPath := q.RevisionPath(location, revisionID)
+	err = h.deps.FS().MkdirAll(q.RevisionFolder(location))
+	if err != nil {
+		return nil, err
+	}
+	err = h.deps.FS().Rename(filePath, revisionPath)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := &db.Revision{
+		ID:          revisionID,
+		Path:        filePath,
+		UploaderID:  userId,
+		Size:        info.Size(),
+		StoragePath: revisionPath,
+		CreatedAt:   time.Now(),
+	}
+	err = h.deps.FileInfos().AddRevision(ctx, revision)
+	if err != nil {
+		if renameErr := h.deps.FS().Rename(revisionPath, filePath); renameErr != nil {
+			h.deps.Log().Errorf("failed to move back revision(%s): %s", filePath, renameErr)
+		}
+		return nil, err
+	}
+	return revision, nil
+}
+
+// undoKeepRevision moves the kept revision back when replacing the file fails,
+// failures are only logged as the original error is returned to the client.
+func (h *FileHandlers) undoKeepRevision(ctx context.Context, kept *db.Revision) {
+	if kept == nil {
+		return
+	}
+	err := h.deps.FS().Rename(kept.StoragePath, kept.Path)
+	if err != nil {
+		h.deps.Log().Errorf("failed to move back revision(%s): %s", kept.Path, err)
+		return
+	}
+	err = h.deps.FileInfos().RestoreRevision(ctx, kept.ID, h.deps.ID().Gen())
+	if err != nil {
+		h.deps.Log().Errorf("failed to restore revision(%s): %s", kept.Path, err)
+	}
+}
+
+// undoReplace drops the file which has been placed at the path of the kept revision,
+// then moves the revision back so that a failed replacement leaves the old file in place.
+// Nothing is dropped if no file is replaced.
+func (h *FileHandlers) undoReplace(ctx context.Context, userId uint64, filePath string, kept *db.Revision) {
+	if kept == nil {
+		return
+	}
+
+	err := h.deps.FileInfos().DelFileInfo(ctx, userId, filePath)
+	if err != nil {
+		h.deps.Log().Errorf("failed to remove info of replacement(%s): %s", filePath, err)
+		return
+	}
+	err = h.deps.FS().Remove(filePath)
+	if err != nil {
+		h.deps.Log().Errorf("failed to remove replacement(%s): %s", filePath, err)
+		return
+	}
+	h.undoKeepRevision(ctx, kept)
+}
+
+// pruneRevisions removes revisions exceeding the retention count,
+// failures are only logged because the pruning will be retried in the next overwriting.
+func (h *FileHandlers) pruneRevisions(ctx context.Context, filePath string) {
+	maxRevisions := h.cfg.GrabInt("Fs.MaxRevisions")
+	if maxRevisions <= 0 {
+		return
+	}
+
+	pruned, err := h.deps.FileInfos().PruneRevisions(ctx, filePath, maxRevisions)
+	if err != nil {
+		h.deps.Log().Errorf("failed to prune revisions(%s): %s", filePath, err)
+		return
+	}
+	for _, revision := range pruned {
+		err = h.deps.FS().Remove(revision.StoragePath)
+		if err != nil {
+			h.deps.Log().Errorf("failed to remove revision(%s): %s", revision.StoragePath, err)
+		}
+	}
+}
+
+type ListRevisionsResp struct {
+	Revisions []*db.Revision `json:"revisions"`
+}
+
+func (h *FileHandlers) ListRevisions(c *gin.Context) {
+	filePath := filepath.Clean(c.Query(FilePathQuery))
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+	if !h.canAccess(c, userId, userName, role, "revision.list", filePath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	revisions, err := h.deps.FileInfos().ListRevisions(c, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &ListRevisionsResp{Revisions: revisions})
+}
+
+// getRevision returns the revision if the user can access its path.
+func (h *FileHandlers) getRevision(c *gin.Context, revisionID uint64, op string) (*db.Revision, int, error) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		return nil, 500, err
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+
+	revision, err := h.deps.FileInfos().GetRevision(c, revisionID)
+	if err != nil {
+		if errors.Is(err, db.ErrRevisionNotFound) {
+			return nil, 404, err
+		}
+		return nil, 500, err
+	} else if !h.canAccess(c, userId, userName, role, op, revision.Path) {
+		return nil, 403, q.ErrAccessDenied
+	}
+	return revision, 200, nil
+}
+
+func (h *FileHandlers) DownloadRevision(c *gin.Context) {
+	revisionID, err := strconv.ParseUint(c.Query(RevisionIDQuery), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid revision ID: %w", err)))
+		return
+	}
+	revision, code, err := h.getRevision(c, revisionID, "revision.download")
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	fd, id, err := h.deps.FS().GetFileReader(revision.StoragePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(q.ErrResp(c, 404, os.ErrNotExist))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	defer func() {
+		err := h.deps.FS().CloseReader(fmt.Sprint(id))
+		if err != nil {
+			h.deps.Log().Errorf("failed to close: %s", err)
+		}
+	}()
+
+	limitedReader, err := h.GetStreamReader(userId, fd)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	defer func() {
+		err := limitedReader.Close()
+		if err != nil {
+			h.deps.Log().Errorf("failed to close limitedReader: %s", err)
+		}
+	}()
+
+	extraHeaders := map[string]string{
+		"Content-Disposition": contentDisposition(filepath.Base(revision.Path)),
+	}
+	c.DataFromReader(200, revision.Size, "application/octet-stream", limitedReader, extraHeaders)
+}
+
+type RestoreRevisionReq struct {
+	ID uint64 `json:"id,string"`
+}
+
+// RestoreRevision makes the revision the current file,
+// the current file is kept as a revision if versioning is enabled.
+func (h *FileHandlers) RestoreRevision(c *gin.Context) {
+	req := &RestoreRevisionReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	revision, code, err := h.getRevision(c, req.ID, "revision.restore")
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	h.lock(lockName(revision.Path), &code, &err, func() (int, error) {
+		_, err := h.deps.FS().Stat(revision.StoragePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return 404, os.ErrNotExist
+			}
+			return 500, err
+		}
+		info, err := h.deps.FS().Stat(revision.Path)
+		if err == nil {
+			// the current file can only be replaced if it can be kept
+			if info.IsDir() || h.cfg.GrabInt("Fs.MaxRevisions") <= 0 {
+				return 400, os.ErrExist
+			}
+		} else if !os.IsNotExist(err) {
+			return 500, err
+		}
+		err = h.deps.FS().MkdirAll(filepath.Dir(revision.Path))
+		if err != nil {
+			return 500, err
+		}
+
+		kept, err := h.keepRevision(c, userId, revision.Path)
+		if err != nil {
+			return 500, err
+		}
+		err = h.deps.FS().Rename(revision.StoragePath, revision.Path)
+		if err != nil {
+			h.undoKeepRevision(c, kept)
+			return 500, err
+		}
+
+		err = h.deps.FileInfos().RestoreRevision(c, revision.ID, h.deps.ID().Gen())
+		if err != nil {
+			if renameErr := h.deps.FS().Rename(revision.Path, revision.StoragePath); renameErr != nil {
+				h.deps.Log().Errorf("failed to move back revision(%s): %s", revision.StoragePath, renameErr)
+			} else {
+				h.undoKeepRevision(c, kept)
+			}
+			return 500, err
+		}
+
+		h.pruneRevisions(c, revision.Path)
+		err = h.deps.FileIndex().AddPath(revision.Path)
+		if err != nil {
+			return 500, err
+		}
+		return 200, nil
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
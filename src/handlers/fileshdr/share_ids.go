@@ -0,0 +1,83 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+const (
+	minShareIDLen = 6
+	maxShareIDLen = 64
+)
+
+var (
+	ErrInvalidShareSlug  = errors.New("slug must be 3-64 letters, digits, '-' or '_', and start and end with a letter or a digit")
+	ErrReservedShareSlug = errors.New("slug is reserved")
+	ErrShareSlugExisting = errors.New("slug is used by another sharing")
+
+	shareSlugPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]{1,62}[a-zA-Z0-9]$`)
+	// reservedShareSlugs may be confused with paths of pages and APIs
+	reservedShareSlugs = map[string]bool{
+		"admin":    true,
+		"api":      true,
+		"files":    true,
+		"login":    true,
+		"logout":   true,
+		"my":       true,
+		"public":   true,
+		"s":        true,
+		"settings": true,
+		"share":    true,
+		"sharings": true,
+		"static":   true,
+		"unlock":   true,
+		"v1":       true,
+		"v2":       true,
+	}
+)
+
+func checkShareIDLen(shareIDLen int) error {
+	if shareIDLen < minShareIDLen || shareIDLen > maxShareIDLen {
+		return fmt.Errorf("share ID length must be in [%d, %d]: %d", minShareIDLen, maxShareIDLen, shareIDLen)
+	}
+	return nil
+}
+
+func checkShareSlug(slug string) error {
+	if !shareSlugPattern.MatchString(slug) {
+		return ErrInvalidShareSlug
+	} else if reservedShareSlugs[strings.ToLower(slug)] {
+		return ErrReservedShareSlug
+	}
+	return nil
+}
+
+// addSharing shares the path with the slug if it is not empty, or with a share ID generated by the store.
+func (h *FileHandlers) addSharing(ctx context.Context, infoId, userId uint64, sharingPath, slug string, opts *db.SharingOptions) (int, error) {
+	if slug != "" {
+		if err := checkShareSlug(slug); err != nil {
+			return 400, err
+		}
+
+		err := h.deps.FileInfos().AddSharingWithID(ctx, infoId, userId, sharingPath, slug, opts)
+		if err != nil {
+			if errors.Is(err, db.ErrConflicted) {
+				return 400, ErrShareSlugExisting
+			}
+			return 500, err
+		}
+		return 200, nil
+	}
+
+	opts.IDLen = h.shareIDLen
+	err := h.deps.FileInfos().AddSharing(ctx, infoId, userId, sharingPath, opts)
+	if err != nil {
+		return 500, err
+	}
+	return 200, nil
+}
@@ -0,0 +1,102 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// unlockAttempts limits password attempts of each sharing from each client in unlockAttemptCyc
+	unlockAttempts   = 10
+	unlockAttemptCyc = 5 * 60 * 1000
+)
+
+var (
+	ErrSharingNotProtected = errors.New("sharing is not protected")
+	ErrTooManyAttempts     = errors.New("too many attempts, try again later")
+)
+
+// unlockedSharingPath returns the path of the protected sharing unlocked by the share token,
+// the ID is set by the AuthN middleware and it is empty if no sharing is unlocked.
+func (h *FileHandlers) unlockedSharingPath(ctx context.Context) string {
+	shareID, _ := ctx.Value(q.ShareIDParam).(string)
+	if shareID == "" {
+		return ""
+	}
+
+	// the sharing may be deleted or replaced after it is unlocked
+	sharingPath, err := h.deps.FileInfos().GetSharingDir(ctx, shareID)
+	if err != nil {
+		return ""
+	}
+	return sharingPath
+}
+
+type UnlockSharingReq struct {
+	ShareID string `json:"shareID"`
+	Pwd     string `json:"pwd"`
+}
+
+// UnlockSharing verifies the password of the protected sharing,
+// then it issues a share token which grants listing and downloading in the sharing.
+func (h *FileHandlers) UnlockSharing(c *gin.Context) {
+	req := &UnlockSharingReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	} else if req.ShareID == "" {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid share ID")))
+		return
+	}
+
+	sharingPath, err := h.deps.FileInfos().GetSharingDir(c, req.ShareID)
+	if err != nil {
+		if errors.Is(err, db.ErrSharingNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	pwdHash, err := h.deps.FileInfos().GetSharingPwd(c, sharingPath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if pwdHash == "" {
+		c.JSON(q.ErrResp(c, 400, ErrSharingNotProtected))
+		return
+	}
+
+	if !h.unlockLimiter.Access(fmt.Sprintf("%s:%s", req.ShareID, c.ClientIP()), unlockAttempts, 1) {
+		c.JSON(q.ErrResp(c, 429, ErrTooManyAttempts))
+		return
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(pwdHash), []byte(req.Pwd))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	ttl := h.cfg.GrabInt("Users.CookieTTL")
+	token, err := h.deps.Token().ToToken(map[string]string{
+		q.ShareIDParam: req.ShareID,
+		q.ExpireParam:  fmt.Sprintf("%d", time.Now().Unix()+int64(ttl)),
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	secure := h.cfg.GrabBool("Users.CookieSecure")
+	httpOnly := h.cfg.GrabBool("Users.CookieHttpOnly")
+	c.SetCookie(q.ShareTokenCookie, token, ttl, "/", "", secure, httpOnly)
+	c.JSON(q.Resp(200))
+}
@@ -0,0 +1,112 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+// rangeDownloadWindow is the period in which range requests of a client count as one download of a sharing,
+// so that resuming or downloading in parts is not counted multiple times.
+const rangeDownloadWindow = 10 * time.Minute
+
+// grantingSharing returns the first sharing path which grants the access to others,
+// it is empty if the user is admin or the owner, because their accesses are not counted.
+func (h *FileHandlers) grantingSharing(ctx context.Context, userName, role string, accessingPaths ...string) string {
+	if role == db.AdminRole {
+		return ""
+	}
+
+	unlockedPath := h.unlockedSharingPath(ctx)
+	for _, accessingPath := range accessingPaths {
+		parts := strings.Split(accessingPath, "/")
+		if userName != "" && parts[0] == userName {
+			return ""
+		} else if unlockedPath != "" &&
+			(accessingPath == unlockedPath || strings.HasPrefix(accessingPath, unlockedPath+"/")) {
+			return unlockedPath
+		}
+
+		isSharing, err := h.deps.FileInfos().IsSharing(ctx, accessingPath)
+		if err == nil && isSharing {
+			return accessingPath
+		}
+	}
+	return ""
+}
+
+// recordSharingListing counts the listing if it is granted by a sharing,
+// failures are only logged because statistics do not affect accessing.
+func (h *FileHandlers) recordSharingListing(c *gin.Context, userName, role, dirPath string) {
+	sharingPath := h.grantingSharing(c, userName, role, dirPath)
+	if sharingPath == "" {
+		return
+	}
+
+	err := h.deps.FileInfos().AddSharingListing(c, sharingPath, c.ClientIP())
+	if err != nil {
+		h.deps.Log().Errorf("failed to record listing of sharing(%s): %s", sharingPath, err)
+	}
+}
+
+// rangeWindow records the file of a counted range download and when its window starts.
+type rangeWindow struct {
+	filePath  string
+	startedAt time.Time
+}
+
+// recordSharingDownload counts the download if it is granted by a sharing,
+// the download is rejected if the sharing is disabled by reaching its max downloads.
+// For range requests, the window of the resource starts only after the download is counted,
+// and the first accessing path is served in the window.
+func (h *FileHandlers) recordSharingDownload(c *gin.Context, userName, role, rangeVal, resource string, accessingPaths ...string) (int, error) {
+	sharingPath := h.grantingSharing(c, userName, role, accessingPaths...)
+	if sharingPath == "" {
+		return 200, nil
+	}
+
+	err := h.deps.FileInfos().AddSharingDownload(c, sharingPath, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, db.ErrSharingNotFound) {
+			return 403, q.ErrAccessDenied
+		}
+		return 500, err
+	}
+	if rangeVal != "" {
+		h.rangeDownloads.Store(rangeWindowKey(resource, c.ClientIP()), &rangeWindow{
+			filePath:  accessingPaths[0],
+			startedAt: time.Now(),
+		})
+	}
+	return 200, nil
+}
+
+// countedRangeDownload returns the file path if range requests of the client on the resource
+// are counted in the window already. Such requests are let through without checking the sharing again,
+// so that resuming is not denied after the sharing is disabled by reaching its max downloads.
+func (h *FileHandlers) countedRangeDownload(resource, ip string) (string, bool) {
+	now := time.Now()
+	h.rangeDownloads.Range(func(key, val any) bool {
+		if now.Sub(val.(*rangeWindow).startedAt) > rangeDownloadWindow {
+			h.rangeDownloads.Delete(key)
+		}
+		return true
+	})
+
+	val, counted := h.rangeDownloads.Load(rangeWindowKey(resource, ip))
+	if !counted {
+		return "", false
+	}
+	return val.(*rangeWindow).filePath, true
+}
+
+func rangeWindowKey(resource, ip string) string {
+	return fmt.Sprintf("%s:%s", ip, resource)
+}
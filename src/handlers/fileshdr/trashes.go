@@ -0,0 +1,307 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihexxa/fsearch"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	TrashIDQuery = "trid"
+)
+
+// trashOwner returns the ID and the location of the owner of the trash for the item,
+// items in group folders are trashed into trashes of groups.
+func (h *FileHandlers) trashOwner(ctx context.Context, userId uint64, itemPath string) (uint64, string, error) {
+	group, err := h.pathGroup(ctx, itemPath)
+	if err != nil {
+		return 0, "", err
+	} else if group != nil {
+		return group.ID, db.GroupLocation(group.Name), nil
+	}
+
+	owner, err := h.getOwner(ctx, itemPath, userId)
+	if err != nil {
+		return 0, "", err
+	}
+	return owner.ID, owner.Name, nil
+}
+
+// trashItem moves the item to the trash of its owner instead of removing it.
+func (h *FileHandlers) trashItem(ctx context.Context, userId uint64, itemPath string) (int, error) {
+	info, err := h.deps.FS().Stat(itemPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 404, os.ErrNotExist
+		}
+		return 500, err
+	}
+
+	ownerId, ownerLocation, err := h.trashOwner(ctx, userId, itemPath)
+	if err != nil {
+		return 500, err
+	}
+	trashFolder := q.TrashFolder(ownerLocation)
+	if itemPath == trashFolder || strings.HasPrefix(itemPath, fmt.Sprintf("%s/", trashFolder)) {
+		return 400, errors.New("items in trash can only be purged")
+	}
+
+	trashID := h.deps.ID().Gen()
+	trashPath := q.TrashPath(ownerLocation, trashID)
+	err = h.deps.FS().MkdirAll(trashFolder)
+	if err != nil {
+		return 500, err
+	}
+	err = h.deps.FS().Rename(itemPath, trashPath)
+	if err != nil {
+		return 500, err
+	}
+
+	err = h.deps.FileInfos().AddTrash(ctx, &db.TrashInfo{
+		ID:         trashID,
+		UserID:     ownerId,
+		OriginPath: itemPath,
+		TrashPath:  trashPath,
+		IsDir:      info.IsDir(),
+		DeletedAt:  time.Now(),
+	})
+	if err != nil {
+		if renameErr := h.deps.FS().Rename(trashPath, itemPath); renameErr != nil {
+			h.deps.Log().Errorf("failed to move back trashed item(%s): %s", itemPath, renameErr)
+		}
+		return 500, err
+	}
+
+	err = h.deps.FileIndex().DelPath(itemPath)
+	if err != nil && !errors.Is(err, fsearch.ErrNotFound) {
+		return 500, err
+	}
+	return 200, nil
+}
+
+type ListTrashesResp struct {
+	Trashes []*db.TrashInfo `json:"trashes"`
+}
+
+// ListTrashes lists trashes of the user and trashes of its groups.
+func (h *FileHandlers) ListTrashes(c *gin.Context) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	trashes, err := h.deps.FileInfos().ListTrashes(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	groupIds, err := h.userGroupIds(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	for _, groupId := range groupIds {
+		groupTrashes, err := h.deps.FileInfos().ListTrashes(c, groupId)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+		trashes = append(trashes, groupTrashes...)
+	}
+	c.JSON(200, &ListTrashesResp{Trashes: trashes})
+}
+
+// getTrash returns the trash if it belongs to the user,
+// or it belongs to a group of the user and the user can write the group folder.
+func (h *FileHandlers) getTrash(c *gin.Context, trashID uint64) (*db.TrashInfo, int, error) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		return nil, 500, err
+	}
+	role := c.MustGet(q.RoleParam).(string)
+
+	trash, err := h.deps.FileInfos().GetTrash(c, trashID)
+	if err != nil {
+		if errors.Is(err, db.ErrTrashNotFound) {
+			return nil, 404, err
+		}
+		return nil, 500, err
+	} else if role != db.AdminRole && trash.UserID != userId &&
+		!h.grantedByGroup(c, userId, role, "delete", trash.TrashPath) {
+		return nil, 404, db.ErrTrashNotFound
+	}
+	return trash, 200, nil
+}
+
+type RestoreTrashReq struct {
+	ID uint64 `json:"id,string"`
+}
+
+// RestoreTrash moves the item back to its original path,
+// it fails if the original path is occupied.
+func (h *FileHandlers) RestoreTrash(c *gin.Context) {
+	req := &RestoreTrashReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	trash, code, err := h.getTrash(c, req.ID)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	h.lock(lockName(trash.OriginPath), &code, &err, func() (int, error) {
+		_, err := h.deps.FS().Stat(trash.OriginPath)
+		if err == nil {
+			return 400, os.ErrExist
+		} else if !os.IsNotExist(err) {
+			return 500, err
+		}
+
+		err = h.deps.FS().MkdirAll(filepath.Dir(trash.OriginPath))
+		if err != nil {
+			return 500, err
+		}
+		err = h.deps.FS().Rename(trash.TrashPath, trash.OriginPath)
+		if err != nil {
+			return 500, err
+		}
+
+		err = h.deps.FileInfos().RestoreTrash(c, trash.ID)
+		if err != nil {
+			if renameErr := h.deps.FS().Rename(trash.OriginPath, trash.TrashPath); renameErr != nil {
+				h.deps.Log().Errorf("failed to move back restored item(%s): %s", trash.TrashPath, renameErr)
+			}
+			if errors.Is(err, db.ErrReachedLimit) {
+				return 403, err
+			}
+			return 500, err
+		}
+
+		err = h.indexItems(trash.OriginPath)
+		if err != nil {
+			return 500, err
+		}
+		return 200, nil
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
+
+// PurgeTrash deletes the trashed item permanently.
+func (h *FileHandlers) PurgeTrash(c *gin.Context) {
+	trashID, err := strconv.ParseUint(c.Query(TrashIDQuery), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid trash ID: %w", err)))
+		return
+	}
+
+	trash, code, err := h.getTrash(c, trashID)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	h.lock(lockName(trash.TrashPath), &code, &err, func() (int, error) {
+		err := h.purgeTrash(c, trash)
+		if err != nil {
+			return 500, err
+		}
+		return 200, nil
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
+
+func (h *FileHandlers) purgeTrash(ctx context.Context, trash *db.TrashInfo) error {
+	err := h.deps.FS().Remove(trash.TrashPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return h.deps.FileInfos().DelTrash(ctx, trash.ID)
+}
+
+// purgeExpiredTrashes is run by cron, it deletes items trashed before the TTL.
+func (h *FileHandlers) purgeExpiredTrashes() {
+	ttl := h.cfg.GrabInt("Fs.TrashTTL")
+	deletedBefore := time.Now().Add(-time.Duration(ttl) * time.Second)
+
+	ctx := context.TODO()
+	trashes, err := h.deps.FileInfos().ListExpiredTrashes(ctx, deletedBefore)
+	if err != nil {
+		h.deps.Log().Errorf("failed to list expired trashes: %s", err)
+		return
+	}
+
+	for _, trash := range trashes {
+		var code int
+		h.lock(lockName(trash.TrashPath), &code, &err, func() (int, error) {
+			err := h.purgeTrash(ctx, trash)
+			if err != nil {
+				return 500, err
+			}
+			return 200, nil
+		})
+		if err != nil {
+			h.deps.Log().Errorf("failed to purge trash(%s): %s", trash.TrashPath, err)
+		}
+	}
+}
+
+// indexItems adds the item and its children to the file index
+func (h *FileHandlers) indexItems(itemPath string) error {
+	err := h.deps.FileIndex().AddPath(itemPath)
+	if err != nil {
+		return err
+	}
+
+	info, err := h.deps.FS().Stat(itemPath)
+	if err != nil {
+		return err
+	} else if !info.IsDir() {
+		return nil
+	}
+
+	dirQueue := []string{itemPath}
+	for len(dirQueue) > 0 {
+		dirPath := dirQueue[0]
+		dirQueue = dirQueue[1:]
+
+		infos, err := h.deps.FS().ListDir(dirPath)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			childPath := filepath.Join(dirPath, info.Name())
+			err = h.deps.FileIndex().AddPath(childPath)
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				dirQueue = append(dirQueue, childPath)
+			}
+		}
+	}
+	return nil
+}
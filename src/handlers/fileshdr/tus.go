@@ -0,0 +1,398 @@
+package fileshdr
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+// tus 1.0 resumable upload protocol: https://tus.io/protocols/resumable-upload
+// uploads are identified by their target paths, so they share upload infos with the JSON uploading APIs.
+const (
+	TusVersion    = "1.0.0"
+	TusExtensions = "creation,termination,checksum"
+
+	// params
+	TusUploadIDParam = "uploadID"
+
+	// headers
+	TusResumableHeader         = "Tus-Resumable"
+	TusVersionHeader           = "Tus-Version"
+	TusExtensionHeader         = "Tus-Extension"
+	TusChecksumAlgorithmHeader = "Tus-Checksum-Algorithm"
+	TusUploadLengthHeader      = "Upload-Length"
+	TusUploadDeferLengthHeader = "Upload-Defer-Length"
+	TusUploadOffsetHeader      = "Upload-Offset"
+	TusUploadMetadataHeader    = "Upload-Metadata"
+	TusUploadChecksumHeader    = "Upload-Checksum"
+	TusOffsetContentType       = "application/offset+octet-stream"
+	tusLocationHeader          = "Location"
+	tusCacheControlHeader      = "Cache-Control"
+	tusChecksumMismatchCode    = 460
+	tusChecksumAlgorithms      = "sha1,md5,sha256,blake2b"
+	tusMetadataPathKey         = "path"
+	tusMetadataFilenameKey     = "filename"
+	tusMetadataFallbackNameKey = "name"
+	tusUploadsPath             = "/v2/my/fs/tus"
+)
+
+var ErrTusChecksumMismatch = errors.New("checksum mismatch")
+
+// TusUploadID encodes the file path as the ID in the upload URL
+func TusUploadID(filePath string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(filePath))
+}
+
+func tusUploadPath(c *gin.Context) (string, error) {
+	filePath, err := base64.RawURLEncoding.DecodeString(c.Param(TusUploadIDParam))
+	if err != nil {
+		return "", fmt.Errorf("invalid upload ID: %w", err)
+	} else if len(filePath) == 0 {
+		return "", errors.New("invalid upload ID")
+	}
+	return filepath.Clean(string(filePath)), nil
+}
+
+// parseTusMetadata parses "key base64(value),key2 base64(value2)"
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if parts[0] == "" {
+			return nil, errors.New("invalid metadata key")
+		}
+
+		value := []byte{}
+		if len(parts) == 2 {
+			var err error
+			value, err = base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata value(%s): %w", parts[0], err)
+			}
+		}
+		metadata[parts[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+// parseTusChecksum parses "algorithm base64(checksum)", hash is nil if the header is empty
+func parseTusChecksum(header string) (hash.Hash, []byte, error) {
+	if header == "" {
+		return nil, nil, nil
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return nil, nil, errors.New("invalid checksum header")
+	}
+	hasher, err := newHasher(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	checksum, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid checksum: %w", err)
+	}
+	return hasher, checksum, nil
+}
+
+// checkTusVersion sets the version header and checks the client's version,
+// it responds 412 if the version is not supported.
+func checkTusVersion(c *gin.Context) bool {
+	c.Header(TusResumableHeader, TusVersion)
+	if c.GetHeader(TusResumableHeader) != TusVersion {
+		c.Header(TusVersionHeader, TusVersion)
+		c.JSON(q.ErrResp(c, 412, fmt.Errorf("unsupported tus version(%s)", c.GetHeader(TusResumableHeader))))
+		return false
+	}
+	return true
+}
+
+// TusOptions reports the server's capabilities
+func (h *FileHandlers) TusOptions(c *gin.Context) {
+	c.Header(TusResumableHeader, TusVersion)
+	c.Header(TusVersionHeader, TusVersion)
+	c.Header(TusExtensionHeader, TusExtensions)
+	c.Header(TusChecksumAlgorithmHeader, tusChecksumAlgorithms)
+	c.Status(204)
+}
+
+// TusCreate creates the upload (creation extension),
+// the target path is the "path" in the metadata, or the "filename" under the user's home folder.
+func (h *FileHandlers) TusCreate(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	if c.GetHeader(TusUploadDeferLengthHeader) != "" {
+		c.JSON(q.ErrResp(c, 400, errors.New("deferring length is not supported")))
+		return
+	}
+	fileSize, err := strconv.ParseInt(c.GetHeader(TusUploadLengthHeader), 10, 64)
+	if err != nil || fileSize < 0 {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid upload length")))
+		return
+	}
+	metadata, err := parseTusMetadata(c.GetHeader(TusUploadMetadataHeader))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+
+	filePath := metadata[tusMetadataPathKey]
+	if filePath == "" {
+		fileName := metadata[tusMetadataFilenameKey]
+		if fileName == "" {
+			fileName = metadata[tusMetadataFallbackNameKey]
+		}
+		if fileName == "" {
+			c.JSON(q.ErrResp(c, 400, errors.New("path or filename is required in metadata")))
+			return
+		}
+		filePath = q.FsRootPath(userName, filepath.Base(fileName))
+	}
+	filePath = filepath.Clean(filePath)
+
+	_, code, err := h.createUpload(c, userId, userName, role, &CreateReq{
+		Path:     filePath,
+		FileSize: fileSize,
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	c.Header(tusLocationHeader, fmt.Sprintf("%s/%s", tusUploadsPath, TusUploadID(filePath)))
+	c.Status(201)
+}
+
+// isEmptyTusUpload checks if the upload is a completed zero-length upload,
+// it is completed at creation so there is no upload info for it.
+func (h *FileHandlers) isEmptyTusUpload(filePath string) bool {
+	info, err := h.deps.FS().Stat(filePath)
+	return err == nil && !info.IsDir() && info.Size() == 0
+}
+
+// TusOffset reports the uploaded size of the upload
+func (h *FileHandlers) TusOffset(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	filePath, err := tusUploadPath(c)
+	if err != nil {
+		c.Status(404)
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.Status(500)
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+	if !h.canAccess(c, userId, userName, role, "upload.status", filePath) {
+		c.Status(403)
+		return
+	}
+
+	_, fileSize, uploaded, err := h.deps.FileInfos().GetUploadInfo(c, userId, filePath)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			c.Status(500)
+			return
+		} else if !h.isEmptyTusUpload(filePath) {
+			c.Status(404)
+			return
+		}
+		fileSize, uploaded = 0, 0
+	}
+
+	c.Header(tusCacheControlHeader, "no-store")
+	c.Header(TusUploadOffsetHeader, fmt.Sprint(uploaded))
+	c.Header(TusUploadLengthHeader, fmt.Sprint(fileSize))
+	c.Status(200)
+}
+
+// TusPatch appends the request body to the upload,
+// the chunk is discarded if it does not match the checksum in the header (checksum extension).
+func (h *FileHandlers) TusPatch(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	if c.ContentType() != TusOffsetContentType {
+		c.JSON(q.ErrResp(c, 415, fmt.Errorf("content type must be %s", TusOffsetContentType)))
+		return
+	}
+	filePath, err := tusUploadPath(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 404, err))
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader(TusUploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid upload offset")))
+		return
+	}
+	hasher, checksum, err := parseTusChecksum(c.GetHeader(TusUploadChecksumHeader))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+	if !h.canAccess(c, userId, userName, role, "upload.chunk", filePath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	tmpFilePath := q.UploadPath(userName, filePath)
+	var code int
+	uploaded := int64(0)
+	h.lock(lockName(tmpFilePath), &code, &err, func() (int, error) {
+		upload, code, err := h.getSequentialUpload(c, userId, filePath)
+		if err != nil {
+			if code == 404 && h.isEmptyTusUpload(filePath) {
+				if offset != 0 {
+					return 409, errors.New("offset != uploaded")
+				} else if c.Request.ContentLength > 0 {
+					return 400, errors.New("chunk exceeds the upload length")
+				}
+				return 204, nil
+			}
+			return code, err
+		}
+		fsFilePath, fileSize, offsetInDB := upload.RealFilePath, upload.Size, upload.Uploaded
+		if offsetInDB != offset {
+			return 409, errors.New("offset != uploaded")
+		}
+
+		// the length can be unknown if the body is chunked
+		chunkSize := c.Request.ContentLength
+		if chunkSize > fileSize-offset {
+			return 400, errors.New("chunk exceeds the upload length")
+		} else if chunkSize < 0 {
+			chunkSize = fileSize - offset
+		}
+		quotaOwnerId, err := h.quotaOwner(c, userId, filePath)
+		if err != nil {
+			return 500, err
+		}
+		ok, err := h.deps.Limiter().CanWrite(quotaOwnerId, int(chunkSize))
+		if err != nil {
+			return 500, err
+		} else if !ok {
+			return 429, errors.New("retry later")
+		}
+
+		var reader io.Reader = io.LimitReader(c.Request.Body, chunkSize)
+		if hasher != nil {
+			reader = io.TeeReader(reader, hasher)
+		}
+		wrote, err := h.writeStream(tmpFilePath, offset, reader)
+		if err != nil {
+			if hasher == nil {
+				// the written part is kept so that the uploading can be resumed
+				setErr := h.deps.FileInfos().SetUploadInfo(c, userId, filePath, offset+wrote)
+				if setErr != nil {
+					h.deps.Log().Errorf("failed to set upload info(%s): %s", filePath, setErr)
+				}
+			}
+			return 500, err
+		}
+		if hasher != nil && !bytes.Equal(hasher.Sum(nil), checksum) {
+			// the offset is not moved so the written part will be overwritten
+			return tusChecksumMismatchCode, ErrTusChecksumMismatch
+		}
+
+		uploaded = offset + wrote
+		err = h.deps.FileInfos().SetUploadInfo(c, userId, filePath, uploaded)
+		if err != nil {
+			return 500, err
+		}
+		if uploaded == fileSize {
+			code, err := h.completeUpload(c, userId, tmpFilePath, fsFilePath)
+			if err != nil {
+				return code, err
+			}
+		}
+		return 204, nil
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	c.Header(TusUploadOffsetHeader, fmt.Sprint(uploaded))
+	c.Status(204)
+}
+
+// TusTerminate removes the upload (termination extension)
+func (h *FileHandlers) TusTerminate(c *gin.Context) {
+	if !checkTusVersion(c) {
+		return
+	}
+	filePath, err := tusUploadPath(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 404, err))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+	// op is empty, because users must be admin, or the path belongs to this user
+	if !h.canAccess(c, userId, userName, role, "", filePath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+
+	_, _, _, err = h.deps.FileInfos().GetUploadInfo(c, userId, filePath)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	code, err := h.delUploading(c, userId, userName, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	c.Status(204)
+}
@@ -0,0 +1,175 @@
+package fileshdr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	OffsetQuery = "offset"
+
+	// headers
+	UploadPathHeader   = "X-Upload-Path"
+	UploadOffsetHeader = "X-Upload-Offset"
+
+	uploadBufSize = 512 * 1024
+)
+
+// getBinaryChunkParams reads the path and offset of the chunk from headers or queries,
+// the path in the header must be url encoded.
+func getBinaryChunkParams(c *gin.Context) (string, int64, error) {
+	filePath := c.Query(FilePathQuery)
+	if pathHeader := c.GetHeader(UploadPathHeader); pathHeader != "" {
+		decodedPath, err := url.PathUnescape(pathHeader)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid path header: %w", err)
+		}
+		filePath = decodedPath
+	}
+	if filePath == "" {
+		return "", 0, errors.New("invalid file path")
+	}
+
+	offsetStr := c.Query(OffsetQuery)
+	if offsetHeader := c.GetHeader(UploadOffsetHeader); offsetHeader != "" {
+		offsetStr = offsetHeader
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		return "", 0, fmt.Errorf("invalid offset(%s)", offsetStr)
+	}
+
+	return filepath.Clean(filePath), offset, nil
+}
+
+// writeStream writes the content from the reader into the file from the offset,
+// the size written is returned even if it fails in the middle.
+func (h *FileHandlers) writeStream(filePath string, offset int64, reader io.Reader) (int64, error) {
+	wrote := int64(0)
+	buf := make([]byte, uploadBufSize)
+	for {
+		n, readErr := reader.Read(buf)
+		if n > 0 {
+			n2, err := h.deps.FS().WriteAt(filePath, buf[:n], offset+wrote)
+			wrote += int64(n2)
+			if err != nil {
+				return wrote, err
+			}
+		}
+		if readErr != nil {
+			if readErr == io.EOF {
+				return wrote, nil
+			}
+			return wrote, readErr
+		}
+	}
+}
+
+// UploadBinaryChunk works as UploadChunk but the chunk is the raw request body (application/octet-stream),
+// it is streamed into the uploading file without being buffered as a whole.
+func (h *FileHandlers) UploadBinaryChunk(c *gin.Context) {
+	filePath, offset, err := getBinaryChunkParams(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	chunkSize := c.Request.ContentLength
+	if chunkSize <= 0 {
+		c.JSON(q.ErrResp(c, 411, errors.New("content length is required")))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+	if !h.canAccess(c, userId, userName, role, "upload.chunk", filePath) {
+		c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+		return
+	}
+	hasher, checksum, err := parseChunkChecksum(c.GetHeader(ChunkChecksumHeader))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	quotaOwnerId, err := h.quotaOwner(c, userId, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	ok, err := h.deps.Limiter().CanWrite(quotaOwnerId, int(chunkSize))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if !ok {
+		c.JSON(q.ErrResp(c, 429, errors.New("retry later")))
+		return
+	}
+
+	tmpFilePath := q.UploadPath(userName, filePath)
+	var code int
+	fsFilePath, fileSize, uploaded, wrote := "", int64(0), int64(0), int64(0)
+	h.lock(lockName(tmpFilePath), &code, &err, func() (int, error) {
+		upload, code, err := h.getSequentialUpload(c, userId, filePath)
+		if err != nil {
+			return code, err
+		}
+		fsFilePath, fileSize, uploaded = upload.RealFilePath, upload.Size, upload.Uploaded
+		if uploaded != offset {
+			return 500, errors.New("offset != uploaded")
+		} else if offset+chunkSize > fileSize {
+			return 400, errors.New("chunk exceeds the file size")
+		}
+
+		var reader io.Reader = io.LimitReader(c.Request.Body, chunkSize)
+		if hasher != nil {
+			reader = io.TeeReader(reader, hasher)
+		}
+		wrote, err = h.writeStream(tmpFilePath, offset, reader)
+		if err == nil {
+			if checkErr := checkChunk(hasher, checksum); checkErr != nil {
+				// the corrupted chunk is discarded by not advancing the offset
+				return chunkCorruptedCode, checkErr
+			}
+		}
+		// the written part is kept so that the uploading can be resumed
+		setErr := h.deps.FileInfos().SetUploadInfo(c, userId, filePath, offset+wrote)
+		if err != nil {
+			return 500, err
+		} else if setErr != nil {
+			return 500, setErr
+		}
+
+		if uploaded+wrote == fileSize {
+			code, err := h.completeUpload(c, userId, tmpFilePath, fsFilePath)
+			if err != nil {
+				return code, err
+			}
+		}
+		return 200, nil
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	c.JSON(200, &UploadStatusResp{
+		Path:     fsFilePath,
+		IsDir:    false,
+		FileSize: fileSize,
+		Uploaded: uploaded + wrote,
+	})
+}
@@ -0,0 +1,211 @@
+package fileshdr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	PartNumberQuery = "part"
+)
+
+var ErrPartsUpload = errors.New("file is uploaded in parts")
+
+type UploadPartsResp struct {
+	Path     string `json:"path"`
+	FileSize int64  `json:"fileSize"`
+	PartSize int64  `json:"partSize"`
+	Uploaded int64  `json:"uploaded"`
+	// Parts are numbers of received parts, they start from 1
+	Parts []int64 `json:"parts"`
+}
+
+func newUploadPartsResp(parts *db.UploadParts) *UploadPartsResp {
+	resp := &UploadPartsResp{
+		Path:     parts.RealFilePath,
+		FileSize: parts.Size,
+		PartSize: parts.PartSize,
+		Uploaded: parts.Uploaded,
+		Parts:    []int64{},
+	}
+	if parts.PartSize <= 0 {
+		return resp
+	}
+
+	// ranges are sorted and merged so a part is received if it is covered by one range
+	for partNumber := int64(1); (partNumber-1)*parts.PartSize < parts.Size; partNumber++ {
+		start, end := partRange(parts, partNumber)
+		for _, uploadRange := range parts.Ranges {
+			if uploadRange.Start <= start && end <= uploadRange.End {
+				resp.Parts = append(resp.Parts, partNumber)
+				break
+			}
+		}
+	}
+	return resp
+}
+
+// partRange returns the range [start, end) of the part
+func partRange(parts *db.UploadParts, partNumber int64) (int64, int64) {
+	start := (partNumber - 1) * parts.PartSize
+	end := start + parts.PartSize
+	if end > parts.Size {
+		end = parts.Size
+	}
+	return start, end
+}
+
+func (h *FileHandlers) getUploadParts(c *gin.Context, filePath string) (*db.UploadParts, int, error) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		return nil, 500, err
+	}
+	role := c.MustGet(q.RoleParam).(string)
+	userName := c.MustGet(q.UserParam).(string)
+	if !h.canAccess(c, userId, userName, role, "upload.chunk", filePath) {
+		return nil, 403, q.ErrAccessDenied
+	}
+
+	parts, err := h.deps.FileInfos().GetUploadParts(c, userId, filePath)
+	if err != nil {
+		if errors.Is(err, db.ErrUploadNotFound) {
+			return nil, 404, err
+		}
+		return nil, 500, err
+	} else if parts.PartSize <= 0 {
+		return nil, 400, errors.New("file is not uploaded in parts")
+	}
+	return parts, 200, nil
+}
+
+// getSequentialUpload returns the upload info which is uploaded sequentially,
+// chunks can not be written to uploads in the multi-part mode as they are tracked by ranges.
+func (h *FileHandlers) getSequentialUpload(ctx context.Context, userId uint64, filePath string) (*db.UploadParts, int, error) {
+	parts, err := h.deps.FileInfos().GetUploadParts(ctx, userId, filePath)
+	if err != nil {
+		if errors.Is(err, db.ErrUploadNotFound) {
+			return nil, 404, err
+		}
+		return nil, 500, err
+	} else if parts.PartSize > 0 {
+		return nil, 400, ErrPartsUpload
+	}
+	return parts, 200, nil
+}
+
+// UploadPart writes the numbered part (application/octet-stream) of the file,
+// parts can be uploaded concurrently and in any order,
+// the file is moved to its path once all parts are received.
+func (h *FileHandlers) UploadPart(c *gin.Context) {
+	filePath := filepath.Clean(c.Query(FilePathQuery))
+	partNumber, err := strconv.ParseInt(c.Query(PartNumberQuery), 10, 64)
+	if err != nil || partNumber < 1 {
+		c.JSON(q.ErrResp(c, 400, errors.New("invalid part number")))
+		return
+	}
+
+	parts, code, err := h.getUploadParts(c, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	start, end := partRange(parts, partNumber)
+	if start >= parts.Size {
+		c.JSON(q.ErrResp(c, 400, errors.New("part number exceeds the file size")))
+		return
+	} else if c.Request.ContentLength != end-start {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("part size must be %d", end-start)))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	userName := c.MustGet(q.UserParam).(string)
+	hasher, checksum, err := parseChunkChecksum(c.GetHeader(ChunkChecksumHeader))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	quotaOwnerId, err := h.quotaOwner(c, userId, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	ok, err := h.deps.Limiter().CanWrite(quotaOwnerId, int(end-start))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if !ok {
+		c.JSON(q.ErrResp(c, 429, errors.New("retry later")))
+		return
+	}
+
+	// only the part is locked so that other parts can be written concurrently
+	tmpFilePath := q.UploadPath(userName, filePath)
+	partLockName := lockName(fmt.Sprintf("%s:%d", tmpFilePath, partNumber))
+	h.lock(partLockName, &code, &err, func() (int, error) {
+		var reader io.Reader = io.LimitReader(c.Request.Body, end-start)
+		if hasher != nil {
+			reader = io.TeeReader(reader, hasher)
+		}
+		wrote, err := h.writeStream(tmpFilePath, start, reader)
+		if err != nil {
+			return 500, err
+		} else if wrote != end-start {
+			return 400, errors.New("part is incomplete")
+		} else if err = checkChunk(hasher, checksum); err != nil {
+			// the part is not recorded so that it can be uploaded again
+			return chunkCorruptedCode, err
+		}
+
+		parts, err = h.deps.FileInfos().AddUploadRange(c, userId, filePath, &db.UploadRange{Start: start, End: end})
+		if err != nil {
+			if errors.Is(err, db.ErrUploadNotFound) {
+				return 404, err
+			}
+			return 500, err
+		}
+
+		if parts.Uploaded == parts.Size {
+			var completeCode int
+			var completeErr error
+			h.lock(lockName(tmpFilePath), &completeCode, &completeErr, func() (int, error) {
+				return h.completeUpload(c, userId, tmpFilePath, filePath)
+			})
+			if completeErr != nil {
+				return completeCode, completeErr
+			}
+		}
+		return 200, nil
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+
+	c.JSON(200, newUploadPartsResp(parts))
+}
+
+// UploadParts reports received parts so that clients can resume by uploading missing parts
+func (h *FileHandlers) UploadParts(c *gin.Context) {
+	filePath := filepath.Clean(c.Query(FilePathQuery))
+	parts, code, err := h.getUploadParts(c, filePath)
+	if err != nil {
+		c.JSON(q.ErrResp(c, code, err))
+		return
+	}
+	c.JSON(200, newUploadPartsResp(parts))
+}
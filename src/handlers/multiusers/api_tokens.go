@@ -0,0 +1,223 @@
+package multiusers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	APITokenIDQuery = "tokid"
+
+	apiTokenPrefix    = "qs_"
+	bearerPrefix      = "Bearer "
+	maxAPITokenName   = 64
+	apiTokenBytes     = 32
+	authorizationName = "Authorization"
+	// apiTokenUsedInterval throttles recording the last used time of API tokens
+	apiTokenUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIToken  = errors.New("invalid api token")
+	ErrReadOnlyAPIToken = errors.New("api token is read-only")
+	ErrAPITokenAuth     = errors.New("api tokens can not be managed by api tokens")
+)
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token in the Authorization header, it is empty if the header is not set.
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader(authorizationName)
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix))
+}
+
+// apiTokenClaims resolves the owner of the API token and returns claims as the cookie token does.
+// Tokens act as the owner's current role, but only admin tokens keep the admin role.
+func (h *MultiUsersSvc) apiTokenClaims(ctx context.Context, token string) (map[string]string, error) {
+	apiToken, err := h.deps.APITokens().GetAPITokenByHash(ctx, hashAPIToken(token))
+	if err != nil {
+		if errors.Is(err, db.ErrAPITokenNotFound) {
+			return nil, ErrInvalidAPIToken
+		}
+		return nil, err
+	}
+	user, err := h.deps.Users().GetUser(ctx, apiToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	role := user.Role
+	if role == db.AdminRole && apiToken.Scope != db.APIScopeAdmin {
+		role = db.UserRole
+	}
+
+	now := time.Now()
+	if now.Sub(apiToken.LastUsedAt) >= apiTokenUsedInterval {
+		err = h.deps.APITokens().SetAPITokenUsed(ctx, apiToken.ID, now)
+		if err != nil {
+			h.deps.Log().Errorf("failed to record usage of api token(%d): %s", apiToken.ID, err)
+		}
+	}
+
+	return map[string]string{
+		q.UserIDParam:     fmt.Sprint(user.ID),
+		q.UserParam:       user.Name,
+		q.RoleParam:       role,
+		q.ExpireParam:     "",
+		q.APITokenIDParam: fmt.Sprint(apiToken.ID),
+		q.APIScopeParam:   apiToken.Scope,
+		q.SessionIDParam:  "",
+	}, nil
+}
+
+// isReadMethod returns true if the method is allowed for read-only API tokens
+func isReadMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS"
+}
+
+// authedByAPIToken returns true if the request is authenticated by an API token
+func authedByAPIToken(c *gin.Context) bool {
+	tokenID, _ := c.Get(q.APITokenIDParam)
+	return tokenID != nil && tokenID != ""
+}
+
+type AddAPITokenReq struct {
+	Name string `json:"name"`
+	// Scope is one of "read-only", "read-write" and "admin"
+	Scope string `json:"scope"`
+}
+
+type AddAPITokenResp struct {
+	ID uint64 `json:"id,string"`
+	// Token is only returned once, it can not be retrieved later
+	Token string `json:"token"`
+}
+
+// AddAPIToken creates a long-lived token which authenticates requests by the "Authorization: Bearer" header.
+func (h *MultiUsersSvc) AddAPIToken(c *gin.Context) {
+	req := &AddAPITokenReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	if authedByAPIToken(c) {
+		c.JSON(q.ErrResp(c, 403, ErrAPITokenAuth))
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPITokenName {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("name must be 1-%d characters", maxAPITokenName)))
+		return
+	}
+
+	role := c.MustGet(q.RoleParam).(string)
+	switch req.Scope {
+	case db.APIScopeReadOnly, db.APIScopeReadWrite:
+	case db.APIScopeAdmin:
+		if role != db.AdminRole {
+			c.JSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+			return
+		}
+	default:
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid scope: %s", req.Scope)))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	buf := make([]byte, apiTokenBytes)
+	_, err = rand.Read(buf)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	tokenID := h.deps.ID().Gen()
+	err = h.deps.APITokens().AddAPIToken(c, &db.APIToken{
+		ID:        tokenID,
+		UserID:    userId,
+		Name:      req.Name,
+		Scope:     req.Scope,
+		TokenHash: hashAPIToken(token),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &AddAPITokenResp{ID: tokenID, Token: token})
+}
+
+type ListAPITokensResp struct {
+	Tokens []*db.APIToken `json:"tokens"`
+}
+
+func (h *MultiUsersSvc) ListAPITokens(c *gin.Context) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	tokens, err := h.deps.APITokens().ListAPITokens(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &ListAPITokensResp{Tokens: tokens})
+}
+
+// DelAPIToken revokes the API token of the current user.
+func (h *MultiUsersSvc) DelAPIToken(c *gin.Context) {
+	tokenID, err := strconv.ParseUint(c.Query(APITokenIDQuery), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid token ID: %w", err)))
+		return
+	}
+	if authedByAPIToken(c) {
+		c.JSON(q.ErrResp(c, 403, ErrAPITokenAuth))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	err = h.deps.APITokens().DelAPIToken(c, userId, tokenID)
+	if err != nil {
+		if errors.Is(err, db.ErrAPITokenNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	c.JSON(q.Resp(200))
+}
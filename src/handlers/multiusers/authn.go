@@ -0,0 +1,126 @@
+package multiusers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ihexxa/quickshare/src/db"
+	"github.com/ihexxa/quickshare/src/depidx"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid user name or password")
+	ErrLocalUserExisting  = errors.New("user name is used by a local user")
+)
+
+// Authenticator verifies names and passwords of logins and returns the logged in users
+type Authenticator interface {
+	Authenticate(ctx context.Context, name, pwd string) (*db.User, error)
+}
+
+// localAuthenticator verifies passwords of users in the db
+type localAuthenticator struct {
+	deps *depidx.Deps
+}
+
+func newLocalAuthenticator(deps *depidx.Deps) *localAuthenticator {
+	return &localAuthenticator{deps: deps}
+}
+
+func (a *localAuthenticator) Authenticate(ctx context.Context, name, pwd string) (*db.User, error) {
+	user, err := a.deps.Users().GetUserByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.Pwd), []byte(pwd))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// groupsRole returns the role mapped from the groups, it is empty if none is mapped.
+// If groups are mapped to several roles, admin is preferred, then user, then other roles in order.
+func groupsRole(groups []string, groupRoles map[string]string) string {
+	roles := map[string]bool{}
+	for _, group := range groups {
+		if role, ok := groupRoles[group]; ok && role != "" {
+			roles[role] = true
+		}
+	}
+	if roles[db.AdminRole] {
+		return db.AdminRole
+	} else if roles[db.UserRole] {
+		return db.UserRole
+	}
+
+	otherRoles := []string{}
+	for role := range roles {
+		otherRoles = append(otherRoles, role)
+	}
+	if len(otherRoles) == 0 {
+		return ""
+	}
+	sort.Strings(otherRoles)
+	return otherRoles[0]
+}
+
+// syncRole sets the role mapped from groups of an external identity, or the default role if no group is mapped,
+// so that users lose mapped roles after leaving the groups. Banned users and the default admin are not changed.
+// Roles are not synced if groupRoles is empty, they are managed by admins then.
+func (h *MultiUsersSvc) syncRole(ctx context.Context, user *db.User, groupRoles map[string]string, role, defaultRole string) error {
+	if len(groupRoles) == 0 {
+		return nil
+	} else if role == "" {
+		role = defaultRole
+	}
+	if role == "" || role == user.Role || user.Role == db.BannedRole || user.ID == 0 {
+		return nil
+	}
+
+	err := h.deps.Users().SetInfo(ctx, user.ID, &db.User{
+		Role:  role,
+		Quota: user.Quota,
+	})
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
+
+// provisionUser creates the user of an external identity,
+// the password is random as the user is authenticated by the identity provider.
+func (h *MultiUsersSvc) provisionUser(ctx context.Context, name, role string, preferences *db.Preferences) (*db.User, error) {
+	if err := h.isValidUserName(name); err != nil {
+		return nil, err
+	} else if strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid user name: %s", name)
+	}
+
+	_, err := h.deps.Users().GetUserByName(ctx, name)
+	if err == nil {
+		return nil, ErrLocalUserExisting
+	} else if !errors.Is(err, db.ErrUserNotFound) {
+		return nil, err
+	}
+
+	buf := make([]byte, 32)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := h.addUser(ctx, name, hex.EncodeToString(buf), role, preferences)
+	if err != nil {
+		return nil, err
+	}
+	return h.deps.Users().GetUser(ctx, uid)
+}
@@ -0,0 +1,341 @@
+package multiusers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+func (h *MultiUsersSvc) isValidGroupName(name string) error {
+	if err := h.isValidUserName(name); err != nil {
+		return err
+	} else if strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid group name: %s", name)
+	}
+	return nil
+}
+
+func isValidQuota(quota *db.Quota) error {
+	if quota == nil {
+		return errors.New("empty quota")
+	} else if quota.SpaceLimit < 0 || quota.UploadSpeedLimit < 0 || quota.DownloadSpeedLimit < 0 {
+		return errors.New("quota can not be negative")
+	}
+	return nil
+}
+
+type AddGroupReq struct {
+	Name string `json:"name"`
+	// Quota is the default quota of users if it is empty
+	Quota *db.Quota `json:"quota"`
+}
+
+type AddGroupResp struct {
+	ID string `json:"id"`
+}
+
+// AddGroup creates the group and its group folder, members are added by SetGroupMember.
+func (h *MultiUsersSvc) AddGroup(c *gin.Context) {
+	var err error
+	req := &AddGroupReq{}
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	if err = h.isValidGroupName(req.Name); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	if req.Quota == nil {
+		req.Quota = &db.Quota{
+			SpaceLimit:         int64(h.cfg.IntOr("Users.SpaceLimit", 100*1024*1024)),
+			UploadSpeedLimit:   h.cfg.IntOr("Users.UploadSpeedLimit", 100*1024),
+			DownloadSpeedLimit: h.cfg.IntOr("Users.DownloadSpeedLimit", 100*1024),
+		}
+	} else if err = isValidQuota(req.Quota); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	gid := h.deps.ID().Gen()
+	err = h.deps.Groups().AddGroup(c, &db.Group{
+		ID:    gid,
+		Name:  req.Name,
+		Quota: req.Quota,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrConflicted) {
+			c.JSON(q.ErrResp(c, 400, fmt.Errorf("group is existing: %s", req.Name)))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	groupFolder := q.FsRootPath(db.GroupLocation(req.Name), "/")
+	if err = h.deps.FS().MkdirAll(groupFolder); err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	c.JSON(200, &AddGroupResp{ID: fmt.Sprint(gid)})
+}
+
+type SetGroupReq struct {
+	ID    uint64    `json:"id,string"`
+	Quota *db.Quota `json:"quota"`
+}
+
+// SetGroup updates the quota of the group
+func (h *MultiUsersSvc) SetGroup(c *gin.Context) {
+	var err error
+	req := &SetGroupReq{}
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	if err = isValidQuota(req.Quota); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	err = h.deps.Groups().SetGroupQuota(c, req.ID, req.Quota)
+	if err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	c.JSON(q.Resp(200))
+}
+
+// DelGroup deletes the group with its members, grants to it, pending uploads and files in its group folder.
+func (h *MultiUsersSvc) DelGroup(c *gin.Context) {
+	groupId, err := strconv.ParseUint(c.Query(q.GroupIDParam), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid group ID %w", err)))
+		return
+	}
+
+	group, err := h.deps.Groups().GetGroup(c, groupId)
+	if err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	// TODO: try to make following atomic
+	tmpPaths, err := h.deps.Groups().DelGroup(c, groupId)
+	if err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	for _, tmpPath := range tmpPaths {
+		if err = h.deps.FS().Remove(tmpPath); err != nil {
+			h.deps.Log().Errorf("failed to remove uploading file(%s): %s", tmpPath, err)
+		}
+	}
+	if err = h.deps.FS().Remove(db.GroupLocation(group.Name)); err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	c.JSON(q.Resp(200))
+}
+
+type ListGroupsResp struct {
+	Groups []*db.Group `json:"groups"`
+}
+
+func (h *MultiUsersSvc) ListGroups(c *gin.Context) {
+	groups, err := h.deps.Groups().ListGroups(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &ListGroupsResp{Groups: groups})
+}
+
+type SetGroupMemberReq struct {
+	GroupID uint64 `json:"groupID,string"`
+	// User is the name of the member
+	User string `json:"user"`
+	// Perm is "r" or "rw", setting it again updates the permission
+	Perm string `json:"perm"`
+}
+
+// SetGroupMember adds the user to the group or updates its permission of the group folder.
+func (h *MultiUsersSvc) SetGroupMember(c *gin.Context) {
+	var err error
+	req := &SetGroupMemberReq{}
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	if req.Perm != db.PermRead && req.Perm != db.PermReadWrite {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid permission: %s", req.Perm)))
+		return
+	}
+
+	user, err := h.deps.Users().GetUserByName(c, req.User)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	} else if user.ID == db.VisitorID {
+		c.JSON(q.ErrResp(c, 400, errors.New("visitors can not join groups")))
+		return
+	}
+
+	err = h.deps.Groups().SetGroupMember(c, &db.GroupMember{
+		GroupID: req.GroupID,
+		UserID:  user.ID,
+		Perm:    req.Perm,
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	c.JSON(q.Resp(200))
+}
+
+func (h *MultiUsersSvc) DelGroupMember(c *gin.Context) {
+	groupId, err := strconv.ParseUint(c.Query(q.GroupIDParam), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid group ID %w", err)))
+		return
+	}
+	userId, err := strconv.ParseUint(c.Query(q.UserIDParam), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid user ID %w", err)))
+		return
+	}
+
+	err = h.deps.Groups().DelGroupMember(c, groupId, userId)
+	if err != nil {
+		if errors.Is(err, db.ErrGroupMemberNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	c.JSON(q.Resp(200))
+}
+
+type GroupMemberResp struct {
+	UserID string `json:"userID"`
+	User   string `json:"user"`
+	Perm   string `json:"perm"`
+}
+
+type ListGroupMembersResp struct {
+	Members []*GroupMemberResp `json:"members"`
+}
+
+func (h *MultiUsersSvc) ListGroupMembers(c *gin.Context) {
+	groupId, err := strconv.ParseUint(c.Query(q.GroupIDParam), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid group ID %w", err)))
+		return
+	}
+
+	_, err = h.deps.Groups().GetGroup(c, groupId)
+	if err != nil {
+		if errors.Is(err, db.ErrGroupNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	members, err := h.deps.Groups().ListGroupMembers(c, groupId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	resp := &ListGroupMembersResp{Members: []*GroupMemberResp{}}
+	for _, member := range members {
+		user, err := h.deps.Users().GetUser(c, member.UserID)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+		resp.Members = append(resp.Members, &GroupMemberResp{
+			UserID: fmt.Sprint(member.UserID),
+			User:   user.Name,
+			Perm:   member.Perm,
+		})
+	}
+	c.JSON(200, resp)
+}
+
+type MyGroupResp struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Folder is the root folder of files of the group
+	Folder string `json:"folder"`
+	Perm   string `json:"perm"`
+}
+
+type ListMyGroupsResp struct {
+	Groups []*MyGroupResp `json:"groups"`
+}
+
+// ListMyGroups lists groups of the current user, their folders can be accessed by the file APIs.
+func (h *MultiUsersSvc) ListMyGroups(c *gin.Context) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	memberships, err := h.deps.Groups().ListUserGroups(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	resp := &ListMyGroupsResp{Groups: []*MyGroupResp{}}
+	for _, membership := range memberships {
+		group, err := h.deps.Groups().GetGroup(c, membership.GroupID)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+		resp.Groups = append(resp.Groups, &MyGroupResp{
+			ID:     fmt.Sprint(group.ID),
+			Name:   group.Name,
+			Folder: q.FsRootPath(db.GroupLocation(group.Name), "/"),
+			Perm:   membership.Perm,
+		})
+	}
+	c.JSON(200, resp)
+}
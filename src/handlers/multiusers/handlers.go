@@ -1,10 +1,13 @@
 package multiusers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dchest/captcha"
@@ -15,7 +18,9 @@ import (
 
 	"github.com/ihexxa/quickshare/src/db"
 	"github.com/ihexxa/quickshare/src/depidx"
+	"github.com/ihexxa/quickshare/src/golimiter"
 	q "github.com/ihexxa/quickshare/src/handlers"
+	"github.com/ihexxa/quickshare/src/oidc"
 )
 
 var (
@@ -28,13 +33,25 @@ type MultiUsersSvc struct {
 	deps       *depidx.Deps
 	apiACRules map[string]bool
 	routeRules *qradix.RTree
+	// sessions caches checked sessions: session ID -> *cachedSession
+	sessions *sync.Map
+	// roles caches custom roles: role name -> *cachedRole
+	roles *sync.Map
+	// totpLimiter limits attempts of verifying 2FA codes by user IDs
+	totpLimiter *golimiter.Limiter
+	// usedChallenges records nonces of exchanged login challenges until they expire: nonce -> expiry
+	usedChallenges *sync.Map
+	// oidc is nil if single sign-on is disabled
+	oidc *oidc.Provider
+	// authn verifies passwords of logins
+	authn Authenticator
 }
 
 func NewMultiUsersSvc(cfg gocfg.ICfg, deps *depidx.Deps) (*MultiUsersSvc, error) {
 	publicPath := filepath.Join("/", cfg.GrabString("Fs.PublicPath"))
 
 	apiACRules := map[string]bool{
-		// TODO: make these configurable
+		// rules of custom roles are stored in the db
 		// admin rules
 		apiRuleCname(db.AdminRole, "GET", "/"):                              true,
 		apiRuleCname(db.AdminRole, "GET", publicPath):                       true,
@@ -136,6 +153,7 @@ func NewMultiUsersSvc(cfg gocfg.ICfg, deps *depidx.Deps) (*MultiUsersSvc, error)
 	prefixRules := map[string]map[string]bool{
 		"/v2/": {
 			fmt.Sprintf("%s:GET", db.AdminRole):     true,
+			fmt.Sprintf("%s:HEAD", db.AdminRole):    true,
 			fmt.Sprintf("%s:POST", db.AdminRole):    true,
 			fmt.Sprintf("%s:PATCH", db.AdminRole):   true,
 			fmt.Sprintf("%s:PUT", db.AdminRole):     true,
@@ -143,10 +161,13 @@ func NewMultiUsersSvc(cfg gocfg.ICfg, deps *depidx.Deps) (*MultiUsersSvc, error)
 			fmt.Sprintf("%s:OPTIONS", db.AdminRole): true,
 		},
 		"/v2/my/": {
-			fmt.Sprintf("%s:GET", db.UserRole):    true,
-			fmt.Sprintf("%s:POST", db.UserRole):   true,
-			fmt.Sprintf("%s:PATCH", db.UserRole):  true,
-			fmt.Sprintf("%s:DELETE", db.UserRole): true,
+			fmt.Sprintf("%s:GET", db.UserRole):     true,
+			fmt.Sprintf("%s:HEAD", db.UserRole):    true,
+			fmt.Sprintf("%s:POST", db.UserRole):    true,
+			fmt.Sprintf("%s:PATCH", db.UserRole):   true,
+			fmt.Sprintf("%s:PUT", db.UserRole):     true,
+			fmt.Sprintf("%s:DELETE", db.UserRole):  true,
+			fmt.Sprintf("%s:OPTIONS", db.UserRole): true,
 		},
 		"/v2/public/": {
 			fmt.Sprintf("%s:GET", db.UserRole):     true,
@@ -164,11 +185,29 @@ func NewMultiUsersSvc(cfg gocfg.ICfg, deps *depidx.Deps) (*MultiUsersSvc, error)
 		routeRulesTree.Insert(prefix, rules)
 	}
 
+	oidcProvider, err := newOIDCProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	handlers := &MultiUsersSvc{
-		cfg:        cfg,
-		deps:       deps,
-		apiACRules: apiACRules,
-		routeRules: routeRulesTree,
+		cfg:            cfg,
+		deps:           deps,
+		apiACRules:     apiACRules,
+		routeRules:     routeRulesTree,
+		sessions:       &sync.Map{},
+		roles:          &sync.Map{},
+		totpLimiter:    golimiter.New(1024, totpAttemptCyc),
+		usedChallenges: &sync.Map{},
+		oidc:           oidcProvider,
+	}
+
+	handlers.authn = newLocalAuthenticator(deps)
+	if cfg.BoolOr("Users.LDAP.Enabled", false) {
+		handlers.authn, err = newLDAPAuthenticator(cfg, handlers, handlers.authn)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return handlers, nil
@@ -181,6 +220,17 @@ type LoginReq struct {
 	CaptchaInput string `json:"captchaInput"`
 }
 
+type LoginResp struct {
+	// TOTPRequired is true if 2FA is enabled or forced, the login is completed by LoginTOTP with the Challenge
+	TOTPRequired bool   `json:"totpRequired"`
+	Challenge    string `json:"challenge,omitempty"`
+	// TOTPURI is the provisioning URI of a new secret if 2FA is forced but not enrolled,
+	// the enrollment is completed by LoginTOTP with a code of it.
+	TOTPURI string `json:"totpUri,omitempty"`
+	// RecoveryCodes are returned once if 2FA is enrolled in the login
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
 func (h *MultiUsersSvc) Login(c *gin.Context) {
 	req := &LoginReq{}
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -197,9 +247,11 @@ func (h *MultiUsersSvc) Login(c *gin.Context) {
 		}
 	}
 
-	user, err := h.deps.Users().GetUserByName(c, req.User)
+	user, err := h.authn.Authenticate(c, req.User, req.Pwd)
 	if err != nil {
-		if errors.Is(err, db.ErrUserNotFound) {
+		if errors.Is(err, db.ErrUserNotFound) ||
+			errors.Is(err, ErrInvalidCredentials) ||
+			errors.Is(err, ErrLocalUserExisting) {
 			c.JSON(q.ErrResp(c, 403, err))
 			return
 		}
@@ -207,39 +259,74 @@ func (h *MultiUsersSvc) Login(c *gin.Context) {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Pwd), []byte(req.Pwd))
+	userTOTP, err := h.deps.Users().GetUserTOTP(c, user.ID)
 	if err != nil {
-		c.JSON(q.ErrResp(c, 403, err))
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if userTOTP.Enabled || userTOTP.Forced {
+		h.totpChallenge(c, user, userTOTP)
+		return
+	}
+
+	err = h.startSession(c, user)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
+	c.JSON(200, &LoginResp{})
+}
 
+// startSession creates a session for the user and sets the token cookie
+func (h *MultiUsersSvc) startSession(c *gin.Context, user *db.User) error {
 	ttl := h.cfg.GrabInt("Users.CookieTTL")
+	expireAt := time.Now().Unix() + int64(ttl)
+	sessionId, err := h.addSession(c, user.ID, time.Unix(expireAt, 0))
+	if err != nil {
+		return err
+	}
+
 	token, err := h.deps.Token().ToToken(map[string]string{
-		q.UserIDParam: fmt.Sprint(user.ID),
-		q.UserParam:   user.Name,
-		q.RoleParam:   user.Role,
-		q.ExpireParam: fmt.Sprintf("%d", time.Now().Unix()+int64(ttl)),
+		q.UserIDParam:    fmt.Sprint(user.ID),
+		q.UserParam:      user.Name,
+		q.RoleParam:      user.Role,
+		q.ExpireParam:    fmt.Sprintf("%d", expireAt),
+		q.SessionIDParam: fmt.Sprint(sessionId),
 	})
 	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
-		return
+		return err
 	}
 
 	secure := h.cfg.GrabBool("Users.CookieSecure")
 	httpOnly := h.cfg.GrabBool("Users.CookieHttpOnly")
 	c.SetCookie(q.TokenCookie, token, ttl, "/", "", secure, httpOnly)
-
-	c.JSON(q.Resp(200))
+	return nil
 }
 
 type LogoutReq struct{}
 
 func (h *MultiUsersSvc) Logout(c *gin.Context) {
 	// token alreay verified in the authn middleware
+	if sessionId := getSessionId(c); sessionId != 0 {
+		userId, err := q.GetUserId(c)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+		err = h.revokeSession(c, userId, sessionId)
+		if err != nil && !errors.Is(err, db.ErrSessionNotFound) {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+	}
+
+	h.clearTokenCookie(c)
+	c.JSON(q.Resp(200))
+}
+
+func (h *MultiUsersSvc) clearTokenCookie(c *gin.Context) {
 	secure := h.cfg.GrabBool("Users.CookieSecure")
 	httpOnly := h.cfg.GrabBool("Users.CookieHttpOnly")
 	c.SetCookie(q.TokenCookie, "", 0, "/", "", secure, httpOnly)
-	c.JSON(q.Resp(200))
 }
 
 func (h *MultiUsersSvc) IsAuthed(c *gin.Context) {
@@ -298,6 +385,13 @@ func (h *MultiUsersSvc) SetPwd(c *gin.Context) {
 		return
 	}
 
+	// other sessions are revoked, the current session is kept
+	err = h.revokeSessions(c, uid, getSessionId(c))
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
 	c.JSON(q.Resp(200))
 }
 
@@ -340,6 +434,12 @@ func (h *MultiUsersSvc) ForceSetPwd(c *gin.Context) {
 		return
 	}
 
+	err = h.revokeSessions(c, targetUser.ID, 0)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
 	c.JSON(q.Resp(200))
 }
 
@@ -368,34 +468,45 @@ func (h *MultiUsersSvc) AddUser(c *gin.Context) {
 	} else if err = h.isValidPwd(req.Pwd); err != nil {
 		c.JSON(q.ErrResp(c, 400, err))
 		return
+	} else if err = h.isKnownRole(c, req.Role); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
 	}
 
-	uid := h.deps.ID().Gen()
-	pwdHash, err := bcrypt.GenerateFromPassword([]byte(req.Pwd), 10)
+	uid, err := h.addUser(c, req.Name, req.Pwd, req.Role, &db.DefaultPreferences)
 	if err != nil {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
 
+	c.JSON(200, &AddUserResp{ID: fmt.Sprint(uid)})
+}
+
+// addUser creates the user with its folders and the default quota
+func (h *MultiUsersSvc) addUser(ctx context.Context, name, pwd, role string, preferences *db.Preferences) (uint64, error) {
+	uid := h.deps.ID().Gen()
+	pwdHash, err := bcrypt.GenerateFromPassword([]byte(pwd), 10)
+	if err != nil {
+		return 0, err
+	}
+
 	// TODO: following operations must be atomic
 	// TODO: check if the folders already exists
-	fsRootFolder := q.FsRootPath(req.Name, "/")
+	fsRootFolder := q.FsRootPath(name, "/")
 	if err = h.deps.FS().MkdirAll(fsRootFolder); err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
-		return
+		return 0, err
 	}
-	uploadFolder := q.UploadFolder(req.Name)
+	uploadFolder := q.UploadFolder(name)
 	if err = h.deps.FS().MkdirAll(uploadFolder); err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
-		return
+		return 0, err
 	}
 
-	newPreferences := db.DefaultPreferences
-	err = h.deps.Users().AddUser(c, &db.User{
+	newPreferences := *preferences
+	err = h.deps.Users().AddUser(ctx, &db.User{
 		ID:   uid,
-		Name: req.Name,
+		Name: name,
 		Pwd:  string(pwdHash),
-		Role: req.Role,
+		Role: role,
 		Quota: &db.Quota{
 			SpaceLimit:         int64(h.cfg.IntOr("Users.SpaceLimit", 100*1024*1024)), // TODO: support int64
 			UploadSpeedLimit:   h.cfg.IntOr("Users.UploadSpeedLimit", 100*1024),
@@ -404,11 +515,9 @@ func (h *MultiUsersSvc) AddUser(c *gin.Context) {
 		Preferences: &newPreferences,
 	})
 	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
-		return
+		return 0, err
 	}
-
-	c.JSON(200, &AddUserResp{ID: fmt.Sprint(uid)})
+	return uid, nil
 }
 
 type DelUserResp struct {
@@ -442,6 +551,8 @@ func (h *MultiUsersSvc) DelUser(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
+	// sessions are deleted with the user
+	h.uncacheSessions(userID, 0)
 
 	// TODO: move the folder to recycle bin when it failed to remove it
 	homePath := userIDStr
@@ -476,90 +587,14 @@ func (h *MultiUsersSvc) ListUsers(c *gin.Context) {
 	c.JSON(200, &ListUsersResp{Users: users})
 }
 
-type AddRoleReq struct {
-	Role string `json:"role"`
-}
-
-func (h *MultiUsersSvc) AddRole(c *gin.Context) {
-	var err error
-	req := &AddRoleReq{}
-	if err = c.ShouldBindJSON(&req); err != nil {
-		c.JSON(q.ErrResp(c, 400, err))
-		return
-	}
-
-	if err = h.isValidRole(req.Role); err != nil {
-		c.JSON(q.ErrResp(c, 400, err))
-		return
-	}
-
-	err = h.deps.Users().AddRole(req.Role)
-	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
-		return
-	}
-
-	c.JSON(q.Resp(200))
-}
-
-type DelRoleReq struct {
-	Role string `json:"role"`
-}
-
-func (h *MultiUsersSvc) DelRole(c *gin.Context) {
-	var err error
-	req := &DelRoleReq{}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(q.ErrResp(c, 400, err))
-		return
-	}
-
-	if err = h.isValidRole(req.Role); err != nil {
-		c.JSON(q.ErrResp(c, 400, err))
-		return
-	}
-
-	err = h.deps.Users().DelRole(req.Role)
-	if err != nil {
-		c.JSON(q.ErrResp(c, 500, err))
-		return
-	}
-
-	c.JSON(q.Resp(200))
-}
-
-type ListRolesReq struct{}
-type ListRolesResp struct {
-	Roles map[string]bool `json:"roles"`
-}
-
-func (h *MultiUsersSvc) ListRoles(c *gin.Context) {
-	// TODO: currently roles are hardcoded
-	c.JSON(200, &ListRolesResp{
-		Roles: map[string]bool{
-			db.AdminRole:   true,
-			db.UserRole:    true,
-			db.VisitorRole: true,
-		}})
-}
-
+// getUserInfo returns claims verified by the AuthN middleware,
+// they are from either the token cookie or the API token.
 func (h *MultiUsersSvc) getUserInfo(c *gin.Context) (map[string]string, error) {
-	tokenStr, err := c.Cookie(q.TokenCookie)
-	if err != nil {
-		return nil, err
+	claims := map[string]string{}
+	for _, key := range []string{q.UserIDParam, q.UserParam, q.RoleParam, q.ExpireParam} {
+		claims[key] = c.GetString(key)
 	}
-	claims, err := h.deps.Token().FromToken(
-		tokenStr,
-		map[string]string{
-			q.UserIDParam: "",
-			q.UserParam:   "",
-			q.RoleParam:   "",
-			q.ExpireParam: "",
-		},
-	)
-	if err != nil {
-		return nil, err
-	} else if claims[q.UserIDParam] == "" || claims[q.UserParam] == "" {
+	if claims[q.UserIDParam] == "" || claims[q.UserParam] == "" {
 		return nil, ErrInvalidConfig
 	}
 
@@ -570,6 +605,8 @@ func (h *MultiUsersSvc) isValidUserName(userName string) error {
 	minUserNameLen := h.cfg.GrabInt("Users.MinUserNameLen")
 	if len(userName) < minUserNameLen {
 		return errors.New("name is too short")
+	} else if strings.HasPrefix(userName, db.GroupLocationPrefix) {
+		return fmt.Errorf("name can not start with %s", db.GroupLocationPrefix)
 	}
 	return nil
 }
@@ -582,13 +619,6 @@ func (h *MultiUsersSvc) isValidPwd(pwd string) error {
 	return nil
 }
 
-func (h *MultiUsersSvc) isValidRole(role string) error {
-	if role == db.AdminRole || role == db.UserRole || role == db.VisitorRole {
-		return errors.New("predefined roles can not be added/deleted")
-	}
-	return h.isValidUserName(role)
-}
-
 type SelfResp struct {
 	ID          string          `json:"id"`
 	Name        string          `json:"name"`
@@ -639,6 +669,10 @@ func (h *MultiUsersSvc) SetUser(c *gin.Context) {
 		c.JSON(q.ErrResp(c, 500, err))
 		return
 	}
+	if err := h.isKnownRole(c, req.Role); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
 
 	err := h.deps.Users().SetInfo(c, req.ID, &db.User{
 		Role:  req.Role,
@@ -649,6 +683,14 @@ func (h *MultiUsersSvc) SetUser(c *gin.Context) {
 		return
 	}
 
+	if req.Role == db.BannedRole {
+		err = h.revokeSessions(c, req.ID, 0)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+	}
+
 	c.JSON(q.Resp(200))
 }
 
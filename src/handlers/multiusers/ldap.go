@@ -0,0 +1,214 @@
+package multiusers
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ihexxa/gocfg"
+
+	"github.com/ihexxa/quickshare/src/db"
+	"github.com/ihexxa/quickshare/src/ldap"
+)
+
+var (
+	ErrLDAPUnavailable  = errors.New("ldap server is unavailable")
+	ErrLDAPUserNotFound = errors.New("user is not found in the directory")
+)
+
+// ldapAuthenticator searches the user entry by the login name and binds it with the password,
+// users are provisioned on their first logins and linked to their DNs.
+type ldapAuthenticator struct {
+	h   *MultiUsersSvc
+	url string
+	// fallback authenticates the login if it is not authenticated by the directory,
+	// it is nil if falling back to local users is disabled.
+	fallback   Authenticator
+	tlsCfg     *tls.Config
+	timeout    time.Duration
+	userFilter string
+	// groupRoles maps lower cased group DNs to roles
+	groupRoles map[string]string
+}
+
+func newLDAPAuthenticator(cfg gocfg.ICfg, h *MultiUsersSvc, local Authenticator) (*ldapAuthenticator, error) {
+	url := cfg.StringOr("Users.LDAP.URL", "")
+	userFilter := cfg.StringOr("Users.LDAP.UserFilter", "")
+	if url == "" || cfg.StringOr("Users.LDAP.BaseDN", "") == "" {
+		return nil, errors.New("ldap: url and baseDN must be set")
+	} else if !strings.Contains(userFilter, "%s") {
+		return nil, errors.New("ldap: userFilter must contain %s")
+	} else if _, err := ldap.CompileFilter(strings.ReplaceAll(userFilter, "%s", "name")); err != nil {
+		return nil, fmt.Errorf("ldap: invalid userFilter: %w", err)
+	}
+
+	groupRoles := map[string]string{}
+	if cfgGroupRoles, ok := cfg.MapOr("Users.LDAP.GroupRoles", map[string]string{}).(map[string]string); ok {
+		for group, role := range cfgGroupRoles {
+			groupRoles[strings.ToLower(group)] = role
+		}
+	}
+
+	var fallback Authenticator
+	if cfg.BoolOr("Users.LDAP.FallbackToLocal", true) {
+		fallback = local
+	}
+	return &ldapAuthenticator{
+		h:        h,
+		url:      url,
+		fallback: fallback,
+		tlsCfg: &tls.Config{
+			InsecureSkipVerify: cfg.BoolOr("Users.LDAP.InsecureSkipVerify", false),
+		},
+		timeout:    time.Duration(cfg.IntOr("Users.LDAP.Timeout", 5000)) * time.Millisecond,
+		userFilter: userFilter,
+		groupRoles: groupRoles,
+	}, nil
+}
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, name, pwd string) (*db.User, error) {
+	entry, err := a.bind(ctx, name, pwd)
+	if err != nil {
+		if a.fallback != nil &&
+			(errors.Is(err, ErrLDAPUserNotFound) ||
+				errors.Is(err, ErrLDAPUnavailable) ||
+				errors.Is(err, ErrInvalidCredentials)) {
+			// users provisioned from the directory have random local passwords
+			return a.fallback.Authenticate(ctx, name, pwd)
+		} else if errors.Is(err, ErrLDAPUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	user, err := a.syncUser(ctx, name, entry)
+	if errors.Is(err, ErrLocalUserExisting) && a.fallback != nil {
+		// the local user is not taken over by the directory user with the same name
+		return a.fallback.Authenticate(ctx, name, pwd)
+	}
+	return user, err
+}
+
+// bind returns the user entry if the password is correct
+func (a *ldapAuthenticator) bind(ctx context.Context, name, pwd string) (*ldap.Entry, error) {
+	if pwd == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := ldap.Dial(ctx, a.url, a.timeout, a.tlsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrLDAPUnavailable, err)
+	}
+	defer conn.Close()
+
+	cfg := a.h.cfg
+	if bindDN := cfg.StringOr("Users.LDAP.BindDN", ""); bindDN != "" {
+		err = conn.Bind(bindDN, cfg.StringOr("Users.LDAP.BindPwd", ""))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrLDAPUnavailable, err)
+		}
+	}
+
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN: cfg.StringOr("Users.LDAP.BaseDN", ""),
+		Scope:  ldap.ScopeWholeSubtree,
+		Filter: strings.ReplaceAll(a.userFilter, "%s", ldap.EscapeFilter(name)),
+		Attributes: []string{
+			cfg.StringOr("Users.LDAP.UserNameAttr", "uid"),
+			cfg.StringOr("Users.LDAP.DisplayNameAttr", "cn"),
+			cfg.StringOr("Users.LDAP.EmailAttr", "mail"),
+			cfg.StringOr("Users.LDAP.GroupAttr", "memberOf"),
+		},
+		SizeLimit: 2,
+	})
+	if err != nil {
+		var resultErr *ldap.ResultError
+		if errors.As(err, &resultErr) && resultErr.Code == ldap.ResultSizeLimitExceeded {
+			return nil, fmt.Errorf("more than one entry is found for %s", name)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrLDAPUnavailable, err)
+	} else if len(entries) == 0 {
+		return nil, ErrLDAPUserNotFound
+	} else if len(entries) > 1 {
+		return nil, fmt.Errorf("more than one entry is found for %s", name)
+	}
+
+	entry := entries[0]
+	err = conn.Bind(entry.DN, pwd)
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("%w: %s", ErrLDAPUnavailable, err)
+	}
+	return entry, nil
+}
+
+// syncUser returns the user linked to the entry, the user is created if it is not linked,
+// and its name, email and role are synced from the entry.
+func (a *ldapAuthenticator) syncUser(ctx context.Context, name string, entry *ldap.Entry) (*db.User, error) {
+	cfg, users := a.h.cfg, a.h.deps.Users()
+	displayName := entry.Value(cfg.StringOr("Users.LDAP.DisplayNameAttr", "cn"))
+	email := entry.Value(cfg.StringOr("Users.LDAP.EmailAttr", "mail"))
+
+	groups := []string{}
+	for _, group := range entry.Values(cfg.StringOr("Users.LDAP.GroupAttr", "memberOf")) {
+		groups = append(groups, strings.ToLower(group))
+	}
+	role := groupsRole(groups, a.groupRoles)
+
+	var user *db.User
+	subject := strings.ToLower(entry.DN)
+	userId, err := users.GetIdentityUser(ctx, a.url, subject)
+	if err == nil {
+		user, err = users.GetUser(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, db.ErrIdentityNotFound) {
+		if entryName := entry.Value(cfg.StringOr("Users.LDAP.UserNameAttr", "uid")); entryName != "" {
+			name = entryName
+		}
+		if role == "" {
+			role = cfg.StringOr("Users.LDAP.DefaultRole", db.UserRole)
+		}
+
+		preferences := db.DefaultPreferences
+		preferences.Name = displayName
+		preferences.Email = email
+		user, err = a.h.provisionUser(ctx, name, role, &preferences)
+		if err != nil {
+			return nil, err
+		}
+		err = users.AddUserIdentity(ctx, a.url, subject, user.ID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	if user.Preferences == nil {
+		preferences := db.DefaultPreferences
+		user.Preferences = &preferences
+	}
+	if user.Preferences.Name != displayName || user.Preferences.Email != email {
+		preferences := *user.Preferences
+		preferences.Name = displayName
+		preferences.Email = email
+		err = users.SetPreferences(ctx, user.ID, &preferences)
+		if err != nil {
+			return nil, err
+		}
+		user.Preferences = &preferences
+	}
+
+	err = a.h.syncRole(ctx, user, a.groupRoles, role, cfg.StringOr("Users.LDAP.DefaultRole", db.UserRole))
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
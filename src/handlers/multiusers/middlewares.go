@@ -23,16 +23,18 @@ func apiRuleCname(role, method, path string) string {
 func (h *MultiUsersSvc) AuthN() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		enableAuth := h.cfg.GrabBool("Users.EnableAuth")
-		claims := map[string]string{
-			q.UserIDParam: "",
-			q.UserParam:   "",
-			q.RoleParam:   db.VisitorRole,
-			q.ExpireParam: "",
-		}
+		claims := visitorClaims()
 
 		if enableAuth {
 			token, err := c.Cookie(q.TokenCookie)
-			if err != nil {
+			if apiToken := bearerToken(c); apiToken != "" {
+				// API tokens take precedence over cookies
+				claims, err = h.apiTokenClaims(c, apiToken)
+				if err != nil {
+					c.AbortWithStatusJSON(q.ErrResp(c, 401, err))
+					return
+				}
+			} else if err != nil {
 				if err != http.ErrNoCookie {
 					c.AbortWithStatusJSON(q.ErrResp(c, 401, err))
 					return
@@ -54,13 +56,42 @@ func (h *MultiUsersSvc) AuthN() gin.HandlerFunc {
 					c.AbortWithStatusJSON(q.ErrResp(c, 401, ErrExpired))
 					return
 				}
+
+				userId, err := strconv.ParseUint(claims[q.UserIDParam], 10, 64)
+				if err != nil {
+					c.AbortWithStatusJSON(q.ErrResp(c, 401, err))
+					return
+				}
+				sessionId, err := strconv.ParseUint(claims[q.SessionIDParam], 10, 64)
+				if err != nil {
+					err = ErrSessionRevoked
+				} else {
+					err = h.checkSession(c, userId, sessionId)
+				}
+				if err != nil {
+					if !errors.Is(err, ErrSessionRevoked) {
+						c.AbortWithStatusJSON(q.ErrResp(c, 500, err))
+						return
+					}
+					// the client continues as a visitor so that it is still able to log in again
+					h.clearTokenCookie(c)
+					claims = visitorClaims()
+				}
 			}
 			// set default values if token is empty
+
+			shareID, err := h.getUnlockedSharing(c)
+			if err != nil {
+				c.AbortWithStatusJSON(q.ErrResp(c, 401, err))
+				return
+			}
+			claims[q.ShareIDParam] = shareID
 		} else {
 			claims[q.UserIDParam] = "0"
 			claims[q.UserParam] = "admin"
 			claims[q.RoleParam] = db.AdminRole
 			claims[q.ExpireParam] = ""
+			claims[q.SessionIDParam] = ""
 		}
 
 		for key, val := range claims {
@@ -70,6 +101,46 @@ func (h *MultiUsersSvc) AuthN() gin.HandlerFunc {
 	}
 }
 
+func visitorClaims() map[string]string {
+	return map[string]string{
+		q.UserIDParam:    "",
+		q.UserParam:      "",
+		q.RoleParam:      db.VisitorRole,
+		q.ExpireParam:    "",
+		q.SessionIDParam: "",
+	}
+}
+
+// getUnlockedSharing returns the ID of the protected sharing unlocked by the share token,
+// it is empty if no sharing is unlocked.
+func (h *MultiUsersSvc) getUnlockedSharing(c *gin.Context) (string, error) {
+	token, err := c.Cookie(q.ShareTokenCookie)
+	if err != nil {
+		if err == http.ErrNoCookie {
+			return "", nil
+		}
+		return "", err
+	} else if token == "" {
+		return "", nil
+	}
+
+	claims, err := h.deps.Token().FromToken(token, map[string]string{
+		q.ShareIDParam: "",
+		q.ExpireParam:  "",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	expire, err := strconv.ParseInt(claims[q.ExpireParam], 10, 64)
+	if err != nil {
+		return "", err
+	} else if expire <= time.Now().Unix() {
+		return "", ErrExpired
+	}
+	return claims[q.ShareIDParam], nil
+}
+
 func (h *MultiUsersSvc) APIAccessControl() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role := c.MustGet(q.RoleParam).(string)
@@ -79,6 +150,28 @@ func (h *MultiUsersSvc) APIAccessControl() gin.HandlerFunc {
 		if role == db.BannedRole {
 			c.AbortWithStatusJSON(q.ErrResp(c, 403, q.ErrAccessDenied))
 		}
+		if scope, ok := c.Get(q.APIScopeParam); ok && scope == db.APIScopeReadOnly && !isReadMethod(method) {
+			c.AbortWithStatusJSON(q.ErrResp(c, 403, ErrReadOnlyAPIToken))
+			return
+		}
+
+		// custom roles are only allowed by their rules and the default rules
+		if !db.IsPredefinedRole(role) {
+			customRole, err := h.getCustomRole(c, role)
+			if err != nil {
+				c.AbortWithStatusJSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+				return
+			}
+			c.Set(q.CustomRoleParam, customRole)
+			if matchRoleRules(customRole.Rules, method, accessPath) ||
+				matchRoleRules(customRoleDefaultRules, method, accessPath) ||
+				isStaticPath(accessPath) {
+				c.Next()
+				return
+			}
+			c.AbortWithStatusJSON(q.ErrResp(c, 403, q.ErrAccessDenied))
+			return
+		}
 
 		// v2 ac control
 		matches := h.routeRules.GetAllPrefixMatches(accessPath)
@@ -93,7 +186,14 @@ func (h *MultiUsersSvc) APIAccessControl() gin.HandlerFunc {
 		}
 
 		// TODO: listDir and download are exceptions: for sharing
-		if accessPath == "/v2/my/fs/dirs" {
+		if accessPath == "/v2/my/fs/dirs" ||
+			(accessPath == "/v2/my/fs/dirs/archive" && method == "GET") {
+			matched = true
+		}
+		// visitors upload files into upload-only sharings, targets are checked in handlers
+		if role == db.VisitorRole &&
+			((accessPath == "/v2/my/fs/files" && method == "POST") ||
+				(accessPath == "/v2/my/fs/files/chunks" && method == "PATCH")) {
 			matched = true
 		}
 
@@ -105,15 +205,20 @@ func (h *MultiUsersSvc) APIAccessControl() gin.HandlerFunc {
 		if h.apiACRules[apiRuleCname(role, method, accessPath)] {
 			c.Next()
 			return
-		} else if accessPath == "/" || // TODO: temporarily allow accessing static resources
-			accessPath == "/favicon.ico" ||
-			strings.HasPrefix(accessPath, "/css") ||
-			strings.HasPrefix(accessPath, "/font") ||
-			strings.HasPrefix(accessPath, "/img") ||
-			strings.HasPrefix(accessPath, "/js") {
+		} else if isStaticPath(accessPath) {
 			c.Next()
 			return
 		}
 		c.AbortWithStatusJSON(q.ErrResp(c, 403, q.ErrAccessDenied))
 	}
 }
+
+// isStaticPath returns true if the path is of static resources
+func isStaticPath(accessPath string) bool {
+	return accessPath == "/" || // TODO: temporarily allow accessing static resources
+		accessPath == "/favicon.ico" ||
+		strings.HasPrefix(accessPath, "/css") ||
+		strings.HasPrefix(accessPath, "/font") ||
+		strings.HasPrefix(accessPath, "/img") ||
+		strings.HasPrefix(accessPath, "/js")
+}
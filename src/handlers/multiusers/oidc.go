@@ -0,0 +1,290 @@
+package multiusers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihexxa/gocfg"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+	"github.com/ihexxa/quickshare/src/oidc"
+)
+
+const (
+	// OIDCCookie keeps the state, the nonce and the PKCE verifier between the login and the callback
+	OIDCCookie     = "oidc"
+	oidcCookiePath = "/v2/public/oidc"
+	oidcStateTTL   = 10 * 60
+	// claims of the state cookie
+	oidcStateClaim    = "state"
+	oidcNonceClaim    = "nonce"
+	oidcVerifierClaim = "verifier"
+)
+
+var (
+	ErrOIDCDisabled     = errors.New("single sign-on is not enabled")
+	ErrInvalidOIDCState = errors.New("invalid or expired single sign-on state")
+	ErrOIDCUserNotFound = errors.New("no user is linked to the identity")
+	ErrIdentityLinked   = errors.New("the identity is linked to a user")
+)
+
+// newOIDCProvider returns nil if single sign-on is disabled
+func newOIDCProvider(cfg gocfg.ICfg) (*oidc.Provider, error) {
+	if !cfg.BoolOr("Users.OIDC.Enabled", false) {
+		return nil, nil
+	}
+
+	oidcCfg := &oidc.Config{
+		Issuer:       cfg.StringOr("Users.OIDC.Issuer", ""),
+		ClientID:     cfg.StringOr("Users.OIDC.ClientID", ""),
+		ClientSecret: cfg.StringOr("Users.OIDC.ClientSecret", ""),
+		RedirectURL:  cfg.StringOr("Users.OIDC.RedirectURL", ""),
+		Scopes:       []string{"openid"},
+	}
+	if oidcCfg.Issuer == "" || oidcCfg.ClientID == "" || oidcCfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, clientID and redirectURL must be set")
+	}
+	if scopes, ok := cfg.Slice("Users.OIDC.Scopes"); ok {
+		if scopeList, ok := scopes.([]string); ok && len(scopeList) > 0 {
+			oidcCfg.Scopes = scopeList
+		}
+	}
+	return oidc.NewProvider(oidcCfg, nil), nil
+}
+
+// OIDCLogin redirects to the identity provider
+func (h *MultiUsersSvc) OIDCLogin(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(q.ErrResp(c, 404, ErrOIDCDisabled))
+		return
+	}
+
+	vals := []string{}
+	for i := 0; i < 3; i++ {
+		val, err := oidc.GenState()
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+		vals = append(vals, val)
+	}
+	state, nonce, verifier := vals[0], vals[1], vals[2]
+
+	authURL, err := h.oidc.AuthCodeURL(c, state, nonce, verifier)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 502, err))
+		return
+	}
+	stateToken, err := h.deps.Token().ToToken(map[string]string{
+		oidcStateClaim:    state,
+		oidcNonceClaim:    nonce,
+		oidcVerifierClaim: verifier,
+		q.ExpireParam:     fmt.Sprintf("%d", time.Now().Unix()+oidcStateTTL),
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	secure := h.cfg.GrabBool("Users.CookieSecure")
+	c.SetCookie(OIDCCookie, stateToken, oidcStateTTL, oidcCookiePath, "", secure, true)
+	c.Redirect(302, authURL)
+}
+
+// OIDCCallback verifies the authorization response, logs the linked user in and redirects to the home page.
+func (h *MultiUsersSvc) OIDCCallback(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(q.ErrResp(c, 404, ErrOIDCDisabled))
+		return
+	}
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(q.ErrResp(c, 403, fmt.Errorf("single sign-on failed: %s %s", errCode, c.Query("error_description"))))
+		return
+	}
+
+	stateToken, err := c.Cookie(OIDCCookie)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, ErrInvalidOIDCState))
+		return
+	}
+	claims, err := h.deps.Token().FromToken(stateToken, map[string]string{
+		oidcStateClaim:    "",
+		oidcNonceClaim:    "",
+		oidcVerifierClaim: "",
+		q.ExpireParam:     "",
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, ErrInvalidOIDCState))
+		return
+	}
+	expire, err := strconv.ParseInt(claims[q.ExpireParam], 10, 64)
+	if err != nil || expire <= time.Now().Unix() || claims[oidcStateClaim] != c.Query("state") {
+		c.JSON(q.ErrResp(c, 400, ErrInvalidOIDCState))
+		return
+	}
+	secure := h.cfg.GrabBool("Users.CookieSecure")
+	c.SetCookie(OIDCCookie, "", -1, oidcCookiePath, "", secure, true)
+
+	rawIDToken, err := h.oidc.Exchange(c, c.Query("code"), claims[oidcVerifierClaim])
+	if err != nil {
+		c.JSON(q.ErrResp(c, 403, err))
+		return
+	}
+	idToken, err := h.oidc.VerifyIDToken(c, rawIDToken, claims[oidcNonceClaim])
+	if err != nil {
+		c.JSON(q.ErrResp(c, 403, err))
+		return
+	}
+
+	user, err := h.oidcUser(c, idToken)
+	if err != nil {
+		if errors.Is(err, ErrOIDCUserNotFound) || errors.Is(err, ErrLocalUserExisting) {
+			c.JSON(q.ErrResp(c, 403, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	// 2FA is required as the password login, the challenge is responded instead of redirecting
+	userTOTP, err := h.deps.Users().GetUserTOTP(c, user.ID)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if userTOTP.Enabled || userTOTP.Forced {
+		h.totpChallenge(c, user, userTOTP)
+		return
+	}
+
+	err = h.startSession(c, user)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.Redirect(302, "/")
+}
+
+// oidcGroupRoles returns the mapping from groups to roles, it is empty if roles are not mapped
+func (h *MultiUsersSvc) oidcGroupRoles() map[string]string {
+	groupRoles, ok := h.cfg.MapOr("Users.OIDC.GroupRoles", map[string]string{}).(map[string]string)
+	if !ok {
+		return map[string]string{}
+	}
+	return groupRoles
+}
+
+// oidcUser returns the user linked to the subject, the subject is linked by admins or by provisioning on its first login.
+// Roles are updated by group claims in each login.
+func (h *MultiUsersSvc) oidcUser(ctx context.Context, idToken *oidc.IDToken) (*db.User, error) {
+	issuer := h.cfg.StringOr("Users.OIDC.Issuer", "")
+	groupRoles := h.oidcGroupRoles()
+	role := groupsRole(idToken.Strings(h.cfg.StringOr("Users.OIDC.GroupsClaim", "groups")), groupRoles)
+	defaultRole := h.cfg.StringOr("Users.OIDC.DefaultRole", db.UserRole)
+
+	var user *db.User
+	userId, err := h.deps.Users().GetIdentityUser(ctx, issuer, idToken.Subject)
+	if err == nil {
+		user, err = h.deps.Users().GetUser(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+	} else if errors.Is(err, db.ErrIdentityNotFound) {
+		if role == "" {
+			role = defaultRole
+		}
+		user, err = h.provisionOIDCUser(ctx, issuer, idToken, role)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	err = h.syncRole(ctx, user, groupRoles, role, defaultRole)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionOIDCUser creates the user named by the email, the preferred user name or the subject,
+// and links the subject to it. Existing users are never linked by names or emails as they can be edited by users,
+// they are linked by admins with LinkOIDCIdentity.
+func (h *MultiUsersSvc) provisionOIDCUser(ctx context.Context, issuer string, idToken *oidc.IDToken, role string) (*db.User, error) {
+	if !h.cfg.BoolOr("Users.OIDC.AutoProvision", false) {
+		return nil, ErrOIDCUserNotFound
+	}
+
+	name := idToken.Email
+	if name == "" {
+		name = idToken.PreferredUsername
+	}
+	if name == "" {
+		name = idToken.Subject
+	}
+
+	preferences := db.DefaultPreferences
+	preferences.Email = idToken.Email
+	user, err := h.provisionUser(ctx, name, role, &preferences)
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.deps.Users().AddUserIdentity(ctx, issuer, idToken.Subject, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+type LinkOIDCIdentityReq struct {
+	ID uint64 `json:"id,string"`
+	// Subject is the "sub" claim of the identity in the configured issuer
+	Subject string `json:"subject"`
+}
+
+// LinkOIDCIdentity links the identity of the single sign-on to the existing user.
+func (h *MultiUsersSvc) LinkOIDCIdentity(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(q.ErrResp(c, 404, ErrOIDCDisabled))
+		return
+	}
+	req := &LinkOIDCIdentityReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	} else if req.Subject == "" {
+		c.JSON(q.ErrResp(c, 400, errors.New("empty subject")))
+		return
+	} else if req.ID == db.VisitorID {
+		c.JSON(q.ErrResp(c, 400, errors.New("visitors can not be linked")))
+		return
+	}
+
+	_, err := h.deps.Users().GetUser(c, req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+
+	issuer := h.cfg.StringOr("Users.OIDC.Issuer", "")
+	err = h.deps.Users().AddUserIdentity(c, issuer, req.Subject, req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrConflicted) {
+			c.JSON(q.ErrResp(c, 400, ErrIdentityLinked))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	c.JSON(q.Resp(200))
+}
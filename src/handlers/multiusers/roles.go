@@ -0,0 +1,292 @@
+package multiusers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+// roleCacheTTL is the interval of re-checking cached custom roles in the db,
+// roles changed by this process are removed from the cache immediately.
+const roleCacheTTL = time.Minute
+
+var (
+	ErrRoleInUse = errors.New("role is used by users")
+
+	ruleMethods = map[string]bool{
+		"GET":     true,
+		"HEAD":    true,
+		"POST":    true,
+		"PATCH":   true,
+		"PUT":     true,
+		"DELETE":  true,
+		"OPTIONS": true,
+		"*":       true,
+	}
+
+	// customRoleDefaultRules are allowed for all custom roles besides their own rules,
+	// so that their users are able to load the client, log in and log out.
+	customRoleDefaultRules = []*db.APIRule{
+		{Method: "GET", Path: "/v2/public", Prefix: true},
+		{Method: "POST", Path: "/v2/public", Prefix: true},
+		{Method: "OPTIONS", Path: "/v2/public", Prefix: true},
+		{Method: "GET", Path: "/v2/my/isauthed"},
+		{Method: "POST", Path: "/v2/my/logout"},
+	}
+)
+
+type cachedRole struct {
+	// role is nil if the role does not exist
+	role      *db.Role
+	checkedAt time.Time
+}
+
+// getCustomRole returns the custom role from the cache or the db.
+func (h *MultiUsersSvc) getCustomRole(ctx context.Context, name string) (*db.Role, error) {
+	now := time.Now()
+	if val, ok := h.roles.Load(name); ok {
+		cached := val.(*cachedRole)
+		if now.Sub(cached.checkedAt) < roleCacheTTL {
+			if cached.role == nil {
+				return nil, db.ErrRoleNotFound
+			}
+			return cached.role, nil
+		}
+	}
+
+	role, err := h.deps.Users().GetRole(ctx, name)
+	if err != nil && !errors.Is(err, db.ErrRoleNotFound) {
+		return nil, err
+	}
+	h.roles.Store(name, &cachedRole{role: role, checkedAt: now})
+	return role, err
+}
+
+// matchRoleRules returns true if the request is allowed by the rules
+func matchRoleRules(rules []*db.APIRule, method, accessPath string) bool {
+	for _, rule := range rules {
+		if rule.Method != "*" && rule.Method != method {
+			continue
+		}
+		// prefixes match whole path segments only, "/v2/my/session" does not match "/v2/my/sessions"
+		if accessPath == rule.Path ||
+			(rule.Prefix && strings.HasPrefix(accessPath, strings.TrimSuffix(rule.Path, "/")+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownRole returns an error if the role is neither predefined nor added
+func (h *MultiUsersSvc) isKnownRole(ctx context.Context, role string) error {
+	if db.IsPredefinedRole(role) {
+		return nil
+	}
+	_, err := h.getCustomRole(ctx, role)
+	if errors.Is(err, db.ErrRoleNotFound) {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+	return err
+}
+
+func (h *MultiUsersSvc) isValidRole(role string) error {
+	if db.IsPredefinedRole(role) {
+		return errors.New("predefined roles can not be added/deleted")
+	}
+	return h.isValidUserName(role)
+}
+
+// isCleanAbsPath returns true if p is an absolute path without "//", "." or "..",
+// a trailing "/" is allowed for prefix rules.
+func isCleanAbsPath(p string) bool {
+	if p != "/" {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return path.IsAbs(p) && path.Clean(p) == p
+}
+
+func isValidRoleRules(rules []*db.APIRule, capabilities []string) error {
+	for _, rule := range rules {
+		if rule == nil {
+			return errors.New("empty rule")
+		} else if !ruleMethods[rule.Method] {
+			return fmt.Errorf("invalid method: %s", rule.Method)
+		} else if !isCleanAbsPath(rule.Path) {
+			return fmt.Errorf("invalid path: %s", rule.Path)
+		}
+	}
+	for _, capability := range capabilities {
+		if !db.Capabilities[capability] {
+			return fmt.Errorf("invalid capability: %s", capability)
+		}
+	}
+	return nil
+}
+
+type AddRoleReq struct {
+	Role         string        `json:"role"`
+	Rules        []*db.APIRule `json:"rules"`
+	Capabilities []string      `json:"capabilities"`
+}
+
+func (h *MultiUsersSvc) AddRole(c *gin.Context) {
+	var err error
+	req := &AddRoleReq{}
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	if err = h.isValidRole(req.Role); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	} else if err = isValidRoleRules(req.Rules, req.Capabilities); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	err = h.deps.Users().AddRole(c, newRole(req.Role, req.Rules, req.Capabilities))
+	if err != nil {
+		if errors.Is(err, db.ErrConflicted) {
+			c.JSON(q.ErrResp(c, 400, fmt.Errorf("role is existing: %s", req.Role)))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	h.roles.Delete(req.Role)
+
+	c.JSON(q.Resp(200))
+}
+
+type SetRoleReq struct {
+	Role         string        `json:"role"`
+	Rules        []*db.APIRule `json:"rules"`
+	Capabilities []string      `json:"capabilities"`
+}
+
+// SetRole replaces rules and capabilities of the custom role
+func (h *MultiUsersSvc) SetRole(c *gin.Context) {
+	var err error
+	req := &SetRoleReq{}
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	if err = h.isValidRole(req.Role); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	} else if err = isValidRoleRules(req.Rules, req.Capabilities); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	err = h.deps.Users().SetRole(c, newRole(req.Role, req.Rules, req.Capabilities))
+	if err != nil {
+		if errors.Is(err, db.ErrRoleNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	h.roles.Delete(req.Role)
+
+	c.JSON(q.Resp(200))
+}
+
+func newRole(name string, rules []*db.APIRule, capabilities []string) *db.Role {
+	role := &db.Role{
+		Name:         name,
+		Rules:        rules,
+		Capabilities: capabilities,
+	}
+	if role.Rules == nil {
+		role.Rules = []*db.APIRule{}
+	}
+	if role.Capabilities == nil {
+		role.Capabilities = []string{}
+	}
+	return role
+}
+
+type DelRoleReq struct {
+	Role string `json:"role"`
+}
+
+// DelRole deletes the custom role, roles of users must be changed before deleting it.
+func (h *MultiUsersSvc) DelRole(c *gin.Context) {
+	var err error
+	req := &DelRoleReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	if err = h.isValidRole(req.Role); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	users, err := h.deps.Users().ListUsers(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	for _, user := range users {
+		if user.Role == req.Role {
+			c.JSON(q.ErrResp(c, 400, ErrRoleInUse))
+			return
+		}
+	}
+
+	err = h.deps.Users().DelRole(c, req.Role)
+	if err != nil {
+		if errors.Is(err, db.ErrRoleNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	h.roles.Delete(req.Role)
+
+	c.JSON(q.Resp(200))
+}
+
+type ListRolesReq struct{}
+type ListRolesResp struct {
+	// Roles contains names of both predefined roles and custom roles
+	Roles       map[string]bool `json:"roles"`
+	CustomRoles []*db.Role      `json:"customRoles"`
+}
+
+func (h *MultiUsersSvc) ListRoles(c *gin.Context) {
+	customRoles, err := h.deps.Users().ListRoles(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	roles := map[string]bool{
+		db.AdminRole:   true,
+		db.UserRole:    true,
+		db.VisitorRole: true,
+	}
+	for _, role := range customRoles {
+		roles[role.Name] = true
+	}
+	c.JSON(200, &ListRolesResp{
+		Roles:       roles,
+		CustomRoles: customRoles,
+	})
+}
@@ -0,0 +1,209 @@
+package multiusers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	// queries
+	SessionIDQuery = "sessid"
+
+	// sessionCacheTTL is the interval of re-checking cached sessions in the db,
+	// sessions revoked by this process are removed from the cache immediately.
+	sessionCacheTTL = time.Minute
+	maxUserAgentLen = 256
+)
+
+var ErrSessionRevoked = errors.New("session is revoked or expired")
+
+type cachedSession struct {
+	userID    uint64
+	expireAt  time.Time
+	checkedAt time.Time
+}
+
+// addSession creates a session for the login and returns its ID for the token.
+func (h *MultiUsersSvc) addSession(c *gin.Context, userId uint64, expireAt time.Time) (uint64, error) {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+
+	now := time.Now()
+	session := &db.Session{
+		ID:        h.deps.ID().Gen(),
+		UserID:    userId,
+		IP:        c.ClientIP(),
+		UserAgent: userAgent,
+		CreatedAt: now,
+		ExpireAt:  expireAt,
+	}
+	err := h.deps.Sessions().AddSession(c, session)
+	if err != nil {
+		return 0, err
+	}
+
+	// logins are not frequent, clean up expired sessions in the cache here
+	h.sessions.Range(func(key, val interface{}) bool {
+		if val.(*cachedSession).expireAt.Before(now) {
+			h.sessions.Delete(key)
+		}
+		return true
+	})
+	h.sessions.Store(session.ID, &cachedSession{
+		userID:    userId,
+		expireAt:  expireAt,
+		checkedAt: now,
+	})
+	return session.ID, nil
+}
+
+// checkSession returns ErrSessionRevoked if the session of the token does not exist any more.
+func (h *MultiUsersSvc) checkSession(ctx context.Context, userId, sessionId uint64) error {
+	now := time.Now()
+	if val, ok := h.sessions.Load(sessionId); ok {
+		cached := val.(*cachedSession)
+		if cached.userID != userId || now.After(cached.expireAt) {
+			return ErrSessionRevoked
+		} else if now.Sub(cached.checkedAt) < sessionCacheTTL {
+			return nil
+		}
+	}
+
+	session, err := h.deps.Sessions().GetSession(ctx, sessionId)
+	if err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			h.sessions.Delete(sessionId)
+			return ErrSessionRevoked
+		}
+		return err
+	} else if session.UserID != userId {
+		return ErrSessionRevoked
+	}
+
+	h.sessions.Store(sessionId, &cachedSession{
+		userID:    session.UserID,
+		expireAt:  session.ExpireAt,
+		checkedAt: now,
+	})
+	return nil
+}
+
+// revokeSession revokes one session of the user
+func (h *MultiUsersSvc) revokeSession(ctx context.Context, userId, sessionId uint64) error {
+	err := h.deps.Sessions().DelSession(ctx, userId, sessionId)
+	if err != nil {
+		return err
+	}
+	h.sessions.Delete(sessionId)
+	return nil
+}
+
+// revokeSessions revokes all sessions of the user except the session exceptId, exceptId is 0 if none is kept.
+func (h *MultiUsersSvc) revokeSessions(ctx context.Context, userId, exceptId uint64) error {
+	err := h.deps.Sessions().DelSessions(ctx, userId, exceptId)
+	if err != nil {
+		return err
+	}
+	h.uncacheSessions(userId, exceptId)
+	return nil
+}
+
+func (h *MultiUsersSvc) uncacheSessions(userId, exceptId uint64) {
+	h.sessions.Range(func(key, val interface{}) bool {
+		if val.(*cachedSession).userID == userId && key.(uint64) != exceptId {
+			h.sessions.Delete(key)
+		}
+		return true
+	})
+}
+
+// getSessionId returns the session ID of the request, it is 0 if the request is not authenticated by a cookie token.
+func getSessionId(c *gin.Context) uint64 {
+	sessionIdStr, ok := c.Value(q.SessionIDParam).(string)
+	if !ok || sessionIdStr == "" {
+		return 0
+	}
+	sessionId, err := strconv.ParseUint(sessionIdStr, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return sessionId
+}
+
+type ListSessionsResp struct {
+	Sessions []*db.Session `json:"sessions"`
+	// Current is the ID of the session of this request, it is 0 if the request is authenticated by an API token
+	Current uint64 `json:"current,string"`
+}
+
+// ListSessions lists active sessions of the current user
+func (h *MultiUsersSvc) ListSessions(c *gin.Context) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	sessions, err := h.deps.Sessions().ListSessions(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &ListSessionsResp{Sessions: sessions, Current: getSessionId(c)})
+}
+
+// DelSession revokes a session of the current user, the token of the session becomes invalid immediately.
+func (h *MultiUsersSvc) DelSession(c *gin.Context) {
+	sessionId, err := strconv.ParseUint(c.Query(SessionIDQuery), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid session ID: %w", err)))
+		return
+	}
+
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	err = h.revokeSession(c, userId, sessionId)
+	if err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	if sessionId == getSessionId(c) {
+		h.clearTokenCookie(c)
+	}
+	c.JSON(q.Resp(200))
+}
+
+// DelSessions revokes all sessions of the current user, including the current one, which logs the user out everywhere.
+func (h *MultiUsersSvc) DelSessions(c *gin.Context) {
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	err = h.revokeSessions(c, userId, 0)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	h.clearTokenCookie(c)
+	c.JSON(q.Resp(200))
+}
@@ -0,0 +1,537 @@
+package multiusers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ihexxa/quickshare/src/cryptoutil/totp"
+	"github.com/ihexxa/quickshare/src/db"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+const (
+	totpIssuer = "Quickshare"
+	// totpSkew accepts codes of adjacent steps for clock drift
+	totpSkew          = 1
+	totpChallengeTTL  = 5 * 60
+	totpChallengeName = "totp"
+	// totpNonceName makes the challenge single-use
+	totpNonceName     = "nonce"
+	totpNonceBytes    = 16
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 5
+	// totpAttempts limits code verifications of each user in totpAttemptCyc
+	totpAttempts   = 10
+	totpAttemptCyc = 5 * 60 * 1000
+)
+
+var (
+	ErrInvalidTOTPCode  = errors.New("invalid totp code")
+	ErrInvalidChallenge = errors.New("invalid or expired login challenge")
+	ErrTOTPEnabled      = errors.New("2fa is already enabled")
+	ErrTOTPNotEnabled   = errors.New("2fa is not enabled")
+	ErrTOTPNotSetUp     = errors.New("2fa is not set up")
+	ErrTOTPForced       = errors.New("2fa is forced by admins")
+	ErrTooManyAttempts  = errors.New("too many attempts, try again later")
+)
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// genRecoveryCodes returns recovery codes for users and their hashes for the db
+func genRecoveryCodes() ([]string, []string, error) {
+	codes, hashes := []string{}, []string{}
+	buf := make([]byte, recoveryCodeBytes)
+	for i := 0; i < recoveryCodeCount; i++ {
+		_, err := rand.Read(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(buf)
+		code = fmt.Sprintf("%s-%s", code[:len(code)/2], code[len(code)/2:])
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// verifyTOTP verifies the code and records its step, so that the code can not be replayed.
+func (h *MultiUsersSvc) verifyTOTP(ctx context.Context, userTOTP *db.UserTOTP, code string) error {
+	if !h.totpLimiter.Access(fmt.Sprint(userTOTP.UserID), totpAttempts, 1) {
+		return ErrTooManyAttempts
+	}
+
+	step, ok := totp.Verify(userTOTP.Secret, strings.TrimSpace(code), time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+	err := h.deps.Users().UseTOTPStep(ctx, userTOTP.UserID, step)
+	if err != nil {
+		if errors.Is(err, db.ErrTOTPCodeUsed) {
+			return ErrInvalidTOTPCode
+		}
+		return err
+	}
+	userTOTP.LastStep = step
+	return nil
+}
+
+// verifySecondFactor verifies the totp code, or consumes the recovery code if it is provided.
+func (h *MultiUsersSvc) verifySecondFactor(ctx context.Context, userTOTP *db.UserTOTP, req *TOTPCodeReq) error {
+	if req.RecoveryCode == "" {
+		return h.verifyTOTP(ctx, userTOTP, req.Code)
+	}
+
+	if !h.totpLimiter.Access(fmt.Sprint(userTOTP.UserID), totpAttempts, 1) {
+		return ErrTooManyAttempts
+	}
+	err := h.deps.Users().UseRecoveryCode(ctx, userTOTP.UserID, hashRecoveryCode(req.RecoveryCode))
+	if err != nil {
+		if errors.Is(err, db.ErrRecoveryCodeNotFound) {
+			return ErrInvalidTOTPCode
+		}
+		return err
+	}
+	return nil
+}
+
+func secondFactorErrResp(c *gin.Context, err error) (int, interface{}) {
+	if errors.Is(err, ErrInvalidTOTPCode) {
+		return q.ErrResp(c, 403, err)
+	} else if errors.Is(err, ErrTooManyAttempts) {
+		return q.ErrResp(c, 429, err)
+	}
+	return q.ErrResp(c, 500, err)
+}
+
+// useChallenge consumes the nonce of the challenge, it returns false if the challenge is exchanged already.
+func (h *MultiUsersSvc) useChallenge(nonce string, expire int64) bool {
+	now := time.Now().Unix()
+	h.usedChallenges.Range(func(key, val any) bool {
+		if val.(int64) <= now {
+			h.usedChallenges.Delete(key)
+		}
+		return true
+	})
+
+	_, used := h.usedChallenges.LoadOrStore(nonce, expire)
+	return !used
+}
+
+// totpChallenge responds a single-use challenge which is exchanged for the token cookie by LoginTOTP.
+// If 2FA is forced but not enrolled, a secret is set up and enrolled by LoginTOTP,
+// the pending secret is reused so that it is not replaced by other logins before enrolling.
+func (h *MultiUsersSvc) totpChallenge(c *gin.Context, user *db.User, userTOTP *db.UserTOTP) {
+	resp := &LoginResp{TOTPRequired: true}
+	if !userTOTP.Enabled {
+		if userTOTP.Secret == "" {
+			secret, err := totp.GenSecret()
+			if err != nil {
+				c.JSON(q.ErrResp(c, 500, err))
+				return
+			}
+			userTOTP.Secret = secret
+			err = h.deps.Users().SetUserTOTP(c, userTOTP)
+			if err != nil {
+				c.JSON(q.ErrResp(c, 500, err))
+				return
+			}
+		}
+		resp.TOTPURI = totp.ProvisioningURI(totpIssuer, user.Name, userTOTP.Secret)
+	}
+
+	nonce := make([]byte, totpNonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	challenge, err := h.deps.Token().ToToken(map[string]string{
+		q.UserIDParam:     fmt.Sprint(user.ID),
+		q.ExpireParam:     fmt.Sprintf("%d", time.Now().Unix()+totpChallengeTTL),
+		totpChallengeName: "1",
+		totpNonceName:     hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	resp.Challenge = challenge
+	c.JSON(200, resp)
+}
+
+type LoginTOTPReq struct {
+	Challenge string `json:"challenge"`
+	TOTPCodeReq
+}
+
+// LoginTOTP completes the login with the challenge of Login and a totp code or a recovery code.
+func (h *MultiUsersSvc) LoginTOTP(c *gin.Context) {
+	req := &LoginTOTPReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+
+	claims, err := h.deps.Token().FromToken(req.Challenge, map[string]string{
+		q.UserIDParam:     "",
+		q.ExpireParam:     "",
+		totpChallengeName: "",
+		totpNonceName:     "",
+	})
+	if err != nil || claims[totpNonceName] == "" {
+		c.JSON(q.ErrResp(c, 401, ErrInvalidChallenge))
+		return
+	}
+	expire, err := strconv.ParseInt(claims[q.ExpireParam], 10, 64)
+	if err != nil || expire <= time.Now().Unix() {
+		c.JSON(q.ErrResp(c, 401, ErrInvalidChallenge))
+		return
+	}
+	userId, err := strconv.ParseUint(claims[q.UserIDParam], 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 401, ErrInvalidChallenge))
+		return
+	}
+	// used challenges are rejected before consuming codes
+	if _, used := h.usedChallenges.Load(claims[totpNonceName]); used {
+		c.JSON(q.ErrResp(c, 401, ErrInvalidChallenge))
+		return
+	}
+
+	user, err := h.deps.Users().GetUser(c, userId)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			c.JSON(q.ErrResp(c, 401, ErrInvalidChallenge))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	userTOTP, err := h.deps.Users().GetUserTOTP(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	} else if userTOTP.Secret == "" {
+		// 2FA is reset after the challenge is issued
+		c.JSON(q.ErrResp(c, 401, ErrInvalidChallenge))
+		return
+	}
+
+	if userTOTP.Enabled {
+		err = h.verifySecondFactor(c, userTOTP, &req.TOTPCodeReq)
+	} else {
+		err = h.verifyTOTP(c, userTOTP, req.Code)
+	}
+	if err != nil {
+		c.JSON(secondFactorErrResp(c, err))
+		return
+	}
+	// the challenge is consumed after verifying so that mistyped codes can be retried
+	if !h.useChallenge(claims[totpNonceName], expire) {
+		c.JSON(q.ErrResp(c, 401, ErrInvalidChallenge))
+		return
+	}
+
+	resp := &LoginResp{}
+	if !userTOTP.Enabled {
+		// enroll the forced 2FA
+		resp.RecoveryCodes, err = h.enableTOTP(c, userTOTP)
+		if err != nil {
+			c.JSON(q.ErrResp(c, 500, err))
+			return
+		}
+	}
+
+	err = h.startSession(c, user)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// enableTOTP enables the verified secret and returns new recovery codes
+func (h *MultiUsersSvc) enableTOTP(ctx context.Context, userTOTP *db.UserTOTP) ([]string, error) {
+	codes, hashes, err := genRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	userTOTP.Enabled = true
+	userTOTP.RecoveryCodes = hashes
+	err = h.deps.Users().SetUserTOTP(ctx, userTOTP)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// resetTOTP disables 2FA, the forced flag is kept
+func (h *MultiUsersSvc) resetTOTP(ctx context.Context, userTOTP *db.UserTOTP) error {
+	userTOTP.Secret = ""
+	userTOTP.Enabled = false
+	userTOTP.RecoveryCodes = []string{}
+	return h.deps.Users().SetUserTOTP(ctx, userTOTP)
+}
+
+// getSelfTOTP returns the 2FA state of the current user, 2FA can not be managed by API tokens.
+func (h *MultiUsersSvc) getSelfTOTP(c *gin.Context) (*db.UserTOTP, bool) {
+	if authedByAPIToken(c) {
+		c.JSON(q.ErrResp(c, 403, ErrAPITokenAuth))
+		return nil, false
+	}
+	userId, err := q.GetUserId(c)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return nil, false
+	}
+	userTOTP, err := h.deps.Users().GetUserTOTP(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return nil, false
+	}
+	return userTOTP, true
+}
+
+type TOTPCodeReq struct {
+	Code string `json:"code"`
+	// RecoveryCode is used instead of the code if it is not empty
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type TOTPStatusResp struct {
+	Enabled bool `json:"enabled"`
+	Forced  bool `json:"forced"`
+	// RecoveryCodes is the number of unused recovery codes
+	RecoveryCodes int `json:"recoveryCodes"`
+}
+
+func (h *MultiUsersSvc) GetTOTP(c *gin.Context) {
+	userTOTP, ok := h.getSelfTOTP(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, &TOTPStatusResp{
+		Enabled:       userTOTP.Enabled,
+		Forced:        userTOTP.Forced,
+		RecoveryCodes: len(userTOTP.RecoveryCodes),
+	})
+}
+
+type SetupTOTPResp struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth URI for QR codes
+	URI string `json:"uri"`
+}
+
+// SetupTOTP generates a new secret, 2FA is enabled after a code of it is verified by EnableTOTP.
+func (h *MultiUsersSvc) SetupTOTP(c *gin.Context) {
+	userTOTP, ok := h.getSelfTOTP(c)
+	if !ok {
+		return
+	} else if userTOTP.Enabled {
+		c.JSON(q.ErrResp(c, 400, ErrTOTPEnabled))
+		return
+	}
+
+	secret, err := totp.GenSecret()
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	userTOTP.Secret = secret
+	err = h.deps.Users().SetUserTOTP(c, userTOTP)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	userName := c.MustGet(q.UserParam).(string)
+	c.JSON(200, &SetupTOTPResp{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(totpIssuer, userName, secret),
+	})
+}
+
+type RecoveryCodesResp struct {
+	// RecoveryCodes are only returned once, each of them can replace a code once
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// EnableTOTP verifies a code of the secret from SetupTOTP and enables 2FA.
+func (h *MultiUsersSvc) EnableTOTP(c *gin.Context) {
+	req := &TOTPCodeReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	userTOTP, ok := h.getSelfTOTP(c)
+	if !ok {
+		return
+	} else if userTOTP.Enabled {
+		c.JSON(q.ErrResp(c, 400, ErrTOTPEnabled))
+		return
+	} else if userTOTP.Secret == "" {
+		c.JSON(q.ErrResp(c, 400, ErrTOTPNotSetUp))
+		return
+	}
+
+	err := h.verifyTOTP(c, userTOTP, req.Code)
+	if err != nil {
+		c.JSON(secondFactorErrResp(c, err))
+		return
+	}
+	codes, err := h.enableTOTP(c, userTOTP)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &RecoveryCodesResp{RecoveryCodes: codes})
+}
+
+// DisableTOTP disables 2FA with a code or a recovery code, forced 2FA can not be disabled.
+func (h *MultiUsersSvc) DisableTOTP(c *gin.Context) {
+	req := &TOTPCodeReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	userTOTP, ok := h.getSelfTOTP(c)
+	if !ok {
+		return
+	} else if userTOTP.Forced {
+		c.JSON(q.ErrResp(c, 403, ErrTOTPForced))
+		return
+	} else if !userTOTP.Enabled {
+		c.JSON(q.ErrResp(c, 400, ErrTOTPNotEnabled))
+		return
+	}
+
+	err := h.verifySecondFactor(c, userTOTP, req)
+	if err != nil {
+		c.JSON(secondFactorErrResp(c, err))
+		return
+	}
+	// reload it as the recovery code may be consumed
+	userTOTP, ok = h.getSelfTOTP(c)
+	if !ok {
+		return
+	}
+	err = h.resetTOTP(c, userTOTP)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
+
+// RegenRecoveryCodes replaces all recovery codes after verifying a code.
+func (h *MultiUsersSvc) RegenRecoveryCodes(c *gin.Context) {
+	req := &TOTPCodeReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	}
+	userTOTP, ok := h.getSelfTOTP(c)
+	if !ok {
+		return
+	} else if !userTOTP.Enabled {
+		c.JSON(q.ErrResp(c, 400, ErrTOTPNotEnabled))
+		return
+	}
+
+	err := h.verifyTOTP(c, userTOTP, req.Code)
+	if err != nil {
+		c.JSON(secondFactorErrResp(c, err))
+		return
+	}
+	codes, err := h.enableTOTP(c, userTOTP)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(200, &RecoveryCodesResp{RecoveryCodes: codes})
+}
+
+type SetUserTOTPReq struct {
+	ID uint64 `json:"id,string"`
+	// Forced requires the user to enroll 2FA at the next login
+	Forced bool `json:"forced"`
+}
+
+// SetUserTOTP forces 2FA for the user or stops forcing it.
+func (h *MultiUsersSvc) SetUserTOTP(c *gin.Context) {
+	req := &SetUserTOTPReq{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(q.ErrResp(c, 400, err))
+		return
+	} else if req.ID == db.VisitorID {
+		c.JSON(q.ErrResp(c, 400, errors.New("visitors can not enroll 2fa")))
+		return
+	}
+
+	_, err := h.deps.Users().GetUser(c, req.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	userTOTP, err := h.deps.Users().GetUserTOTP(c, req.ID)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+
+	userTOTP.Forced = req.Forced
+	err = h.deps.Users().SetUserTOTP(c, userTOTP)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
+
+// ResetUserTOTP disables 2FA of the user, e.g., the device is lost.
+// If 2FA is forced, the user enrolls it again at the next login.
+func (h *MultiUsersSvc) ResetUserTOTP(c *gin.Context) {
+	userId, err := strconv.ParseUint(c.Query(q.UserIDParam), 10, 64)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 400, fmt.Errorf("invalid users ID %w", err)))
+		return
+	}
+
+	_, err = h.deps.Users().GetUser(c, userId)
+	if err != nil {
+		if errors.Is(err, db.ErrUserNotFound) {
+			c.JSON(q.ErrResp(c, 404, err))
+		} else {
+			c.JSON(q.ErrResp(c, 500, err))
+		}
+		return
+	}
+	userTOTP, err := h.deps.Users().GetUserTOTP(c, userId)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	err = h.resetTOTP(c, userTOTP)
+	if err != nil {
+		c.JSON(q.ErrResp(c, 500, err))
+		return
+	}
+	c.JSON(q.Resp(200))
+}
@@ -7,6 +7,7 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ihexxa/quickshare/src/cryptoutil"
@@ -15,9 +16,12 @@ import (
 
 var (
 	// dirs
-	UploadDir = "uploadings"
-	FsDir     = "files"
-	FsRootDir = "files"
+	UploadDir     = "uploadings"
+	FsDir         = "files"
+	FsRootDir     = "files"
+	TrashDir      = ".trash"
+	RevisionDir   = ".revisions"
+	QuarantineDir = ".quarantine"
 
 	UserIDParam    = "uid"
 	UserParam      = "user"
@@ -28,6 +32,19 @@ var (
 	CaptchaIDParam = "capid"
 	TokenCookie    = "tk"
 	LastID         = "lid"
+	// ShareIDParam is the ID of the protected sharing unlocked by the share token
+	ShareIDParam     = "shid"
+	ShareTokenCookie = "stk"
+	// APITokenIDParam is the ID of the API token which authenticates the request, it is empty for other requests
+	APITokenIDParam = "atid"
+	// APIScopeParam is the scope of the API token which authenticates the request
+	APIScopeParam = "ascope"
+	// SessionIDParam is the ID of the login session of the token cookie
+	SessionIDParam = "sid"
+	// CustomRoleParam is the *db.Role of the request if its role is a custom role
+	CustomRoleParam = "crole"
+	// GroupIDParam is the ID of the group in group APIs
+	GroupIDParam = "gid"
 
 	// DownloadChunkSize can not be greater than limiter's token count
 	// downloadSpeedLimit can not be lower than DownloadChunkSize
@@ -145,6 +162,40 @@ func UploadFolder(userName string) string {
 	return path.Join(userName, UploadDir)
 }
 
+func TrashPath(userName string, trashID uint64) string {
+	return path.Join(TrashFolder(userName), fmt.Sprint(trashID))
+}
+
+func TrashFolder(userName string) string {
+	return path.Join(userName, TrashDir)
+}
+
+func RevisionPath(location string, revisionID uint64) string {
+	return path.Join(RevisionFolder(location), fmt.Sprint(revisionID))
+}
+
+func RevisionFolder(location string) string {
+	return path.Join(location, RevisionDir)
+}
+
+func QuarantinePath(location string, fileID uint64) string {
+	return path.Join(QuarantineFolder(location), fmt.Sprint(fileID))
+}
+
+func QuarantineFolder(location string) string {
+	return path.Join(location, QuarantineDir)
+}
+
+// IsReservedPath returns true if the item is in folders managed by the server, e.g. trashes and revisions,
+// they are only accessed by their own APIs.
+func IsReservedPath(itemPath string) bool {
+	parts := strings.Split(itemPath, "/")
+	if len(parts) < 2 {
+		return false
+	}
+	return parts[1] == TrashDir || parts[1] == RevisionDir || parts[1] == QuarantineDir
+}
+
 func GetUserInfo(tokenStr string, tokenEncDec cryptoutil.ITokenEncDec) (map[string]string, error) {
 	claims, err := tokenEncDec.FromToken(
 		tokenStr,
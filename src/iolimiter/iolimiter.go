@@ -2,6 +2,7 @@ package iolimiter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 
@@ -21,30 +22,48 @@ type IOLimiter struct {
 	UploadLimiter   *golimiter.Limiter
 	DownloadLimiter *golimiter.Limiter
 	users           db.IUserDB
+	groups          db.IGroupDB
 	quotaCache      map[uint64]*db.Quota
 }
 
-func NewIOLimiter(cap, cyc int, users db.IUserDB) *IOLimiter {
+func NewIOLimiter(cap, cyc int, users db.IUserDB, groups db.IGroupDB) *IOLimiter {
 	return &IOLimiter{
 		mtx:             &sync.Mutex{},
 		UploadLimiter:   golimiter.New(cap, cyc),
 		DownloadLimiter: golimiter.New(cap, cyc),
 		users:           users,
+		groups:          groups,
 		quotaCache:      map[uint64]*db.Quota{},
 	}
 }
 
+// getQuota returns the quota of the user or the group with the ID
+func (lm *IOLimiter) getQuota(id uint64) (*db.Quota, error) {
+	user, err := lm.users.GetUser(context.TODO(), id) // TODO: add context
+	if err == nil {
+		return user.Quota, nil
+	} else if !errors.Is(err, db.ErrUserNotFound) {
+		return nil, err
+	}
+
+	group, err := lm.groups.GetGroup(context.TODO(), id)
+	if err != nil {
+		return nil, err
+	}
+	return group.Quota, nil
+}
+
 func (lm *IOLimiter) CanWrite(id uint64, chunkSize int) (bool, error) {
 	lm.mtx.Lock()
 	defer lm.mtx.Unlock()
 
 	quota, ok := lm.quotaCache[id]
 	if !ok {
-		user, err := lm.users.GetUser(context.TODO(), id) // TODO: add context
+		var err error
+		quota, err = lm.getQuota(id)
 		if err != nil {
 			return false, err
 		}
-		quota = user.Quota
 		lm.quotaCache[id] = quota
 	}
 	if len(lm.quotaCache) > cacheSizeLimit {
@@ -64,11 +83,11 @@ func (lm *IOLimiter) CanRead(id uint64, chunkSize int) (bool, error) {
 
 	quota, ok := lm.quotaCache[id]
 	if !ok {
-		user, err := lm.users.GetUser(context.TODO(), id) // TODO: add context
+		var err error
+		quota, err = lm.getQuota(id)
 		if err != nil {
 			return false, err
 		}
-		quota = user.Quota
 		lm.quotaCache[id] = quota
 	}
 	if len(lm.quotaCache) > cacheSizeLimit {
@@ -0,0 +1,229 @@
+package ldap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER classes of identifiers
+const (
+	ClassUniversal   = 0x00
+	ClassApplication = 0x40
+	ClassContext     = 0x80
+)
+
+// universal tags used by LDAP
+const (
+	TagBoolean     = 1
+	TagInteger     = 2
+	TagOctetString = 4
+	TagNull        = 5
+	TagEnumerated  = 10
+	TagSequence    = 16
+	TagSet         = 17
+)
+
+// maxPacketSize limits packets read from connections
+const maxPacketSize = 4 * 1024 * 1024
+
+var ErrMalformedPacket = errors.New("malformed ber packet")
+
+// Packet is a BER element, only definite lengths and tags lower than 31 are supported which are enough for LDAP.
+type Packet struct {
+	Class       byte
+	Constructed bool
+	Tag         int
+	// Value is the content of primitive elements
+	Value    []byte
+	Children []*Packet
+}
+
+func NewPacket(class byte, constructed bool, tag int, value []byte) *Packet {
+	return &Packet{
+		Class:       class,
+		Constructed: constructed,
+		Tag:         tag,
+		Value:       value,
+		Children:    []*Packet{},
+	}
+}
+
+func NewSequence(children ...*Packet) *Packet {
+	return NewConstructed(ClassUniversal, TagSequence, children...)
+}
+
+func NewConstructed(class byte, tag int, children ...*Packet) *Packet {
+	packet := NewPacket(class, true, tag, nil)
+	packet.Children = append(packet.Children, children...)
+	return packet
+}
+
+func NewString(class byte, tag int, val string) *Packet {
+	return NewPacket(class, false, tag, []byte(val))
+}
+
+func NewOctetString(val string) *Packet {
+	return NewString(ClassUniversal, TagOctetString, val)
+}
+
+func NewInteger(class byte, tag int, val int64) *Packet {
+	// minimal two's complement encoding
+	buf := []byte{}
+	for {
+		buf = append([]byte{byte(val)}, buf...)
+		if (val < 128 && val >= -128) || len(buf) == 8 {
+			break
+		}
+		val >>= 8
+	}
+	return NewPacket(class, false, tag, buf)
+}
+
+func NewBoolean(val bool) *Packet {
+	if val {
+		return NewPacket(ClassUniversal, false, TagBoolean, []byte{0xff})
+	}
+	return NewPacket(ClassUniversal, false, TagBoolean, []byte{0x00})
+}
+
+func NewEnumerated(val int64) *Packet {
+	return NewInteger(ClassUniversal, TagEnumerated, val)
+}
+
+// Is checks the class and the tag of the packet
+func (p *Packet) Is(class byte, tag int) bool {
+	return p.Class == class && p.Tag == tag
+}
+
+func (p *Packet) Int() (int64, error) {
+	if p.Constructed || len(p.Value) == 0 || len(p.Value) > 8 {
+		return 0, ErrMalformedPacket
+	}
+	val := int64(int8(p.Value[0]))
+	for _, b := range p.Value[1:] {
+		val = val<<8 | int64(b)
+	}
+	return val, nil
+}
+
+func (p *Packet) Bool() (bool, error) {
+	if p.Constructed || len(p.Value) != 1 {
+		return false, ErrMalformedPacket
+	}
+	return p.Value[0] != 0, nil
+}
+
+func (p *Packet) String() string {
+	return string(p.Value)
+}
+
+// Bytes encodes the packet
+func (p *Packet) Bytes() []byte {
+	content := p.Value
+	if p.Constructed {
+		buf := &bytes.Buffer{}
+		for _, child := range p.Children {
+			buf.Write(child.Bytes())
+		}
+		content = buf.Bytes()
+	}
+
+	identifier := p.Class | byte(p.Tag&0x1f)
+	if p.Constructed {
+		identifier |= 0x20
+	}
+	encoded := []byte{identifier}
+	encoded = append(encoded, encodeLength(len(content))...)
+	return append(encoded, content...)
+}
+
+func encodeLength(length int) []byte {
+	if length < 128 {
+		return []byte{byte(length)}
+	}
+	buf := []byte{}
+	for ; length > 0; length >>= 8 {
+		buf = append([]byte{byte(length)}, buf...)
+	}
+	return append([]byte{0x80 | byte(len(buf))}, buf...)
+}
+
+// ReadPacket reads one packet from the reader
+func ReadPacket(reader io.Reader) (*Packet, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	lengthBytes := []byte{}
+	if header[1]&0x80 != 0 {
+		lengthSize := int(header[1] & 0x7f)
+		if lengthSize == 0 || lengthSize > 4 {
+			return nil, ErrMalformedPacket
+		}
+		lengthBytes = make([]byte, lengthSize)
+		if _, err := io.ReadFull(reader, lengthBytes); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("packet is too large: %d", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, err
+	}
+	buf := append(append(header, lengthBytes...), content...)
+	packet, _, err := ParsePacket(buf)
+	return packet, err
+}
+
+// ParsePacket decodes the first packet of the buffer and returns its encoded size
+func ParsePacket(buf []byte) (*Packet, int, error) {
+	if len(buf) < 2 {
+		return nil, 0, ErrMalformedPacket
+	}
+	if buf[0]&0x1f == 0x1f {
+		return nil, 0, fmt.Errorf("%w: high tag numbers are not supported", ErrMalformedPacket)
+	}
+	packet := NewPacket(buf[0]&0xc0, buf[0]&0x20 != 0, int(buf[0]&0x1f), nil)
+
+	offset, length := 2, int(buf[1])
+	if buf[1]&0x80 != 0 {
+		lengthSize := int(buf[1] & 0x7f)
+		if lengthSize == 0 || lengthSize > 4 || len(buf) < 2+lengthSize {
+			return nil, 0, ErrMalformedPacket
+		}
+		length = 0
+		for _, b := range buf[2 : 2+lengthSize] {
+			length = length<<8 | int(b)
+		}
+		offset += lengthSize
+	}
+	if length < 0 || len(buf)-offset < length {
+		return nil, 0, ErrMalformedPacket
+	}
+	content := buf[offset : offset+length]
+
+	if !packet.Constructed {
+		packet.Value = append([]byte{}, content...)
+		return packet, offset + length, nil
+	}
+	for len(content) > 0 {
+		child, size, err := ParsePacket(content)
+		if err != nil {
+			return nil, 0, err
+		}
+		packet.Children = append(packet.Children, child)
+		content = content[size:]
+	}
+	return packet, offset + length, nil
+}
@@ -0,0 +1,163 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// context tags of filters
+const (
+	FilterAnd        = 0
+	FilterOr         = 1
+	FilterNot        = 2
+	FilterEquality   = 3
+	FilterSubstrings = 4
+	FilterPresent    = 7
+)
+
+// context tags of substrings
+const (
+	SubstringInitial = 0
+	SubstringAny     = 1
+	SubstringFinal   = 2
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// EscapeFilter escapes special characters of values in filters (RFC 4515)
+func EscapeFilter(val string) string {
+	builder := &strings.Builder{}
+	for i := 0; i < len(val); i++ {
+		switch c := val[i]; c {
+		case '\\', '*', '(', ')', 0:
+			builder.WriteString(fmt.Sprintf("\\%02x", c))
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+func unescapeFilter(val string) (string, error) {
+	builder := &strings.Builder{}
+	for i := 0; i < len(val); i++ {
+		if val[i] != '\\' {
+			builder.WriteByte(val[i])
+			continue
+		}
+		if i+2 >= len(val) {
+			return "", ErrInvalidFilter
+		}
+		decoded, err := hex.DecodeString(val[i+1 : i+3])
+		if err != nil {
+			return "", ErrInvalidFilter
+		}
+		builder.Write(decoded)
+		i += 2
+	}
+	return builder.String(), nil
+}
+
+// CompileFilter encodes the string filter, and, or, not, equality, presence and substrings filters are supported.
+func CompileFilter(filter string) (*Packet, error) {
+	packet, rest, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	} else if rest != "" {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, rest)
+	}
+	return packet, nil
+}
+
+// compileFilter compiles the first filter and returns the rest of the string
+func compileFilter(filter string) (*Packet, string, error) {
+	if !strings.HasPrefix(filter, "(") {
+		return nil, "", fmt.Errorf("%w: filters must start with '('", ErrInvalidFilter)
+	}
+	filter = filter[1:]
+	if filter == "" {
+		return nil, "", ErrInvalidFilter
+	}
+
+	switch filter[0] {
+	case '&', '|':
+		tag := FilterAnd
+		if filter[0] == '|' {
+			tag = FilterOr
+		}
+		packet := NewConstructed(ClassContext, tag)
+		rest := filter[1:]
+		for strings.HasPrefix(rest, "(") {
+			child, childRest, err := compileFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			packet.Children = append(packet.Children, child)
+			rest = childRest
+		}
+		if !strings.HasPrefix(rest, ")") || len(packet.Children) == 0 {
+			return nil, "", ErrInvalidFilter
+		}
+		return packet, rest[1:], nil
+	case '!':
+		child, rest, err := compileFilter(filter[1:])
+		if err != nil {
+			return nil, "", err
+		} else if !strings.HasPrefix(rest, ")") {
+			return nil, "", ErrInvalidFilter
+		}
+		return NewConstructed(ClassContext, FilterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(filter, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("%w: missing ')'", ErrInvalidFilter)
+	}
+	item, rest := filter[:end], filter[end+1:]
+	packet, err := compileItem(item)
+	return packet, rest, err
+}
+
+func compileItem(item string) (*Packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFilter, item)
+	}
+	attr, rawVal := item[:eq], item[eq+1:]
+	if strings.ContainsAny(attr, "<>~:") {
+		return nil, fmt.Errorf("%w: only equality, presence and substrings are supported", ErrInvalidFilter)
+	}
+
+	if rawVal == "*" {
+		return NewString(ClassContext, FilterPresent, attr), nil
+	}
+	if !strings.Contains(rawVal, "*") {
+		val, err := unescapeFilter(rawVal)
+		if err != nil {
+			return nil, err
+		}
+		return NewConstructed(ClassContext, FilterEquality, NewOctetString(attr), NewOctetString(val)), nil
+	}
+
+	parts := strings.Split(rawVal, "*")
+	substrings := NewSequence()
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		val, err := unescapeFilter(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := SubstringAny
+		if i == 0 {
+			tag = SubstringInitial
+		} else if i == len(parts)-1 {
+			tag = SubstringFinal
+		}
+		substrings.Children = append(substrings.Children, NewString(ClassContext, tag, val))
+	}
+	return NewConstructed(ClassContext, FilterSubstrings, NewOctetString(attr), substrings), nil
+}
@@ -0,0 +1,286 @@
+// Package ldap implements a minimal LDAPv3 client which supports simple binds and searches.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// application tags of protocol operations
+const (
+	AppBindRequest       = 0
+	AppBindResponse      = 1
+	AppUnbindRequest     = 2
+	AppSearchRequest     = 3
+	AppSearchResultEntry = 4
+	AppSearchResultDone  = 5
+	AppSearchResultRef   = 19
+)
+
+// search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// result codes
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultNoSuchObject       = 32
+	ResultInvalidCredentials = 49
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUnexpectedResponse = errors.New("unexpected ldap response")
+)
+
+// ResultError is the error of non-successful results
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (err *ResultError) Error() string {
+	return fmt.Sprintf("ldap result code %d: %s", err.Code, err.Message)
+}
+
+type Conn struct {
+	conn    net.Conn
+	msgID   int64
+	timeout time.Duration
+}
+
+// Dial connects to the ldap:// or ldaps:// URL, each operation of the connection must be completed within the timeout.
+func Dial(ctx context.Context, rawURL string, timeout time.Duration, tlsCfg *tls.Config) (*Conn, error) {
+	serverURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := serverURL.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch serverURL.Scheme {
+	case "ldap":
+		if serverURL.Port() == "" {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if serverURL.Port() == "" {
+			host = net.JoinHostPort(host, "636")
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		if tlsCfg.ServerName == "" {
+			tlsCfg = tlsCfg.Clone()
+			tlsCfg.ServerName = serverURL.Hostname()
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsCfg}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported scheme: %s", serverURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, timeout: timeout}, nil
+}
+
+// Close sends the unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.msgID++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	c.conn.Write(NewSequence(
+		NewInteger(ClassUniversal, TagInteger, c.msgID),
+		NewPacket(ClassApplication, false, AppUnbindRequest, nil),
+	).Bytes())
+	return c.conn.Close()
+}
+
+func (c *Conn) send(op *Packet) (int64, error) {
+	c.msgID++
+	err := c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.conn.Write(NewSequence(NewInteger(ClassUniversal, TagInteger, c.msgID), op).Bytes())
+	return c.msgID, err
+}
+
+// receive returns the protocol operation of the next message
+func (c *Conn) receive(msgID int64) (*Packet, error) {
+	msg, err := ReadPacket(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if !msg.Is(ClassUniversal, TagSequence) || len(msg.Children) < 2 {
+		return nil, ErrUnexpectedResponse
+	}
+	id, err := msg.Children[0].Int()
+	if err != nil {
+		return nil, err
+	} else if id != msgID {
+		return nil, fmt.Errorf("%w: message ID %d", ErrUnexpectedResponse, id)
+	}
+	return msg.Children[1], nil
+}
+
+// parseResult returns nil if the LDAPResult is successful
+func parseResult(op *Packet) error {
+	if len(op.Children) < 3 {
+		return ErrUnexpectedResponse
+	}
+	code, err := op.Children[0].Int()
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case ResultSuccess:
+		return nil
+	case ResultInvalidCredentials:
+		return ErrInvalidCredentials
+	}
+	return &ResultError{Code: code, Message: op.Children[2].String()}
+}
+
+// Bind authenticates the connection by the simple bind,
+// the empty password is rejected as it is an unauthenticated bind which always succeeds (RFC 4513).
+func (c *Conn) Bind(dn, pwd string) error {
+	if pwd == "" {
+		return ErrInvalidCredentials
+	}
+
+	msgID, err := c.send(NewConstructed(
+		ClassApplication, AppBindRequest,
+		NewInteger(ClassUniversal, TagInteger, 3),
+		NewOctetString(dn),
+		NewString(ClassContext, 0, pwd),
+	))
+	if err != nil {
+		return err
+	}
+
+	op, err := c.receive(msgID)
+	if err != nil {
+		return err
+	} else if !op.Is(ClassApplication, AppBindResponse) {
+		return ErrUnexpectedResponse
+	}
+	return parseResult(op)
+}
+
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	// SizeLimit is the max number of returned entries, it is unlimited if it is 0
+	SizeLimit int
+}
+
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values returns values of the attribute, attribute names are case-insensitive
+func (entry *Entry) Values(attr string) []string {
+	for name, vals := range entry.Attributes {
+		if strings.EqualFold(name, attr) {
+			return vals
+		}
+	}
+	return []string{}
+}
+
+// Value returns the first value of the attribute
+func (entry *Entry) Value(attr string) string {
+	vals := entry.Values(attr)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+func (c *Conn) Search(req *SearchRequest) ([]*Entry, error) {
+	filter, err := CompileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := NewSequence()
+	for _, attr := range req.Attributes {
+		attrs.Children = append(attrs.Children, NewOctetString(attr))
+	}
+
+	msgID, err := c.send(NewConstructed(
+		ClassApplication, AppSearchRequest,
+		NewOctetString(req.BaseDN),
+		NewEnumerated(int64(req.Scope)),
+		NewEnumerated(0), // never dereference aliases
+		NewInteger(ClassUniversal, TagInteger, int64(req.SizeLimit)),
+		NewInteger(ClassUniversal, TagInteger, int64(c.timeout/time.Second)),
+		NewBoolean(false),
+		filter,
+		attrs,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*Entry{}
+	for {
+		op, err := c.receive(msgID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case op.Is(ClassApplication, AppSearchResultEntry):
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case op.Is(ClassApplication, AppSearchResultRef):
+			// referrals are not followed
+		case op.Is(ClassApplication, AppSearchResultDone):
+			return entries, parseResult(op)
+		default:
+			return nil, ErrUnexpectedResponse
+		}
+	}
+}
+
+func parseEntry(op *Packet) (*Entry, error) {
+	if len(op.Children) < 2 {
+		return nil, ErrUnexpectedResponse
+	}
+	entry := &Entry{
+		DN:         op.Children[0].String(),
+		Attributes: map[string][]string{},
+	}
+	for _, attr := range op.Children[1].Children {
+		if len(attr.Children) < 2 {
+			return nil, ErrUnexpectedResponse
+		}
+		vals := []string{}
+		for _, val := range attr.Children[1].Children {
+			vals = append(vals, val.String())
+		}
+		name := attr.Children[0].String()
+		entry.Attributes[name] = append(entry.Attributes[name], vals...)
+	}
+	return entry, nil
+}
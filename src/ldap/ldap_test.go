@@ -0,0 +1,131 @@
+package ldap_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/ldap"
+	"github.com/ihexxa/quickshare/src/ldap/ldaptest"
+)
+
+func TestFilter(t *testing.T) {
+	if got := ldap.EscapeFilter("a*(b)\\c"); got != "a\\2a\\28b\\29\\5cc" {
+		t.Fatalf("incorrect escaping: %s", got)
+	}
+
+	valid := []string{
+		"(uid=alice)",
+		"(objectClass=*)",
+		"(cn=al*ce*)",
+		"(&(objectClass=person)(|(uid=alice)(mail=alice@example.com))(!(uid=bob)))",
+		"(uid=" + ldap.EscapeFilter("a*(b)") + ")",
+	}
+	for _, filter := range valid {
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			t.Errorf("filter %s should be valid: %s", filter, err)
+		}
+	}
+
+	invalid := []string{
+		"uid=alice",
+		"(uid=alice",
+		"(uid=alice))",
+		"(&)",
+		"(uid>=1)",
+		"(uid=\\2)",
+	}
+	for _, filter := range invalid {
+		if _, err := ldap.CompileFilter(filter); !errors.Is(err, ldap.ErrInvalidFilter) {
+			t.Errorf("filter %s should be invalid: %v", filter, err)
+		}
+	}
+}
+
+func TestBindAndSearch(t *testing.T) {
+	srv, err := ldaptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	srv.AddEntry(&ldap.Entry{DN: "cn=admin,dc=example,dc=com"}, "admin_pwd")
+	srv.AddEntry(&ldap.Entry{
+		DN: "uid=alice,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"alice"},
+			"mail":        {"alice@example.com"},
+			"memberOf":    {"cn=admins,ou=groups,dc=example,dc=com", "cn=users,ou=groups,dc=example,dc=com"},
+		},
+	}, "alice_pwd")
+	srv.AddEntry(&ldap.Entry{
+		DN: "uid=bob,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+		},
+	}, "bob_pwd")
+
+	conn, err := ldap.Dial(context.TODO(), srv.URL(), 5*time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = conn.Bind("cn=admin,dc=example,dc=com", ""); !errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Fatalf("empty password should be rejected: %v", err)
+	}
+	if err = conn.Bind("cn=admin,dc=example,dc=com", "wrong"); !errors.Is(err, ldap.ErrInvalidCredentials) {
+		t.Fatalf("wrong password should be rejected: %v", err)
+	}
+	if err = conn.Bind("cn=admin,dc=example,dc=com", "admin_pwd"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN:     "ou=people,dc=example,dc=com",
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     "(&(objectClass=person)(uid=" + ldap.EscapeFilter("alice") + "))",
+		Attributes: []string{"mail", "memberOf"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 {
+		t.Fatalf("incorrect entries: %+v", entries)
+	}
+	entry := entries[0]
+	if entry.DN != "uid=alice,ou=people,dc=example,dc=com" ||
+		entry.Value("MAIL") != "alice@example.com" ||
+		len(entry.Values("memberof")) != 2 ||
+		entry.Value("uid") != "" {
+		t.Fatalf("incorrect entry: %+v", entry)
+	}
+
+	entries, err = conn.Search(&ldap.SearchRequest{
+		BaseDN: "dc=example,dc=com",
+		Scope:  ldap.ScopeWholeSubtree,
+		Filter: "(objectClass=person)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 2 {
+		t.Fatalf("incorrect entries: %+v", entries)
+	}
+
+	_, err = conn.Search(&ldap.SearchRequest{
+		BaseDN:    "dc=example,dc=com",
+		Scope:     ldap.ScopeWholeSubtree,
+		Filter:    "(objectClass=person)",
+		SizeLimit: 1,
+	})
+	var resultErr *ldap.ResultError
+	if !errors.As(err, &resultErr) || resultErr.Code != ldap.ResultSizeLimitExceeded {
+		t.Fatalf("size limit should be exceeded: %v", err)
+	}
+
+	if err = conn.Bind("uid=alice,ou=people,dc=example,dc=com", "alice_pwd"); err != nil {
+		t.Fatal(err)
+	}
+}
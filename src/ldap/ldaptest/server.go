@@ -0,0 +1,332 @@
+// Package ldaptest provides an in-process LDAP server for tests, it supports simple binds and searches.
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/ihexxa/quickshare/src/ldap"
+)
+
+const (
+	resultProtocolError      = 2
+	resultInsufficientAccess = 50
+	resultUnwillingToPerform = 53
+)
+
+type Server struct {
+	listener net.Listener
+	wg       *sync.WaitGroup
+	conns    *sync.Map
+
+	mtx     *sync.RWMutex
+	entries []*ldap.Entry
+	// pwds are passwords of entries indexed by lower cased DNs
+	pwds map[string]string
+}
+
+// NewServer starts a server listening on a random local port
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		listener: listener,
+		wg:       &sync.WaitGroup{},
+		conns:    &sync.Map{},
+		mtx:      &sync.RWMutex{},
+		entries:  []*ldap.Entry{},
+		pwds:     map[string]string{},
+	}
+	srv.wg.Add(1)
+	go srv.serve()
+	return srv, nil
+}
+
+// URL returns the ldap:// URL of the server
+func (srv *Server) URL() string {
+	return "ldap://" + srv.listener.Addr().String()
+}
+
+// AddEntry adds the entry, it can be bound with the password if the password is not empty.
+func (srv *Server) AddEntry(entry *ldap.Entry, pwd string) {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+
+	srv.entries = append(srv.entries, entry)
+	if pwd != "" {
+		srv.pwds[strings.ToLower(entry.DN)] = pwd
+	}
+}
+
+// SetPwd sets the password of the entry
+func (srv *Server) SetPwd(dn, pwd string) {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	srv.pwds[strings.ToLower(dn)] = pwd
+}
+
+// Close stops listening and closes open connections
+func (srv *Server) Close() error {
+	err := srv.listener.Close()
+	srv.conns.Range(func(conn, _ interface{}) bool {
+		conn.(net.Conn).Close()
+		return true
+	})
+	srv.wg.Wait()
+	return err
+}
+
+func (srv *Server) serve() {
+	defer srv.wg.Done()
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.wg.Add(1)
+		srv.conns.Store(conn, true)
+		go func() {
+			defer srv.wg.Done()
+			defer srv.conns.Delete(conn)
+			defer conn.Close()
+			srv.handle(conn)
+		}()
+	}
+}
+
+func (srv *Server) handle(conn net.Conn) {
+	bound := false
+	for {
+		msg, err := ldap.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if !msg.Is(ldap.ClassUniversal, ldap.TagSequence) || len(msg.Children) < 2 {
+			return
+		}
+		msgID, err := msg.Children[0].Int()
+		if err != nil {
+			return
+		}
+		op := msg.Children[1]
+
+		respond := func(resp *ldap.Packet) bool {
+			_, err := conn.Write(ldap.NewSequence(
+				ldap.NewInteger(ldap.ClassUniversal, ldap.TagInteger, msgID),
+				resp,
+			).Bytes())
+			return err == nil
+		}
+
+		switch {
+		case op.Is(ldap.ClassApplication, ldap.AppBindRequest):
+			code := srv.bind(op)
+			bound = code == ldap.ResultSuccess
+			if !respond(result(ldap.AppBindResponse, code)) {
+				return
+			}
+		case op.Is(ldap.ClassApplication, ldap.AppSearchRequest):
+			if !bound {
+				if !respond(result(ldap.AppSearchResultDone, resultInsufficientAccess)) {
+					return
+				}
+				continue
+			}
+			entries, code := srv.search(op)
+			for _, entry := range entries {
+				if !respond(entry) {
+					return
+				}
+			}
+			if !respond(result(ldap.AppSearchResultDone, code)) {
+				return
+			}
+		case op.Is(ldap.ClassApplication, ldap.AppUnbindRequest):
+			return
+		default:
+			// other operations are not supported
+			return
+		}
+	}
+}
+
+func result(tag int, code int64) *ldap.Packet {
+	return ldap.NewConstructed(
+		ldap.ClassApplication, tag,
+		ldap.NewEnumerated(code),
+		ldap.NewOctetString(""),
+		ldap.NewOctetString(""),
+	)
+}
+
+func (srv *Server) bind(op *ldap.Packet) int64 {
+	if len(op.Children) < 3 || !op.Children[2].Is(ldap.ClassContext, 0) {
+		return resultProtocolError
+	}
+	dn, pwd := op.Children[1].String(), op.Children[2].String()
+	if pwd == "" {
+		return resultUnwillingToPerform
+	}
+
+	srv.mtx.RLock()
+	defer srv.mtx.RUnlock()
+	if expected, ok := srv.pwds[strings.ToLower(dn)]; ok && expected == pwd {
+		return ldap.ResultSuccess
+	}
+	return ldap.ResultInvalidCredentials
+}
+
+func (srv *Server) search(op *ldap.Packet) ([]*ldap.Packet, int64) {
+	if len(op.Children) < 8 {
+		return nil, resultProtocolError
+	}
+	baseDN := strings.ToLower(op.Children[0].String())
+	scope, err := op.Children[1].Int()
+	if err != nil {
+		return nil, resultProtocolError
+	}
+	sizeLimit, err := op.Children[3].Int()
+	if err != nil {
+		return nil, resultProtocolError
+	}
+	filter := op.Children[6]
+	attrs := []string{}
+	for _, attr := range op.Children[7].Children {
+		attrs = append(attrs, attr.String())
+	}
+
+	srv.mtx.RLock()
+	defer srv.mtx.RUnlock()
+
+	results := []*ldap.Packet{}
+	for _, entry := range srv.entries {
+		if !inScope(strings.ToLower(entry.DN), baseDN, scope) || !matches(entry, filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(results)) >= sizeLimit {
+			return results, ldap.ResultSizeLimitExceeded
+		}
+		results = append(results, encodeEntry(entry, attrs))
+	}
+	return results, ldap.ResultSuccess
+}
+
+func inScope(dn, baseDN string, scope int64) bool {
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return dn == baseDN
+	case ldap.ScopeSingleLevel:
+		rdnEnd := strings.IndexByte(dn, ',')
+		return rdnEnd >= 0 && dn[rdnEnd+1:] == baseDN
+	}
+	return baseDN == "" || dn == baseDN || strings.HasSuffix(dn, ","+baseDN)
+}
+
+func matches(entry *ldap.Entry, filter *ldap.Packet) bool {
+	if filter.Class != ldap.ClassContext {
+		return false
+	}
+
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !matches(entry, filter.Children[0])
+	case ldap.FilterPresent:
+		return len(entry.Values(filter.String())) > 0
+	case ldap.FilterEquality:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, val := range entry.Values(filter.Children[0].String()) {
+			if strings.EqualFold(val, filter.Children[1].String()) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, val := range entry.Values(filter.Children[0].String()) {
+			if matchSubstrings(strings.ToLower(val), filter.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func matchSubstrings(val string, substrings []*ldap.Packet) bool {
+	for _, substring := range substrings {
+		part := strings.ToLower(substring.String())
+		switch substring.Tag {
+		case ldap.SubstringInitial:
+			if !strings.HasPrefix(val, part) {
+				return false
+			}
+			val = val[len(part):]
+		case ldap.SubstringAny:
+			idx := strings.Index(val, part)
+			if idx < 0 {
+				return false
+			}
+			val = val[idx+len(part):]
+		case ldap.SubstringFinal:
+			if !strings.HasSuffix(val, part) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func encodeEntry(entry *ldap.Entry, attrs []string) *ldap.Packet {
+	allAttrs := len(attrs) == 0
+	for _, attr := range attrs {
+		if attr == "*" {
+			allAttrs = true
+		}
+	}
+
+	attrList := ldap.NewSequence()
+	for name, vals := range entry.Attributes {
+		requested := allAttrs
+		for _, attr := range attrs {
+			if strings.EqualFold(attr, name) {
+				requested = true
+			}
+		}
+		if !requested {
+			continue
+		}
+
+		valSet := ldap.NewConstructed(ldap.ClassUniversal, ldap.TagSet)
+		for _, val := range vals {
+			valSet.Children = append(valSet.Children, ldap.NewOctetString(val))
+		}
+		attrList.Children = append(attrList.Children, ldap.NewSequence(ldap.NewOctetString(name), valSet))
+	}
+	return ldap.NewConstructed(
+		ldap.ClassApplication, ldap.AppSearchResultEntry,
+		ldap.NewOctetString(entry.DN),
+		attrList,
+	)
+}
@@ -0,0 +1,246 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is the leeway of checking expiry and issue time
+const clockSkew = time.Minute
+
+// IDToken is the verified ID token
+type IDToken struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// PreferredUsername is "preferred_username" of the standard claims
+	PreferredUsername string
+	Claims            map[string]interface{}
+}
+
+// Strings returns the claim as strings, e.g., groups, a single string is also accepted.
+func (t *IDToken) Strings(claim string) []string {
+	vals := []string{}
+	switch val := t.Claims[claim].(type) {
+	case string:
+		vals = append(vals, val)
+	case []interface{}:
+		for _, item := range val {
+			if str, ok := item.(string); ok {
+				vals = append(vals, str)
+			}
+		}
+	}
+	return vals
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks struct {
+	Keys []*jwk `json:"keys"`
+}
+
+func decodeBigInt(val string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+func (key *jwk) publicKey() (interface{}, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if key.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", key.Crv)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", key.Kty)
+}
+
+// getKey returns the signing key of the ID, keys are refetched if it is not found.
+func (p *Provider) getKey(ctx context.Context, kid string) (interface{}, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	} else if time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("signing key not found: %s", kid)
+	}
+
+	keySet := &jwks{}
+	err = p.getJSON(ctx, discovery.JWKSURI, keySet)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		pubKey, err := key.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[key.Kid] = pubKey
+	}
+	p.keys, p.keysFetchedAt = keys, time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("signing key not found: %s", kid)
+}
+
+func verifySignature(alg string, key interface{}, signingInput, sig []byte) error {
+	digest := sha256.Sum256(signingInput)
+	switch alg {
+	case "RS256":
+		pubKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidIDToken
+		}
+		return rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, digest[:], sig)
+	case "ES256":
+		pubKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return ErrInvalidIDToken
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pubKey, digest[:], r, s) {
+			return ErrInvalidIDToken
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm: %s", alg)
+}
+
+func numClaim(claims map[string]interface{}, name string) (int64, bool) {
+	val, ok := claims[name].(float64)
+	return int64(val), ok
+}
+
+// VerifyIDToken verifies the signature, the issuer, the audience, the expiry and the nonce of the ID token.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDToken, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	header := &jwtHeader{}
+	if err = json.Unmarshal(headerBytes, header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	key, err := p.getKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidIDToken, err)
+	}
+
+	claims := map[string]interface{}{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	if iss, _ := claims["iss"].(string); iss != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidIDToken)
+	}
+
+	audiences := (&IDToken{Claims: claims}).Strings("aud")
+	audOK := false
+	for _, aud := range audiences {
+		if aud == p.cfg.ClientID {
+			audOK = true
+		}
+	}
+	if azp, ok := claims["azp"].(string); !audOK || (len(audiences) > 1 && (!ok || azp != p.cfg.ClientID)) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	}
+
+	now := time.Now()
+	exp, ok := numClaim(claims, "exp")
+	if !ok || now.Add(-clockSkew).Unix() >= exp {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if iat, ok := numClaim(claims, "iat"); ok && iat > now.Add(clockSkew).Unix() {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: unexpected nonce", ErrInvalidIDToken)
+	}
+
+	idToken := &IDToken{Claims: claims}
+	idToken.Subject, _ = claims["sub"].(string)
+	idToken.Email, _ = claims["email"].(string)
+	idToken.EmailVerified, _ = claims["email_verified"].(bool)
+	idToken.PreferredUsername, _ = claims["preferred_username"].(string)
+	if idToken.Subject == "" {
+		return nil, fmt.Errorf("%w: subject is empty", ErrInvalidIDToken)
+	}
+	return idToken, nil
+}
@@ -0,0 +1,213 @@
+// Package oidc implements the relying party of the OpenID Connect authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	// maxRespSize limits responses of the provider
+	maxRespSize = 1024 * 1024
+	// keysRefreshInterval throttles refetching keys for unknown key IDs
+	keysRefreshInterval = time.Minute
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrInvalidIssuer  = errors.New("issuer does not match the discovery document")
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Discovery is the subset of the provider metadata used by the flow
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Provider fetches the discovery document and keys lazily, so that the server starts if the provider is down.
+type Provider struct {
+	cfg    *Config
+	client *http.Client
+
+	mtx           *sync.Mutex
+	discovery     *Discovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+func NewProvider(cfg *Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{
+		cfg:    cfg,
+		client: client,
+		mtx:    &sync.Mutex{},
+		keys:   map[string]interface{}{},
+	}
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, val interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: %d", endpoint, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxRespSize)).Decode(val)
+}
+
+// Discover returns the cached discovery document, it is fetched if it is not cached.
+func (p *Provider) Discover(ctx context.Context) (*Discovery, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	discovery := &Discovery{}
+	err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+discoveryPath, discovery)
+	if err != nil {
+		return nil, err
+	} else if discovery.Issuer != p.cfg.Issuer {
+		return nil, ErrInvalidIssuer
+	} else if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+	p.discovery = discovery
+	return discovery, nil
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint which users are redirected to.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", S256Challenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+type tokenResp struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange exchanges the authorization code for the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	// client_secret_basic is the default method of the spec
+	secretPost := len(discovery.TokenAuthMethods) > 0
+	for _, method := range discovery.TokenAuthMethods {
+		if method == "client_secret_basic" {
+			secretPost = false
+		}
+	}
+	if secretPost {
+		form.Set("client_id", p.cfg.ClientID)
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !secretPost {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	tokens := &tokenResp{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxRespSize)).Decode(tokens)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token response(%d): %w", resp.StatusCode, err)
+	} else if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("failed to exchange code(%d): %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	} else if tokens.IDToken == "" {
+		return "", errors.New("id token is not returned")
+	}
+	return tokens.IDToken, nil
+}
+
+func randString(size int) (string, error) {
+	buf := make([]byte, size)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// GenState generates a random value for states, nonces and PKCE code verifiers.
+func GenState() (string, error) {
+	return randString(32)
+}
+
+// S256Challenge returns the PKCE code challenge of the verifier
+func S256Challenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
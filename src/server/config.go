@@ -13,12 +13,20 @@ type DbConfig struct {
 }
 
 type FSConfig struct {
-	Root              string `json:"root" yaml:"root"`
-	OpensLimit        int    `json:"opensLimit" yaml:"opensLimit"`
-	OpenTTL           int    `json:"openTTL" yaml:"openTTL"`
-	PublicPath        string `json:"publicPath" yaml:"publicPath"`
-	SearchResultLimit int    `json:"searchResultLimit" yaml:"searchResultLimit"`
-	InitFileIndex     bool   `json:"initFileIndex" yaml:"initFileIndex"`
+	Root               string   `json:"root" yaml:"root"`
+	OpensLimit         int      `json:"opensLimit" yaml:"opensLimit"`
+	OpenTTL            int      `json:"openTTL" yaml:"openTTL"`
+	PublicPath         string   `json:"publicPath" yaml:"publicPath"`
+	SearchResultLimit  int      `json:"searchResultLimit" yaml:"searchResultLimit"`
+	InitFileIndex      bool     `json:"initFileIndex" yaml:"initFileIndex"`
+	CopyAsyncThreshold int      `json:"copyAsyncThreshold" yaml:"copyAsyncThreshold"`
+	TrashTTL           int      `json:"trashTTL" yaml:"trashTTL"`
+	TrashPurgeSpec     string   `json:"trashPurgeSpec" yaml:"trashPurgeSpec"`
+	MaxRevisions       int      `json:"maxRevisions" yaml:"maxRevisions"`
+	Dedup              bool     `json:"dedup" yaml:"dedup"`
+	HashAlgorithms     []string `json:"hashAlgorithms" yaml:"hashAlgorithms"`
+	SharingPurgeSpec   string   `json:"sharingPurgeSpec" yaml:"sharingPurgeSpec"` // cron spec of clearing expired sharings
+	ShareIDLen         int      `json:"shareIDLen" yaml:"shareIDLen"`
 }
 
 type UsersCfg struct {
@@ -39,6 +47,45 @@ type UsersCfg struct {
 	LimiterCapacity    int           `json:"limiterCapacity" yaml:"limiterCapacity"`
 	LimiterCyc         int           `json:"limiterCyc" yaml:"limiterCyc"`
 	PredefinedUsers    []*db.UserCfg `json:"predefinedUsers" yaml:"predefinedUsers"`
+	OIDC               *OIDCCfg      `json:"oidc" yaml:"oidc"`
+	LDAP               *LDAPCfg      `json:"ldap" yaml:"ldap"`
+}
+
+type OIDCCfg struct {
+	Enabled      bool     `json:"enabled" yaml:"enabled"`
+	Issuer       string   `json:"issuer" yaml:"issuer"`
+	ClientID     string   `json:"clientID" yaml:"clientID"`
+	ClientSecret string   `json:"clientSecret" yaml:"clientSecret"`
+	RedirectURL  string   `json:"redirectURL" yaml:"redirectURL"`
+	Scopes       []string `json:"scopes" yaml:"scopes"`
+	// AutoProvision creates users on their first logins with DefaultRole and the default quota
+	AutoProvision bool              `json:"autoProvision" yaml:"autoProvision"`
+	DefaultRole   string            `json:"defaultRole" yaml:"defaultRole"`
+	GroupsClaim   string            `json:"groupsClaim" yaml:"groupsClaim"`
+	GroupRoles    map[string]string `json:"groupRoles" yaml:"groupRoles"`
+}
+
+type LDAPCfg struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// URL is ldap://host:port or ldaps://host:port
+	URL                string `json:"url" yaml:"url"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+	Timeout            int    `json:"timeout" yaml:"timeout"`
+	// BindDN and BindPwd are credentials for searching users, users are searched anonymously if BindDN is empty
+	BindDN  string `json:"bindDN" yaml:"bindDN"`
+	BindPwd string `json:"bindPwd" yaml:"bindPwd" cfg:"env"`
+	BaseDN  string `json:"baseDN" yaml:"baseDN"`
+	// UserFilter finds the user entry, "%s" is replaced by the escaped login name
+	UserFilter      string `json:"userFilter" yaml:"userFilter"`
+	UserNameAttr    string `json:"userNameAttr" yaml:"userNameAttr"`
+	DisplayNameAttr string `json:"displayNameAttr" yaml:"displayNameAttr"`
+	EmailAttr       string `json:"emailAttr" yaml:"emailAttr"`
+	GroupAttr       string `json:"groupAttr" yaml:"groupAttr"`
+	// GroupRoles maps group DNs to roles
+	GroupRoles  map[string]string `json:"groupRoles" yaml:"groupRoles"`
+	DefaultRole string            `json:"defaultRole" yaml:"defaultRole"`
+	// FallbackToLocal authenticates local users if logins are not authenticated by the directory
+	FallbackToLocal bool `json:"fallbackToLocal" yaml:"fallbackToLocal"`
 }
 
 type Secrets struct {
@@ -70,6 +117,43 @@ type Config struct {
 	Server  *ServerCfg     `json:"server" yaml:"server"`
 }
 
+// DefaultOIDCCfg returns the disabled single sign-on config
+func DefaultOIDCCfg() *OIDCCfg {
+	return &OIDCCfg{
+		Enabled:       false,
+		Issuer:        "",
+		ClientID:      "",
+		ClientSecret:  "",
+		RedirectURL:   "", // e.g., https://example.com/v2/public/oidc/callback
+		Scopes:        []string{"openid", "profile", "email"},
+		AutoProvision: false,
+		DefaultRole:   db.UserRole,
+		GroupsClaim:   "groups",
+		GroupRoles:    map[string]string{}, // e.g., {"qs-admins": "admin"}
+	}
+}
+
+// DefaultLDAPCfg returns the disabled LDAP config
+func DefaultLDAPCfg() *LDAPCfg {
+	return &LDAPCfg{
+		Enabled:            false,
+		URL:                "", // e.g., ldaps://ldap.example.com:636
+		InsecureSkipVerify: false,
+		Timeout:            5000, // 5s
+		BindDN:             "",
+		BindPwd:            "",
+		BaseDN:             "",
+		UserFilter:         "(&(objectClass=person)(uid=%s))", // e.g., (&(objectClass=user)(sAMAccountName=%s)) for AD
+		UserNameAttr:       "uid",
+		DisplayNameAttr:    "cn",
+		EmailAttr:          "mail",
+		GroupAttr:          "memberOf",
+		GroupRoles:         map[string]string{}, // e.g., {"cn=qs-admins,ou=groups,dc=example,dc=com": "admin"}
+		DefaultRole:        db.UserRole,
+		FallbackToLocal:    true,
+	}
+}
+
 func NewConfig() *Config {
 	return &Config{}
 }
@@ -82,12 +166,20 @@ func DefaultConfig() (string, error) {
 func DefaultConfigStruct() *Config {
 	return &Config{
 		Fs: &FSConfig{
-			Root:              "quickshare",
-			OpensLimit:        1024,
-			OpenTTL:           60, // 1 min
-			PublicPath:        "static/public",
-			SearchResultLimit: 16,
-			InitFileIndex:     true,
+			Root:               "quickshare",
+			OpensLimit:         1024,
+			OpenTTL:            60, // 1 min
+			PublicPath:         "static/public",
+			SearchResultLimit:  16,
+			InitFileIndex:      true,
+			CopyAsyncThreshold: 32 * 1024 * 1024, // 32MB
+			TrashTTL:           3600 * 24 * 30,   // 30 days, items are deleted permanently if it is 0
+			TrashPurgeSpec:     "@hourly",
+			MaxRevisions:       0,                // versioning is disabled if it is 0
+			Dedup:              false,            // files with identical content readable by the same uploader are stored once if it is true
+			HashAlgorithms:     []string{"sha1"}, // sha1 is always computed, others: sha256, md5, blake2b
+			SharingPurgeSpec:   "@hourly",
+			ShareIDLen:         12,
 		},
 		Users: &UsersCfg{
 			EnableAuth:         true,
@@ -107,6 +199,8 @@ func DefaultConfigStruct() *Config {
 			LimiterCapacity:    1000,
 			LimiterCyc:         1000, // 1s
 			PredefinedUsers:    []*db.UserCfg{},
+			OIDC:               DefaultOIDCCfg(),
+			LDAP:               DefaultLDAPCfg(),
 		},
 		Secrets: &Secrets{
 			TokenSecret: "", // it will auto generated if it is left as empty
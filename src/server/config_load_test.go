@@ -96,12 +96,20 @@ func TestLoadCfg(t *testing.T) {
 
 	cfg1 := &Config{
 		Fs: &FSConfig{
-			Root:              "1",
-			OpensLimit:        1,
-			OpenTTL:           1,
-			PublicPath:        "1",
-			SearchResultLimit: 16,
-			InitFileIndex:     true,
+			Root:               "1",
+			OpensLimit:         1,
+			OpenTTL:            1,
+			PublicPath:         "1",
+			SearchResultLimit:  16,
+			InitFileIndex:      true,
+			CopyAsyncThreshold: 32 * 1024 * 1024,
+			TrashTTL:           3600 * 24 * 30,
+			TrashPurgeSpec:     "@hourly",
+			MaxRevisions:       0,
+			Dedup:              false,
+			HashAlgorithms:     []string{"sha1"},
+			SharingPurgeSpec:   "@hourly",
+			ShareIDLen:         12,
 		},
 		Users: &UsersCfg{
 			EnableAuth:         true,
@@ -120,6 +128,8 @@ func TestLoadCfg(t *testing.T) {
 			SpaceLimit:         1,
 			LimiterCapacity:    1,
 			LimiterCyc:         1,
+			OIDC:               DefaultOIDCCfg(),
+			LDAP:               DefaultLDAPCfg(),
 			PredefinedUsers: []*db.UserCfg{
 				&db.UserCfg{
 					Name: "1",
@@ -166,12 +176,20 @@ func TestLoadCfg(t *testing.T) {
 
 	cfg4 := &Config{
 		Fs: &FSConfig{
-			Root:              "4",
-			OpensLimit:        4,
-			OpenTTL:           4,
-			PublicPath:        "4",
-			SearchResultLimit: 16,
-			InitFileIndex:     true,
+			Root:               "4",
+			OpensLimit:         4,
+			OpenTTL:            4,
+			PublicPath:         "4",
+			SearchResultLimit:  16,
+			InitFileIndex:      true,
+			CopyAsyncThreshold: 32 * 1024 * 1024,
+			TrashTTL:           3600 * 24 * 30,
+			TrashPurgeSpec:     "@hourly",
+			MaxRevisions:       0,
+			Dedup:              false,
+			HashAlgorithms:     []string{"sha1"},
+			SharingPurgeSpec:   "@hourly",
+			ShareIDLen:         12,
 		},
 		Users: &UsersCfg{
 			EnableAuth:         false,
@@ -190,6 +208,8 @@ func TestLoadCfg(t *testing.T) {
 			SpaceLimit:         4,
 			LimiterCapacity:    4,
 			LimiterCyc:         4,
+			OIDC:               DefaultOIDCCfg(),
+			LDAP:               DefaultLDAPCfg(),
 			PredefinedUsers: []*db.UserCfg{
 				&db.UserCfg{
 					Name: "4",
@@ -236,12 +256,20 @@ func TestLoadCfg(t *testing.T) {
 
 	cfg5 := &Config{
 		Fs: &FSConfig{
-			Root:              "4",
-			OpensLimit:        4,
-			OpenTTL:           4,
-			PublicPath:        "4",
-			SearchResultLimit: 16,
-			InitFileIndex:     true,
+			Root:               "4",
+			OpensLimit:         4,
+			OpenTTL:            4,
+			PublicPath:         "4",
+			SearchResultLimit:  16,
+			InitFileIndex:      true,
+			CopyAsyncThreshold: 32 * 1024 * 1024,
+			TrashTTL:           3600 * 24 * 30,
+			TrashPurgeSpec:     "@hourly",
+			MaxRevisions:       0,
+			Dedup:              false,
+			HashAlgorithms:     []string{"sha1"},
+			SharingPurgeSpec:   "@hourly",
+			ShareIDLen:         12,
 		},
 		Users: &UsersCfg{
 			EnableAuth:         true,
@@ -260,6 +288,8 @@ func TestLoadCfg(t *testing.T) {
 			SpaceLimit:         5,
 			LimiterCapacity:    5,
 			LimiterCyc:         5,
+			OIDC:               DefaultOIDCCfg(),
+			LDAP:               DefaultLDAPCfg(),
 			PredefinedUsers: []*db.UserCfg{
 				&db.UserCfg{
 					Name: "5",
@@ -306,12 +336,20 @@ func TestLoadCfg(t *testing.T) {
 
 	cfgWithPartialCfg := &Config{
 		Fs: &FSConfig{
-			Root:              "4",
-			OpensLimit:        4,
-			OpenTTL:           4,
-			PublicPath:        "4",
-			SearchResultLimit: 16,
-			InitFileIndex:     true,
+			Root:               "4",
+			OpensLimit:         4,
+			OpenTTL:            4,
+			PublicPath:         "4",
+			SearchResultLimit:  16,
+			InitFileIndex:      true,
+			CopyAsyncThreshold: 32 * 1024 * 1024,
+			TrashTTL:           3600 * 24 * 30,
+			TrashPurgeSpec:     "@hourly",
+			MaxRevisions:       0,
+			Dedup:              false,
+			HashAlgorithms:     []string{"sha1"},
+			SharingPurgeSpec:   "@hourly",
+			ShareIDLen:         12,
 		},
 		Users: &UsersCfg{
 			EnableAuth:         true,
@@ -330,6 +368,8 @@ func TestLoadCfg(t *testing.T) {
 			SpaceLimit:         5,
 			LimiterCapacity:    5,
 			LimiterCyc:         5,
+			OIDC:               DefaultOIDCCfg(),
+			LDAP:               DefaultLDAPCfg(),
 			PredefinedUsers: []*db.UserCfg{
 				&db.UserCfg{
 					Name: "5",
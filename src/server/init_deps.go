@@ -19,6 +19,7 @@ import (
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/ihexxa/quickshare/src/cron"
 	"github.com/ihexxa/quickshare/src/cryptoutil"
 	"github.com/ihexxa/quickshare/src/cryptoutil/jwt"
 	"github.com/ihexxa/quickshare/src/db"
@@ -62,6 +63,7 @@ func (it *Initer) InitDeps() *depidx.Deps {
 	}
 	rateLimiter := it.initRateLimiter(quickshareDb)
 	fileIndex := it.initSearchIndex(filesystem, logger)
+	crons := it.initCron()
 
 	deps := depidx.NewDeps(it.cfg)
 	deps.SetDB(quickshareDb)
@@ -72,6 +74,7 @@ func (it *Initer) InitDeps() *depidx.Deps {
 	deps.SetLimiter(rateLimiter)
 	deps.SetWorkers(workers)
 	deps.SetFileIndex(fileIndex)
+	deps.SetCron(crons)
 
 	return deps
 }
@@ -97,7 +100,10 @@ func (it *Initer) initLogger() *zap.SugaredLogger {
 
 func (it *Initer) initJWT(logger *zap.SugaredLogger) cryptoutil.ITokenEncDec {
 	secret, ok := it.cfg.String("ENV.TOKENSECRET")
-	if !ok {
+	if !ok || secret == "" {
+		secret = it.cfg.StringOr("Secrets.TokenSecret", "")
+	}
+	if secret == "" {
 		b := make([]byte, 32)
 		_, err := rand.Read(b)
 		if err != nil {
@@ -106,6 +112,8 @@ func (it *Initer) initJWT(logger *zap.SugaredLogger) cryptoutil.ITokenEncDec {
 		secret = string(b)
 		logger.Info("warning: TOKENSECRET is not set, a random token is generated")
 	}
+	// the secret is also used for signing other tokens, e.g., presigned URLs
+	it.cfg.SetString("Secrets.TokenSecret", secret)
 
 	return jwt.NewJWTEncDec(secret)
 }
@@ -113,7 +121,7 @@ func (it *Initer) initJWT(logger *zap.SugaredLogger) cryptoutil.ITokenEncDec {
 func (it *Initer) initRateLimiter(quickshareDb db.IDBQuickshare) iolimiter.ILimiter {
 	limiterCap := it.cfg.IntOr("Users.LimiterCapacity", 10000)
 	limiterCyc := it.cfg.IntOr("Users.LimiterCyc", 1000)
-	return iolimiter.NewIOLimiter(limiterCap, limiterCyc, quickshareDb)
+	return iolimiter.NewIOLimiter(limiterCap, limiterCyc, quickshareDb, quickshareDb)
 }
 
 func (it *Initer) initWorkerPool(logger *zap.SugaredLogger) worker.IWorkerPool {
@@ -126,6 +134,12 @@ func (it *Initer) initWorkerPool(logger *zap.SugaredLogger) worker.IWorkerPool {
 	return workers
 }
 
+func (it *Initer) initCron() cron.ICron {
+	crons := cron.NewMyCron()
+	crons.Start()
+	return crons
+}
+
 func (it *Initer) initSearchIndex(filesystem fs.ISimpleFS, logger *zap.SugaredLogger) fileindex.IFileIndex {
 	searchResultLimit := it.cfg.GrabInt("Server.SearchResultLimit")
 	fileIndex := fileindex.NewFileTreeIndex(filesystem, "/", searchResultLimit)
@@ -254,6 +268,11 @@ func (it *Initer) initDb(filesystem fs.ISimpleFS) (db.IDBQuickshare, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to init tables: %w %s", err, dbPath)
 		}
+	} else {
+		err = dbQuickshare.Upgrade(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to upgrade tables: %w %s", err, dbPath)
+		}
 	}
 
 	return dbQuickshare, nil
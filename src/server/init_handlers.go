@@ -95,7 +95,6 @@ func (it *Initer) InitHandlers(deps *depidx.Deps) (*gin.Engine, error) {
 		filesAPI.GET("/dirs", fileHdrs.List)
 		filesAPI.GET("/dirs/home", fileHdrs.ListHome)
 		filesAPI.POST("/dirs", fileHdrs.Mkdir)
-		// files.POST("/dirs/copy", fileHdrs.CopyDir)
 
 		filesAPI.GET("/uploadings", fileHdrs.ListUploadings)
 		filesAPI.DELETE("/uploadings", fileHdrs.DelUploading)
@@ -136,12 +135,25 @@ func (it *Initer) InitHandlers(deps *depidx.Deps) (*gin.Engine, error) {
 	adminUsersAPI.GET("/list", userHdrs.ListUsers)
 	adminUsersAPI.PATCH("/", userHdrs.SetUser)
 	adminUsersAPI.PATCH("/pwd/force-set", userHdrs.ForceSetPwd)
+	adminUsersAPI.PATCH("/totp", userHdrs.SetUserTOTP)
+	adminUsersAPI.DELETE("/totp", userHdrs.ResetUserTOTP)
+	adminUsersAPI.POST("/identities/oidc", userHdrs.LinkOIDCIdentity)
 
 	adminRolesAPI := adminAPI.Group("/roles")
-	// rolesAPI.POST("/", userHdrs.AddRole)
-	// rolesAPI.DELETE("/", userHdrs.DelRole)
+	adminRolesAPI.POST("/", userHdrs.AddRole)
+	adminRolesAPI.PATCH("/", userHdrs.SetRole)
+	adminRolesAPI.DELETE("/", userHdrs.DelRole)
 	adminRolesAPI.GET("/list", userHdrs.ListRoles)
 
+	adminGroupsAPI := adminAPI.Group("/groups")
+	adminGroupsAPI.POST("/", userHdrs.AddGroup)
+	adminGroupsAPI.PATCH("/", userHdrs.SetGroup)
+	adminGroupsAPI.DELETE("/", userHdrs.DelGroup)
+	adminGroupsAPI.GET("/list", userHdrs.ListGroups)
+	adminGroupsAPI.PUT("/members", userHdrs.SetGroupMember)
+	adminGroupsAPI.DELETE("/members", userHdrs.DelGroupMember)
+	adminGroupsAPI.GET("/members", userHdrs.ListGroupMembers)
+
 	// user
 	userAPI := v2.Group("/my")
 
@@ -151,11 +163,26 @@ func (it *Initer) InitHandlers(deps *depidx.Deps) (*gin.Engine, error) {
 	userAPI.POST("/errors", settingsSvc.ReportErrors)
 	userAPI.GET("/isauthed", userHdrs.IsAuthed)
 	userAPI.POST("/logout", userHdrs.Logout)
+	userAPI.POST("/tokens", userHdrs.AddAPIToken)
+	userAPI.GET("/tokens", userHdrs.ListAPITokens)
+	userAPI.DELETE("/tokens", userHdrs.DelAPIToken)
+	userAPI.GET("/sessions", userHdrs.ListSessions)
+	userAPI.DELETE("/sessions", userHdrs.DelSession)
+	userAPI.DELETE("/sessions/all", userHdrs.DelSessions)
+	userAPI.GET("/totp", userHdrs.GetTOTP)
+	userAPI.POST("/totp/setup", userHdrs.SetupTOTP)
+	userAPI.POST("/totp/enable", userHdrs.EnableTOTP)
+	userAPI.POST("/totp/disable", userHdrs.DisableTOTP)
+	userAPI.POST("/totp/recovery-codes", userHdrs.RegenRecoveryCodes)
+	userAPI.GET("/groups", userHdrs.ListMyGroups)
 
 	// public
 	publicAPI := v2.Group("/public")
 
 	publicAPI.POST("/login", userHdrs.Login)
+	publicAPI.POST("/login/totp", userHdrs.LoginTOTP)
+	publicAPI.GET("/oidc/login", userHdrs.OIDCLogin)
+	publicAPI.GET("/oidc/callback", userHdrs.OIDCCallback)
 
 	publicCaptchaAPI2 := publicAPI.Group("/captchas")
 	publicCaptchaAPI2.GET("/", userHdrs.GetCaptchaID)
@@ -173,32 +200,62 @@ func (it *Initer) InitHandlers(deps *depidx.Deps) (*gin.Engine, error) {
 		userFilesAPI.DELETE("/files", fileHdrs.Delete)
 		userFilesAPI.GET("/files", fileHdrs.Download)
 		userFilesAPI.PATCH("/files/chunks", fileHdrs.UploadChunk)
+		userFilesAPI.PATCH("/files/chunks/binary", fileHdrs.UploadBinaryChunk)
+		userFilesAPI.PUT("/files/parts", fileHdrs.UploadPart)
+		userFilesAPI.GET("/files/parts", fileHdrs.UploadParts)
 		userFilesAPI.GET("/files/chunks", fileHdrs.UploadStatus)
 		userFilesAPI.PATCH("/files/copy", fileHdrs.Copy)
+		userFilesAPI.GET("/files/copy", fileHdrs.CopyStatus)
+		userFilesAPI.POST("/files/presign", fileHdrs.PresignDownload)
 		userFilesAPI.PATCH("/files/move", fileHdrs.Move)
 
 		userFilesAPI.GET("/dirs", fileHdrs.List)
 		userFilesAPI.GET("/dirs/home", fileHdrs.ListHome)
 		userFilesAPI.POST("/dirs", fileHdrs.Mkdir)
-		// files.POST("/dirs/copy", fileHdrs.CopyDir)
+		userFilesAPI.GET("/dirs/archive", fileHdrs.ArchiveDir)
+
+		userFilesAPI.OPTIONS("/tus", fileHdrs.TusOptions)
+		userFilesAPI.POST("/tus", fileHdrs.TusCreate)
+		userFilesAPI.HEAD("/tus/:uploadID", fileHdrs.TusOffset)
+		userFilesAPI.PATCH("/tus/:uploadID", fileHdrs.TusPatch)
+		userFilesAPI.DELETE("/tus/:uploadID", fileHdrs.TusTerminate)
 
 		userFilesAPI.GET("/uploadings", fileHdrs.ListUploadings)
 		userFilesAPI.DELETE("/uploadings", fileHdrs.DelUploading)
 
+		userFilesAPI.GET("/trashes", fileHdrs.ListTrashes)
+		userFilesAPI.PATCH("/trashes/restore", fileHdrs.RestoreTrash)
+		userFilesAPI.DELETE("/trashes", fileHdrs.PurgeTrash)
+
+		userFilesAPI.GET("/revisions", fileHdrs.ListRevisions)
+		userFilesAPI.GET("/revisions/file", fileHdrs.DownloadRevision)
+		userFilesAPI.PATCH("/revisions/restore", fileHdrs.RestoreRevision)
+
 		userFilesAPI.POST("/sharings", fileHdrs.AddSharing)
 		userFilesAPI.DELETE("/sharings", fileHdrs.DelSharing)
 		userFilesAPI.GET("/sharings", fileHdrs.ListSharings)
 		userFilesAPI.GET("/sharings/ids", fileHdrs.ListSharingIDs)
 
+		userFilesAPI.POST("/acls", fileHdrs.AddFileACL)
+		userFilesAPI.DELETE("/acls", fileHdrs.DelFileACL)
+		userFilesAPI.GET("/acls", fileHdrs.ListFileACLs)
+		userFilesAPI.GET("/acls/shared-with-me", fileHdrs.ListSharedWithMe)
+
 		userFilesAPI.GET("/metadata", fileHdrs.Metadata)
 		userFilesAPI.GET("/search", fileHdrs.SearchItems)
 		userFilesAPI.PUT("/reindex", fileHdrs.Reindex)
 
-		userFilesAPI.POST("/hashes/sha1", fileHdrs.GenerateHash)
+		userFilesAPI.POST("/hashes/:algo", fileHdrs.GenerateHash)
+		userFilesAPI.GET("/hashes/sha1", fileHdrs.HasSha1)
 
 		publicSharingsAPI := publicAPI.Group("/sharings")
 		publicSharingsAPI.GET("/exist", fileHdrs.IsSharing)
 		publicSharingsAPI.GET("/dirs", fileHdrs.GetSharingDir)
+		publicSharingsAPI.GET("/files", fileHdrs.DownloadSharing)
+		publicSharingsAPI.POST("/unlock", fileHdrs.UnlockSharing)
+
+		publicFilesAPI := publicAPI.Group("/fs")
+		publicFilesAPI.GET("/files", fileHdrs.Download)
 	}
 
 	return router, nil
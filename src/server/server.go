@@ -98,6 +98,7 @@ func (s *Server) Shutdown() error {
 	if err != nil {
 		s.deps.Log().Errorf("failed to persist file index: %s", err)
 	}
+	s.deps.Cron().Stop()
 	s.deps.Workers().Stop()
 	err = s.deps.FS().Close()
 	if err != nil {
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/client"
+	q "github.com/ihexxa/quickshare/src/handlers"
+)
+
+func TestFileDedup(t *testing.T) {
+	addr := "http://127.0.0.1:8686"
+	rootPath := "tmpTestData"
+	config := `{
+		"users": {
+			"enableAuth": true,
+			"minUserNameLen": 2,
+			"minPwdLen": 4,
+			"captchaEnabled": false,
+			"uploadSpeedLimit": 409600,
+			"downloadSpeedLimit": 409600,
+			"spaceLimit": 1000,
+			"limiterCapacity": 1000,
+			"limiterCyc": 1000,
+			"predefinedUsers": [
+				{
+					"name": "demo",
+					"pwd": "Quicksh@re",
+					"role": "user"
+				}
+			]
+		},
+		"server": {
+			"debug": true,
+			"host": "127.0.0.1"
+		},
+		"fs": {
+			"root": "tmpTestData",
+			"copyAsyncThreshold": 16,
+			"dedup": true
+		},
+		"db": {
+			"dbPath": "tmpTestData/quickshare"
+		}
+	}`
+
+	adminName := "qs"
+	adminPwd := "quicksh@re"
+	setUpEnv(t, rootPath, adminName, adminPwd)
+	defer os.RemoveAll(rootPath)
+
+	srv := startTestServer(config)
+	defer srv.Shutdown()
+	if !isServerReady(addr) {
+		t.Fatal("fail to start server")
+	}
+
+	usersCl := client.NewUsersClient(addr)
+	resp, _, errs := usersCl.Login(adminName, adminPwd)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	} else if resp.StatusCode != 200 {
+		t.Fatal(resp.StatusCode)
+	}
+	token := client.GetCookie(resp.Cookies(), q.TokenCookie)
+
+	userUsersCl := client.NewUsersClient(addr)
+	resp, _, errs = userUsersCl.Login("demo", "Quicksh@re")
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	} else if resp.StatusCode != 200 {
+		t.Fatal(resp.StatusCode)
+	}
+	userUsersToken := client.GetCookie(resp.Cookies(), q.TokenCookie)
+	userFilesCl := client.NewFilesClient(addr, userUsersToken)
+	adminFilesCl := client.NewFilesClient(addr, token)
+
+	content := "identical content"
+	size := int64(len(content))
+	sum := sha1.Sum([]byte(content))
+	sha1Sign := fmt.Sprintf("%x", sum)
+
+	t.Run("identical files are stored once", func(t *testing.T) {
+		// sha1 is generated asynchronously
+		waitForSha1 := func(cl *client.FilesClient) {
+			for i := 0; ; i++ {
+				resp, hasResp, errs := cl.HasSha1(sha1Sign, size)
+				assertResp(t, resp, errs, 200, "has sha1")
+				if hasResp.Exist {
+					return
+				} else if i > 60 {
+					t.Fatal("sha1 is not generated")
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+		}
+		assertLinked := func(filePath1, filePath2 string) {
+			for i := 0; ; i++ {
+				info1, err := os.Stat(filepath.Join(rootPath, filePath1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				info2, err := os.Stat(filepath.Join(rootPath, filePath2))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if os.SameFile(info1, info2) {
+					return
+				} else if i > 60 {
+					t.Fatalf("files are not deduplicated: %s %s", filePath1, filePath2)
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+		}
+
+		assertNotLinked := func(filePath1, filePath2 string) {
+			info1, err := os.Stat(filepath.Join(rootPath, filePath1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			info2, err := os.Stat(filepath.Join(rootPath, filePath2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if os.SameFile(info1, info2) {
+				t.Fatalf("files should not be linked: %s %s", filePath1, filePath2)
+			}
+		}
+
+		adminFilePath := "qs/files/dedup/src"
+		assertUploadOK(t, adminFilePath, content, addr, token)
+		waitForSha1(adminFilesCl)
+
+		// files of others are not exposed
+		resp, hasResp, errs := userFilesCl.HasSha1(sha1Sign, size)
+		assertResp(t, resp, errs, 200, "has sha1")
+		if hasResp.Exist {
+			t.Fatal("files of others should not be found")
+		}
+
+		resp, selfResp, errs := userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		usedBefore := selfResp.UsedSpace
+
+		filePath := "demo/files/dedup/f1"
+		resp, createResp, errs := userFilesCl.CreateWithSha1(filePath, size, sha1Sign)
+		assertResp(t, resp, errs, 200, "create with sha1")
+		if createResp.Completed {
+			t.Fatal("content should be uploaded if no accessible file has it")
+		}
+		resp, _, errs = userFilesCl.UploadChunk(filePath, base64.StdEncoding.EncodeToString([]byte(content)), 0)
+		assertResp(t, resp, errs, 200, "upload chunk")
+		waitForSha1(userFilesCl)
+
+		uploadedFilePath := "demo/files/dedup/f2"
+		assertUploadOK(t, uploadedFilePath, content, addr, userUsersToken)
+		assertLinked(filePath, uploadedFilePath)
+		// files are only linked to files readable by the uploader
+		assertNotLinked(adminFilePath, filePath)
+
+		// the content is not uploaded again, sha1 signs are case insensitive
+		instantFilePath := "demo/files/dedup/f3"
+		resp, createResp, errs = userFilesCl.CreateWithSha1(instantFilePath, size, strings.ToUpper(sha1Sign))
+		assertResp(t, resp, errs, 200, "create with sha1")
+		if !createResp.Completed {
+			t.Fatal("file should be created without uploading")
+		}
+		assertDownloadOK(t, instantFilePath, content, addr, userUsersToken)
+		assertLinked(filePath, instantFilePath)
+
+		// each file is charged to the uploader
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if selfResp.UsedSpace != usedBefore+3*size {
+			t.Fatalf("used space not match %d %d", selfResp.UsedSpace, usedBefore+3*size)
+		}
+
+		// other files are not affected after deleting one of them
+		resp, _, errs = userFilesCl.Delete(filePath)
+		assertResp(t, resp, errs, 200, "delete")
+		assertDownloadOK(t, instantFilePath, content, addr, userUsersToken)
+	})
+
+	t.Run("files with matched sha1 but different bytes are not linked", func(t *testing.T) {
+		content := "original content"
+		srcPath := "demo/files/dedup2/src"
+		assertUploadOK(t, srcPath, content, addr, userUsersToken)
+		for i := 0; ; i++ {
+			resp, metadata, errs := userFilesCl.Metadata(srcPath)
+			assertResp(t, resp, errs, 200, "metadata")
+			if metadata.Sha1 != "" {
+				break
+			} else if i > 60 {
+				t.Fatal("sha1 is not generated")
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		// the recorded sha1 is kept as if the changed content collides with it
+		err := os.WriteFile(filepath.Join(rootPath, srcPath), []byte("tampered content"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filePath := "demo/files/dedup2/f1"
+		assertUploadOK(t, filePath, content, addr, userUsersToken)
+		for i := 0; ; i++ {
+			resp, metadata, errs := userFilesCl.Metadata(filePath)
+			assertResp(t, resp, errs, 200, "metadata")
+			if metadata.Sha1 != "" {
+				break
+			} else if i > 60 {
+				t.Fatal("sha1 is not generated")
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+		// deduplication follows generating sha1
+		time.Sleep(500 * time.Millisecond)
+
+		info1, err := os.Stat(filepath.Join(rootPath, srcPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		info2, err := os.Stat(filepath.Join(rootPath, filePath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if os.SameFile(info1, info2) {
+			t.Fatal("files with different bytes should not be linked")
+		}
+		assertDownloadOK(t, filePath, content, addr, userUsersToken)
+	})
+}
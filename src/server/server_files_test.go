@@ -1,16 +1,31 @@
 package server
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"golang.org/x/crypto/blake2b"
+
 	"github.com/ihexxa/quickshare/src/client"
 	"github.com/ihexxa/quickshare/src/db"
 	q "github.com/ihexxa/quickshare/src/handlers"
@@ -45,7 +60,10 @@ func TestFileHandlers(t *testing.T) {
 			"initFileIndex": true
 		},
 		"fs": {
-			"root": "tmpTestData"
+			"root": "tmpTestData",
+			"copyAsyncThreshold": 16,
+			"maxRevisions": 2,
+			"hashAlgorithms": ["sha256", "md5"]
 		},
 		"db": {
 			"dbPath": "tmpTestData/quickshare"
@@ -387,6 +405,691 @@ func TestFileHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("test copy APIs: Mkdir-Create-UploadChunk-Copy-CopyStatus-List", func(t *testing.T) {
+		resp, selfResp, errs := userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		usedBefore := selfResp.UsedSpace
+
+		srcDir := "demo/files/copy/src"
+		files := map[string]string{
+			"f1.md":        "111",
+			"sub/f2.md":    "22222",
+			"sub/sub/f3":   "333",
+			"large/f4.txt": "4444444444444444444444444",
+		}
+		for filePath, content := range files {
+			assertUploadOK(t, filepath.Join(srcDir, filePath), content, addr, userUsersToken)
+		}
+		err = fs.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// small items are copied synchronously
+		dstDir := "demo/files/copy/dst"
+		resp, _, errs = userFilesCl.Copy(filepath.Join(srcDir, "sub"), dstDir)
+		assertResp(t, resp, errs, 200, "copy dir")
+		for filePath, content := range map[string]string{
+			"f2.md":  files["sub/f2.md"],
+			"sub/f3": files["sub/sub/f3"],
+		} {
+			assertDownloadOK(t, filepath.Join(dstDir, filePath), content, addr, userUsersToken)
+		}
+
+		resp, _, errs = userFilesCl.Copy(filepath.Join(srcDir, "f1.md"), dstDir)
+		assertResp(t, resp, errs, 400, "copy to existing path")
+		resp, _, errs = userFilesCl.Copy(srcDir, filepath.Join(srcDir, "sub/copied"))
+		assertResp(t, resp, errs, 400, "copy into itself")
+		resp, _, errs = userFilesCl.Copy(srcDir, "qs/files/copied")
+		assertResp(t, resp, errs, 403, "copy to others' folder")
+		resp, _, errs = userFilesCl.Copy(filepath.Join(srcDir, "notfound"), "demo/files/copy/notfound")
+		assertResp(t, resp, errs, 404, "copy missing item")
+
+		// large items are copied by workers
+		largeDst := "demo/files/copy/large"
+		resp, cpResp, errs := userFilesCl.Copy(filepath.Join(srcDir, "large"), largeDst)
+		assertResp(t, resp, errs, 202, "copy dir async")
+		for i := 0; ; i++ {
+			resp, statusResp, errs := userFilesCl.CopyStatus(cpResp.TaskID)
+			assertResp(t, resp, errs, 200, "copy status")
+			if statusResp.Done {
+				if statusResp.Err != "" {
+					t.Fatal(statusResp.Err)
+				} else if statusResp.Copied != statusResp.Total ||
+					statusResp.Total != int64(len(files["large/f4.txt"])) {
+					t.Fatalf("incorrect progress %d/%d", statusResp.Copied, statusResp.Total)
+				}
+				break
+			} else if i > 60 {
+				t.Fatal("copying timeout")
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+		resp, _, errs = userFilesCl.CopyStatus(cpResp.TaskID)
+		assertResp(t, resp, errs, 404, "copy status is cleaned")
+		assertDownloadOK(t, filepath.Join(largeDst, "f4.txt"), files["large/f4.txt"], addr, userUsersToken)
+
+		// copies are charged to the user
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		expectedUsed := usedBefore
+		for _, content := range files {
+			expectedUsed += int64(len(content))
+		}
+		expectedUsed += int64(len(files["sub/f2.md"]) + len(files["sub/sub/f3"]) + len(files["large/f4.txt"]))
+		if selfResp.UsedSpace != expectedUsed {
+			t.Fatalf("used space not match %d %d", selfResp.UsedSpace, expectedUsed)
+		}
+
+		// queued copies are checked against the quota again, and failed copies are removed
+		largeSize := int64(len(files["large/f4.txt"]))
+		fillerSize := selfResp.Quota.SpaceLimit - selfResp.UsedSpace - largeSize - 1
+		assertUploadOK(t, "demo/files/copy/filler", strings.Repeat("0", int(fillerSize)), addr, userUsersToken)
+		taskDsts := map[string]string{}
+		for i := 0; i < 3; i++ {
+			overDst := fmt.Sprintf("demo/files/copy/over/%d", i)
+			resp, cpResp, errs := userFilesCl.Copy(filepath.Join(srcDir, "large"), overDst)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			} else if resp.StatusCode == 202 {
+				taskDsts[cpResp.TaskID] = overDst
+			} else if resp.StatusCode != 403 {
+				t.Fatalf("incorrect status of copying: %d", resp.StatusCode)
+			}
+		}
+		copied := 0
+		for taskID, overDst := range taskDsts {
+			for i := 0; ; i++ {
+				resp, statusResp, errs := userFilesCl.CopyStatus(taskID)
+				assertResp(t, resp, errs, 200, "copy status")
+				if statusResp.Done {
+					if statusResp.Err == "" {
+						copied++
+					} else {
+						resp, _, errs = userFilesCl.Metadata(overDst)
+						assertResp(t, resp, errs, 404, "get metadata of failed copy")
+					}
+					break
+				} else if i > 60 {
+					t.Fatal("copying timeout")
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+		}
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if copied != 1 || selfResp.UsedSpace > selfResp.Quota.SpaceLimit {
+			t.Fatalf("quota is exceeded by copies: %d %d", copied, selfResp.UsedSpace)
+		}
+
+		resp, _, errs = userFilesCl.Delete("demo/files/copy")
+		assertResp(t, resp, errs, 200, "delete copies")
+		// trashes are charged until they are purged
+		resp, trashesResp, errs := userFilesCl.ListTrashes()
+		assertResp(t, resp, errs, 200, "list trashes")
+		for _, trash := range trashesResp.Trashes {
+			if trash.OriginPath == "demo/files/copy" {
+				resp, _, errs = userFilesCl.PurgeTrash(trash.ID)
+				assertResp(t, resp, errs, 200, "purge copies")
+			}
+		}
+	})
+
+	t.Run("test trash APIs: Upload-Delete-ListTrashes-RestoreTrash-PurgeTrash", func(t *testing.T) {
+		resp, selfResp, errs := userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		usedBefore := selfResp.UsedSpace
+
+		files := map[string]string{
+			"demo/files/trash/dir/f1": "123456",
+			"demo/files/trash/f2":     "12345678",
+		}
+		for filePath, content := range files {
+			assertUploadOK(t, filePath, content, addr, userUsersToken)
+		}
+		err = fs.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deletedPaths := map[string]int64{
+			"demo/files/trash/dir": 6,
+			"demo/files/trash/f2":  8,
+		}
+		for itemPath := range deletedPaths {
+			resp, _, errs := userFilesCl.Delete(itemPath)
+			assertResp(t, resp, errs, 200, "delete")
+			_, err := fs.Stat(itemPath)
+			if !os.IsNotExist(err) {
+				t.Fatalf("%s should be trashed: %s", itemPath, err)
+			}
+		}
+		resp, _, errs = userFilesCl.Delete("demo/files/trash/dir")
+		assertResp(t, resp, errs, 404, "delete trashed item")
+
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if selfResp.UsedSpace != usedBefore+14 {
+			t.Fatalf("space of trashes should be charged until they are purged: %d %d", selfResp.UsedSpace, usedBefore+14)
+		}
+
+		// trashes created by other cases are ignored
+		listTrashes := func() []*db.TrashInfo {
+			resp, lsResp, errs := userFilesCl.ListTrashes()
+			assertResp(t, resp, errs, 200, "list trashes")
+			trashes := []*db.TrashInfo{}
+			for _, trash := range lsResp.Trashes {
+				if strings.HasPrefix(trash.OriginPath, "demo/files/trash") {
+					trashes = append(trashes, trash)
+				}
+			}
+			return trashes
+		}
+
+		trashes := listTrashes()
+		if len(trashes) != len(deletedPaths) {
+			t.Fatalf("incorrect trashes size (%d)", len(trashes))
+		}
+		trashIDs := map[string]uint64{}
+		for _, trash := range trashes {
+			size, ok := deletedPaths[trash.OriginPath]
+			if !ok {
+				t.Fatalf("unknown trash (%s)", trash.OriginPath)
+			} else if trash.Size != size {
+				t.Fatalf("incorrect trash size (%d) (%d)", trash.Size, size)
+			}
+			trashIDs[trash.OriginPath] = trash.ID
+		}
+
+		resp, _, errs = adminFilesClient.ListTrashes()
+		assertResp(t, resp, errs, 200, "list admin's trashes")
+
+		// trashes are only accessed by trash APIs
+		trashPath := q.TrashPath("demo", trashIDs["demo/files/trash/dir"])
+		resp, _, errs = userFilesCl.List(q.TrashFolder("demo"))
+		assertResp(t, resp, errs, 403, "list trash folder")
+		resp, _, errs = userFilesCl.Move(trashPath, "demo/files/restored")
+		assertResp(t, resp, errs, 403, "move out of trash folder")
+		resp, _, errs = adminFilesClient.List(q.TrashFolder("demo"))
+		assertResp(t, resp, errs, 403, "list trash folder by admin")
+		resp, _, errs = userFilesCl.RestoreTrash(trashIDs["demo/files/trash/dir"] + 1)
+		assertResp(t, resp, errs, 404, "restore unknown trash")
+
+		// restore the folder
+		resp, _, errs = userFilesCl.RestoreTrash(trashIDs["demo/files/trash/dir"])
+		assertResp(t, resp, errs, 200, "restore trash")
+		assertDownloadOK(t, "demo/files/trash/dir/f1", files["demo/files/trash/dir/f1"], addr, userUsersToken)
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if selfResp.UsedSpace != usedBefore+14 {
+			t.Fatalf("space of restored items should be charged: %d %d", selfResp.UsedSpace, usedBefore+14)
+		}
+
+		// restoring fails if the original path is occupied
+		assertUploadOK(t, "demo/files/trash/f2", "1", addr, userUsersToken)
+		resp, _, errs = userFilesCl.RestoreTrash(trashIDs["demo/files/trash/f2"])
+		assertResp(t, resp, errs, 400, "restore to existing path")
+
+		// purge the file
+		resp, _, errs = userFilesCl.PurgeTrash(trashIDs["demo/files/trash/f2"])
+		assertResp(t, resp, errs, 200, "purge trash")
+		_, err = fs.Stat(q.TrashPath("demo", trashIDs["demo/files/trash/f2"]))
+		if !os.IsNotExist(err) {
+			t.Fatalf("trash should be purged: %s", err)
+		}
+		if trashes = listTrashes(); len(trashes) != 0 {
+			t.Fatalf("incorrect trashes size (%d)", len(trashes))
+		}
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if selfResp.UsedSpace != usedBefore+6+1 {
+			t.Fatalf("space of purged trashes should be released: %d %d", selfResp.UsedSpace, usedBefore+6+1)
+		}
+
+		resp, _, errs = userFilesCl.Delete("demo/files/trash")
+		assertResp(t, resp, errs, 200, "delete")
+		for _, trash := range listTrashes() {
+			resp, _, errs = userFilesCl.PurgeTrash(trash.ID)
+			assertResp(t, resp, errs, 200, "purge trash")
+		}
+	})
+
+	t.Run("test hashes: Upload-Metadata-List-GenerateHash", func(t *testing.T) {
+		dirPath := "qs/files/hashes"
+		filePath := path.Join(dirPath, "f")
+		content := []byte("hashed content")
+		sha1Sum := sha1.Sum(content)
+		sha256Sum := sha256.Sum256(content)
+		md5Sum := md5.Sum(content)
+		blake2bSum := blake2b.Sum256(content)
+		expected := map[string]string{
+			fileshdr.HashSha1:   fmt.Sprintf("%x", sha1Sum),
+			fileshdr.HashSha256: fmt.Sprintf("%x", sha256Sum),
+			fileshdr.HashMD5:    fmt.Sprintf("%x", md5Sum),
+		}
+
+		// hashes are generated asynchronously
+		waitForHashes := func(expected map[string]string) {
+			for i := 0; ; i++ {
+				resp, metadata, errs := adminFilesClient.Metadata(filePath)
+				assertResp(t, resp, errs, 200, "get metadata")
+				matched := true
+				for algo, sign := range expected {
+					if metadata.Hashes[algo] != sign {
+						matched = false
+					}
+				}
+				if matched {
+					if metadata.Sha1 != expected[fileshdr.HashSha1] {
+						t.Fatalf("incorrect sha1 %s", metadata.Sha1)
+					}
+					return
+				} else if i > 60 {
+					t.Fatalf("hashes not match: got(%+v) expected(%+v)", metadata.Hashes, expected)
+				}
+				time.Sleep(200 * time.Millisecond)
+			}
+		}
+
+		assertUploadOK(t, filePath, string(content), addr, token)
+		waitForHashes(expected)
+
+		resp, lsResp, errs := adminFilesClient.List(dirPath)
+		assertResp(t, resp, errs, 200, "list")
+		if len(lsResp.Metadatas) != 1 {
+			t.Fatalf("incorrect metadatas size (%d)", len(lsResp.Metadatas))
+		}
+		for algo, sign := range expected {
+			if lsResp.Metadatas[0].Hashes[algo] != sign {
+				t.Fatalf("incorrect %s in listing: %s", algo, lsResp.Metadatas[0].Hashes[algo])
+			}
+		}
+
+		// algorithms not configured can be computed on demand
+		resp, _, errs = adminFilesClient.GenerateHashWith(fileshdr.HashBlake2b, filePath)
+		assertResp(t, resp, errs, 200, "generate blake2b")
+		expected[fileshdr.HashBlake2b] = fmt.Sprintf("%x", blake2bSum)
+		waitForHashes(expected)
+
+		resp, _, errs = adminFilesClient.GenerateHashWith("crc", filePath)
+		assertResp(t, resp, errs, 400, "generate unsupported hash")
+		resp, _, errs = userFilesCl.GenerateHashWith(fileshdr.HashSha256, filePath)
+		assertResp(t, resp, errs, 403, "generate hash of others")
+	})
+
+	t.Run("test upload integrity: CreateWithExpectedHash-UploadChunkWithChecksum-Metadata", func(t *testing.T) {
+		content := []byte("verified content")
+		sha256Sum := sha256.Sum256(content)
+		expectedHash := fmt.Sprintf("%s:%x", fileshdr.HashSha256, sha256Sum)
+		crc32cChecksum := func(chunk []byte) string {
+			return fmt.Sprintf("%s %08x", fileshdr.ChecksumCRC32C, crc32.Checksum(chunk, crc32.MakeTable(crc32.Castagnoli)))
+		}
+		sha256Checksum := func(chunk []byte) string {
+			return fmt.Sprintf("%s %x", fileshdr.HashSha256, sha256.Sum256(chunk))
+		}
+		uploadChunk := func(filePath string, chunk []byte, offset int64, checksum string, expectedCode int) {
+			resp, _, errs := adminFilesClient.UploadChunkWithChecksum(
+				filePath, base64.StdEncoding.EncodeToString(chunk), offset, checksum,
+			)
+			assertResp(t, resp, errs, expectedCode, "upload chunk with checksum")
+		}
+
+		resp, _, errs := adminFilesClient.CreateWithExpectedHash("qs/files/integrity/invalid", 1, "crc:zz")
+		assertResp(t, resp, errs, 400, "create with invalid expected hash")
+
+		// corrupted chunks are rejected and the offset is not advanced
+		filePath := "qs/files/integrity/verified"
+		resp, _, errs = adminFilesClient.CreateWithExpectedHash(filePath, int64(len(content)), expectedHash)
+		assertResp(t, resp, errs, 200, "create with expected hash")
+		uploadChunk(filePath, content[:5], 0, crc32cChecksum(content[1:6]), 422)
+		resp, statusResp, errs := adminFilesClient.UploadStatus(filePath)
+		assertResp(t, resp, errs, 200, "upload status")
+		if statusResp.Uploaded != 0 {
+			t.Fatalf("corrupted chunk should not be kept: %d", statusResp.Uploaded)
+		}
+		uploadChunk(filePath, content[:5], 0, crc32cChecksum(content[:5]), 200)
+		uploadChunk(filePath, content[5:], 5, sha256Checksum(content[5:]), 200)
+
+		for i := 0; ; i++ {
+			resp, metadata, errs := adminFilesClient.Metadata(filePath)
+			assertResp(t, resp, errs, 200, "get metadata")
+			if metadata.Hashes[fileshdr.HashSha256] == fmt.Sprintf("%x", sha256Sum) {
+				break
+			} else if i > 60 {
+				t.Fatal("verified file is not hashed")
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		// the file not matching the expected hash is quarantined before it is completed
+		resp, selfResp, errs := usersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		usedBefore := selfResp.UsedSpace
+		mismatchedPath := "qs/files/integrity/mismatched"
+		resp, _, errs = adminFilesClient.CreateWithExpectedHash(mismatchedPath, int64(len(content)), expectedHash)
+		assertResp(t, resp, errs, 200, "create with expected hash")
+		mismatched := []byte("corrupted content")
+		uploadChunk(mismatchedPath, mismatched[:len(content)], 0, "", 422)
+
+		resp, _, errs = adminFilesClient.Metadata(mismatchedPath)
+		assertResp(t, resp, errs, 404, "get metadata of mismatched file")
+		resp, selfResp, errs = usersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if selfResp.UsedSpace != usedBefore {
+			t.Fatalf("space of mismatched file is not released: %d != %d", selfResp.UsedSpace, usedBefore)
+		}
+		resp, _, errs = adminFilesClient.CreateWithExpectedHash("qs/files/integrity/empty", 0, expectedHash)
+		assertResp(t, resp, errs, 422, "create empty file with mismatched hash")
+
+		quarantined, err := os.ReadDir(filepath.Join(rootPath, q.QuarantineFolder("qs")))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(quarantined) != 1 {
+			t.Fatalf("incorrect quarantined files size (%d)", len(quarantined))
+		}
+	})
+
+	t.Run("test revision APIs: Upload-Overwrite-ListRevisions-DownloadRevision-RestoreRevision", func(t *testing.T) {
+		filePath := "demo/files/revisions/f"
+		versions := []string{"11111", "2222222", "333", "44"}
+
+		resp, selfResp, errs := userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		usedBefore := selfResp.UsedSpace
+
+		assertUploadOK(t, filePath, versions[0], addr, userUsersToken)
+		resp, _, errs = userFilesCl.Create(filePath, int64(len(versions[1])))
+		assertResp(t, resp, errs, 400, "create without overwriting")
+
+		overwrite := func(content string) {
+			resp, _, errs := userFilesCl.Overwrite(filePath, int64(len(content)))
+			assertResp(t, resp, errs, 200, "overwrite")
+			base64Content := base64.StdEncoding.EncodeToString([]byte(content))
+			resp, _, errs = userFilesCl.UploadChunk(filePath, base64Content, 0)
+			assertResp(t, resp, errs, 200, "upload chunk")
+		}
+		listRevisions := func() []*db.Revision {
+			resp, lsResp, errs := userFilesCl.ListRevisions(filePath)
+			assertResp(t, resp, errs, 200, "list revisions")
+			return lsResp.Revisions
+		}
+		assertRevision := func(revision *db.Revision, content string) {
+			resp, body, errs := userFilesCl.DownloadRevision(revision.ID)
+			assertResp(t, resp, errs, 200, "download revision")
+			if body != content {
+				t.Fatalf("incorrect revision content (%s) (%s)", body, content)
+			}
+		}
+
+		overwrite(versions[1])
+		revisions := listRevisions()
+		if len(revisions) != 1 {
+			t.Fatalf("incorrect revisions size (%d)", len(revisions))
+		}
+		assertRevision(revisions[0], versions[0])
+		firstRevisionID := revisions[0].ID
+
+		// the oldest revision is pruned
+		overwrite(versions[2])
+		overwrite(versions[3])
+		revisions = listRevisions()
+		if len(revisions) != 2 {
+			t.Fatalf("incorrect revisions size (%d)", len(revisions))
+		}
+		assertRevision(revisions[0], versions[2])
+		assertRevision(revisions[1], versions[1])
+		_, err = fs.Stat(q.RevisionPath("demo", firstRevisionID))
+		if !os.IsNotExist(err) {
+			t.Fatalf("pruned revision should be removed: %s", err)
+		}
+		assertDownloadOK(t, filePath, versions[3], addr, userUsersToken)
+
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		expectedUsed := usedBefore + int64(len(versions[1])+len(versions[2])+len(versions[3]))
+		if selfResp.UsedSpace != expectedUsed {
+			t.Fatalf("incorrect used space: %d %d", selfResp.UsedSpace, expectedUsed)
+		}
+
+		// restore the revision and the current file is kept
+		resp, _, errs = userFilesCl.RestoreRevision(revisions[1].ID)
+		assertResp(t, resp, errs, 200, "restore revision")
+		assertDownloadOK(t, filePath, versions[1], addr, userUsersToken)
+		revisions = listRevisions()
+		if len(revisions) != 2 {
+			t.Fatalf("incorrect revisions size (%d)", len(revisions))
+		}
+		assertRevision(revisions[0], versions[3])
+		assertRevision(revisions[1], versions[2])
+
+		// a failed restoring keeps no extra revision
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		usedBeforeRestoring := selfResp.UsedSpace
+		err = fs.Remove(q.RevisionPath("demo", revisions[1].ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _, errs = userFilesCl.RestoreRevision(revisions[1].ID)
+		assertResp(t, resp, errs, 404, "restore revision without content")
+		if revisions = listRevisions(); len(revisions) != 2 {
+			t.Fatalf("incorrect revisions size (%d)", len(revisions))
+		}
+		assertDownloadOK(t, filePath, versions[1], addr, userUsersToken)
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if selfResp.UsedSpace != usedBeforeRestoring {
+			t.Fatalf("incorrect used space: %d %d", selfResp.UsedSpace, usedBeforeRestoring)
+		}
+
+		resp, _, errs = userFilesCl.ListRevisions("qs/files/revisions/f")
+		assertResp(t, resp, errs, 403, "list others' revisions")
+		resp, _, errs = userFilesCl.DownloadRevision(revisions[0].ID + 1)
+		assertResp(t, resp, errs, 404, "download unknown revision")
+
+		resp, _, errs = userFilesCl.Delete(filePath)
+		assertResp(t, resp, errs, 200, "delete")
+	})
+
+	t.Run("test binary uploading APIs: Create-UploadBinaryChunk-UploadStatus-Download", func(t *testing.T) {
+		filePath := "qs/files/binary/文件 1"
+		content := []byte("0123456789abcdef")
+
+		resp, _, errs := adminFilesClient.Create(filePath, int64(len(content)))
+		assertResp(t, resp, errs, 200, "create")
+
+		resp, _, errs = adminFilesClient.UploadBinaryChunk(filePath, content[:6], 1)
+		assertResp(t, resp, errs, 500, "upload with incorrect offset")
+		resp, _, errs = adminFilesClient.UploadBinaryChunk(filePath, append(content, '!'), 0)
+		assertResp(t, resp, errs, 400, "upload exceeding the file size")
+
+		resp, _, errs = adminFilesClient.UploadBinaryChunk(filePath, content[:6], 0)
+		assertResp(t, resp, errs, 200, "upload chunk")
+		resp, _, errs = adminFilesClient.UploadPart(filePath, 1, content[:6])
+		assertResp(t, resp, errs, 400, "upload part in sequential mode")
+		resp, statusResp, errs := adminFilesClient.UploadStatus(filePath)
+		assertResp(t, resp, errs, 200, "upload status")
+		if statusResp.Uploaded != 6 {
+			t.Fatalf("incorrect uploaded size (%d)", statusResp.Uploaded)
+		}
+
+		resp, _, errs = adminFilesClient.UploadBinaryChunk(filePath, content[6:], 6)
+		assertResp(t, resp, errs, 200, "upload chunk")
+
+		err = fs.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertDownloadOK(t, filePath, string(content), addr, token)
+	})
+
+	t.Run("test parts uploading APIs: CreateInParts-UploadPart-UploadParts", func(t *testing.T) {
+		filePath := "qs/files/parts/f1"
+		content := []byte("0123456789")
+		partSize := int64(4)
+
+		resp, _, errs := adminFilesClient.CreateInParts(filePath, int64(len(content)), partSize)
+		assertResp(t, resp, errs, 200, "create in parts")
+
+		// parts are uploaded concurrently and out of order
+		wg := &sync.WaitGroup{}
+		codes := make([]int, 2)
+		for i, partNumber := range []int64{3, 1} {
+			wg.Add(1)
+			go func(i int, partNumber int64) {
+				defer wg.Done()
+				start := (partNumber - 1) * partSize
+				end := start + partSize
+				if end > int64(len(content)) {
+					end = int64(len(content))
+				}
+				resp, _, errs := adminFilesClient.UploadPart(filePath, partNumber, content[start:end])
+				if len(errs) > 0 {
+					t.Error(errs)
+					return
+				}
+				codes[i] = resp.StatusCode
+			}(i, partNumber)
+		}
+		wg.Wait()
+		for _, code := range codes {
+			if code != 200 {
+				t.Fatalf("incorrect code (%d)", code)
+			}
+		}
+
+		resp, partsResp, errs := adminFilesClient.UploadParts(filePath)
+		assertResp(t, resp, errs, 200, "upload parts")
+		if partsResp.Uploaded != 6 || fmt.Sprint(partsResp.Parts) != "[1 3]" {
+			t.Fatalf("incorrect parts (%+v)", partsResp)
+		}
+
+		// parts can not be uploaded sequentially
+		resp, _, errs = adminFilesClient.UploadChunk(filePath, base64.StdEncoding.EncodeToString(content[4:8]), 4)
+		assertResp(t, resp, errs, 400, "upload chunk in parts mode")
+		resp, _, errs = adminFilesClient.UploadBinaryChunk(filePath, content[4:8], 4)
+		assertResp(t, resp, errs, 400, "upload binary chunk in parts mode")
+
+		resp, _, errs = adminFilesClient.UploadPart(filePath, 2, content[4:7])
+		assertResp(t, resp, errs, 400, "upload part with incorrect size")
+		resp, _, errs = adminFilesClient.UploadPart(filePath, 4, content[:2])
+		assertResp(t, resp, errs, 400, "upload part exceeding the file")
+
+		resp, partsResp, errs = adminFilesClient.UploadPart(filePath, 2, content[4:8])
+		assertResp(t, resp, errs, 200, "upload part")
+		if partsResp.Uploaded != int64(len(content)) {
+			t.Fatalf("incorrect parts (%+v)", partsResp)
+		}
+		resp, _, errs = adminFilesClient.UploadParts(filePath)
+		assertResp(t, resp, errs, 404, "upload parts of completed upload")
+
+		err = fs.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertDownloadOK(t, filePath, string(content), addr, token)
+	})
+
+	t.Run("test tus APIs: TusCreate-TusOffset-TusPatch-TusTerminate", func(t *testing.T) {
+		filePath := "qs/files/tus/f1"
+		content := []byte("0123456789abcdef")
+
+		resp, _, errs := adminFilesClient.TusCreate(filePath, int64(len(content)))
+		assertResp(t, resp, errs, 201, "tus create")
+		uploadURL := resp.Header.Get("Location")
+		if uploadURL == "" {
+			t.Fatal("upload URL not found")
+		}
+
+		assertOffset := func(expected int64) {
+			resp, _, errs := adminFilesClient.TusOffset(uploadURL)
+			assertResp(t, resp, errs, 200, "tus offset")
+			if resp.Header.Get(fileshdr.TusUploadOffsetHeader) != fmt.Sprint(expected) ||
+				resp.Header.Get(fileshdr.TusUploadLengthHeader) != fmt.Sprint(len(content)) {
+				t.Fatalf("incorrect offset headers (%v)", resp.Header)
+			}
+		}
+		assertOffset(0)
+
+		resp, _, errs = adminFilesClient.TusPatch(uploadURL, content[:6], 3, "")
+		assertResp(t, resp, errs, 409, "tus patch with incorrect offset")
+
+		// the chunk is discarded if checksum mismatches
+		wrongSum := sha1.Sum(content[:5])
+		resp, _, errs = adminFilesClient.TusPatch(
+			uploadURL, content[:6], 0,
+			fmt.Sprintf("sha1 %s", base64.StdEncoding.EncodeToString(wrongSum[:])),
+		)
+		assertResp(t, resp, errs, 460, "tus patch with mismatched checksum")
+		assertOffset(0)
+
+		resp, _, errs = adminFilesClient.TusPatch(uploadURL, content[:6], 0, "md5 invalid")
+		assertResp(t, resp, errs, 400, "tus patch with invalid checksum")
+
+		sum := sha1.Sum(content[:6])
+		resp, _, errs = adminFilesClient.TusPatch(
+			uploadURL, content[:6], 0,
+			fmt.Sprintf("sha1 %s", base64.StdEncoding.EncodeToString(sum[:])),
+		)
+		assertResp(t, resp, errs, 204, "tus patch")
+		if resp.Header.Get(fileshdr.TusUploadOffsetHeader) != "6" {
+			t.Fatalf("incorrect offset (%s)", resp.Header.Get(fileshdr.TusUploadOffsetHeader))
+		}
+		assertOffset(6)
+
+		resp, _, errs = adminFilesClient.TusPatch(uploadURL, content[6:], 6, "")
+		assertResp(t, resp, errs, 204, "tus patch")
+		resp, _, errs = adminFilesClient.TusOffset(uploadURL)
+		assertResp(t, resp, errs, 404, "tus offset of completed upload")
+
+		err = fs.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertDownloadOK(t, filePath, string(content), addr, token)
+
+		// terminate the upload
+		terminatedPath := "qs/files/tus/f2"
+		resp, _, errs = adminFilesClient.TusCreate(terminatedPath, int64(len(content)))
+		assertResp(t, resp, errs, 201, "tus create")
+		uploadURL = resp.Header.Get("Location")
+		resp, _, errs = adminFilesClient.TusPatch(uploadURL, content[:6], 0, "")
+		assertResp(t, resp, errs, 204, "tus patch")
+
+		resp, _, errs = adminFilesClient.TusTerminate(uploadURL)
+		assertResp(t, resp, errs, 204, "tus terminate")
+		resp, _, errs = adminFilesClient.TusOffset(uploadURL)
+		assertResp(t, resp, errs, 404, "tus offset of terminated upload")
+		resp, lResp, errs := adminFilesClient.ListUploadings()
+		assertResp(t, resp, errs, 200, "list uploadings")
+		for _, info := range lResp.UploadInfos {
+			if info.RealFilePath == terminatedPath {
+				t.Fatalf("upload should be terminated (%+v)", info)
+			}
+		}
+
+		// zero-length uploads are completed at creation
+		emptyPath := "qs/files/tus/empty"
+		resp, _, errs = adminFilesClient.TusCreate(emptyPath, 0)
+		assertResp(t, resp, errs, 201, "tus create zero-length upload")
+		uploadURL = resp.Header.Get("Location")
+		resp, _, errs = adminFilesClient.TusOffset(uploadURL)
+		assertResp(t, resp, errs, 200, "tus offset of zero-length upload")
+		if resp.Header.Get(fileshdr.TusUploadOffsetHeader) != "0" ||
+			resp.Header.Get(fileshdr.TusUploadLengthHeader) != "0" {
+			t.Fatalf("incorrect offset or length (%+v)", resp.Header)
+		}
+		resp, _, errs = adminFilesClient.TusPatch(uploadURL, []byte{}, 0, "")
+		assertResp(t, resp, errs, 204, "tus patch zero-length upload")
+		resp, _, errs = adminFilesClient.TusPatch(uploadURL, []byte("a"), 0, "")
+		assertResp(t, resp, errs, 400, "tus patch exceeding zero-length upload")
+		resp, body, errs := adminFilesClient.Download(emptyPath, map[string]string{})
+		assertResp(t, resp, errs, 200, "download zero-length upload")
+		if body != "" {
+			t.Fatalf("incorrect content (%s)", body)
+		}
+	})
+
 	t.Run("test download APIs: Download(normal, ranges)", func(t *testing.T) {
 		for filePath, content := range map[string]string{
 			"qs/files/download/path1/f1":    "123456",
@@ -403,6 +1106,628 @@ func TestFileHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("test archive APIs: Upload-ArchiveDir(zip, tar.gz)-AddSharing-ArchiveDir(visitor)", func(t *testing.T) {
+		dirPath := "qs/files/archive/root"
+		files := map[string]string{
+			"f1":          "123456",
+			"sub/f2":      "12345678",
+			"sub/sub/f3":  "abc",
+			"sub2/f4.txt": "f4",
+		}
+		for filePath, content := range files {
+			assertUploadOK(t, filepath.Join(dirPath, filePath), content, addr, token)
+		}
+		err = fs.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{}
+		for filePath, content := range files {
+			expected[path.Join("root", filePath)] = content
+		}
+		visitorFilesCl := client.NewFilesClient(addr, &http.Cookie{Name: q.TokenCookie, Value: ""})
+
+		assertArchiveOK := func(cl *client.FilesClient, format string, expected map[string]string) {
+			resp, body, errs := cl.ArchiveDir(dirPath, format)
+			assertResp(t, resp, errs, 200, "archive dir")
+			if resp.Header.Get("Content-Disposition") != fmt.Sprintf(`attachment; filename="root.%s"`, format) {
+				t.Fatalf("incorrect Content-Disposition header: %s", resp.Header.Get("Content-Disposition"))
+			}
+
+			got, err := readArchive([]byte(body), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(expected) {
+				t.Fatalf("archived files not match: got(%+v) expected(%+v)", got, expected)
+			}
+			for filePath, content := range expected {
+				if got[filePath] != content {
+					t.Fatalf("content of %s not match: got(%s) expected(%s)", filePath, got[filePath], content)
+				}
+			}
+		}
+
+		for _, format := range []string{fileshdr.ZipFormat, fileshdr.TarGzFormat} {
+			assertArchiveOK(adminFilesClient, format, expected)
+
+			resp, _, errs := visitorFilesCl.ArchiveDir(dirPath, format)
+			assertResp(t, resp, errs, 403, "archive unshared dir")
+		}
+
+		resp, _, errs := adminFilesClient.ArchiveDir(dirPath, "rar")
+		assertResp(t, resp, errs, 400, "unsupported format")
+		resp, _, errs = adminFilesClient.ArchiveDir(filepath.Join(dirPath, "f1"), fileshdr.ZipFormat)
+		assertResp(t, resp, errs, 400, "archive a file")
+
+		// sub-folders of sharings are not shared
+		resp, _, errs = adminFilesClient.AddSharing(dirPath)
+		assertResp(t, resp, errs, 200, "add sharing")
+		expectedShared := map[string]string{"root/f1": files["f1"]}
+		for _, format := range []string{fileshdr.ZipFormat, fileshdr.TarGzFormat} {
+			assertArchiveOK(visitorFilesCl, format, expectedShared)
+			assertArchiveOK(userFilesCl, format, expectedShared)
+		}
+		// archiving is counted as downloading the sharing
+		resp, shRes, errs := adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		if stat := shRes.Stats[dirPath]; stat == nil || stat.DownloadCount != 4 {
+			t.Fatalf("incorrect sharing stat: %+v", stat)
+		}
+		resp, _, errs = visitorFilesCl.List(filepath.Join(dirPath, "sub"))
+		assertResp(t, resp, errs, 403, "list sub-folder of sharing")
+
+		resp, _, errs = adminFilesClient.DelSharing(dirPath)
+		assertResp(t, resp, errs, 200, "del sharing")
+		resp, _, errs = adminFilesClient.Delete("qs/files/archive")
+		assertResp(t, resp, errs, 200, "delete archive dir")
+	})
+
+	t.Run("test file sharing: Upload-AddSharing-GetSharingDir-DownloadSharing-Download-DelSharing", func(t *testing.T) {
+		dirPath := "qs/files/file_sharing"
+		sharedPath := path.Join(dirPath, "shared 文件.txt")
+		privatePath := path.Join(dirPath, "private")
+		content := "shared file"
+		assertUploadOK(t, sharedPath, content, addr, token)
+		assertUploadOK(t, privatePath, "private file", addr, token)
+		visitorFilesCl := client.NewFilesClient(addr, &http.Cookie{Name: q.TokenCookie, Value: ""})
+
+		resp, _, errs := adminFilesClient.AddSharing(sharedPath)
+		assertResp(t, resp, errs, 200, "share file")
+		resp, shRes, errs := adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		shareID, ok := shRes.IDs[sharedPath]
+		if !ok {
+			t.Fatalf("shared file not found: %+v", shRes.IDs)
+		}
+
+		resp, sharingPath, errs := visitorFilesCl.GetSharingDir(shareID)
+		assertResp(t, resp, errs, 200, "get sharing")
+		if sharingPath != sharedPath {
+			t.Fatalf("incorrect sharing path: %s", sharingPath)
+		}
+
+		resp, body, errs := visitorFilesCl.DownloadSharing(shareID)
+		assertResp(t, resp, errs, 200, "download sharing")
+		if body != content {
+			t.Fatalf("incorrect content: %s", body)
+		}
+		expectedDisposition := fmt.Sprintf(
+			`attachment; filename="shared __.txt"; filename*=UTF-8''%s`,
+			url.PathEscape("shared 文件.txt"),
+		)
+		if resp.Header.Get("Content-Disposition") != expectedDisposition {
+			t.Fatalf("incorrect Content-Disposition header: %s", resp.Header.Get("Content-Disposition"))
+		}
+
+		// only the shared file is accessible
+		assertDownloadOK(t, sharedPath, content, addr, userUsersToken)
+		resp, _, errs = userFilesCl.Download(privatePath, map[string]string{})
+		assertResp(t, resp, errs, 403, "download unshared file")
+		resp, _, errs = userFilesCl.List(dirPath)
+		assertResp(t, resp, errs, 403, "list folder of shared file")
+
+		resp, _, errs = visitorFilesCl.DownloadSharing("not_exist")
+		assertResp(t, resp, errs, 404, "download not existing sharing")
+		resp, _, errs = adminFilesClient.DelSharing(sharedPath)
+		assertResp(t, resp, errs, 200, "delete sharing")
+		resp, _, errs = visitorFilesCl.DownloadSharing(shareID)
+		assertResp(t, resp, errs, 404, "download deleted sharing")
+	})
+
+	t.Run("test expiring sharing: Upload-AddExpiringSharing-ListSharingIDs-IsSharing-GetSharingDir-List", func(t *testing.T) {
+		dirPath := "qs/files/expiring_sharing"
+		filePath := path.Join(dirPath, "file")
+		assertUploadOK(t, filePath, "expiring", addr, token)
+
+		resp, _, errs := adminFilesClient.AddExpiringSharing(dirPath, time.Now().Add(-time.Second).Unix())
+		assertResp(t, resp, errs, 400, "share with passed expiry")
+
+		resp, _, errs = adminFilesClient.AddExpiringSharing(dirPath, time.Now().Add(time.Hour).Unix())
+		assertResp(t, resp, errs, 200, "share with expiry")
+		resp, shRes, errs := adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		if ttl, ok := shRes.TTLs[dirPath]; !ok || ttl <= 0 || ttl > 3600 {
+			t.Fatalf("incorrect TTL(%d) of sharing: %+v", ttl, shRes.TTLs)
+		}
+
+		resp, _, errs = adminFilesClient.AddExpiringSharing(dirPath, time.Now().Add(2*time.Second).Unix())
+		assertResp(t, resp, errs, 200, "share with short expiry")
+		resp, shRes, errs = adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		shareID := shRes.IDs[dirPath]
+		resp, _, errs = userFilesCl.List(dirPath)
+		assertResp(t, resp, errs, 200, "list unexpired sharing")
+
+		time.Sleep(3 * time.Second)
+		resp, _, errs = adminFilesClient.IsSharing(dirPath)
+		assertResp(t, resp, errs, 404, "expired sharing")
+		resp, _, errs = adminFilesClient.GetSharingDir(shareID)
+		assertResp(t, resp, errs, 404, "get expired sharing")
+		resp, _, errs = userFilesCl.List(dirPath)
+		assertResp(t, resp, errs, 403, "list expired sharing")
+		resp, shRes, errs = adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		if _, ok := shRes.IDs[dirPath]; ok {
+			t.Fatalf("expired sharing should not be listed: %+v", shRes.IDs)
+		}
+	})
+
+	t.Run("test protected sharing: Upload-AddProtectedSharing-List-UnlockSharing-List-DownloadSharing", func(t *testing.T) {
+		dirPath := "qs/files/protected_sharing"
+		subDirPath := path.Join(dirPath, "sub")
+		filePath := "qs/files/protected_file/doc"
+		content := "protected"
+		assertUploadOK(t, path.Join(subDirPath, "f1"), "f1", addr, token)
+		assertUploadOK(t, path.Join(dirPath, "f2"), "f2", addr, token)
+		assertUploadOK(t, filePath, content, addr, token)
+
+		resp, _, errs := adminFilesClient.AddProtectedSharing(dirPath, "dir_pwd")
+		assertResp(t, resp, errs, 200, "share protected folder")
+		resp, _, errs = adminFilesClient.AddProtectedSharing(filePath, "file_pwd")
+		assertResp(t, resp, errs, 200, "share protected file")
+		resp, shRes, errs := adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		dirShareID, fileShareID := shRes.IDs[dirPath], shRes.IDs[filePath]
+
+		visitorFilesCl := client.NewFilesClient(addr, &http.Cookie{Name: q.TokenCookie, Value: ""})
+		resp, _, errs = visitorFilesCl.List(dirPath)
+		assertResp(t, resp, errs, 403, "list locked sharing")
+		resp, _, errs = visitorFilesCl.DownloadSharing(fileShareID)
+		assertResp(t, resp, errs, 403, "download locked sharing")
+		resp, _, errs = userFilesCl.List(dirPath)
+		assertResp(t, resp, errs, 403, "list locked sharing by user")
+
+		resp, _, errs = visitorFilesCl.UnlockSharing(dirShareID, "wrong_pwd")
+		assertResp(t, resp, errs, 403, "unlock with wrong password")
+		resp, _, errs = visitorFilesCl.UnlockSharing("not_exist", "dir_pwd")
+		assertResp(t, resp, errs, 404, "unlock not existing sharing")
+
+		getShareToken := func(shareID, pwd string) *http.Cookie {
+			resp, _, errs := visitorFilesCl.UnlockSharing(shareID, pwd)
+			assertResp(t, resp, errs, 200, "unlock sharing")
+			for _, cookie := range resp.Cookies() {
+				if cookie.Name == q.ShareTokenCookie {
+					return cookie
+				}
+			}
+			t.Fatal("share token not found")
+			return nil
+		}
+
+		// the share token grants the subtree of the sharing only
+		dirUnlockedCl := client.NewFilesClient(addr, getShareToken(dirShareID, "dir_pwd"))
+		resp, _, errs = dirUnlockedCl.List(dirPath)
+		assertResp(t, resp, errs, 200, "list unlocked sharing")
+		resp, _, errs = dirUnlockedCl.List(subDirPath)
+		assertResp(t, resp, errs, 200, "list sub folder of unlocked sharing")
+		resp, _, errs = dirUnlockedCl.List("qs/files")
+		assertResp(t, resp, errs, 403, "list parent of unlocked sharing")
+		resp, _, errs = dirUnlockedCl.DownloadSharing(fileShareID)
+		assertResp(t, resp, errs, 403, "download another locked sharing")
+
+		fileUnlockedCl := client.NewFilesClient(addr, getShareToken(fileShareID, "file_pwd"))
+		resp, body, errs := fileUnlockedCl.DownloadSharing(fileShareID)
+		assertResp(t, resp, errs, 200, "download unlocked sharing")
+		if body != content {
+			t.Fatalf("incorrect content: %s", body)
+		}
+
+		// attempts of unlocking are limited
+		for i := 0; ; i++ {
+			resp, _, errs = visitorFilesCl.UnlockSharing(fileShareID, "wrong_pwd")
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			} else if resp.StatusCode == 429 {
+				break
+			} else if i > 20 {
+				t.Fatal("unlocking attempts are not limited")
+			}
+			assertResp(t, resp, errs, 403, "unlock with wrong password")
+		}
+		resp, _, errs = visitorFilesCl.UnlockSharing(fileShareID, "file_pwd")
+		assertResp(t, resp, errs, 429, "unlock after too many attempts")
+
+		// the share token is invalid after the sharing is deleted
+		for _, sharingPath := range []string{dirPath, filePath} {
+			resp, _, errs = adminFilesClient.DelSharing(sharingPath)
+			assertResp(t, resp, errs, 200, "delete sharing")
+		}
+		resp, _, errs = dirUnlockedCl.List(dirPath)
+		assertResp(t, resp, errs, 403, "list deleted sharing")
+	})
+
+	t.Run("test sharing stats: Upload-AddLimitedSharing-List-DownloadSharing-ListSharingIDs", func(t *testing.T) {
+		dirPath := "qs/files/sharing_stats"
+		filePath := "qs/files/sharing_stats_file/doc"
+		content := "limited"
+		assertUploadOK(t, path.Join(dirPath, "f"), "f", addr, token)
+		assertUploadOK(t, filePath, content, addr, token)
+
+		resp, _, errs := adminFilesClient.AddLimitedSharing(filePath, -1)
+		assertResp(t, resp, errs, 400, "share with invalid max downloads")
+		resp, _, errs = adminFilesClient.AddSharing(dirPath)
+		assertResp(t, resp, errs, 200, "share folder")
+		resp, _, errs = adminFilesClient.AddLimitedSharing(filePath, 3)
+		assertResp(t, resp, errs, 200, "share file with max downloads")
+		resp, shRes, errs := adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		shareID := shRes.IDs[filePath]
+
+		// accesses of owners are not counted
+		resp, _, errs = adminFilesClient.List(dirPath)
+		assertResp(t, resp, errs, 200, "list by owner")
+		resp, _, errs = userFilesCl.List(dirPath)
+		assertResp(t, resp, errs, 200, "list sharing")
+		visitorFilesCl := client.NewFilesClient(addr, &http.Cookie{Name: q.TokenCookie, Value: ""})
+		resp, _, errs = visitorFilesCl.DownloadSharing(shareID)
+		assertResp(t, resp, errs, 200, "download sharing")
+
+		resp, shRes, errs = adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		dirStat, fileStat := shRes.Stats[dirPath], shRes.Stats[filePath]
+		if dirStat == nil || dirStat.ListCount != 1 || dirStat.DownloadCount != 0 {
+			t.Fatalf("incorrect folder stat: %+v", dirStat)
+		} else if fileStat == nil || fileStat.DownloadCount != 1 || fileStat.MaxDownloads != 3 {
+			t.Fatalf("incorrect file stat: %+v", fileStat)
+		} else if fileStat.LastIP == "" || fileStat.LastAccess.IsZero() {
+			t.Fatalf("last access is not recorded: %+v", fileStat)
+		}
+
+		// range requests of a client are counted once in a period
+		for _, rangeVal := range []string{"bytes=1-", "bytes=2-"} {
+			resp, _, errs = userFilesCl.Download(filePath, map[string]string{"Range": rangeVal})
+			assertResp(t, resp, errs, 206, "download range of sharing")
+		}
+		resp, shRes, errs = adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		if fileStat = shRes.Stats[filePath]; fileStat == nil || fileStat.DownloadCount != 2 {
+			t.Fatalf("incorrect file stat: %+v", fileStat)
+		}
+
+		// the sharing is disabled after reaching the max downloads
+		resp, body, errs := visitorFilesCl.DownloadSharing(shareID)
+		assertResp(t, resp, errs, 200, "download sharing")
+		if body != content {
+			t.Fatalf("incorrect content: %s", body)
+		}
+		resp, _, errs = visitorFilesCl.DownloadSharing(shareID)
+		assertResp(t, resp, errs, 404, "download disabled sharing")
+		// range requests counted in the window are still served
+		resp, _, errs = userFilesCl.Download(filePath, map[string]string{"Range": "bytes=3-"})
+		assertResp(t, resp, errs, 206, "resume range download of disabled sharing")
+		resp, shRes, errs = adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing IDs")
+		if _, ok := shRes.IDs[filePath]; ok {
+			t.Fatalf("disabled sharing should not be listed: %+v", shRes.IDs)
+		} else if _, ok := shRes.Stats[filePath]; ok {
+			t.Fatalf("stats of disabled sharing should not be listed: %+v", shRes.Stats)
+		}
+
+		resp, _, errs = adminFilesClient.DelSharing(dirPath)
+		assertResp(t, resp, errs, 200, "delete sharing")
+	})
+
+	t.Run("test drop sharing: Mkdir-AddDropSharing-Create(visitor)-UploadChunk(visitor)-List", func(t *testing.T) {
+		resp, selfResp, errs := userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		usedBefore := selfResp.UsedSpace
+
+		dropPath := "demo/files/drop"
+		resp, _, errs = userFilesCl.Mkdir(dropPath)
+		assertResp(t, resp, errs, 200, "mkdir")
+		assertUploadOK(t, "demo/files/drop_file", "file", addr, userUsersToken)
+
+		resp, _, errs = userFilesCl.AddDropSharing(dropPath, 0, 0)
+		assertResp(t, resp, errs, 400, "share drop without max size")
+		resp, _, errs = userFilesCl.AddDropSharing("demo/files/drop_file", 10, 0)
+		assertResp(t, resp, errs, 400, "share file as drop")
+		resp, _, errs = userFilesCl.AddDropSharing(dropPath, 8, 2)
+		assertResp(t, resp, errs, 200, "share drop")
+
+		visitorFilesCl := client.NewFilesClient(addr, &http.Cookie{Name: q.TokenCookie, Value: ""})
+		uploadByVisitor := func(filePath, content string, expectedCode int) {
+			resp, _, errs := visitorFilesCl.Create(filePath, int64(len(content)))
+			assertResp(t, resp, errs, expectedCode, "create in drop")
+			if expectedCode != 200 {
+				return
+			}
+			resp, _, errs = visitorFilesCl.UploadChunk(filePath, base64.StdEncoding.EncodeToString([]byte(content)), 0)
+			assertResp(t, resp, errs, 200, "upload chunk in drop")
+		}
+		uploadByVisitor(path.Join(dropPath, "f1"), "12345", 200)
+
+		// usage of abandoned uploads is released after cleaning them up
+		resp, _, errs = visitorFilesCl.Create(path.Join(dropPath, "f2"), 3)
+		assertResp(t, resp, errs, 200, "create in drop")
+		resp, _, errs = userFilesCl.DelUploading(path.Join(dropPath, "f2"))
+		assertResp(t, resp, errs, 200, "delete abandoned upload in drop")
+
+		uploadByVisitor(path.Join(dropPath, "f1"), "1", 400)      // no overwriting
+		uploadByVisitor(path.Join(dropPath, "f2"), "123456", 403) // exceeds the size cap
+		uploadByVisitor(path.Join(dropPath, "f2"), "123", 200)
+		uploadByVisitor(path.Join(dropPath, "f3"), "", 403)      // exceeds the file count cap
+		uploadByVisitor(path.Join(dropPath, "sub/f4"), "1", 403) // only the folder itself accepts files
+		uploadByVisitor("demo/files/drop_outside", "1", 403)
+
+		// visitors can not see files in the drop
+		resp, _, errs = visitorFilesCl.List(dropPath)
+		assertResp(t, resp, errs, 403, "list drop")
+		resp, _, errs = adminFilesClient.List(dropPath)
+		assertResp(t, resp, errs, 200, "list drop by admin")
+		resp, lsResp, errs := userFilesCl.List(dropPath)
+		assertResp(t, resp, errs, 200, "list drop by owner")
+		if len(lsResp.Metadatas) != 2 {
+			t.Fatalf("incorrect uploaded files: %+v", lsResp.Metadatas)
+		}
+		assertDownloadOK(t, path.Join(dropPath, "f1"), "12345", addr, userUsersToken)
+
+		// uploads are charged to the owner
+		resp, selfResp, errs = userUsersCl.Self()
+		assertResp(t, resp, errs, 200, "self")
+		if selfResp.UsedSpace != usedBefore+int64(len("file12345123")) {
+			t.Fatalf("incorrect used space %d %d", selfResp.UsedSpace, usedBefore)
+		}
+
+		resp, _, errs = userFilesCl.DelSharing(dropPath)
+		assertResp(t, resp, errs, 200, "delete drop")
+		uploadByVisitor(path.Join(dropPath, "f5"), "1", 403)
+	})
+
+	t.Run("test acls: Mkdir-AddFileACL-List-Download-Mkdir-UploadChunk-Delete-ListSharedWithMe-DelFileACL", func(t *testing.T) {
+		projPath := "qs/files/projects/foo"
+		resp, _, errs := adminFilesClient.Mkdir(projPath)
+		assertResp(t, resp, errs, 200, "mkdir")
+		assertUploadOK(t, path.Join(projPath, "readme.md"), "readme", addr, token)
+
+		// demo can not access the folder before it is shared
+		resp, _, errs = userFilesCl.List(projPath)
+		assertResp(t, resp, errs, 403, "list before granting")
+		resp, _, errs = userFilesCl.AddFileACL(projPath, "qs", db.PermRead)
+		assertResp(t, resp, errs, 403, "grant by non-owner")
+		resp, _, errs = adminFilesClient.AddFileACL(projPath, "demo", "x")
+		assertResp(t, resp, errs, 400, "grant invalid permission")
+		resp, _, errs = adminFilesClient.AddFileACL(projPath, "not_existing", db.PermRead)
+		assertResp(t, resp, errs, 404, "grant to missing user")
+
+		resp, _, errs = adminFilesClient.AddFileACL(projPath, "demo", db.PermRead)
+		assertResp(t, resp, errs, 200, "grant read")
+
+		// read grants apply to the subtree
+		resp, lsResp, errs := userFilesCl.List(projPath)
+		assertResp(t, resp, errs, 200, "list with read grant")
+		if len(lsResp.Metadatas) != 1 || lsResp.Metadatas[0].Name != "readme.md" {
+			t.Fatalf("incorrect listing: %+v", lsResp.Metadatas)
+		}
+		assertDownloadOK(t, path.Join(projPath, "readme.md"), "readme", addr, userUsersToken)
+		resp, _, errs = userFilesCl.List("qs/files/projects")
+		assertResp(t, resp, errs, 403, "list parent folder")
+
+		// writing is denied
+		resp, _, errs = userFilesCl.Mkdir(path.Join(projPath, "sub"))
+		assertResp(t, resp, errs, 403, "mkdir with read grant")
+		resp, _, errs = userFilesCl.Create(path.Join(projPath, "new.md"), 3)
+		assertResp(t, resp, errs, 403, "create with read grant")
+		resp, _, errs = userFilesCl.Delete(path.Join(projPath, "readme.md"))
+		assertResp(t, resp, errs, 403, "delete with read grant")
+		resp, _, errs = userFilesCl.Move(path.Join(projPath, "readme.md"), path.Join(projPath, "moved.md"))
+		assertResp(t, resp, errs, 403, "move with read grant")
+		resp, _, errs = userFilesCl.AddSharing(projPath)
+		assertResp(t, resp, errs, 403, "share with read grant")
+
+		// granting again upgrades the permission
+		resp, _, errs = adminFilesClient.AddFileACL(projPath, "demo", db.PermReadWrite)
+		assertResp(t, resp, errs, 200, "grant read-write")
+		resp, _, errs = userFilesCl.Mkdir(path.Join(projPath, "sub"))
+		assertResp(t, resp, errs, 200, "mkdir with read-write grant")
+		assertUploadOK(t, path.Join(projPath, "sub/new.md"), "new", addr, userUsersToken)
+		resp, _, errs = userFilesCl.Move(path.Join(projPath, "sub/new.md"), path.Join(projPath, "moved.md"))
+		assertResp(t, resp, errs, 200, "move with read-write grant")
+		resp, _, errs = userFilesCl.Delete(path.Join(projPath, "moved.md"))
+		assertResp(t, resp, errs, 200, "delete with read-write grant")
+		resp, _, errs = userFilesCl.AddSharing(projPath)
+		assertResp(t, resp, errs, 403, "share with read-write grant")
+
+		resp, sharedResp, errs := userFilesCl.ListSharedWithMe()
+		assertResp(t, resp, errs, 200, "list shared with me")
+		if len(sharedResp.ACLs) != 1 ||
+			sharedResp.ACLs[0].Path != projPath ||
+			sharedResp.ACLs[0].Perm != db.PermReadWrite {
+			t.Fatalf("incorrect shared items: %+v", sharedResp.ACLs)
+		}
+		aclID := sharedResp.ACLs[0].ID
+
+		resp, _, errs = userFilesCl.ListFileACLs(projPath)
+		assertResp(t, resp, errs, 403, "list acls by grantee")
+		resp, aclsResp, errs := adminFilesClient.ListFileACLs(projPath)
+		assertResp(t, resp, errs, 200, "list acls")
+		if len(aclsResp.ACLs) != 1 || aclsResp.ACLs[0].ID != aclID {
+			t.Fatalf("incorrect acls: %+v", aclsResp.ACLs)
+		}
+
+		resp, _, errs = userFilesCl.DelFileACL(aclID)
+		assertResp(t, resp, errs, 404, "revoke by grantee")
+		resp, _, errs = adminFilesClient.DelFileACL(aclID)
+		assertResp(t, resp, errs, 200, "revoke")
+		resp, _, errs = userFilesCl.List(projPath)
+		assertResp(t, resp, errs, 403, "list after revoking")
+		resp, sharedResp, errs = userFilesCl.ListSharedWithMe()
+		assertResp(t, resp, errs, 200, "list shared with me")
+		if len(sharedResp.ACLs) != 0 {
+			t.Fatalf("incorrect shared items: %+v", sharedResp.ACLs)
+		}
+
+		resp, _, errs = adminFilesClient.Delete("qs/files/projects")
+		assertResp(t, resp, errs, 200, "delete")
+	})
+
+	t.Run("test share IDs: Mkdir-AddSharing-AddSlugSharing-GetSharingDir-DelSharing", func(t *testing.T) {
+		dirPath, otherPath := "qs/files/slug", "qs/files/slug_other"
+		for _, itemPath := range []string{dirPath, otherPath} {
+			resp, _, errs := adminFilesClient.Mkdir(itemPath)
+			assertResp(t, resp, errs, 200, "mkdir")
+		}
+
+		resp, _, errs := adminFilesClient.AddSharing(dirPath)
+		assertResp(t, resp, errs, 200, "add sharing")
+		resp, shRes, errs := adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing ids")
+		if len(shRes.IDs[dirPath]) != 12 {
+			t.Fatalf("incorrect generated share ID: %s", shRes.IDs[dirPath])
+		}
+
+		slugs := []struct {
+			slug         string
+			expectedCode int
+		}{
+			{"q", 400}, // too short
+			{"-q3-report", 400},
+			{"q3 report", 400},
+			{"q3/report", 400},
+			{"Public", 400}, // reserved
+			{shRes.IDs[dirPath], 200},
+			{"q3-report", 200},
+		}
+		for _, slug := range slugs {
+			resp, _, errs = adminFilesClient.AddSlugSharing(dirPath, slug.slug)
+			assertResp(t, resp, errs, slug.expectedCode, fmt.Sprintf("add slug sharing(%s)", slug.slug))
+		}
+		resp, _, errs = adminFilesClient.AddSlugSharing(otherPath, "q3-report")
+		assertResp(t, resp, errs, 400, "add existing slug")
+
+		resp, _, errs = adminFilesClient.GetSharingDir("q3-report")
+		assertResp(t, resp, errs, 200, "get sharing dir by slug")
+		resp, shRes, errs = adminFilesClient.ListSharingIDs()
+		assertResp(t, resp, errs, 200, "list sharing ids")
+		if shRes.IDs[dirPath] != "q3-report" {
+			t.Fatalf("incorrect share ID: %s", shRes.IDs[dirPath])
+		}
+
+		resp, _, errs = adminFilesClient.DelSharing(dirPath)
+		assertResp(t, resp, errs, 200, "del sharing")
+		resp, _, errs = adminFilesClient.AddSlugSharing(otherPath, "q3-report")
+		assertResp(t, resp, errs, 200, "add released slug")
+		resp, _, errs = adminFilesClient.DelSharing(otherPath)
+		assertResp(t, resp, errs, 200, "del sharing")
+
+		for _, itemPath := range []string{dirPath, otherPath} {
+			resp, _, errs = adminFilesClient.Delete(itemPath)
+			assertResp(t, resp, errs, 200, "delete")
+		}
+	})
+
+	t.Run("test presigned downloads: Upload-PresignDownload-DownloadPresigned", func(t *testing.T) {
+		filePath, otherPath := "qs/files/presigned/ci.tar", "qs/files/presigned/other.tar"
+		content := "ci artifacts"
+		assertUploadOK(t, filePath, content, addr, token)
+		assertUploadOK(t, otherPath, "other", addr, token)
+
+		resp, _, errs := userFilesCl.PresignDownload(filePath, 60)
+		assertResp(t, resp, errs, 403, "presign by others")
+		resp, _, errs = adminFilesClient.PresignDownload(filePath, 0)
+		assertResp(t, resp, errs, 400, "presign without ttl")
+		resp, _, errs = adminFilesClient.PresignDownload("qs/files/presigned", 60)
+		assertResp(t, resp, errs, 400, "presign folder")
+		resp, presignResp, errs := adminFilesClient.PresignDownload(filePath, 60)
+		assertResp(t, resp, errs, 200, "presign")
+
+		visitorFilesCl := client.NewFilesClient(addr, &http.Cookie{Name: q.TokenCookie, Value: ""})
+		resp, body, errs := visitorFilesCl.DownloadPresigned(presignResp.URL)
+		assertResp(t, resp, errs, 200, "download presigned")
+		if body != content {
+			t.Fatalf("incorrect content: %s", body)
+		}
+
+		// the URL is bound to the path and the expiry
+		presignedURL, err := url.Parse(presignResp.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := presignedURL.Query()
+		query.Set(fileshdr.FilePathQuery, otherPath)
+		resp, _, errs = visitorFilesCl.DownloadPresigned(fmt.Sprintf("%s?%s", presignedURL.Path, query.Encode()))
+		assertResp(t, resp, errs, 403, "download other file")
+
+		query = presignedURL.Query()
+		query.Set(fileshdr.PresignExpQuery, fmt.Sprint(presignResp.ExpireAt+3600))
+		resp, _, errs = visitorFilesCl.DownloadPresigned(fmt.Sprintf("%s?%s", presignedURL.Path, query.Encode()))
+		assertResp(t, resp, errs, 403, "download with extended expiry")
+
+		query = presignedURL.Query()
+		query.Set(fileshdr.PresignExpQuery, fmt.Sprint(time.Now().Unix()-1))
+		resp, _, errs = visitorFilesCl.DownloadPresigned(fmt.Sprintf("%s?%s", presignedURL.Path, query.Encode()))
+		assertResp(t, resp, errs, 403, "download expired")
+
+		query = presignedURL.Query()
+		query.Set(fileshdr.PresignUserQuery, fmt.Sprint(db.VisitorID))
+		resp, _, errs = visitorFilesCl.DownloadPresigned(fmt.Sprintf("%s?%s", presignedURL.Path, query.Encode()))
+		assertResp(t, resp, errs, 403, "download with other minter")
+
+		// the URL is revoked with the access of its minter
+		resp, addResp, errs := usersCl.AddUser("presigner", "1234", db.UserRole)
+		assertResp(t, resp, errs, 200, "add user")
+		presignerId, err := strconv.ParseUint(addResp.ID, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		presignerUsersCl := client.NewUsersClient(addr)
+		resp, _, errs = presignerUsersCl.Login("presigner", "1234")
+		assertResp(t, resp, errs, 200, "login")
+		presignerFilesCl := client.NewFilesClient(addr, presignerUsersCl.Token())
+
+		resp, _, errs = adminFilesClient.AddFileACL(otherPath, "presigner", db.PermRead)
+		assertResp(t, resp, errs, 200, "grant")
+		resp, presignResp, errs = presignerFilesCl.PresignDownload(otherPath, 60)
+		assertResp(t, resp, errs, 200, "presign granted file")
+		resp, _, errs = visitorFilesCl.DownloadPresigned(presignResp.URL)
+		assertResp(t, resp, errs, 200, "download presigned granted file")
+		resp, aclsResp, errs := adminFilesClient.ListFileACLs(otherPath)
+		assertResp(t, resp, errs, 200, "list acls")
+		for _, acl := range aclsResp.ACLs {
+			resp, _, errs = adminFilesClient.DelFileACL(acl.ID)
+			assertResp(t, resp, errs, 200, "revoke")
+		}
+		resp, _, errs = visitorFilesCl.DownloadPresigned(presignResp.URL)
+		assertResp(t, resp, errs, 403, "download presigned revoked file")
+
+		ownPath := "presigner/files/own.tar"
+		assertUploadOK(t, ownPath, "own", addr, presignerUsersCl.Token())
+		resp, presignResp, errs = presignerFilesCl.PresignDownload(ownPath, 60)
+		assertResp(t, resp, errs, 200, "presign own file")
+		resp, _, errs = usersCl.SetUser(presignerId, db.BannedRole, &db.Quota{})
+		assertResp(t, resp, errs, 200, "ban")
+		resp, _, errs = visitorFilesCl.DownloadPresigned(presignResp.URL)
+		assertResp(t, resp, errs, 403, "download presigned by banned user")
+		resp, _, errs = usersCl.DelUser(addResp.ID)
+		assertResp(t, resp, errs, 200, "delete user")
+		resp, _, errs = visitorFilesCl.DownloadPresigned(presignResp.URL)
+		assertResp(t, resp, errs, 403, "download presigned by deleted user")
+
+		resp, _, errs = adminFilesClient.Delete("qs/files/presigned")
+		assertResp(t, resp, errs, 200, "delete")
+	})
+
 	t.Run("test sharing APIs: Upload-AddSharing-ListSharings-IsSharing-List-Download-DelSharing-ListSharings", func(t *testing.T) {
 		files := map[string]string{
 			"qs/files/sharing/path1/f1": "123456",
@@ -431,11 +1756,6 @@ func TestFileHandlers(t *testing.T) {
 					t.Fatal(res.StatusCode)
 				}
 
-				res, _, errs = adminFilesClient.AddSharing(filePath)
-				if res.StatusCode != 400 {
-					t.Fatal(res.StatusCode)
-				}
-
 				res, _, errs = adminFilesClient.AddSharing(filepath.Join(filePath, "not_exist"))
 				if res.StatusCode != 500 {
 					t.Fatal(res.StatusCode)
@@ -1098,3 +2418,133 @@ func TestFileHandlers(t *testing.T) {
 		t.Fatal(resp.StatusCode)
 	}
 }
+
+// readArchive returns contents of files in the archive, folders are skipped
+func readArchive(data []byte, format string) (map[string]string, error) {
+	contents := map[string]string{}
+	switch format {
+	case fileshdr.ZipFormat:
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range zr.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+			fr, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			content, err := io.ReadAll(fr)
+			fr.Close()
+			if err != nil {
+				return nil, err
+			}
+			contents[file.Name] = string(content)
+		}
+	case fileshdr.TarGzFormat:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gr)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if header.Typeflag == tar.TypeDir {
+				continue
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			contents[header.Name] = string(content)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+	return contents, nil
+}
+
+func TestTrashPurging(t *testing.T) {
+	addr := "http://127.0.0.1:8686"
+	rootPath := "tmpTestData"
+	config := `{
+		"users": {
+			"enableAuth": true,
+			"minUserNameLen": 2,
+			"minPwdLen": 4,
+			"captchaEnabled": false
+		},
+		"server": {
+			"debug": true,
+			"host": "127.0.0.1"
+		},
+		"fs": {
+			"root": "tmpTestData",
+			"trashTTL": 1,
+			"trashPurgeSpec": "@every 1s"
+		},
+		"db": {
+			"dbPath": "tmpTestData/quickshare"
+		}
+	}`
+
+	adminName := "qs"
+	adminPwd := "quicksh@re"
+	setUpEnv(t, rootPath, adminName, adminPwd)
+	defer os.RemoveAll(rootPath)
+
+	srv := startTestServer(config)
+	defer srv.Shutdown()
+	fs := srv.depsFS()
+	if !isServerReady(addr) {
+		t.Fatal("fail to start server")
+	}
+
+	usersCl := client.NewUsersClient(addr)
+	resp, _, errs := usersCl.Login(adminName, adminPwd)
+	assertResp(t, resp, errs, 200, "login")
+	token := usersCl.Token()
+	filesCl := client.NewFilesClient(addr, token)
+
+	var err error
+	t.Run("expired trashes are purged", func(t *testing.T) {
+		filePath := "qs/files/expiring/f1"
+		assertUploadOK(t, filePath, "123456", addr, token)
+		err = fs.Sync()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, _, errs := filesCl.Delete(filePath)
+		assertResp(t, resp, errs, 200, "delete")
+		resp, lsResp, errs := filesCl.ListTrashes()
+		assertResp(t, resp, errs, 200, "list trashes")
+		if len(lsResp.Trashes) != 1 {
+			t.Fatalf("incorrect trashes size (%d)", len(lsResp.Trashes))
+		}
+		trashPath := lsResp.Trashes[0].TrashPath
+
+		for i := 0; ; i++ {
+			resp, lsResp, errs = filesCl.ListTrashes()
+			assertResp(t, resp, errs, 200, "list trashes")
+			if len(lsResp.Trashes) == 0 {
+				break
+			} else if i > 10 {
+				t.Fatal("trashes are not purged")
+			}
+			time.Sleep(time.Second)
+		}
+
+		_, err = fs.Stat(trashPath)
+		if !os.IsNotExist(err) {
+			t.Fatalf("trash should be purged: %s", err)
+		}
+	})
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ihexxa/quickshare/src/client"
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func TestGroups(t *testing.T) {
+	addr := "http://127.0.0.1:8686"
+	rootPath := "tmpTestData"
+	config := `{
+		"users": {
+			"enableAuth": true,
+			"minUserNameLen": 2,
+			"minPwdLen": 4,
+			"captchaEnabled": false,
+			"limiterCapacity": 1000,
+			"limiterCyc": 1000
+		},
+		"server": {
+			"debug": true,
+			"host": "127.0.0.1"
+		},
+		"fs": {
+			"root": "tmpTestData"
+		},
+		"db": {
+			"dbPath": "tmpTestData/quickshare"
+		}
+	}`
+
+	adminName := "qs"
+	adminPwd := "quicksh@re"
+	setUpEnv(t, rootPath, adminName, adminPwd)
+	defer os.RemoveAll(rootPath)
+
+	srv := startTestServer(config)
+	defer srv.Shutdown()
+	if !isServerReady(addr) {
+		t.Fatal("fail to start server")
+	}
+
+	adminUsersCl := client.NewUsersClient(addr)
+	resp, _, errs := adminUsersCl.Login(adminName, adminPwd)
+	assertResp(t, resp, errs, 200, "admin login")
+
+	userPwd := "1234"
+	userIds := map[string]uint64{}
+	userCls := map[string]*client.UsersClient{}
+	for _, name := range []string{"writer", "reader", "outsider"} {
+		resp, addResp, errs := adminUsersCl.AddUser(name, userPwd, db.UserRole)
+		assertResp(t, resp, errs, 200, "add user")
+		userId, err := strconv.ParseUint(addResp.ID, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIds[name] = userId
+
+		usersCl := client.NewUsersClient(addr)
+		resp, _, errs = usersCl.Login(name, userPwd)
+		assertResp(t, resp, errs, 200, "user login")
+		userCls[name] = usersCl
+	}
+	writerFilesCl := client.NewFilesClient(addr, userCls["writer"].Token())
+	readerFilesCl := client.NewFilesClient(addr, userCls["reader"].Token())
+	outsiderFilesCl := client.NewFilesClient(addr, userCls["outsider"].Token())
+
+	groupFolder := "@devs/files"
+	var groupId uint64
+	quota := &db.Quota{
+		SpaceLimit:         16,
+		UploadSpeedLimit:   1024 * 1024,
+		DownloadSpeedLimit: 1024 * 1024,
+	}
+
+	t.Run("test groups: managing groups and members", func(t *testing.T) {
+		resp, addResp, errs := adminUsersCl.AddGroup("devs", quota)
+		assertResp(t, resp, errs, 200, "add group")
+		var err error
+		groupId, err = strconv.ParseUint(addResp.ID, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, _, errs = adminUsersCl.AddGroup("devs", quota)
+		assertResp(t, resp, errs, 400, "add existing group")
+		resp, _, errs = adminUsersCl.AddGroup("a/b", quota)
+		assertResp(t, resp, errs, 400, "add group with invalid name")
+		resp, _, errs = adminUsersCl.AddUser("@devs", userPwd, db.UserRole)
+		assertResp(t, resp, errs, 400, "add user with group prefix")
+		resp, _, errs = userCls["writer"].AddGroup("ops", quota)
+		assertResp(t, resp, errs, 403, "add group by user")
+
+		resp, _, errs = adminUsersCl.SetGroupMember(groupId, "writer", db.PermReadWrite)
+		assertResp(t, resp, errs, 200, "add writer")
+		resp, _, errs = adminUsersCl.SetGroupMember(groupId, "reader", db.PermReadWrite)
+		assertResp(t, resp, errs, 200, "add reader")
+		resp, _, errs = adminUsersCl.SetGroupMember(groupId, "reader", db.PermRead)
+		assertResp(t, resp, errs, 200, "update reader")
+		resp, _, errs = adminUsersCl.SetGroupMember(groupId, "nobody", db.PermRead)
+		assertResp(t, resp, errs, 404, "add missing user")
+		resp, _, errs = adminUsersCl.SetGroupMember(groupId, "outsider", "x")
+		assertResp(t, resp, errs, 400, "add member with invalid permission")
+
+		resp, membersResp, errs := adminUsersCl.ListGroupMembers(groupId)
+		assertResp(t, resp, errs, 200, "list members")
+		perms := map[string]string{}
+		for _, member := range membersResp.Members {
+			perms[member.User] = member.Perm
+		}
+		if len(perms) != 2 || perms["writer"] != db.PermReadWrite || perms["reader"] != db.PermRead {
+			t.Fatalf("incorrect members: %+v", perms)
+		}
+
+		resp, myResp, errs := userCls["reader"].ListMyGroups()
+		assertResp(t, resp, errs, 200, "list my groups")
+		if len(myResp.Groups) != 1 || myResp.Groups[0].Folder != groupFolder || myResp.Groups[0].Perm != db.PermRead {
+			t.Fatalf("incorrect groups: %+v", myResp.Groups)
+		}
+	})
+
+	t.Run("test groups: accessing group folders", func(t *testing.T) {
+		filePath := path.Join(groupFolder, "report.md")
+		content := "report"
+		assertUploadOK(t, filePath, content, addr, userCls["writer"].Token())
+
+		// uploads are charged to the group instead of the uploader
+		resp, lsResp, errs := adminUsersCl.ListGroups()
+		assertResp(t, resp, errs, 200, "list groups")
+		if len(lsResp.Groups) != 1 || lsResp.Groups[0].UsedSpace != int64(len(content)) {
+			t.Fatalf("incorrect groups: %+v", lsResp.Groups)
+		}
+		resp, selfResp, errs := userCls["writer"].Self()
+		assertResp(t, resp, errs, 200, "get self")
+		if selfResp.UsedSpace != 0 {
+			t.Fatalf("incorrect used space of the uploader: %d", selfResp.UsedSpace)
+		}
+
+		resp, listResp, errs := readerFilesCl.List(groupFolder)
+		assertResp(t, resp, errs, 200, "list by reader")
+		if len(listResp.Metadatas) != 1 || listResp.Metadatas[0].Name != "report.md" {
+			t.Fatalf("incorrect listing: %+v", listResp.Metadatas)
+		}
+		resp, body, errs := readerFilesCl.Download(filePath, map[string]string{})
+		assertResp(t, resp, errs, 200, "download by reader")
+		if body != content {
+			t.Fatalf("incorrect content: %s", body)
+		}
+		resp, _, errs = readerFilesCl.Create(path.Join(groupFolder, "new.md"), 1)
+		assertResp(t, resp, errs, 403, "create by reader")
+		resp, _, errs = readerFilesCl.Delete(filePath)
+		assertResp(t, resp, errs, 403, "delete by reader")
+
+		resp, _, errs = outsiderFilesCl.List(groupFolder)
+		assertResp(t, resp, errs, 403, "list by outsider")
+		resp, _, errs = outsiderFilesCl.Download(filePath, map[string]string{})
+		assertResp(t, resp, errs, 403, "download by outsider")
+
+		resp, _, errs = writerFilesCl.Create(path.Join(groupFolder, "large.md"), quota.SpaceLimit)
+		assertResp(t, resp, errs, 403, "create exceeding the group quota")
+		resp, _, errs = writerFilesCl.Move(filePath, "writer/files/report.md")
+		assertResp(t, resp, errs, 400, "move out of the group folder")
+	})
+
+	t.Run("test groups: trashing group files", func(t *testing.T) {
+		filePath := path.Join(groupFolder, "trashed.md")
+		content := "trash"
+		assertUploadOK(t, filePath, content, addr, userCls["writer"].Token())
+		resp, _, errs := writerFilesCl.Delete(filePath)
+		assertResp(t, resp, errs, 200, "delete group file")
+
+		// group files are trashed into the trash of the group instead of the trash of the deleter
+		resp, trashesResp, errs := readerFilesCl.ListTrashes()
+		assertResp(t, resp, errs, 200, "list trashes by reader")
+		if len(trashesResp.Trashes) != 1 ||
+			trashesResp.Trashes[0].UserID != groupId ||
+			trashesResp.Trashes[0].OriginPath != filePath ||
+			!strings.HasPrefix(trashesResp.Trashes[0].TrashPath, db.GroupLocation("devs")+"/") {
+			t.Fatalf("incorrect trashes: %+v", trashesResp.Trashes)
+		}
+		trashId := trashesResp.Trashes[0].ID
+		resp, trashesResp, errs = outsiderFilesCl.ListTrashes()
+		assertResp(t, resp, errs, 200, "list trashes by outsider")
+		if len(trashesResp.Trashes) != 0 {
+			t.Fatalf("incorrect trashes: %+v", trashesResp.Trashes)
+		}
+
+		resp, _, errs = readerFilesCl.RestoreTrash(trashId)
+		assertResp(t, resp, errs, 404, "restore by reader")
+		resp, _, errs = outsiderFilesCl.RestoreTrash(trashId)
+		assertResp(t, resp, errs, 404, "restore by outsider")
+		resp, _, errs = writerFilesCl.RestoreTrash(trashId)
+		assertResp(t, resp, errs, 200, "restore by writer")
+
+		resp, lsResp, errs := adminUsersCl.ListGroups()
+		assertResp(t, resp, errs, 200, "list groups")
+		if len(lsResp.Groups) != 1 || lsResp.Groups[0].UsedSpace != int64(len("report")+len(content)) {
+			t.Fatalf("incorrect groups: %+v", lsResp.Groups)
+		}
+
+		// the trash is kept to be deleted with the group
+		resp, _, errs = writerFilesCl.Delete(filePath)
+		assertResp(t, resp, errs, 200, "delete group file")
+	})
+
+	t.Run("test groups: granting groups", func(t *testing.T) {
+		docPath := "outsider/files/doc.md"
+		assertUploadOK(t, docPath, "doc", addr, userCls["outsider"].Token())
+
+		resp, _, errs := outsiderFilesCl.AddGroupFileACL(docPath, "ops", db.PermRead)
+		assertResp(t, resp, errs, 404, "grant to missing group")
+		resp, _, errs = outsiderFilesCl.AddGroupFileACL(docPath, "devs", db.PermRead)
+		assertResp(t, resp, errs, 200, "grant to group")
+
+		resp, body, errs := readerFilesCl.Download(docPath, map[string]string{})
+		assertResp(t, resp, errs, 200, "download granted file")
+		if body != "doc" {
+			t.Fatalf("incorrect content: %s", body)
+		}
+		resp, aclsResp, errs := readerFilesCl.ListSharedWithMe()
+		assertResp(t, resp, errs, 200, "list shared with me")
+		if len(aclsResp.ACLs) != 1 || aclsResp.ACLs[0].GranteeType != db.GranteeGroup {
+			t.Fatalf("incorrect acls: %+v", aclsResp.ACLs)
+		}
+
+		// removed members lose access to the group folder and grants to the group
+		resp, _, errs = adminUsersCl.DelGroupMember(groupId, userIds["reader"])
+		assertResp(t, resp, errs, 200, "delete member")
+		resp, _, errs = adminUsersCl.DelGroupMember(groupId, userIds["reader"])
+		assertResp(t, resp, errs, 404, "delete deleted member")
+		resp, _, errs = readerFilesCl.Download(docPath, map[string]string{})
+		assertResp(t, resp, errs, 403, "download granted file after leaving")
+		resp, _, errs = readerFilesCl.List(groupFolder)
+		assertResp(t, resp, errs, 403, "list group folder after leaving")
+	})
+
+	t.Run("test groups: deleting groups", func(t *testing.T) {
+		resp, _, errs := writerFilesCl.Create(path.Join(groupFolder, "pending.md"), 3)
+		assertResp(t, resp, errs, 200, "create pending upload")
+
+		resp, _, errs = adminUsersCl.DelGroup(groupId)
+		assertResp(t, resp, errs, 200, "delete group")
+		resp, _, errs = adminUsersCl.DelGroup(groupId)
+		assertResp(t, resp, errs, 404, "delete deleted group")
+
+		resp, _, errs = writerFilesCl.List(groupFolder)
+		assertResp(t, resp, errs, 403, "list deleted group folder")
+		resp, lsResp, errs := adminUsersCl.ListGroups()
+		assertResp(t, resp, errs, 200, "list groups")
+		if len(lsResp.Groups) != 0 {
+			t.Fatalf("incorrect groups: %+v", lsResp.Groups)
+		}
+		if _, err := os.Stat(path.Join(rootPath, "@devs")); !os.IsNotExist(err) {
+			t.Fatalf("group folder is not removed: %v", err)
+		}
+		resp, uploadingsResp, errs := writerFilesCl.ListUploadings()
+		assertResp(t, resp, errs, 200, "list uploadings")
+		if len(uploadingsResp.UploadInfos) != 0 {
+			t.Fatalf("pending uploads are not deleted: %+v", uploadingsResp.UploadInfos)
+		}
+	})
+}
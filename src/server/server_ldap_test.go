@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/ihexxa/quickshare/src/client"
+	"github.com/ihexxa/quickshare/src/db"
+	"github.com/ihexxa/quickshare/src/ldap"
+	"github.com/ihexxa/quickshare/src/ldap/ldaptest"
+)
+
+func TestLDAPLogin(t *testing.T) {
+	addr := "http://127.0.0.1:8686"
+	rootPath := "tmpTestData"
+
+	ldapSrv, err := ldaptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldapSrv.Close()
+
+	ldapSrv.AddEntry(&ldap.Entry{DN: "cn=reader,dc=example,dc=com"}, "reader_pwd")
+	aliceDN := "uid=alice,ou=people,dc=example,dc=com"
+	aliceEntry := &ldap.Entry{
+		DN: aliceDN,
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"alice"},
+			"cn":          {"Alice Liddell"},
+			"mail":        {"alice@example.com"},
+			"memberOf":    {"cn=users,ou=groups,dc=example,dc=com", "CN=Admins,OU=Groups,DC=example,DC=com"},
+		},
+	}
+	ldapSrv.AddEntry(aliceEntry, "alice_pwd")
+	ldapSrv.AddEntry(&ldap.Entry{
+		DN: "uid=bob,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bob"},
+			"cn":          {"Bob"},
+		},
+	}, "bob_pwd")
+	// demo is also a local user
+	ldapSrv.AddEntry(&ldap.Entry{
+		DN: "uid=demo,ou=people,dc=example,dc=com",
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"demo"},
+		},
+	}, "ldap_demo_pwd")
+
+	config := fmt.Sprintf(`{
+		"users": {
+			"enableAuth": true,
+			"minUserNameLen": 2,
+			"minPwdLen": 4,
+			"captchaEnabled": false,
+			"limiterCapacity": 1000,
+			"limiterCyc": 1000,
+			"predefinedUsers": [
+				{
+					"name": "demo",
+					"pwd": "Quicksh@re",
+					"role": "user"
+				}
+			],
+			"ldap": {
+				"enabled": true,
+				"url": "%s",
+				"bindDN": "cn=reader,dc=example,dc=com",
+				"bindPwd": "reader_pwd",
+				"baseDN": "ou=people,dc=example,dc=com",
+				"userFilter": "(&(objectClass=person)(uid=%%s))",
+				"groupRoles": {
+					"cn=admins,ou=groups,dc=example,dc=com": "admin"
+				},
+				"defaultRole": "user",
+				"fallbackToLocal": true
+			}
+		},
+		"server": {
+			"debug": true,
+			"host": "127.0.0.1"
+		},
+		"fs": {
+			"root": "tmpTestData"
+		},
+		"db": {
+			"dbPath": "tmpTestData/quickshare"
+		}
+	}`, ldapSrv.URL())
+
+	adminName := "qs"
+	adminPwd := "quicksh@re"
+	setUpEnv(t, rootPath, adminName, adminPwd)
+	defer os.RemoveAll(rootPath)
+
+	srv := startTestServer(config)
+	defer srv.Shutdown()
+	if !isServerReady(addr) {
+		t.Fatal("fail to start server")
+	}
+
+	t.Run("test ldap: provisioning and syncing", func(t *testing.T) {
+		usersCl := client.NewUsersClient(addr)
+		resp, _, errs := usersCl.Login("alice", "alice_pwd")
+		assertResp(t, resp, errs, 200, "ldap login")
+
+		resp, selfResp, errs := usersCl.Self()
+		assertResp(t, resp, errs, 200, "get self")
+		if selfResp.Name != "alice" || selfResp.Role != db.AdminRole {
+			t.Fatalf("unexpected user: %+v", selfResp)
+		} else if selfResp.Preferences.Name != "Alice Liddell" || selfResp.Preferences.Email != "alice@example.com" {
+			t.Fatalf("unexpected preferences: %+v", selfResp.Preferences)
+		}
+		aliceID := selfResp.ID
+
+		resp, _, errs = usersCl.Login("alice", "wrong_pwd")
+		assertResp(t, resp, errs, 403, "login with wrong password")
+		resp, _, errs = usersCl.Login("alice", "")
+		assertResp(t, resp, errs, 403, "login with empty password")
+		resp, _, errs = usersCl.Login("*", "alice_pwd")
+		assertResp(t, resp, errs, 403, "login with wildcard")
+
+		// passwords are verified by the directory
+		ldapSrv.SetPwd(aliceDN, "alice_new_pwd")
+		resp, _, errs = usersCl.Login("alice", "alice_pwd")
+		assertResp(t, resp, errs, 403, "login with old password")
+		resp, _, errs = usersCl.Login("alice", "alice_new_pwd")
+		assertResp(t, resp, errs, 200, "login with new password")
+		resp, selfResp, errs = usersCl.Self()
+		assertResp(t, resp, errs, 200, "get self")
+		if selfResp.ID != aliceID {
+			t.Fatalf("unexpected user ID: %s %s", selfResp.ID, aliceID)
+		}
+
+		// the default role is set after leaving mapped groups
+		aliceEntry.Attributes["memberOf"] = []string{"cn=users,ou=groups,dc=example,dc=com"}
+		resp, _, errs = usersCl.Login("alice", "alice_new_pwd")
+		assertResp(t, resp, errs, 200, "login after leaving groups")
+		resp, selfResp, errs = usersCl.Self()
+		assertResp(t, resp, errs, 200, "get self")
+		if selfResp.ID != aliceID || selfResp.Role != db.UserRole {
+			t.Fatalf("role should fall back to the default role: %+v", selfResp)
+		}
+
+		resp, _, errs = usersCl.Login("bob", "bob_pwd")
+		assertResp(t, resp, errs, 200, "ldap login")
+		resp, selfResp, errs = usersCl.Self()
+		assertResp(t, resp, errs, 200, "get self")
+		if selfResp.Name != "bob" || selfResp.Role != db.UserRole || selfResp.Preferences.Name != "Bob" {
+			t.Fatalf("unexpected user: %+v", selfResp)
+		}
+	})
+
+	t.Run("test ldap: falling back to local users", func(t *testing.T) {
+		usersCl := client.NewUsersClient(addr)
+		resp, _, errs := usersCl.Login(adminName, adminPwd)
+		assertResp(t, resp, errs, 200, "local login")
+
+		// the local user is not taken over by the directory user
+		resp, _, errs = usersCl.Login("demo", "ldap_demo_pwd")
+		assertResp(t, resp, errs, 403, "ldap login of the local user")
+		resp, _, errs = usersCl.Login("demo", "Quicksh@re")
+		assertResp(t, resp, errs, 200, "local login")
+		resp, selfResp, errs := usersCl.Self()
+		assertResp(t, resp, errs, 200, "get self")
+		if selfResp.Name != "demo" || selfResp.Preferences.Name != "" {
+			t.Fatalf("unexpected user: %+v", selfResp)
+		}
+
+		resp, _, errs = usersCl.Login("nobody", "nobody_pwd")
+		assertResp(t, resp, errs, 403, "login of unknown user")
+	})
+}
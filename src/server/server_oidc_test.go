@@ -0,0 +1,535 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihexxa/quickshare/src/client"
+	"github.com/ihexxa/quickshare/src/cryptoutil/totp"
+	"github.com/ihexxa/quickshare/src/db"
+	"github.com/ihexxa/quickshare/src/handlers/multiusers"
+	"github.com/ihexxa/quickshare/src/oidc"
+)
+
+type mockIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
+
+type mockAuthReq struct {
+	nonce         string
+	codeChallenge string
+	identity      mockIdentity
+}
+
+// mockOIDCProvider is a minimal OpenID provider which authorizes the current identity without user interactions.
+type mockOIDCProvider struct {
+	t            *testing.T
+	srv          *httptest.Server
+	key          *rsa.PrivateKey
+	clientID     string
+	clientSecret string
+
+	mtx      *sync.Mutex
+	identity mockIdentity
+	codes    map[string]*mockAuthReq
+}
+
+func newMockOIDCProvider(t *testing.T, clientID, clientSecret string) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockOIDCProvider{
+		t:            t,
+		key:          key,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		mtx:          &sync.Mutex{},
+		codes:        map[string]*mockAuthReq{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+	idp.srv = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *mockOIDCProvider) setIdentity(identity mockIdentity) {
+	idp.mtx.Lock()
+	defer idp.mtx.Unlock()
+	idp.identity = identity
+}
+
+func (idp *mockOIDCProvider) writeJSON(w http.ResponseWriter, code int, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(val); err != nil {
+		idp.t.Error(err)
+	}
+}
+
+func (idp *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	idp.writeJSON(w, 200, map[string]interface{}{
+		"issuer":                                idp.srv.URL,
+		"authorization_endpoint":                idp.srv.URL + "/authorize",
+		"token_endpoint":                        idp.srv.URL + "/token",
+		"jwks_uri":                              idp.srv.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+	})
+}
+
+func (idp *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != idp.clientID ||
+		query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") == "" ||
+		!strings.Contains(query.Get("scope"), "openid") {
+		idp.writeJSON(w, 400, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code, err := oidc.GenState()
+	if err != nil {
+		idp.writeJSON(w, 500, map[string]string{"error": err.Error()})
+		return
+	}
+	idp.mtx.Lock()
+	idp.codes[code] = &mockAuthReq{
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		identity:      idp.identity,
+	}
+	idp.mtx.Unlock()
+
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		idp.writeJSON(w, 400, map[string]string{"error": "invalid_request"})
+		return
+	}
+	redirectQuery := redirectURL.Query()
+	redirectQuery.Set("code", code)
+	redirectQuery.Set("state", query.Get("state"))
+	redirectURL.RawQuery = redirectQuery.Encode()
+	http.Redirect(w, r, redirectURL.String(), http.StatusFound)
+}
+
+func (idp *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != idp.clientID || clientSecret != idp.clientSecret {
+		idp.writeJSON(w, 401, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		idp.writeJSON(w, 400, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	idp.mtx.Lock()
+	authReq, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mtx.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" {
+		idp.writeJSON(w, 400, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if oidc.S256Challenge(r.PostForm.Get("code_verifier")) != authReq.codeChallenge {
+		idp.writeJSON(w, 400, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now().Unix()
+	idToken := idp.sign(map[string]interface{}{
+		"iss":            idp.srv.URL,
+		"aud":            idp.clientID,
+		"sub":            authReq.identity.Subject,
+		"email":          authReq.identity.Email,
+		"email_verified": authReq.identity.EmailVerified,
+		"groups":         authReq.identity.Groups,
+		"nonce":          authReq.nonce,
+		"iat":            now,
+		"exp":            now + 300,
+	})
+	idp.writeJSON(w, 200, map[string]string{
+		"access_token": "access_token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func (idp *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.writeJSON(w, 200, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func (idp *mockOIDCProvider) sign(claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	if err != nil {
+		idp.t.Error(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		idp.t.Error(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Error(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	addr := "http://127.0.0.1:8686"
+	rootPath := "tmpTestData"
+	clientID, clientSecret := "quickshare", "client_secret"
+	idp := newMockOIDCProvider(t, clientID, clientSecret)
+	defer idp.srv.Close()
+
+	config := fmt.Sprintf(`{
+		"users": {
+			"enableAuth": true,
+			"minUserNameLen": 2,
+			"minPwdLen": 4,
+			"captchaEnabled": false,
+			"limiterCapacity": 1000,
+			"limiterCyc": 1000,
+			"predefinedUsers": [
+				{
+					"name": "bob@example.com",
+					"pwd": "Quicksh@re",
+					"role": "user"
+				}
+			],
+			"oidc": {
+				"enabled": true,
+				"issuer": "%s",
+				"clientID": "%s",
+				"clientSecret": "%s",
+				"redirectURL": "%s/v2/public/oidc/callback",
+				"autoProvision": true,
+				"defaultRole": "user",
+				"groupsClaim": "groups",
+				"groupRoles": {
+					"qs-admins": "admin",
+					"qs-users": "user"
+				}
+			}
+		},
+		"server": {
+			"debug": true,
+			"host": "127.0.0.1"
+		},
+		"fs": {
+			"root": "tmpTestData"
+		},
+		"db": {
+			"dbPath": "tmpTestData/quickshare"
+		}
+	}`, idp.srv.URL, clientID, clientSecret, addr)
+
+	adminName := "qs"
+	adminPwd := "quicksh@re"
+	setUpEnv(t, rootPath, adminName, adminPwd)
+	defer os.RemoveAll(rootPath)
+
+	srv := startTestServer(config)
+	defer srv.Shutdown()
+	if !isServerReady(addr) {
+		t.Fatal("fail to start server")
+	}
+
+	newBrowser := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	// follow redirects from the login to the callback and return the callback response,
+	// the login response is returned if the callback responds a 2FA challenge
+	ssoLogin := func(browser *http.Client) (*http.Response, *multiusers.LoginResp) {
+		nextURL := addr + "/v2/public/oidc/login"
+		for i := 0; i < 3; i++ {
+			resp, err := browser.Get(nextURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loginResp := &multiusers.LoginResp{}
+			if i == 2 && resp.StatusCode == 200 {
+				if err = json.NewDecoder(resp.Body).Decode(loginResp); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp.Body.Close()
+			if i == 2 || resp.StatusCode != 302 {
+				return resp, loginResp
+			}
+			nextURL = resp.Header.Get("Location")
+		}
+		return nil, nil
+	}
+
+	getSelf := func(browser *http.Client) (int, *multiusers.SelfResp) {
+		resp, err := browser.Get(addr + "/v2/my/self")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		selfResp := &multiusers.SelfResp{}
+		if resp.StatusCode == 200 {
+			if err = json.NewDecoder(resp.Body).Decode(selfResp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, selfResp
+	}
+
+	t.Run("test oidc: auto provisioning and group roles", func(t *testing.T) {
+		idp.setIdentity(mockIdentity{
+			Subject:       "alice-subject",
+			Email:         "alice@example.com",
+			EmailVerified: true,
+			Groups:        []string{"qs-users", "qs-admins"},
+		})
+
+		browser := newBrowser()
+		resp, _ := ssoLogin(browser)
+		if resp.StatusCode != 302 || resp.Header.Get("Location") != "/" {
+			t.Fatalf("unexpected callback response: %d %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		code, self := getSelf(browser)
+		if code != 200 {
+			t.Fatalf("failed to get self: %d", code)
+		} else if self.Name != "alice@example.com" || self.Role != db.AdminRole {
+			t.Fatalf("unexpected user: %+v", self)
+		} else if self.Preferences == nil || self.Preferences.Email != "alice@example.com" {
+			t.Fatalf("unexpected preferences: %+v", self.Preferences)
+		}
+		aliceID := self.ID
+
+		// the same subject logs in as the same user, and its role follows its groups
+		idp.setIdentity(mockIdentity{
+			Subject: "alice-subject",
+			Email:   "alice@example.com",
+			Groups:  []string{"qs-users"},
+		})
+		browser = newBrowser()
+		resp, _ = ssoLogin(browser)
+		if resp.StatusCode != 302 {
+			t.Fatalf("unexpected callback response: %d", resp.StatusCode)
+		}
+		code, self = getSelf(browser)
+		if code != 200 {
+			t.Fatalf("failed to get self: %d", code)
+		} else if self.ID != aliceID || self.Role != db.UserRole {
+			t.Fatalf("unexpected user: %+v", self)
+		}
+
+		// the default role is set if no group is mapped
+		for _, groups := range [][]string{{"qs-admins"}, {"others"}} {
+			idp.setIdentity(mockIdentity{
+				Subject: "alice-subject",
+				Email:   "alice@example.com",
+				Groups:  groups,
+			})
+			browser = newBrowser()
+			resp, _ = ssoLogin(browser)
+			if resp.StatusCode != 302 {
+				t.Fatalf("unexpected callback response: %d", resp.StatusCode)
+			}
+		}
+		code, self = getSelf(browser)
+		if code != 200 {
+			t.Fatalf("failed to get self: %d", code)
+		} else if self.ID != aliceID || self.Role != db.UserRole {
+			t.Fatalf("role should fall back to the default role: %+v", self)
+		}
+	})
+
+	adminUsersCl := client.NewUsersClient(addr)
+	resp, _, errs := adminUsersCl.Login(adminName, adminPwd)
+	assertResp(t, resp, errs, 200, "admin login")
+
+	t.Run("test oidc: link existing users", func(t *testing.T) {
+		// emails can not take over local users even if they are verified
+		for _, verified := range []bool{false, true} {
+			idp.setIdentity(mockIdentity{
+				Subject:       "mallory-subject",
+				Email:         "bob@example.com",
+				EmailVerified: verified,
+			})
+			browser := newBrowser()
+			resp, _ := ssoLogin(browser)
+			if resp.StatusCode != 403 {
+				t.Fatalf("email should not be linked: %d", resp.StatusCode)
+			}
+			if code, _ := getSelf(browser); code != 401 && code != 403 {
+				t.Fatalf("unexpected self response: %d", code)
+			}
+		}
+
+		// admins link identities to existing users
+		resp, lsResp, errs := adminUsersCl.ListUsers()
+		assertResp(t, resp, errs, 200, "list users")
+		var bobID uint64
+		for _, user := range lsResp.Users {
+			if user.Name == "bob@example.com" {
+				bobID = user.ID
+			}
+		}
+		resp, _, errs = adminUsersCl.LinkOIDCIdentity(bobID, "bob-subject")
+		assertResp(t, resp, errs, 200, "link identity")
+		resp, _, errs = adminUsersCl.LinkOIDCIdentity(bobID, "bob-subject")
+		assertResp(t, resp, errs, 400, "link linked identity")
+		resp, _, errs = adminUsersCl.LinkOIDCIdentity(404, "other-subject")
+		assertResp(t, resp, errs, 404, "link identity to missing user")
+
+		idp.setIdentity(mockIdentity{
+			Subject: "bob-subject",
+			Email:   "changed@example.com",
+		})
+		browser := newBrowser()
+		resp, _ = ssoLogin(browser)
+		if resp.StatusCode != 302 {
+			t.Fatalf("unexpected callback response: %d", resp.StatusCode)
+		}
+		code, self := getSelf(browser)
+		if code != 200 {
+			t.Fatalf("failed to get self: %d", code)
+		} else if self.Name != "bob@example.com" || self.Role != db.UserRole {
+			t.Fatalf("unexpected user: %+v", self)
+		}
+	})
+
+	t.Run("test oidc: 2fa", func(t *testing.T) {
+		idp.setIdentity(mockIdentity{
+			Subject: "carol-subject",
+			Email:   "carol@example.com",
+		})
+		browser := newBrowser()
+		resp, _ := ssoLogin(browser)
+		if resp.StatusCode != 302 {
+			t.Fatalf("unexpected callback response: %d", resp.StatusCode)
+		}
+		code, self := getSelf(browser)
+		if code != 200 {
+			t.Fatalf("failed to get self: %d", code)
+		}
+
+		carolID, err := strconv.ParseUint(self.ID, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, _, errs := adminUsersCl.SetUserTOTP(carolID, true)
+		assertResp(t, resp, errs, 200, "force 2fa")
+		browser = newBrowser()
+		resp, loginResp := ssoLogin(browser)
+		if resp.StatusCode != 200 || !loginResp.TOTPRequired || loginResp.Challenge == "" || loginResp.TOTPURI == "" {
+			t.Fatalf("2fa should be required: %d %+v", resp.StatusCode, loginResp)
+		}
+		if code, _ := getSelf(browser); code != 401 && code != 403 {
+			t.Fatalf("session should not be started before 2fa: %d", code)
+		}
+
+		uri, err := url.Parse(loginResp.TOTPURI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totpCode, err := totp.Code(uri.Query().Get("secret"), totp.Step(time.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		usersCl := client.NewUsersClient(addr)
+		resp, _, errs = usersCl.LoginTOTP(loginResp.Challenge, totpCode, "")
+		assertResp(t, resp, errs, 200, "login with code")
+		resp, _, errs = usersCl.IsAuthed()
+		assertResp(t, resp, errs, 200, "authed by 2fa login")
+	})
+
+	t.Run("test oidc: invalid states", func(t *testing.T) {
+		idp.setIdentity(mockIdentity{
+			Subject:       "alice-subject",
+			Email:         "alice@example.com",
+			EmailVerified: true,
+		})
+
+		browser := newBrowser()
+		resp, err := browser.Get(addr + "/v2/public/oidc/login")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		resp, err = browser.Get(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		callbackURL, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := callbackURL.Query()
+		query.Set("state", "forged")
+		callbackURL.RawQuery = query.Encode()
+		resp, err = browser.Get(callbackURL.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("forged state should be rejected: %d", resp.StatusCode)
+		}
+
+		// the callback without the state cookie is rejected
+		resp, err = newBrowser().Get(addr + "/v2/public/oidc/callback?code=code&state=state")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("callback without state should be rejected: %d", resp.StatusCode)
+		}
+	})
+}
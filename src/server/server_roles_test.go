@@ -0,0 +1,195 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ihexxa/quickshare/src/client"
+	"github.com/ihexxa/quickshare/src/db"
+)
+
+func TestCustomRoles(t *testing.T) {
+	addr := "http://127.0.0.1:8686"
+	rootPath := "tmpTestData"
+	config := `{
+		"users": {
+			"enableAuth": true,
+			"minUserNameLen": 2,
+			"minPwdLen": 4,
+			"captchaEnabled": false,
+			"limiterCapacity": 1000,
+			"limiterCyc": 1000
+		},
+		"server": {
+			"debug": true,
+			"host": "127.0.0.1"
+		},
+		"fs": {
+			"root": "tmpTestData"
+		},
+		"db": {
+			"dbPath": "tmpTestData/quickshare"
+		}
+	}`
+
+	adminName := "qs"
+	adminPwd := "quicksh@re"
+	setUpEnv(t, rootPath, adminName, adminPwd)
+	defer os.RemoveAll(rootPath)
+
+	srv := startTestServer(config)
+	defer srv.Shutdown()
+	if !isServerReady(addr) {
+		t.Fatal("fail to start server")
+	}
+
+	adminUsersCl := client.NewUsersClient(addr)
+	resp, _, errs := adminUsersCl.Login(adminName, adminPwd)
+	assertResp(t, resp, errs, 200, "admin login")
+	adminToken := adminUsersCl.Token()
+
+	auditorRules := []*db.APIRule{
+		{Method: "GET", Path: "/v2/my/", Prefix: true},
+		{Method: "GET", Path: "/v2/admin/users/list"},
+	}
+	uploaderRules := []*db.APIRule{
+		{Method: "*", Path: "/v2/my/fs/files", Prefix: true},
+		{Method: "GET", Path: "/v2/my/self"},
+		{Method: "GET", Path: "/v2/my/session", Prefix: true},
+	}
+	userPwd := "1234"
+
+	t.Run("test roles: managing roles", func(t *testing.T) {
+		resp, _, errs := adminUsersCl.AddRole("auditor", auditorRules, []string{db.CapRead, db.CapAllFiles})
+		assertResp(t, resp, errs, 200, "add auditor")
+		resp, _, errs = adminUsersCl.AddRole("uploader", uploaderRules, []string{db.CapWrite})
+		assertResp(t, resp, errs, 200, "add uploader")
+
+		resp, _, errs = adminUsersCl.AddRole("auditor", nil, nil)
+		assertResp(t, resp, errs, 400, "add existing role")
+		resp, _, errs = adminUsersCl.AddRole(db.UserRole, nil, nil)
+		assertResp(t, resp, errs, 400, "add predefined role")
+		resp, _, errs = adminUsersCl.AddRole("invalid", []*db.APIRule{{Method: "FETCH", Path: "/v2/my/"}}, nil)
+		assertResp(t, resp, errs, 400, "add role with invalid method")
+		resp, _, errs = adminUsersCl.AddRole("invalid", []*db.APIRule{{Method: "GET", Path: "v2/my/"}}, nil)
+		assertResp(t, resp, errs, 400, "add role with invalid path")
+		for _, rulePath := range []string{"/v2/my/../admin/", "/v2//admin", "/v2/./admin", "/v2/my//"} {
+			resp, _, errs = adminUsersCl.AddRole("invalid", []*db.APIRule{{Method: "GET", Path: rulePath, Prefix: true}}, nil)
+			assertResp(t, resp, errs, 400, "add role with unclean path")
+		}
+		resp, _, errs = adminUsersCl.AddRole("invalid", nil, []string{"root"})
+		assertResp(t, resp, errs, 400, "add role with invalid capability")
+		resp, _, errs = adminUsersCl.SetRole("nobody", nil, nil)
+		assertResp(t, resp, errs, 404, "set missing role")
+
+		resp, lsResp, errs := adminUsersCl.ListRoles()
+		assertResp(t, resp, errs, 200, "list roles")
+		if !lsResp.Roles["auditor"] || !lsResp.Roles["uploader"] || !lsResp.Roles[db.UserRole] ||
+			len(lsResp.CustomRoles) != 2 || lsResp.CustomRoles[0].Name != "auditor" {
+			t.Fatalf("incorrect roles: %+v", lsResp)
+		}
+
+		for _, name := range []string{"auditor", "uploader"} {
+			resp, _, errs = adminUsersCl.AddUser(name, userPwd, name)
+			assertResp(t, resp, errs, 200, "add user of custom role")
+		}
+		resp, _, errs = adminUsersCl.AddUser("nobody", userPwd, "nobody")
+		assertResp(t, resp, errs, 400, "add user of unknown role")
+	})
+
+	t.Run("test roles: read-only auditor", func(t *testing.T) {
+		filePath := "qs/files/audited.txt"
+		assertUploadOK(t, filePath, "audited", addr, adminToken)
+
+		usersCl := client.NewUsersClient(addr)
+		resp, _, errs := usersCl.Login("auditor", userPwd)
+		assertResp(t, resp, errs, 200, "auditor login")
+		resp, selfResp, errs := usersCl.Self()
+		assertResp(t, resp, errs, 200, "get self")
+		if selfResp.Role != "auditor" {
+			t.Fatalf("incorrect role: %s", selfResp.Role)
+		}
+		resp, _, errs = usersCl.ListUsers()
+		assertResp(t, resp, errs, 200, "list users")
+		resp, _, errs = usersCl.AddUser("auditor2", userPwd, db.UserRole)
+		assertResp(t, resp, errs, 403, "add user")
+		resp, _, errs = usersCl.ListRoles()
+		assertResp(t, resp, errs, 403, "list roles")
+
+		filesCl := client.NewFilesClient(addr, usersCl.Token())
+		resp, _, errs = filesCl.List("qs/files")
+		assertResp(t, resp, errs, 200, "list others' folder")
+		resp, _, errs = filesCl.Download(filePath, map[string]string{})
+		assertResp(t, resp, errs, 200, "download others' file")
+		resp, _, errs = filesCl.Create("auditor/files/new.txt", 3)
+		assertResp(t, resp, errs, 403, "create file")
+		resp, _, errs = filesCl.Delete(filePath)
+		assertResp(t, resp, errs, 403, "delete file")
+	})
+
+	t.Run("test roles: write-only uploader", func(t *testing.T) {
+		usersCl := client.NewUsersClient(addr)
+		resp, _, errs := usersCl.Login("uploader", userPwd)
+		assertResp(t, resp, errs, 200, "uploader login")
+		resp, _, errs = usersCl.ListUsers()
+		assertResp(t, resp, errs, 403, "list users")
+		resp, _, errs = usersCl.ListSessions()
+		assertResp(t, resp, errs, 403, "list sessions out of the prefix")
+
+		filePath := "uploader/files/uploaded.txt"
+		assertUploadOK(t, filePath, "uploaded", addr, usersCl.Token())
+
+		filesCl := client.NewFilesClient(addr, usersCl.Token())
+		resp, _, errs = filesCl.Download(filePath, map[string]string{})
+		assertResp(t, resp, errs, 403, "download own file")
+		resp, _, errs = filesCl.List("uploader/files")
+		assertResp(t, resp, errs, 403, "list own folder")
+		resp, _, errs = filesCl.Create("qs/files/uploaded.txt", 3)
+		assertResp(t, resp, errs, 403, "create file in others' folder")
+
+		// custom roles are not checked as visitors when their rules do not match
+		sharedPath := "qs/files/roles_shared"
+		adminFilesCl := client.NewFilesClient(addr, adminToken)
+		resp, _, errs = adminFilesCl.Mkdir(sharedPath)
+		assertResp(t, resp, errs, 200, "mkdir")
+		resp, _, errs = adminFilesCl.AddSharing(sharedPath)
+		assertResp(t, resp, errs, 200, "add sharing")
+		resp, _, errs = filesCl.List(sharedPath)
+		assertResp(t, resp, errs, 403, "list sharing out of rules")
+		resp, _, errs = usersCl.IsAuthed()
+		assertResp(t, resp, errs, 200, "default rules of custom roles")
+
+		// changes of roles are applied immediately
+		resp, _, errs = adminUsersCl.SetRole("uploader", uploaderRules, []string{db.CapRead, db.CapWrite})
+		assertResp(t, resp, errs, 200, "set uploader")
+		resp, _, errs = filesCl.Download(filePath, map[string]string{})
+		assertResp(t, resp, errs, 200, "download own file")
+
+		resp, _, errs = usersCl.Logout()
+		assertResp(t, resp, errs, 200, "logout by default rules")
+	})
+
+	t.Run("test roles: deleting roles", func(t *testing.T) {
+		resp, _, errs := adminUsersCl.DelRole("uploader")
+		assertResp(t, resp, errs, 400, "delete role in use")
+
+		resp, lsResp, errs := adminUsersCl.ListUsers()
+		assertResp(t, resp, errs, 200, "list users")
+		for _, user := range lsResp.Users {
+			if user.Role == "uploader" {
+				resp, _, errs = adminUsersCl.SetUser(user.ID, db.UserRole, user.Quota)
+				assertResp(t, resp, errs, 200, "set user")
+			}
+		}
+		resp, _, errs = adminUsersCl.DelRole("uploader")
+		assertResp(t, resp, errs, 200, "delete role")
+		resp, _, errs = adminUsersCl.DelRole("uploader")
+		assertResp(t, resp, errs, 404, "delete deleted role")
+
+		resp, rolesResp, errs := adminUsersCl.ListRoles()
+		assertResp(t, resp, errs, 200, "list roles")
+		if rolesResp.Roles["uploader"] || len(rolesResp.CustomRoles) != 1 {
+			t.Fatalf("incorrect roles: %+v", rolesResp)
+		}
+	})
+}
@@ -140,12 +140,16 @@ func TestSpaceLimit(t *testing.T) {
 		}
 
 		for i := 0; i < spaceLimit/fileSize; i++ {
-			resp, _, errs := userFilesClient.Delete(fmt.Sprintf("%s/files/spacelimit/f_%d", getUserName(0), i))
+			deletedPath := fmt.Sprintf("%s/files/spacelimit/f_%d", getUserName(0), i)
+			resp, _, errs := userFilesClient.Delete(deletedPath)
 			if len(errs) > 0 {
 				t.Fatalf("failed to delete %d", i)
 			} else if resp.StatusCode != 200 {
 				t.Fatalf("failed to delete status %d", resp.StatusCode)
 			}
+			if err := purgeTrashes(userFilesClient, deletedPath); err != nil {
+				t.Fatal(err)
+			}
 
 			resp, selfResp, errs := usersCli.Self()
 			if len(errs) > 0 {
@@ -161,6 +165,14 @@ func TestSpaceLimit(t *testing.T) {
 	t.Run("usedSpace keeps correct in operations: Mkdir-Create-UploadChunk-AddSharing-Move-IsSharing-List", func(t *testing.T) {
 		srcDir := "qs/files/folder/move/src"
 		dstDir := "qs/files/folder/move/dst"
+		// the admin logged out above and its session was revoked
+		resp, _, errs := adminUsersCli.Login(adminName, adminPwd)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		} else if resp.StatusCode != 200 {
+			t.Fatal(resp.StatusCode)
+		}
+		adminToken = client.GetCookie(resp.Cookies(), q.TokenCookie)
 		adminFilesCli := client.NewFilesClient(addr, adminToken)
 
 		getUsedSpace := func() int64 {
@@ -223,6 +235,9 @@ func TestSpaceLimit(t *testing.T) {
 		} else if res.StatusCode != 200 {
 			t.Fatal(res.StatusCode)
 		}
+		if err := purgeTrashes(adminFilesCli, dstDir); err != nil {
+			t.Fatal(err)
+		}
 
 		if getUsedSpace() != initUsedSpace {
 			t.Fatal("used space incorrect")
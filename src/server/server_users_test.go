@@ -1,15 +1,22 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ihexxa/quickshare/src/client"
+	"github.com/ihexxa/quickshare/src/cryptoutil/totp"
 	"github.com/ihexxa/quickshare/src/db"
 	q "github.com/ihexxa/quickshare/src/handlers"
+	"github.com/ihexxa/quickshare/src/handlers/multiusers"
 	"github.com/ihexxa/quickshare/src/handlers/settings"
 )
 
@@ -556,4 +563,354 @@ func TestUsersHandlers(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("test api tokens: AddAPIToken-ListAPITokens-Bearer-DelAPIToken", func(t *testing.T) {
+		adminUsersCli := client.NewUsersClient(addr)
+		resp, _, errs := adminUsersCli.Login(adminName, adminNewPwd)
+		assertResp(t, resp, errs, 200, "login")
+		demoUsersCli := client.NewUsersClient(addr)
+		resp, _, errs = demoUsersCli.Login("demo", adminNewPwd)
+		assertResp(t, resp, errs, 200, "login")
+
+		requestWith := func(apiToken, method, path string) int {
+			req, err := http.NewRequest(method, addr+path, strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+apiToken)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		resp, _, errs = demoUsersCli.AddAPIToken("", db.APIScopeReadOnly)
+		assertResp(t, resp, errs, 400, "add token without name")
+		resp, _, errs = demoUsersCli.AddAPIToken("ci", "unknown")
+		assertResp(t, resp, errs, 400, "add token with invalid scope")
+		resp, _, errs = demoUsersCli.AddAPIToken("ci", db.APIScopeAdmin)
+		assertResp(t, resp, errs, 403, "add admin token by user")
+
+		resp, roToken, errs := demoUsersCli.AddAPIToken("ci read", db.APIScopeReadOnly)
+		assertResp(t, resp, errs, 200, "add read-only token")
+		resp, rwToken, errs := demoUsersCli.AddAPIToken("ci write", db.APIScopeReadWrite)
+		assertResp(t, resp, errs, 200, "add read-write token")
+		resp, adminRWToken, errs := adminUsersCli.AddAPIToken("admin write", db.APIScopeReadWrite)
+		assertResp(t, resp, errs, 200, "add read-write token by admin")
+		resp, adminToken, errs := adminUsersCli.AddAPIToken("admin", db.APIScopeAdmin)
+		assertResp(t, resp, errs, 200, "add admin token")
+
+		if code := requestWith(roToken.Token, "GET", "/v2/my/self"); code != 200 {
+			t.Fatalf("read-only token should read: %d", code)
+		}
+		if code := requestWith(roToken.Token, "PATCH", "/v2/my/preferences"); code != 403 {
+			t.Fatalf("read-only token should not write: %d", code)
+		}
+		if code := requestWith(rwToken.Token, "GET", "/v2/my/fs/dirs/home"); code != 200 {
+			t.Fatalf("read-write token should read: %d", code)
+		}
+		if code := requestWith(rwToken.Token, "POST", "/v2/my/tokens"); code != 403 {
+			t.Fatalf("tokens should not be managed by api tokens: %d", code)
+		}
+		if code := requestWith(adminRWToken.Token, "GET", "/v2/admin/users/list"); code != 403 {
+			t.Fatalf("read-write token of admin should not call admin APIs: %d", code)
+		}
+		if code := requestWith(adminToken.Token, "GET", "/v2/admin/users/list"); code != 200 {
+			t.Fatalf("admin token should call admin APIs: %d", code)
+		}
+		if code := requestWith("qs_invalid", "GET", "/v2/my/self"); code != 401 {
+			t.Fatalf("invalid token should be rejected: %d", code)
+		}
+
+		resp, lsResp, errs := demoUsersCli.ListAPITokens()
+		assertResp(t, resp, errs, 200, "list tokens")
+		if len(lsResp.Tokens) != 2 {
+			t.Fatalf("incorrect tokens size (%d)", len(lsResp.Tokens))
+		}
+		for _, token := range lsResp.Tokens {
+			if token.Name == "ci read" && (token.ID != roToken.ID || token.LastUsedAt.IsZero()) {
+				t.Fatalf("incorrect token: %+v", token)
+			} else if token.Name == "ci write" && token.Scope != db.APIScopeReadWrite {
+				t.Fatalf("incorrect token: %+v", token)
+			}
+		}
+
+		resp, _, errs = adminUsersCli.DelAPIToken(roToken.ID)
+		assertResp(t, resp, errs, 404, "revoke token of others")
+		resp, _, errs = demoUsersCli.DelAPIToken(roToken.ID)
+		assertResp(t, resp, errs, 200, "revoke token")
+		if code := requestWith(roToken.Token, "GET", "/v2/my/self"); code != 401 {
+			t.Fatalf("revoked token should be rejected: %d", code)
+		}
+	})
+
+	t.Run("test sessions: Login-ListSessions-DelSession-SetPwd-ForceSetPwd-SetUser-DelSessions", func(t *testing.T) {
+		adminUsersCli := client.NewUsersClient(addr)
+		resp, _, errs := adminUsersCli.Login(adminName, adminNewPwd)
+		assertResp(t, resp, errs, 200, "login")
+
+		userName, userPwd, userNewPwd := "session_user", "1234", "12345"
+		resp, auResp, errs := adminUsersCli.AddUser(userName, userPwd, db.UserRole)
+		assertResp(t, resp, errs, 200, "add user")
+		userID, err := strconv.ParseUint(auResp.ID, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		login := func(pwd string) *client.UsersClient {
+			cl := client.NewUsersClient(addr)
+			resp, _, errs := cl.Login(userName, pwd)
+			assertResp(t, resp, errs, 200, "login")
+			return cl
+		}
+		assertAuthed := func(cl *client.UsersClient, expected int, desc string) {
+			resp, _, errs := cl.IsAuthed()
+			assertResp(t, resp, errs, expected, desc)
+		}
+
+		cl1, cl2 := login(userPwd), login(userPwd)
+		resp, lsResp, errs := cl1.ListSessions()
+		assertResp(t, resp, errs, 200, "list sessions")
+		if len(lsResp.Sessions) != 2 {
+			t.Fatalf("incorrect sessions size (%d)", len(lsResp.Sessions))
+		}
+		cl1SessionID := lsResp.Current
+		cl2SessionID := uint64(0)
+		for _, session := range lsResp.Sessions {
+			if session.UserID != userID || session.IP == "" || session.UserAgent == "" {
+				t.Fatalf("incorrect session: %+v", session)
+			} else if session.ID != cl1SessionID {
+				cl2SessionID = session.ID
+			}
+		}
+		if cl1SessionID == 0 || cl2SessionID == 0 {
+			t.Fatalf("sessions are not found: %d %d", cl1SessionID, cl2SessionID)
+		}
+
+		resp, _, errs = adminUsersCli.DelSession(cl2SessionID)
+		assertResp(t, resp, errs, 404, "revoke session of others")
+		resp, _, errs = cl1.DelSession(cl2SessionID)
+		assertResp(t, resp, errs, 200, "revoke session")
+		assertAuthed(cl1, 200, "current session")
+
+		// clients of revoked sessions continue as visitors, so that they are able to log in again
+		resp, _, errs = cl2.IsAuthed()
+		assertResp(t, resp, errs, 403, "revoked session")
+		if token := client.GetCookie(resp.Cookies(), q.TokenCookie); token == nil || token.Value != "" {
+			t.Fatalf("token of revoked session should be cleared: %+v", token)
+		}
+		loginBody, err := json.Marshal(multiusers.LoginReq{User: userName, Pwd: userPwd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", addr+"/v2/public/login", strings.NewReader(string(loginBody)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(cl2.Token())
+		loginResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loginResp.Body.Close()
+		if loginResp.StatusCode != 200 {
+			t.Fatalf("clients of revoked sessions should be able to log in: %d", loginResp.StatusCode)
+		}
+
+		cl2 = login(userPwd)
+		resp, _, errs = cl1.SetPwd(userPwd, userNewPwd)
+		assertResp(t, resp, errs, 200, "set password")
+		assertAuthed(cl1, 200, "session of setting password")
+		assertAuthed(cl2, 403, "other sessions after setting password")
+
+		cl2 = login(userNewPwd)
+		resp, _, errs = adminUsersCli.ForceSetPwd(auResp.ID, userPwd)
+		assertResp(t, resp, errs, 200, "force set password")
+		assertAuthed(cl1, 403, "sessions after force setting password")
+		assertAuthed(cl2, 403, "sessions after force setting password")
+
+		cl1 = login(userPwd)
+		resp, _, errs = adminUsersCli.SetUser(userID, db.BannedRole, &db.Quota{})
+		assertResp(t, resp, errs, 200, "ban user")
+		assertAuthed(cl1, 403, "sessions of banned users")
+		resp, _, errs = adminUsersCli.SetUser(userID, db.UserRole, &db.Quota{})
+		assertResp(t, resp, errs, 200, "unban user")
+
+		cl1, cl2 = login(userPwd), login(userPwd)
+		resp, _, errs = cl1.DelSessions()
+		assertResp(t, resp, errs, 200, "revoke all sessions")
+		assertAuthed(cl1, 403, "sessions after logging out everywhere")
+		assertAuthed(cl2, 403, "sessions after logging out everywhere")
+
+		cl1 = login(userPwd)
+		resp, _, errs = cl1.Logout()
+		assertResp(t, resp, errs, 200, "logout")
+		resp, lsResp, errs = adminUsersCli.ListSessions()
+		assertResp(t, resp, errs, 200, "list sessions")
+		for _, session := range lsResp.Sessions {
+			if session.UserID != 0 {
+				t.Fatalf("sessions of others should not be listed: %+v", session)
+			}
+		}
+
+		resp, _, errs = adminUsersCli.DelUser(auResp.ID)
+		assertResp(t, resp, errs, 200, "delete user")
+	})
+
+	t.Run("test 2fa: SetupTOTP-EnableTOTP-Login-LoginTOTP-SetUserTOTP-ResetUserTOTP", func(t *testing.T) {
+		adminUsersCli := client.NewUsersClient(addr)
+		resp, _, errs := adminUsersCli.Login(adminName, adminNewPwd)
+		assertResp(t, resp, errs, 200, "login")
+
+		userName, forcedName, userPwd := "totp_user", "totp_forced", "1234"
+		resp, auResp, errs := adminUsersCli.AddUser(userName, userPwd, db.UserRole)
+		assertResp(t, resp, errs, 200, "add user")
+		userID, err := strconv.ParseUint(auResp.ID, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, auResp, errs = adminUsersCli.AddUser(forcedName, userPwd, db.UserRole)
+		assertResp(t, resp, errs, 200, "add user")
+		forcedID, err := strconv.ParseUint(auResp.ID, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		login := func(cl *client.UsersClient, name string) *multiusers.LoginResp {
+			resp, body, errs := cl.Login(name, userPwd)
+			assertResp(t, resp, errs, 200, "login")
+			loginResp := &multiusers.LoginResp{}
+			if err := json.Unmarshal([]byte(body), loginResp); err != nil {
+				t.Fatal(err)
+			}
+			return loginResp
+		}
+		codeOf := func(secret string, stepOffset int64) string {
+			code, err := totp.Code(secret, totp.Step(time.Now())+stepOffset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return code
+		}
+
+		// enroll
+		usersCli := client.NewUsersClient(addr)
+		if loginResp := login(usersCli, userName); loginResp.TOTPRequired {
+			t.Fatal("2fa should not be required before enrolling")
+		}
+		resp, setupResp, errs := usersCli.SetupTOTP()
+		assertResp(t, resp, errs, 200, "set up 2fa")
+		if !strings.Contains(setupResp.URI, "secret="+setupResp.Secret) {
+			t.Fatalf("incorrect provisioning uri: %s", setupResp.URI)
+		}
+		resp, _, errs = usersCli.EnableTOTP("0000000")
+		assertResp(t, resp, errs, 403, "enable 2fa with invalid code")
+		enabledCode := codeOf(setupResp.Secret, 0)
+		resp, codesResp, errs := usersCli.EnableTOTP(enabledCode)
+		assertResp(t, resp, errs, 200, "enable 2fa")
+		if len(codesResp.RecoveryCodes) != 10 {
+			t.Fatalf("incorrect recovery codes size (%d)", len(codesResp.RecoveryCodes))
+		}
+		resp, statusResp, errs := usersCli.GetTOTP()
+		assertResp(t, resp, errs, 200, "get 2fa")
+		if !statusResp.Enabled || statusResp.Forced || statusResp.RecoveryCodes != 10 {
+			t.Fatalf("incorrect 2fa status: %+v", statusResp)
+		}
+		resp, _, errs = usersCli.SetupTOTP()
+		assertResp(t, resp, errs, 400, "set up enabled 2fa")
+
+		// login
+		loginResp := login(usersCli, userName)
+		if !loginResp.TOTPRequired || loginResp.Challenge == "" || loginResp.TOTPURI != "" {
+			t.Fatalf("2fa should be required: %+v", loginResp)
+		}
+		resp, _, errs = usersCli.LoginTOTP("invalid", codeOf(setupResp.Secret, 1), "")
+		assertResp(t, resp, errs, 401, "login with invalid challenge")
+		resp, _, errs = usersCli.LoginTOTP(loginResp.Challenge, enabledCode, "")
+		assertResp(t, resp, errs, 403, "login with used code")
+		resp, _, errs = usersCli.LoginTOTP(loginResp.Challenge, codeOf(setupResp.Secret, 1), "")
+		assertResp(t, resp, errs, 200, "login with code")
+		resp, _, errs = usersCli.IsAuthed()
+		assertResp(t, resp, errs, 200, "authed by 2fa login")
+
+		loginResp = login(usersCli, userName)
+		resp, _, errs = usersCli.LoginTOTP(loginResp.Challenge, "", codesResp.RecoveryCodes[0])
+		assertResp(t, resp, errs, 200, "login with recovery code")
+		resp, _, errs = usersCli.LoginTOTP(loginResp.Challenge, "", codesResp.RecoveryCodes[1])
+		assertResp(t, resp, errs, 401, "login with used challenge")
+		loginResp = login(usersCli, userName)
+		resp, _, errs = usersCli.LoginTOTP(loginResp.Challenge, "", codesResp.RecoveryCodes[0])
+		assertResp(t, resp, errs, 403, "login with used recovery code")
+		resp, _, errs = usersCli.LoginTOTP(loginResp.Challenge, "", codesResp.RecoveryCodes[1])
+		assertResp(t, resp, errs, 200, "login with another recovery code")
+		resp, statusResp, errs = usersCli.GetTOTP()
+		assertResp(t, resp, errs, 200, "get 2fa")
+		if statusResp.RecoveryCodes != 8 {
+			t.Fatalf("recovery codes should be consumed: %+v", statusResp)
+		}
+
+		// admins reset 2fa
+		resp, _, errs = adminUsersCli.ResetUserTOTP(userID)
+		assertResp(t, resp, errs, 200, "reset 2fa")
+		if loginResp := login(usersCli, userName); loginResp.TOTPRequired {
+			t.Fatal("2fa should not be required after resetting")
+		}
+
+		// admins force 2fa
+		resp, _, errs = adminUsersCli.SetUserTOTP(forcedID, true)
+		assertResp(t, resp, errs, 200, "force 2fa")
+		forcedCli := client.NewUsersClient(addr)
+		loginResp = login(forcedCli, forcedName)
+		if !loginResp.TOTPRequired || loginResp.TOTPURI == "" {
+			t.Fatalf("2fa should be enrolled: %+v", loginResp)
+		}
+		// the pending secret is not replaced by other logins
+		if pendingResp := login(forcedCli, forcedName); pendingResp.TOTPURI != loginResp.TOTPURI {
+			t.Fatalf("pending secret should be reused: %s %s", pendingResp.TOTPURI, loginResp.TOTPURI)
+		}
+		uri, err := url.Parse(loginResp.TOTPURI)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret := uri.Query().Get("secret")
+		resp, forcedLoginResp, errs := forcedCli.LoginTOTP(loginResp.Challenge, codeOf(secret, 0), "")
+		assertResp(t, resp, errs, 200, "enroll 2fa in login")
+		if len(forcedLoginResp.RecoveryCodes) != 10 {
+			t.Fatalf("incorrect recovery codes size (%d)", len(forcedLoginResp.RecoveryCodes))
+		}
+		resp, _, errs = forcedCli.DisableTOTP(codeOf(secret, 1), "")
+		assertResp(t, resp, errs, 403, "disable forced 2fa")
+
+		resp, _, errs = adminUsersCli.SetUserTOTP(forcedID, false)
+		assertResp(t, resp, errs, 200, "stop forcing 2fa")
+		resp, _, errs = forcedCli.DisableTOTP("", forcedLoginResp.RecoveryCodes[0])
+		assertResp(t, resp, errs, 200, "disable 2fa")
+		if loginResp := login(forcedCli, forcedName); loginResp.TOTPRequired {
+			t.Fatal("2fa should not be required after disabling")
+		}
+
+		// attempts are limited
+		resp, setupResp, errs = forcedCli.SetupTOTP()
+		assertResp(t, resp, errs, 200, "set up 2fa")
+		limited := false
+		for i := 0; i < 11; i++ {
+			resp, _, errs = forcedCli.EnableTOTP("0000000")
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			} else if resp.StatusCode == 429 {
+				limited = true
+				break
+			}
+		}
+		if !limited {
+			t.Fatal("attempts should be limited")
+		}
+
+		for _, id := range []string{fmt.Sprint(userID), fmt.Sprint(forcedID)} {
+			resp, _, errs = adminUsersCli.DelUser(id)
+			assertResp(t, resp, errs, 200, "delete user")
+		}
+	})
 }
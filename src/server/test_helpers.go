@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"strings"
@@ -176,7 +177,13 @@ func assertDownloadOK(t testing.TB, filePath, content, addr string, token *http.
 		t.Error(fmt.Errorf("error code is not 200 or 206 in download (%d): %s", res.StatusCode, body))
 		return false
 	}
-	if contentDispositionHeader != fmt.Sprintf(`attachment; filename="%s"`, fileName) {
+	expectedHeader := fmt.Sprintf(`attachment; filename="%s"`, fileName)
+	if strings.IndexFunc(fileName, func(r rune) bool { return r > 0x7e }) >= 0 {
+		// non-ASCII names are encoded as RFC 6266 filename*
+		expectedHeader = fmt.Sprintf(`filename*=UTF-8''%s`, url.PathEscape(fileName))
+	}
+	if !strings.HasPrefix(contentDispositionHeader, "attachment; ") ||
+		!strings.Contains(contentDispositionHeader, expectedHeader) {
 		t.Errorf("incorrect Content-Disposition header: %s", contentDispositionHeader)
 		return false
 	}
@@ -235,6 +242,29 @@ func joinErrs(errs []error) error {
 	return errors.New(strings.Join(msgs, ","))
 }
 
+// purgeTrashes purges trashes of the item, the space of trashes is charged until they are purged.
+func purgeTrashes(filesCl *client.FilesClient, originPath string) error {
+	resp, lsResp, errs := filesCl.ListTrashes()
+	if len(errs) > 0 {
+		return joinErrs(errs)
+	} else if resp.StatusCode != 200 {
+		return fmt.Errorf("failed to list trashes: %d", resp.StatusCode)
+	}
+
+	for _, trash := range lsResp.Trashes {
+		if trash.OriginPath != originPath {
+			continue
+		}
+		resp, _, errs = filesCl.PurgeTrash(trash.ID)
+		if len(errs) > 0 {
+			return joinErrs(errs)
+		} else if resp.StatusCode != 200 {
+			return fmt.Errorf("failed to purge trash: %d", resp.StatusCode)
+		}
+	}
+	return nil
+}
+
 func loginFilesClient(addr, user, pwd string) (*client.FilesClient, error) {
 	usersCl := client.NewUsersClient(addr)
 	resp, _, errs := usersCl.Login(user, pwd)
@@ -321,6 +351,10 @@ func (cl *MockClient) uploadAndDownload(tb testing.TB, addr, name, pwd string, f
 		cl.errs = append(cl.errs, errors.New("failed to delete file"))
 		return
 	}
+	if err := purgeTrashes(filesCl, getFilePath(name, 0)); err != nil {
+		cl.errs = append(cl.errs, err)
+		return
+	}
 
 	resp, selfResp, errs = userUsersCli.Self()
 	if len(errs) > 0 {
@@ -349,5 +383,9 @@ func (cl *MockClient) uploadAndDownload(tb testing.TB, addr, name, pwd string, f
 			cl.errs = append(cl.errs, errors.New("failed to delete file"))
 			return
 		}
+		if err := purgeTrashes(filesCl, getFilePath(name, i)); err != nil {
+			cl.errs = append(cl.errs, err)
+			return
+		}
 	}
 }